@@ -3,113 +3,399 @@ package aof
 import (
 	"GoMiniCache/config"
 	databaseface "GoMiniCache/interface/database"
+	"GoMiniCache/interface/persistence"
 	"GoMiniCache/lib/logger"
+	"GoMiniCache/lib/sync/atomic"
 	"GoMiniCache/lib/utils"
 	"GoMiniCache/resp/connection"
 	"GoMiniCache/resp/parser"
 	"GoMiniCache/resp/reply"
+	"bytes"
+	"errors"
 	"io"
-	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	aofQueueSize = 1 << 16 // 65535
+
+	timestampPrefix = "TS:" // 时间戳注释的前缀，例：#TS:1700000000
+
+	defaultSegmentSize = 64 * 1024 * 1024 // 分段文件默认 64MB 切换一次
+)
+
+// 刷盘策略
+const (
+	FsyncAlways   = "always"   // 每写一条命令刷一次盘
+	FsyncEverySec = "everysec" // 每秒刷一次盘
+	FsyncNo       = "no"       // 交给操作系统决定
 )
 
 type payload struct {
 	cmdLine [][]byte
 	dbIndex int
+	marker  chan struct{} // 不为 nil 时是重写的快照点，写入协程处理到这里时关闭它
+}
+
+// Options 创建 HandlerAof 需要的参数
+type Options struct {
+	Backend          persistence.Backend // 命令日志的存储介质
+	Fsync            string              // 刷盘策略
+	TimestampEnabled bool                // 是否定期写入时间戳注释
+	LoadUntilTime    int64               // 加载时只回放这个时间之前的命令，0 表示不限制
+	LoadMaxCommands  int                 // 加载时最多回放的命令条数，0 表示不限制
+	SkipLoad         bool                // 启动时不回放，数据已经由 dict-backend lsm 保存在磁盘上
+}
+
+// MakeBackend 根据配置选择 AOF 的存储介质
+func MakeBackend(props *config.ServerProperties) (persistence.Backend, error) {
+	switch strings.ToLower(props.AofBackend) {
+	case "", "file":
+		return MakeFileBackend(props.AppendFilename)
+	case "memory":
+		return MakeMemoryBackend(), nil
+	case "segmented":
+		limit := int64(props.AofSegmentSize)
+		if limit <= 0 {
+			limit = defaultSegmentSize
+		}
+		return MakeSegmentBackend(props.AppendFilename, limit)
+	}
+	return nil, errors.New("unknown aof-backend: " + props.AofBackend)
+}
+
+// MakeOptions 根据配置创建 Options
+func MakeOptions(props *config.ServerProperties) (*Options, error) {
+	backend, err := MakeBackend(props)
+	if err != nil {
+		return nil, err
+	}
+	return &Options{
+		Backend:          backend,
+		Fsync:            strings.ToLower(props.AppendFsync),
+		TimestampEnabled: props.AofTimestampEnabled,
+		LoadUntilTime:    int64(props.AofLoadUntilTime),
+		LoadMaxCommands:  props.AofLoadMaxCommands,
+		SkipLoad:         strings.EqualFold(props.DictBackend, "lsm"),
+	}, nil
 }
 
 // HandlerAof 从通道接收消息并写入AOF文件
 type HandlerAof struct {
-	db          databaseface.Database
-	aofChan     chan *payload
-	aofFile     *os.File
-	aofFilename string
-	currentDB   int
+	db        databaseface.Database
+	aofChan   chan *payload
+	aofFinish chan struct{} // 写入协程退出时关闭
+	backend   persistence.Backend
+	mu        sync.Mutex // 保护 backend 的写入、currentDB 和 rewriteBuf，重写期间暂停追加
+	currentDB int
+	fsync     string
+	chanMu    sync.RWMutex // 发送时持有读锁，关闭管道时持有写锁，不会向已经关闭的管道发送
+	closed    bool         // 管道是否已经关闭，在 chanMu 中读写
+	buffered  atomic.Int64 // 还在管道里排队的命令的字节数（估算）
+
+	timestampEnabled bool  // 是否定期写入时间戳注释
+	lastTimestamp    int64 // 上一次写入的时间戳（秒）
+	loadUntilTime    int64 // 加载时只回放这个时间之前的命令，0 表示不限制
+	loadMaxCommands  int   // 加载时最多回放的命令条数，0 表示不限制
+	partialLoad      bool  // 加载时是否因为 loadUntilTime 或 loadMaxCommands 提前停止了回放
+
+	rewriting  sync.Mutex // 同一时间只能有一个重写
+	rewriteBuf []*payload // 重写缓冲区：快照点之后的命令，重写完成后追加到新文件的末尾，不在重写时为 nil
 }
 
 // NewAOFHandler 创建 aof.HandlerAof
-func NewAOFHandler(db databaseface.Database) (*HandlerAof, error) {
+func NewAOFHandler(db databaseface.Database, opts *Options) (*HandlerAof, error) {
+	if opts.Backend == nil {
+		return nil, errors.New("aof backend is required")
+	}
 	handler := &HandlerAof{}
-	handler.aofFilename = config.Properties.AppendFilename
+	handler.backend = opts.Backend
+	handler.fsync = opts.Fsync
+	if handler.fsync == "" {
+		handler.fsync = FsyncEverySec
+	}
+	handler.timestampEnabled = opts.TimestampEnabled
+	handler.loadUntilTime = opts.LoadUntilTime
+	handler.loadMaxCommands = opts.LoadMaxCommands
 	handler.db = db
 	// 恢复曾经的AOF文件
-	handler.LoadAof()
-	// 打开AOF文件: os.O_APPEND: 表示在文件末尾追加数据; os.O_CREATE: 如果文件不存在，则创建一个新文件; os.O_RDWR: 表示以读写模式打开文件。
-	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		return nil, err
+	if !opts.SkipLoad {
+		handler.LoadAof()
 	}
-	handler.aofFile = aofFile
 	handler.aofChan = make(chan *payload, aofQueueSize)
+	handler.aofFinish = make(chan struct{})
+	// 启动写入协程之前设置好，之后只在 mu 中修改
+	handler.currentDB = 0
 	go func() { // 起一个协程执行AOF
 		handler.handleAof()
 	}()
+	if handler.fsync == FsyncEverySec {
+		go handler.fsyncEverySecond()
+	}
 	logger.Info("start aof persistence...")
 	return handler, nil
 }
 
 // AddAof 通过管道向处理AOF的协程发送命令
 func (handler *HandlerAof) AddAof(dbIndex int, cmdLine [][]byte) {
-	// 判断AOF是否启用
-	if config.Properties.AppendOnly && handler.aofChan != nil {
-		handler.aofChan <- &payload{
-			cmdLine: cmdLine,
-			dbIndex: dbIndex,
-		}
+	handler.chanMu.RLock()
+	defer handler.chanMu.RUnlock()
+	if handler.aofChan == nil || handler.closed {
+		return
+	}
+	handler.buffered.Add(cmdLineSize(cmdLine))
+	handler.aofChan <- &payload{
+		cmdLine: cmdLine,
+		dbIndex: dbIndex,
 	}
 }
 
 // handleAof 监听管道传输的数据并写入文件
 func (handler *HandlerAof) handleAof() {
-	handler.currentDB = 0
 	for p := range handler.aofChan {
+		if p.marker != nil { // 快照点：之后的命令同时记进重写缓冲区
+			handler.mu.Lock()
+			handler.rewriteBuf = make([]*payload, 0)
+			handler.mu.Unlock()
+			close(p.marker)
+			continue
+		}
+		handler.buffered.Add(-cmdLineSize(p.cmdLine))
+		handler.mu.Lock()
+		if handler.rewriteBuf != nil {
+			handler.rewriteBuf = append(handler.rewriteBuf, p)
+		}
+		handler.writeTimestamp()
 		if p.dbIndex != handler.currentDB {
 			// 使用其他数据库编号，编好格式，写入文件
-			data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
-			_, err := handler.aofFile.Write(data)
+			err := handler.backend.Append(selectCmd(p.dbIndex))
 			if err != nil {
 				logger.Warn(err)
+				handler.mu.Unlock()
 				continue
 			}
 			handler.currentDB = p.dbIndex
 		}
 		// 用的同一个数据库编号，直接写入即可
 		data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
-		_, err := handler.aofFile.Write(data)
+		err := handler.backend.Append(data)
 		if err != nil {
 			logger.Warn(err)
 		}
+		if handler.fsync == FsyncAlways {
+			_ = handler.backend.Sync()
+		}
+		handler.mu.Unlock()
+	}
+	close(handler.aofFinish)
+}
+
+// BufferedBytes 返回还在管道里等待写入的命令占用的内存（估算）
+func (handler *HandlerAof) BufferedBytes() int64 {
+	return handler.buffered.Get()
+}
+
+// cmdLineSize 估算一条命令占用的内存
+func cmdLineSize(cmdLine [][]byte) int64 {
+	size := int64(24 * (len(cmdLine) + 1)) // 外层和每个参数的切片头
+	for _, arg := range cmdLine {
+		size += int64(len(arg))
+	}
+	return size
+}
+
+// fsyncEverySecond 每秒刷一次盘，直到关闭
+func (handler *HandlerAof) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			handler.mu.Lock()
+			if err := handler.backend.Sync(); err != nil {
+				logger.Warn(err)
+			}
+			handler.mu.Unlock()
+		case <-handler.aofFinish:
+			return
+		}
 	}
 }
 
+// selectCmd 返回切换数据库的命令
+func selectCmd(dbIndex int) []byte {
+	return reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex))).ToBytes()
+}
+
+// writeTimestamp 每过一秒在命令之间写入一条时间戳注释，用于按时间点恢复
+func (handler *HandlerAof) writeTimestamp() {
+	if !handler.timestampEnabled {
+		return
+	}
+	now := time.Now().Unix()
+	if now <= handler.lastTimestamp {
+		return
+	}
+	data := reply.MakeAnnotationReply(timestampPrefix + strconv.FormatInt(now, 10)).ToBytes()
+	err := handler.backend.Append(data)
+	if err != nil {
+		logger.Warn(err)
+		return
+	}
+	handler.lastTimestamp = now
+}
+
+// ParseTimestamp 解析时间戳注释，如果不是时间戳注释返回 false
+func ParseTimestamp(annotation *reply.AnnotationReply) (int64, bool) {
+	if !strings.HasPrefix(annotation.Text, timestampPrefix) {
+		return 0, false
+	}
+	ts, err := strconv.ParseInt(annotation.Text[len(timestampPrefix):], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return ts, true
+}
+
+// Rewrite 用 dump 生成的命令替换掉 AOF 中的全部内容
+// pause 暂停执行命令并返回恢复执行的函数，暂停的这一刻就是快照点，dump 依次交出快照点上每个数据库中重建数据所需的命令
+// 和 Redis 一样，快照点之前的命令已经反映在 dump 里，只写进旧文件；快照点之后的命令照常写进旧文件，
+// 同时记进重写缓冲区，新文件写完快照以后再追加上去，这样每条命令在新文件里只会回放一次
+func (handler *HandlerAof) Rewrite(pause func() (resume func()), dump func(emit func(dbIndex int, cmdLine [][]byte))) error {
+	if !handler.rewriting.TryLock() {
+		return errors.New("background append only file rewriting already in progress")
+	}
+	defer handler.rewriting.Unlock()
+
+	// 在暂停期间生成快照，快照先放在内存里，不用在暂停期间写盘
+	marker := make(chan struct{})
+	resume := pause()
+	handler.chanMu.RLock()
+	if handler.closed {
+		handler.chanMu.RUnlock()
+		resume()
+		return errors.New("aof handler is closed")
+	}
+	handler.aofChan <- &payload{marker: marker} // 排在快照点之前的命令后面
+	handler.chanMu.RUnlock()
+	var snapshot bytes.Buffer
+	currentDB := 0
+	dump(func(dbIndex int, cmdLine [][]byte) {
+		if dbIndex != currentDB {
+			snapshot.Write(selectCmd(dbIndex))
+			currentDB = dbIndex
+		}
+		snapshot.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+	})
+	resume()
+	<-marker // 快照点之前的命令都已经写进旧文件了
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	rewriteBuf := handler.rewriteBuf
+	handler.rewriteBuf = nil
+	err := handler.backend.Rewrite(func(w io.Writer) error {
+		if _, err := w.Write(snapshot.Bytes()); err != nil {
+			return err
+		}
+		for _, p := range rewriteBuf {
+			if p.dbIndex != currentDB {
+				if _, err := w.Write(selectCmd(p.dbIndex)); err != nil {
+					return err
+				}
+				currentDB = p.dbIndex
+			}
+			if _, err := w.Write(reply.MakeMultiBulkReply(p.cmdLine).ToBytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	handler.currentDB = currentDB
+	handler.lastTimestamp = 0 // 新文件里重新开始写时间戳
+	return nil
+}
+
+// Close 等待管道里的命令写完，刷盘后关闭存储介质
+// 拿到写锁时已经没有正在发送的命令，之后的 AddAof 看到 closed 直接返回
+func (handler *HandlerAof) Close() {
+	handler.chanMu.Lock()
+	if handler.closed {
+		handler.chanMu.Unlock()
+		return
+	}
+	handler.closed = true
+	close(handler.aofChan)
+	handler.chanMu.Unlock()
+	<-handler.aofFinish
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	_ = handler.backend.Sync()
+	_ = handler.backend.Close()
+}
+
+// PartialLoad 返回启动时是否只回放了一部分命令
+// 剩下的命令还在存储介质上，调用者需要用加载到的数据重写 AOF，否则下次不带限制启动时会把它们也回放了
+func (handler *HandlerAof) PartialLoad() bool {
+	return handler.partialLoad
+}
+
 // LoadAof 读取AOF文件
+// 如果配置了 loadUntilTime，遇到比它晚的时间戳注释就停止回放；如果配置了 loadMaxCommands，回放够了条数就停止
 func (handler *HandlerAof) LoadAof() {
-	file, err := os.Open(handler.aofFilename)
+	file, err := handler.backend.Load()
 	if err != nil { // 如果文件不存在，就返回
 		logger.Warn(err)
 		return
 	}
-	defer func(file *os.File) {
+	defer func(file io.ReadCloser) {
 		_ = file.Close()
 	}(file)
-	ch := parser.ParseStream(file)       // 解析命令
+	reader := parser.NewReader(file) // 解析命令
+	defer reader.Close()
 	fakeConn := &connection.Connection{} // 用于记录 dbIndex
-	for p := range ch {
+	fakeConn.SetAuthenticated(true)      // 回放的命令不需要密码
+	index := 0                           // 已经回放的命令条数
+	for {
+		p := reader.Next()
 		if p.Err != nil {
 			if p.Err == io.EOF { // 读到文件结束符，读完这个AOF文件了
 				break
 			}
 			logger.Error("parse error: " + p.Err.Error())
+			if reader.Err() != nil { // 读取出错或者数据超过了长度限制，不能继续解析
+				break
+			}
 			continue
 		}
 		if p.Data == nil { // 遇到空指令，跳过
 			logger.Error("empty payload")
 			continue
 		}
+		if p.Inline { // AOF 中只有 RESP 编码的命令
+			logger.Error("unexpected inline command in aof")
+			continue
+		}
+		if annotation, ok := p.Data.(*reply.AnnotationReply); ok { // 注释行，只关心时间戳
+			ts, ok := ParseTimestamp(annotation)
+			if ok && handler.loadUntilTime > 0 && ts > handler.loadUntilTime {
+				logger.Info("aof replay stopped at timestamp " + strconv.FormatInt(ts, 10))
+				handler.partialLoad = true
+				return
+			}
+			continue
+		}
+		if handler.loadMaxCommands > 0 && index >= handler.loadMaxCommands {
+			logger.Info("aof replay stopped at command #" + strconv.Itoa(index))
+			handler.partialLoad = true
+			return
+		}
 		r, ok := p.Data.(*reply.MultiBulkReply) // 需要转成二维
 		if !ok {
 			logger.Error("require multi bulk reply")
@@ -119,5 +405,6 @@ func (handler *HandlerAof) LoadAof() {
 		if reply.IsErrorReply(ret) {
 			logger.Error("exec err", err)
 		}
+		index++
 	}
 }
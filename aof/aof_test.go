@@ -0,0 +1,351 @@
+package aof
+
+import (
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/resp/reply"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordDatabase 记录所有执行过的命令
+type recordDatabase struct {
+	cmds []string
+}
+
+func (db *recordDatabase) Exec(client resp.Connection, args [][]byte) resp.Reply {
+	db.cmds = append(db.cmds, joinArgs(args))
+	return reply.MakeOkReply()
+}
+
+func (db *recordDatabase) AfterClientClose(c resp.Connection) {}
+
+func (db *recordDatabase) Close() {}
+
+func joinArgs(args [][]byte) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = string(arg)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestHandlerAofWithMemoryBackend(t *testing.T) {
+	backend := MakeMemoryBackend()
+	handler, err := NewAOFHandler(&recordDatabase{}, &Options{Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(0, utils.ToCmdLine("set", "a", "1"))
+	handler.AddAof(2, utils.ToCmdLine("set", "b", "2"))
+	handler.Close()
+
+	db := &recordDatabase{}
+	if _, err := NewAOFHandler(db, &Options{Backend: backend}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"set a 1", "SELECT 2", "set b 2"}
+	if strings.Join(db.cmds, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, db.cmds)
+	}
+}
+
+func TestHandlerAofRewrite(t *testing.T) {
+	backend := MakeMemoryBackend()
+	handler, err := NewAOFHandler(&recordDatabase{}, &Options{Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(0, utils.ToCmdLine("set", "a", "1"))
+	handler.AddAof(0, utils.ToCmdLine("set", "a", "2"))
+	err = handler.Rewrite(noPause, func(emit func(dbIndex int, cmdLine [][]byte)) {
+		emit(1, utils.ToCmdLine("set", "a", "2"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(0, utils.ToCmdLine("del", "a"))
+	handler.Close()
+
+	db := &recordDatabase{}
+	if _, err := NewAOFHandler(db, &Options{Backend: backend}); err != nil {
+		t.Fatal(err)
+	}
+	// 重写之前已经写入的命令被丢弃，重写之后的命令继续追加
+	last := db.cmds[len(db.cmds)-1]
+	if db.cmds[0] != "SELECT 1" || db.cmds[1] != "set a 2" || last != "del a" {
+		t.Errorf("unexpected commands after rewrite: %v", db.cmds)
+	}
+}
+
+// noPause 测试中没有并发执行的命令，不需要暂停
+func noPause() func() {
+	return func() {}
+}
+
+// TestHandlerAofRewriteBuffer 快照点之前排队的命令已经反映在快照里，不能在新文件中再回放一次
+func TestHandlerAofRewriteBuffer(t *testing.T) {
+	backend := MakeMemoryBackend()
+	handler, err := NewAOFHandler(&recordDatabase{}, &Options{Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(0, utils.ToCmdLine("cf.add", "f", "x"))
+	handler.AddAof(0, utils.ToCmdLine("cf.add", "f", "y"))
+	pause := func() func() {
+		return func() { // 恢复执行以后的命令写进重写缓冲区
+			handler.AddAof(2, utils.ToCmdLine("cf.add", "f", "z"))
+		}
+	}
+	err = handler.Rewrite(pause, func(emit func(dbIndex int, cmdLine [][]byte)) {
+		emit(0, utils.ToCmdLine("cf.loadchunk", "f", "1", "xy"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(2, utils.ToCmdLine("cf.del", "f", "z"))
+	handler.Close()
+
+	db := &recordDatabase{}
+	if _, err := NewAOFHandler(db, &Options{Backend: backend}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"cf.loadchunk f 1 xy", "SELECT 2", "cf.add f z", "cf.del f z"}
+	if strings.Join(db.cmds, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, db.cmds)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	if ts, ok := ParseTimestamp(reply.MakeAnnotationReply("TS:1700000000")); !ok || ts != 1700000000 {
+		t.Errorf("expected 1700000000, got %d %v", ts, ok)
+	}
+	for _, text := range []string{"TS:", "TS:abc", "comment", "ts:100"} {
+		if _, ok := ParseTimestamp(reply.MakeAnnotationReply(text)); ok {
+			t.Errorf("%q should not be a timestamp", text)
+		}
+	}
+}
+
+func TestHandlerAofTimestamp(t *testing.T) {
+	backend := MakeMemoryBackend()
+	handler, err := NewAOFHandler(&recordDatabase{}, &Options{Backend: backend, TimestampEnabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Unix()
+	handler.AddAof(0, utils.ToCmdLine("set", "a", "1"))
+	handler.Close()
+	after := time.Now().Unix()
+
+	// 时间戳注释写在命令前面
+	content := string(backend.Bytes())
+	header, rest, ok := strings.Cut(content, "\r\n")
+	if !ok || !strings.HasPrefix(header, "#TS:") {
+		t.Fatalf("expected a timestamp annotation first, got %q", content)
+	}
+	ts, err := strconv.ParseInt(strings.TrimPrefix(header, "#TS:"), 10, 64)
+	if err != nil || ts < before || ts > after {
+		t.Errorf("unexpected timestamp %q", header)
+	}
+	if rest != string(reply.MakeMultiBulkReply(utils.ToCmdLine("set", "a", "1")).ToBytes()) {
+		t.Errorf("unexpected command after timestamp: %q", rest)
+	}
+
+	// 时间戳注释不会被当成命令回放
+	db := &recordDatabase{}
+	if _, err := NewAOFHandler(db, &Options{Backend: backend}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(db.cmds, ",") != "set a 1" {
+		t.Errorf("expected only the command to be replayed, got %v", db.cmds)
+	}
+}
+
+// TestHandlerAofTimestampOncePerSecond 这一秒已经写过时间戳时不再写
+func TestHandlerAofTimestampOncePerSecond(t *testing.T) {
+	backend := MakeMemoryBackend()
+	handler, err := NewAOFHandler(&recordDatabase{}, &Options{Backend: backend, TimestampEnabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.mu.Lock()
+	handler.lastTimestamp = time.Now().Unix() + 3600
+	handler.mu.Unlock()
+	handler.AddAof(0, utils.ToCmdLine("set", "a", "1"))
+	handler.AddAof(0, utils.ToCmdLine("set", "b", "2"))
+	handler.Close()
+	if strings.Contains(string(backend.Bytes()), "#TS:") {
+		t.Errorf("unexpected timestamp in %q", backend.Bytes())
+	}
+}
+
+// timestampedBackend 三条命令，前两条之间和后两条之间各有一个时间戳注释
+func timestampedBackend() *MemoryBackend {
+	backend := MakeMemoryBackend()
+	for _, data := range []string{
+		string(reply.MakeMultiBulkReply(utils.ToCmdLine("set", "a", "1")).ToBytes()),
+		"#TS:100\r\n",
+		string(reply.MakeMultiBulkReply(utils.ToCmdLine("set", "b", "2")).ToBytes()),
+		"#TS:200\r\n",
+		string(reply.MakeMultiBulkReply(utils.ToCmdLine("set", "c", "3")).ToBytes()),
+	} {
+		_ = backend.Append([]byte(data))
+	}
+	return backend
+}
+
+func TestLoadAofCutoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{"no limit", Options{}, "set a 1,set b 2,set c 3"},
+		{"until before first timestamp", Options{LoadUntilTime: 50}, "set a 1"},
+		{"until between timestamps", Options{LoadUntilTime: 150}, "set a 1,set b 2"},
+		{"until equal to timestamp", Options{LoadUntilTime: 200}, "set a 1,set b 2,set c 3"},
+		{"max commands", Options{LoadMaxCommands: 2}, "set a 1,set b 2"},
+		{"max commands exceeds file", Options{LoadMaxCommands: 10}, "set a 1,set b 2,set c 3"},
+		{"both limits", Options{LoadUntilTime: 150, LoadMaxCommands: 1}, "set a 1"},
+	}
+	for _, tt := range tests {
+		opts := tt.opts
+		opts.Backend = timestampedBackend()
+		db := &recordDatabase{}
+		handler, err := NewAOFHandler(db, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.Close()
+		if got := strings.Join(db.cmds, ","); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestSegmentBackendRoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	base := filepath.Join(dir, "appendonly.aof")
+	backend, err := MakeSegmentBackend(base, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewAOFHandler(&recordDatabase{}, &Options{Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		handler.AddAof(0, utils.ToCmdLine("set", "key", "value"))
+	}
+	handler.Close()
+
+	seqs, err := listSegments(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) < 2 {
+		t.Errorf("expected several segments, got %v", seqs)
+	}
+	backend, err = MakeSegmentBackend(base, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &recordDatabase{}
+	if _, err := NewAOFHandler(db, &Options{Backend: backend}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.cmds) != 10 {
+		t.Errorf("expected 10 commands, got %d", len(db.cmds))
+	}
+}
+
+// TestSegmentBackendRewriteCrash 重写时在切换 manifest 前后崩溃，重启时只回放一份数据
+func TestSegmentBackendRewriteCrash(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "appendonly.aof")
+	backend, err := MakeSegmentBackend(base, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewAOFHandler(&recordDatabase{}, &Options{Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		handler.AddAof(0, utils.ToCmdLine("set", "key", strconv.Itoa(i)))
+	}
+	err = handler.Rewrite(noPause, func(emit func(dbIndex int, cmdLine [][]byte)) {
+		emit(0, utils.ToCmdLine("set", "key", "4"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Close()
+	seqs, err := listSegments(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := seqs[len(seqs)-1]
+
+	// 切换之后、删除旧文件之前崩溃：旧文件留在 manifest 的范围之前
+	stale := reply.MakeMultiBulkReply(utils.ToCmdLine("set", "stale", "1")).ToBytes()
+	if err := os.WriteFile(segmentName(base, seqs[0]-1), stale, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 下一次重写写到一半崩溃：新文件在 manifest 的范围之后
+	if err := os.WriteFile(segmentName(base, last+1), stale, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err = MakeSegmentBackend(base, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &recordDatabase{}
+	handler, err = NewAOFHandler(db, &Options{Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	if got := strings.Join(db.cmds, ","); got != "set key 4" {
+		t.Errorf("expected only the rewritten command, got %q", got)
+	}
+	for _, seq := range []int{seqs[0] - 1, last + 1} {
+		if _, err := os.Stat(segmentName(base, seq)); !os.IsNotExist(err) {
+			t.Errorf("expected segment %d outside the manifest to be removed, got %v", seq, err)
+		}
+	}
+}
+
+// TestHandlerAofCloseWhileAdding 关闭的同时还有命令在追加，不会向已经关闭的管道发送
+func TestHandlerAofCloseWhileAdding(t *testing.T) {
+	handler, err := NewAOFHandler(&recordDatabase{}, &Options{Backend: MakeMemoryBackend()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				handler.AddAof(0, utils.ToCmdLine("set", "key", "value"))
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	handler.Close()
+	wg.Wait()
+}
@@ -0,0 +1,79 @@
+package aof
+
+/*
+ * 把 AOF 写到单个文件里（默认的持久化方式）
+ */
+
+import (
+	"io"
+	"os"
+)
+
+// FileBackend 使用单个文件存储命令日志
+type FileBackend struct {
+	filename string
+	file     *os.File
+}
+
+// MakeFileBackend 创建 FileBackend
+func MakeFileBackend(filename string) (*FileBackend, error) {
+	// os.O_APPEND: 表示在文件末尾追加数据; os.O_CREATE: 如果文件不存在，则创建一个新文件; os.O_RDWR: 表示以读写模式打开文件。
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileBackend{
+		filename: filename,
+		file:     file,
+	}, nil
+}
+
+// Append 在文件末尾追加数据
+func (b *FileBackend) Append(data []byte) error {
+	_, err := b.file.Write(data)
+	return err
+}
+
+// Sync 刷盘
+func (b *FileBackend) Sync() error {
+	return b.file.Sync()
+}
+
+// Rewrite 先写到临时文件，写完后再替换掉原来的文件
+func (b *FileBackend) Rewrite(write func(w io.Writer) error) error {
+	tmpFilename := b.filename + ".rewrite"
+	tmpFile, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = write(tmpFile)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	_ = tmpFile.Close()
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return err
+	}
+	if err = os.Rename(tmpFilename, b.filename); err != nil {
+		return err
+	}
+	// 原来的文件已经被替换了，重新打开新文件继续追加
+	file, err := os.OpenFile(b.filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	_ = b.file.Close()
+	b.file = file
+	return nil
+}
+
+// Load 打开文件从头读取
+func (b *FileBackend) Load() (io.ReadCloser, error) {
+	return os.Open(b.filename)
+}
+
+// Close 关闭文件
+func (b *FileBackend) Close() error {
+	return b.file.Close()
+}
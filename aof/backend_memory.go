@@ -0,0 +1,70 @@
+package aof
+
+/*
+ * 把 AOF 存在内存里，主要给测试使用
+ */
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// MemoryBackend 使用 bytes.Buffer 存储命令日志
+type MemoryBackend struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// MakeMemoryBackend 创建 MemoryBackend
+func MakeMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+// Append 在末尾追加数据
+func (b *MemoryBackend) Append(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(data)
+	return nil
+}
+
+// Sync 内存里不需要刷盘
+func (b *MemoryBackend) Sync() error {
+	return nil
+}
+
+// Rewrite 用新内容替换掉全部旧内容
+func (b *MemoryBackend) Rewrite(write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = buf
+	return nil
+}
+
+// Load 返回当前内容的一份拷贝
+func (b *MemoryBackend) Load() (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data := make([]byte, b.buf.Len())
+	copy(data, b.buf.Bytes())
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Close 无需操作
+func (b *MemoryBackend) Close() error {
+	return nil
+}
+
+// Bytes 返回当前内容的一份拷贝
+func (b *MemoryBackend) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data := make([]byte, b.buf.Len())
+	copy(data, b.buf.Bytes())
+	return data
+}
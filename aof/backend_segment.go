@@ -0,0 +1,324 @@
+package aof
+
+/*
+ * 把 AOF 写到多个分段文件里，当前文件写满后切换到下一个文件
+ * 文件名为 <base>.<序号>，例：appendonly.aof.1, appendonly.aof.2 ...
+ * <base>.manifest 记录有效的分段的序号范围，不在范围里的文件是重写到一半或者重写后没删掉的，加载时忽略并删除
+ * manifest 先写临时文件再改名，然后刷目录，崩溃时看到的是完整的旧范围或新范围
+ */
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// segmentManifest 有效的分段的序号范围 [First, Last]
+type segmentManifest struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+// segmentWriter 按大小切分文件的 io.Writer
+type segmentWriter struct {
+	base   string
+	limit  int64
+	seq    int // 当前文件的序号
+	file   *os.File
+	size   int64               // 当前文件的大小
+	onRoll func(seq int) error // 打开新的文件之后、写入数据之前调用，为 nil 时不调用
+}
+
+// Write 当前文件写满了就先切换到下一个文件（一次 Write 的内容不会被拆开）
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	if w.file == nil || (w.size > 0 && w.size+int64(len(p)) > w.limit) {
+		if err := w.roll(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// roll 关闭当前文件，打开下一个序号的文件
+func (w *segmentWriter) roll() error {
+	if w.file != nil {
+		_ = w.file.Sync()
+		_ = w.file.Close()
+	}
+	w.seq++
+	if err := w.open(); err != nil {
+		return err
+	}
+	if w.onRoll == nil {
+		return nil
+	}
+	if err := w.onRoll(w.seq); err != nil { // 没能加进有效范围，不能往这个文件里写，下次写入时重试
+		_ = w.close()
+		_ = os.Remove(segmentName(w.base, w.seq))
+		w.seq--
+		return err
+	}
+	return nil
+}
+
+// open 打开当前序号的文件
+func (w *segmentWriter) open() error {
+	file, err := os.OpenFile(segmentName(w.base, w.seq), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// sync 刷盘
+func (w *segmentWriter) sync() error {
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// close 关闭当前文件
+func (w *segmentWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// SegmentBackend 使用多个分段文件存储命令日志
+type SegmentBackend struct {
+	base   string
+	limit  int64
+	first  int // 第一个有效的分段的序号
+	writer *segmentWriter
+}
+
+// MakeSegmentBackend 创建 SegmentBackend，删除 manifest 范围以外的文件，继续往最后一个有效的文件里追加
+func MakeSegmentBackend(base string, limit int64) (*SegmentBackend, error) {
+	seqs, err := listSegments(base)
+	if err != nil {
+		return nil, err
+	}
+	m, err := readSegmentManifest(base)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil { // 没有 manifest：新建的或者旧版本留下的文件，全部有效
+		m = &segmentManifest{First: 1, Last: 1}
+		if len(seqs) > 0 {
+			m.First, m.Last = seqs[0], seqs[len(seqs)-1]
+		}
+		if err := writeSegmentManifest(base, m); err != nil {
+			return nil, err
+		}
+	}
+	for _, seq := range seqs {
+		if seq < m.First || seq > m.Last {
+			_ = os.Remove(segmentName(base, seq))
+		}
+	}
+	b := &SegmentBackend{
+		base:  base,
+		limit: limit,
+		first: m.First,
+	}
+	b.writer = &segmentWriter{
+		base:   base,
+		limit:  limit,
+		seq:    m.Last,
+		onRoll: b.extend,
+	}
+	if err := b.writer.open(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// extend 追加时切换到了新的文件，把它加进有效范围
+func (b *SegmentBackend) extend(seq int) error {
+	return writeSegmentManifest(b.base, &segmentManifest{First: b.first, Last: seq})
+}
+
+// Append 在最后一个文件末尾追加数据，写满了会切换文件
+func (b *SegmentBackend) Append(data []byte) error {
+	_, err := b.writer.Write(data)
+	return err
+}
+
+// Sync 刷盘
+func (b *SegmentBackend) Sync() error {
+	return b.writer.sync()
+}
+
+// Rewrite 把新内容写到序号更大的文件里，刷盘后切换 manifest，最后再删除旧的文件
+// 切换之前崩溃，新文件不在有效范围里；切换之后崩溃，旧文件不在有效范围里；重启时都会被删除，不会回放两遍
+func (b *SegmentBackend) Rewrite(write func(w io.Writer) error) error {
+	oldFirst, oldLast := b.first, b.writer.seq
+	writer := &segmentWriter{
+		base:  b.base,
+		limit: b.limit,
+		seq:   oldLast, // Write 时会先 roll 到下一个序号
+	}
+	err := write(writer)
+	if err == nil && writer.file == nil { // 没有任何内容，也要留下一个空文件继续追加
+		err = writer.roll()
+	}
+	if err == nil {
+		err = writer.sync()
+	}
+	if err == nil {
+		err = writeSegmentManifest(b.base, &segmentManifest{First: oldLast + 1, Last: writer.seq})
+	}
+	if err != nil {
+		_ = writer.close()
+		for seq := oldLast + 1; seq <= writer.seq; seq++ {
+			_ = os.Remove(segmentName(b.base, seq))
+		}
+		return err
+	}
+	_ = b.writer.close()
+	writer.onRoll = b.extend
+	b.writer = writer
+	b.first = oldLast + 1
+	for seq := oldFirst; seq <= oldLast; seq++ {
+		_ = os.Remove(segmentName(b.base, seq))
+	}
+	return nil
+}
+
+// Load 按序号把所有有效的文件拼起来读取
+func (b *SegmentBackend) Load() (io.ReadCloser, error) {
+	files := make([]*os.File, 0, b.writer.seq-b.first+1)
+	readers := make([]io.Reader, 0, b.writer.seq-b.first+1)
+	for seq := b.first; seq <= b.writer.seq; seq++ {
+		file, err := os.Open(segmentName(b.base, seq))
+		if os.IsNotExist(err) { // 切换到新文件以后还没写入就崩溃了
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, file)
+		readers = append(readers, file)
+	}
+	return &multiFileReader{
+		Reader: io.MultiReader(readers...),
+		files:  files,
+	}, nil
+}
+
+// Close 关闭当前文件
+func (b *SegmentBackend) Close() error {
+	return b.writer.close()
+}
+
+// multiFileReader 读取多个文件，关闭时把它们全部关闭
+type multiFileReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (r *multiFileReader) Close() error {
+	for _, file := range r.files {
+		_ = file.Close()
+	}
+	return nil
+}
+
+// segmentName 返回分段文件的文件名
+func segmentName(base string, seq int) string {
+	return base + "." + strconv.Itoa(seq)
+}
+
+// manifestName 返回 manifest 的文件名
+func manifestName(base string) string {
+	return base + ".manifest"
+}
+
+// readSegmentManifest 读出 manifest，文件不存在时返回 nil
+func readSegmentManifest(base string) (*segmentManifest, error) {
+	data, err := os.ReadFile(manifestName(base))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &segmentManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeSegmentManifest 原子地重写 manifest：写临时文件并刷盘，改名，再刷目录让改名落盘
+func writeSegmentManifest(base string, m *segmentManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := manifestName(base) + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, manifestName(base)); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(base))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dir.Close()
+	}()
+	return dir.Sync()
+}
+
+// listSegments 返回已经存在的分段文件的序号（从小到大）
+func listSegments(base string) ([]int, error) {
+	matches, err := filepath.Glob(base + ".*")
+	if err != nil {
+		return nil, err
+	}
+	seqs := make([]int, 0, len(matches))
+	for _, match := range matches {
+		seq, err := strconv.Atoi(strings.TrimPrefix(match, base+"."))
+		if err != nil || seq <= 0 { // 例：appendonly.aof.rewrite 这类不是分段文件
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
@@ -0,0 +1,47 @@
+package aof
+
+/*
+ * 把数据库中的实体转换成可以重建它的命令，用于重写 AOF
+ */
+
+import (
+	"GoMiniCache/datastruct/bloom"
+	"GoMiniCache/datastruct/cuckoo"
+	"GoMiniCache/interface/database"
+	"GoMiniCache/lib/utils"
+	"strconv"
+	"time"
+)
+
+var (
+	setCmd          = []byte("SET")
+	bfLoadChunkCmd  = []byte("BF.LOADCHUNK")
+	cfLoadChunkCmd  = []byte("CF.LOADCHUNK")
+	firstChunkIndex = []byte("1")
+)
+
+// EntityToCmd 返回重建 key 对应实体的命令，不支持的类型返回 nil
+func EntityToCmd(key string, entity *database.DataEntity) [][]byte {
+	if entity == nil {
+		return nil
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		return utils.ToCmdLine2(string(setCmd), []byte(key), val)
+	case int64: // 按整数保存的字符串
+		return utils.ToCmdLine2(string(setCmd), []byte(key), strconv.AppendInt(nil, val, 10))
+	case *bloom.Filter: // 过滤器把全部的状态作为一块导入
+		data, _ := val.MarshalBinary()
+		return utils.ToCmdLine2(string(bfLoadChunkCmd), []byte(key), firstChunkIndex, data)
+	case *cuckoo.Filter:
+		data, _ := val.MarshalBinary()
+		return utils.ToCmdLine2(string(cfLoadChunkCmd), []byte(key), firstChunkIndex, data)
+	}
+	return nil
+}
+
+// ExpireToCmd 返回设置过期时间的命令，统一使用绝对时间 PEXPIREAT
+func ExpireToCmd(key string, expireAt time.Time) [][]byte {
+	ms := expireAt.UnixNano() / int64(time.Millisecond)
+	return utils.ToCmdLine("PEXPIREAT", key, strconv.FormatInt(ms, 10))
+}
@@ -0,0 +1,208 @@
+package main
+
+/*
+ * 离线检查 AOF 文件的工具：定位第一个错误的位置、统计命令，并且可以截断到最后一条合法命令
+ * 用法: gominicache-check-aof [--fix] [--truncate-to-timestamp <unix>] appendonly.aof
+ */
+
+import (
+	"GoMiniCache/aof"
+	"GoMiniCache/resp/parser"
+	"GoMiniCache/resp/reply"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aofStats AOF 文件中合法命令的统计信息
+type aofStats struct {
+	commands int            // 合法命令总数
+	byName   map[string]int // 每种命令的数量
+	byDB     map[int]int    // 每个数据库的命令数量（SELECT 本身不计入）
+
+	timestamps    int   // 时间戳注释的数量
+	lastTimestamp int64 // 最后一个时间戳
+}
+
+// checkResult 检查的结果
+type checkResult struct {
+	stats     *aofStats
+	validSize int64 // 最后一条合法命令结束的位置，也就是 --fix 截断后的文件大小
+	errIndex  int   // 第一个错误对应的命令序号（从 0 开始），没有错误时为 -1
+	err       error // 第一个错误
+
+	cutSize int64 // 第一个晚于 --truncate-to-timestamp 的时间戳注释的位置，-1 表示没有
+}
+
+// checkAof 逐条解析 AOF 内容，遇到第一个错误就停下
+// truncateTo 大于 0 时，记录第一个晚于它的时间戳注释的位置，按时间点截断时使用
+func checkAof(reader io.Reader, size int64, truncateTo int64) *checkResult {
+	result := &checkResult{
+		stats: &aofStats{
+			byName: make(map[string]int),
+			byDB:   make(map[int]int),
+		},
+		errIndex: -1,
+		cutSize:  -1,
+	}
+	currentDB := 0
+	index := 0
+	ch := parser.ParseStream(reader)
+	for p := range ch {
+		if p.Err != nil {
+			if p.Err == io.EOF {
+				break
+			}
+			result.errIndex = index
+			result.err = p.Err
+			break
+		}
+		if annotation, ok := p.Data.(*reply.AnnotationReply); ok {
+			if ts, ok := aof.ParseTimestamp(annotation); ok {
+				if truncateTo > 0 && ts > truncateTo && result.cutSize < 0 {
+					result.cutSize = result.validSize
+				}
+				result.stats.timestamps++
+				result.stats.lastTimestamp = ts
+			}
+			result.validSize = p.Offset
+			continue
+		}
+		r, ok := p.Data.(*reply.MultiBulkReply)
+		if !ok || len(r.Args) == 0 || p.Inline {
+			result.errIndex = index
+			result.err = errors.New("require multi bulk reply")
+			break
+		}
+		name := strings.ToLower(string(r.Args[0]))
+		if name == "select" {
+			if len(r.Args) != 2 {
+				result.errIndex = index
+				result.err = errors.New("wrong number of arguments for 'select' command")
+				break
+			}
+			dbIndex, err := strconv.Atoi(string(r.Args[1]))
+			if err != nil || dbIndex < 0 {
+				result.errIndex = index
+				result.err = errors.New("invalid DB index: " + string(r.Args[1]))
+				break
+			}
+			currentDB = dbIndex
+		} else {
+			result.stats.byDB[currentDB]++
+		}
+		result.stats.byName[name]++
+		result.stats.commands++
+		result.validSize = p.Offset
+		index++
+	}
+	// 如果一直读到了文件结束但还有剩余的字节，说明最后一条命令不完整（一般是写入时宕机了）
+	if result.err == nil && result.validSize < size {
+		result.errIndex = index
+		result.err = io.ErrUnexpectedEOF
+	}
+	// 遇到错误就不再读取了，把剩下的数据读完让解析协程退出
+	go func() {
+		for range ch {
+		}
+	}()
+	return result
+}
+
+// printStats 打印统计信息
+func printStats(stats *aofStats) {
+	fmt.Printf("commands: %d\n", stats.commands)
+	names := make([]string, 0, len(stats.byName))
+	for name := range stats.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("commands per type:")
+	for _, name := range names {
+		fmt.Printf("  %-16s %d\n", name, stats.byName[name])
+	}
+	dbs := make([]int, 0, len(stats.byDB))
+	for db := range stats.byDB {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	fmt.Println("commands per db:")
+	for _, db := range dbs {
+		fmt.Printf("  db%-14d %d\n", db, stats.byDB[db])
+	}
+	if stats.timestamps > 0 {
+		fmt.Printf("timestamps: %d, last: %s\n",
+			stats.timestamps, time.Unix(stats.lastTimestamp, 0).Format(time.RFC3339))
+	}
+}
+
+func usage() {
+	_, _ = fmt.Fprintln(os.Stderr, "usage: gominicache-check-aof [--fix] [--truncate-to-timestamp <unix>] <file.aof>")
+	flag.PrintDefaults()
+}
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file to the last valid command")
+	truncateTo := flag.Int64("truncate-to-timestamp", 0, "truncate the file before the first timestamp annotation later than this unix time")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	result := checkAof(file, info.Size(), *truncateTo)
+	_ = file.Close()
+
+	printStats(result.stats)
+	fmt.Printf("AOF analyzed: size=%d, ok_up_to=%d, diff=%d\n",
+		info.Size(), result.validSize, info.Size()-result.validSize)
+	if result.err == nil {
+		fmt.Println("AOF is valid")
+		if *truncateTo > 0 {
+			truncateToTimestamp(filename, result.cutSize, *truncateTo)
+		}
+		return
+	}
+	fmt.Printf("first error at offset %d (command #%d): %v\n",
+		result.validSize, result.errIndex, result.err)
+	if !*fix {
+		fmt.Println("AOF is not valid. Use the --fix option to try fixing it.")
+		os.Exit(1)
+	}
+	if err := os.Truncate(filename, result.validSize); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to truncate AOF: "+err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("successfully truncated AOF to %d bytes\n", result.validSize)
+}
+
+// truncateToTimestamp 把 AOF 截断到第一个晚于 ts 的时间戳注释之前，用于时间点恢复
+func truncateToTimestamp(filename string, cutSize int64, ts int64) {
+	if cutSize < 0 {
+		fmt.Printf("no timestamp annotation later than %d, nothing to truncate\n", ts)
+		return
+	}
+	if err := os.Truncate(filename, cutSize); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to truncate AOF: "+err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("successfully truncated AOF to %d bytes (timestamp %d)\n", cutSize, ts)
+}
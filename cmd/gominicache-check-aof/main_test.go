@@ -0,0 +1,132 @@
+package main
+
+import (
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/resp/reply"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// encode 把命令编码成 AOF 中的格式
+func encode(args ...string) string {
+	return string(reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes())
+}
+
+// check 检查字符串形式的 AOF 内容
+func check(content string, truncateTo int64) *checkResult {
+	return checkAof(strings.NewReader(content), int64(len(content)), truncateTo)
+}
+
+func TestCheckAofValid(t *testing.T) {
+	content := encode("set", "a", "1") + "#TS:100\r\n" + encode("select", "1") + encode("set", "b", "2") + encode("del", "b")
+	result := check(content, 0)
+	if result.err != nil || result.errIndex != -1 {
+		t.Fatalf("expected valid aof, got error %v at #%d", result.err, result.errIndex)
+	}
+	if result.validSize != int64(len(content)) {
+		t.Errorf("expected valid size %d, got %d", len(content), result.validSize)
+	}
+	stats := result.stats
+	if stats.commands != 4 || stats.byName["set"] != 2 || stats.byName["select"] != 1 || stats.byName["del"] != 1 {
+		t.Errorf("unexpected command stats %d %v", stats.commands, stats.byName)
+	}
+	if stats.byDB[0] != 1 || stats.byDB[1] != 2 { // SELECT 本身不计入
+		t.Errorf("unexpected db stats %v", stats.byDB)
+	}
+	if stats.timestamps != 1 || stats.lastTimestamp != 100 {
+		t.Errorf("unexpected timestamps %d, last %d", stats.timestamps, stats.lastTimestamp)
+	}
+}
+
+func TestCheckAofTruncatedTail(t *testing.T) {
+	valid := encode("set", "a", "1") + encode("set", "b", "2")
+	tail := encode("set", "c", "3")
+	for _, cut := range []int{1, 4, len(tail) - 1} { // 头部、参数中间、缺少最后的 \n
+		result := check(valid+tail[:cut], 0)
+		if result.err != io.ErrUnexpectedEOF {
+			t.Errorf("cut %d: expected unexpected EOF, got %v", cut, result.err)
+		}
+		if result.errIndex != 2 {
+			t.Errorf("cut %d: expected error at #2, got #%d", cut, result.errIndex)
+		}
+		if result.validSize != int64(len(valid)) {
+			t.Errorf("cut %d: expected valid size %d, got %d", cut, len(valid), result.validSize)
+		}
+	}
+}
+
+func TestCheckAofCorruptHeader(t *testing.T) {
+	valid := encode("set", "a", "1")
+	for _, corrupt := range []string{"*x\r\n", "*-2\r\n", "*2\r\n$x\r\n"} {
+		result := check(valid+corrupt+encode("set", "b", "2"), 0)
+		if result.err == nil || result.err == io.ErrUnexpectedEOF {
+			t.Errorf("%q: expected protocol error, got %v", corrupt, result.err)
+		}
+		if result.errIndex != 1 || result.validSize != int64(len(valid)) {
+			t.Errorf("%q: expected error at #1 offset %d, got #%d offset %d",
+				corrupt, len(valid), result.errIndex, result.validSize)
+		}
+		if result.stats.commands != 1 {
+			t.Errorf("%q: commands after the error should not be counted, got %d", corrupt, result.stats.commands)
+		}
+	}
+}
+
+// TestCheckAofFix --fix 截断到 validSize 以后文件是合法的，并且保留了所有合法的命令
+func TestCheckAofFix(t *testing.T) {
+	valid := encode("set", "a", "1") + "#TS:100\r\n" + encode("set", "b", "2")
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	content := valid + encode("set", "c", "3")[:7]
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result := check(content, 0)
+	if result.validSize != int64(len(valid)) {
+		t.Fatalf("expected truncation offset %d, got %d", len(valid), result.validSize)
+	}
+	if err := os.Truncate(filename, result.validSize); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = check(string(data), 0)
+	if result.err != nil || result.stats.commands != 2 {
+		t.Errorf("expected fixed aof with 2 commands, got %d commands and error %v", result.stats.commands, result.err)
+	}
+}
+
+func TestCheckAofTruncateToTimestamp(t *testing.T) {
+	head := encode("set", "a", "1") + "#TS:100\r\n" + encode("set", "b", "2")
+	content := head + "#TS:200\r\n" + encode("set", "c", "3") + "#TS:300\r\n" + encode("del", "a")
+	result := check(content, 150)
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	if result.cutSize != int64(len(head)) {
+		t.Fatalf("expected cut at %d, got %d", len(head), result.cutSize)
+	}
+	if result := check(content, 300); result.cutSize != -1 { // 没有晚于 300 的时间戳
+		t.Errorf("expected no cut, got %d", result.cutSize)
+	}
+	if result := check(content, 0); result.cutSize != -1 {
+		t.Errorf("expected no cut without --truncate-to-timestamp, got %d", result.cutSize)
+	}
+
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	truncateToTimestamp(filename, result.cutSize, 150)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != head {
+		t.Errorf("expected %q after truncation, got %q", head, data)
+	}
+}
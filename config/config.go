@@ -20,24 +20,71 @@ type ServerProperties struct {
 	RequirePass    string `cfg:"requirepass"`
 	Databases      int    `cfg:"databases"`
 
+	AppendFsync         string `cfg:"appendfsync"`           // 刷盘策略: always, everysec（默认）, no
+	AofBackend          string `cfg:"aof-backend"`           // AOF 存储介质: file（默认）, memory, segmented
+	AofSegmentSize      int    `cfg:"aof-segment-size"`      // segmented 模式下单个文件的大小上限（字节）
+	AofTimestampEnabled bool   `cfg:"aof-timestamp-enabled"` // yes 表示在 AOF 中定期写入时间戳注释
+	AofLoadUntilTime    int    `cfg:"aof-load-until-time"`   // 加载 AOF 时只回放这个时间（Unix 秒）之前的命令，0 表示不限制
+	AofLoadMaxCommands  int    `cfg:"aof-load-max-commands"` // 加载 AOF 时最多回放多少条命令，0 表示不限制；提前停止时启动后立刻用加载到的数据重写 AOF
+
+	LazyfreeLazyEviction  bool `cfg:"lazyfree-lazy-eviction"`   // yes 表示淘汰键时在后台释放
+	LazyfreeLazyExpire    bool `cfg:"lazyfree-lazy-expire"`     // yes 表示删除过期键时在后台释放
+	LazyfreeLazyUserDel   bool `cfg:"lazyfree-lazy-user-del"`   // yes 表示 DEL 和 UNLINK 一样在后台释放
+	LazyfreeLazyUserFlush bool `cfg:"lazyfree-lazy-user-flush"` // yes 表示不带参数的 FLUSHDB/FLUSHALL 在后台释放
+
+	MaxMemory        int    `cfg:"maxmemory"`         // 内存上限（字节，支持 kb/mb/gb 等单位），0 表示不限制
+	MaxMemoryPolicy  string `cfg:"maxmemory-policy"`  // 淘汰策略，默认 noeviction
+	MaxMemorySamples int    `cfg:"maxmemory-samples"` // 每次淘汰时每个数据库采样的键的个数，默认 5
+	LfuLogFactor     int    `cfg:"lfu-log-factor"`    // LFU 计数器的对数因子，越大计数器增长越慢，默认 10
+	LfuDecayTime     int    `cfg:"lfu-decay-time"`    // LFU 计数器每隔多少分钟衰减一次，默认 1，0 表示不衰减
+
+	DictBackend string `cfg:"dict-backend"` // 数据库底层字典: hashtable（默认，分段加锁，支持 SCAN 游标）, concurrent, sync, lsm（数据保存在磁盘上），只有 hashtable 支持 SCAN
+	DictShards  int    `cfg:"dict-shards"`  // hashtable 和 concurrent 字典的分段个数（向上取到 2 的幂），默认分别是 16 和 256
+
+	LsmDir          string `cfg:"lsm-dir"`           // lsm 存储的目录，每个数据库一个子目录，默认 data
+	LsmMemtableSize int    `cfg:"lsm-memtable-size"` // 内存表的大小上限（字节，支持 kb/mb/gb 等单位），默认 4mb
+	LsmSyncWrites   bool   `cfg:"lsm-sync-writes"`   // yes 表示每次写入都把预写日志刷盘
+
+	TieredStorage    bool   `cfg:"tiered-storage"`      // yes 表示把冷数据写到磁盘上
+	TierDir          string `cfg:"tier-dir"`            // 磁盘层的目录，每个数据库一个子目录，默认 tier
+	TierIdleTime     int    `cfg:"tier-idle-time"`      // 空闲超过这么多秒的值写到磁盘上，0 表示只在淘汰时写
+	TierMinValueSize int    `cfg:"tier-min-value-size"` // 小于这个字节数的值不写到磁盘上，默认 64
+	TierSegmentSize  int    `cfg:"tier-segment-size"`   // value log 单个段的大小上限（字节，支持 kb/mb/gb 等单位），默认 64mb
+
+	WriteBehind           bool   `cfg:"write-behind"`             // yes 表示把写入异步地回写到数据源
+	WriteBehindFile       string `cfg:"write-behind-file"`        // 回写的 JSON 文件，默认 writebehind.json
+	WriteBehindBatchSize  int    `cfg:"write-behind-batch-size"`  // 每批最多写出的键的个数，默认 100
+	WriteBehindInterval   int    `cfg:"write-behind-interval"`    // 最长多少毫秒写出一次，默认 1000
+	WriteBehindMaxRetries int    `cfg:"write-behind-max-retries"` // 一批写入失败以后最多重试的次数，默认 10
+
+	ProtoMaxBulkLen        int `cfg:"proto-max-bulk-len"`        // 一个字符串参数最多的字节数（支持 kb/mb/gb 等单位），默认 512mb
+	ClientQueryBufferLimit int `cfg:"client-query-buffer-limit"` // 一条命令最多的字节数（支持 kb/mb/gb 等单位），默认 1gb，超过了关闭连接
+
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"` // 键空间通知的类别，和 Redis 相同，例：KEA、Ex，默认为空表示关闭，可以用 CONFIG SET 修改
+
 	Peers []string `cfg:"peers"`
 	Self  string   `cfg:"self"`
 }
 
+// DefaultLfuDecayTime lfu-decay-time 的默认值（分钟）
+const DefaultLfuDecayTime = 1
+
 // Properties 保存全局配置属性
 var Properties *ServerProperties
 
 func init() {
 	// 默认配置
 	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
+		Bind:         "127.0.0.1",
+		Port:         6379,
+		AppendOnly:   false,
+		LfuDecayTime: DefaultLfuDecayTime,
 	}
 }
 
 func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+	// 0 是有意义的取值，默认值不能在使用的地方补上，先填好再被配置文件覆盖
+	config := &ServerProperties{LfuDecayTime: DefaultLfuDecayTime}
 
 	// 读配置文件
 	rawMap := make(map[string]string)
@@ -76,7 +123,7 @@ func parse(src io.Reader) *ServerProperties {
 			case reflect.String:
 				fieldVal.SetString(value)
 			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
+				intValue, err := parseInt(value)
 				if err == nil {
 					fieldVal.SetInt(intValue)
 				}
@@ -94,6 +141,34 @@ func parse(src io.Reader) *ServerProperties {
 	return config
 }
 
+// memoryUnits 数字后面可以跟的单位（和 Redis 一致，k 是 1000，kb 是 1024）
+var memoryUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"k", 1000},
+	{"m", 1000 * 1000},
+	{"g", 1000 * 1000 * 1000},
+}
+
+// parseInt 解析整数配置，例：1024, 100mb, 1gb
+func parseInt(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(lower, unit.suffix) {
+			n, err := strconv.ParseInt(lower[:len(lower)-len(unit.suffix)], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * unit.scale, nil
+		}
+	}
+	return strconv.ParseInt(lower, 10, 64)
+}
+
 // SetupConfig 读取配置文件并将属性存储到 Properties
 func SetupConfig(configFilename string) {
 	file, err := os.Open(configFilename)
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/resp/connection"
+	"path/filepath"
+	"testing"
+)
+
+// TestAofPartialLoadRewrite 按条数恢复以后 AOF 被重写成加载到的数据，不带限制重启时不会回放剩下的命令
+func TestAofPartialLoadRewrite(t *testing.T) {
+	saved := *config.Properties
+	defer func() { *config.Properties = saved }()
+	config.Properties.AppendOnly = true
+	config.Properties.AofBackend = "file"
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+
+	c := &connection.Connection{}
+	mdb := NewDatabase()
+	execLine(mdb, c, "set", "a", "1")
+	execLine(mdb, c, "set", "b", "2")
+	execLine(mdb, c, "set", "c", "3")
+	mdb.Close()
+
+	config.Properties.AofLoadMaxCommands = 2
+	mdb = NewDatabase()
+	assertReply(t, execLine(mdb, c, "dbsize"), ":2\r\n")
+	assertReply(t, execLine(mdb, c, "exists", "c"), ":0\r\n")
+	mdb.Close()
+
+	config.Properties.AofLoadMaxCommands = 0
+	mdb = NewDatabase()
+	defer mdb.Close()
+	assertReply(t, execLine(mdb, c, "dbsize"), ":2\r\n")
+	assertReply(t, execLine(mdb, c, "get", "b"), "$1\r\n2\r\n")
+	assertReply(t, execLine(mdb, c, "exists", "c"), ":0\r\n")
+}
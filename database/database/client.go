@@ -0,0 +1,216 @@
+package database
+
+/*
+ * CLIENT 命令：ID、SETNAME、GETNAME、TRACKING、CACHING、GETREDIR、TRACKINGINFO
+ * 开启了客户端缓存以后，读命令记下读的键，写命令执行成功以后通知缓存了这些键的客户端
+ */
+
+import (
+	"GoMiniCache/database/structure"
+	"GoMiniCache/database/tracking"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/notify"
+	"GoMiniCache/resp/connection"
+	"GoMiniCache/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// lookupClient 按编号找到打开的客户端连接
+func lookupClient(id int64) (resp.Connection, bool) {
+	c, ok := connection.Lookup(id)
+	if !ok {
+		return nil, false
+	}
+	return c, true
+}
+
+// execTracking 有客户端开启了客户端缓存时执行命令
+func (mdb *Database) execTracking(c resp.Connection, cmdName string, cmdLine [][]byte) resp.Reply {
+	if cmdName != "client" { // CLIENT CACHING 只对下一个命令有效
+		defer mdb.tracking.EndCommand(c)
+	}
+	flags := structure.CommandFlags(cmdName)
+	if flags&structure.FlagReadOnly != 0 {
+		return mdb.tracking.Read(c, structure.CommandKeys(cmdLine), func() resp.Reply {
+			return mdb.exec(c, cmdName, cmdLine)
+		})
+	}
+	result := mdb.exec(c, cmdName, cmdLine)
+	if _, ok := result.(reply.ErrorReply); ok {
+		return result
+	}
+	switch {
+	case cmdName == "flushall" || cmdName == "flushdb" || cmdName == "swapdb":
+		mdb.tracking.InvalidateAll()
+	case cmdName == "move":
+		mdb.tracking.Invalidate(c, []string{string(cmdLine[1])})
+	case cmdName == "copy":
+		mdb.tracking.Invalidate(c, []string{string(cmdLine[2])})
+	case flags&structure.FlagWrite != 0:
+		mdb.tracking.Invalidate(c, structure.CommandKeys(cmdLine))
+	}
+	return result
+}
+
+// invalidateEvent 键过期或者被淘汰时通知缓存了它的客户端
+// 事件可能在读命令持有客户端的锁时发出（读到过期的键），所以在另一个协程里通知
+func (mdb *Database) invalidateEvent(event notify.Event) {
+	if event.Class&(notify.Expired|notify.Evicted) == 0 || !mdb.tracking.Active() {
+		return
+	}
+	go mdb.tracking.Invalidate(nil, []string{event.Key})
+}
+
+// execClient 例：CLIENT ID，CLIENT SETNAME name，CLIENT TRACKING on|off [options]，CLIENT CACHING yes|no
+func execClient(mdb *Database, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply("client")
+	}
+	sub := strings.ToLower(string(cmdLine[1]))
+	args := cmdLine[2:]
+	switch sub {
+	case "id":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|id")
+		}
+		return reply.MakeIntReply(c.GetID())
+	case "setname":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("client|setname")
+		}
+		if errReply := checkClientName(args[0]); errReply != nil {
+			return errReply
+		}
+		c.SetName(string(args[0]))
+		return reply.MakeOkReply()
+	case "getname":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|getname")
+		}
+		if c.GetName() == "" {
+			return reply.MakeNullReply()
+		}
+		return reply.MakeBulkReply([]byte(c.GetName()))
+	case "tracking":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply("client|tracking")
+		}
+		return execClientTracking(mdb, c, args)
+	case "caching":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("client|caching")
+		}
+		var yes bool
+		switch strings.ToLower(string(args[0])) {
+		case "yes":
+			yes = true
+		case "no":
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+		if err := mdb.tracking.SetCaching(c, yes); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	case "getredir":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|getredir")
+		}
+		info, ok := mdb.tracking.Info(c)
+		if !ok {
+			return reply.MakeIntReply(-1)
+		}
+		return reply.MakeIntReply(info.Redirect)
+	case "trackinginfo":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|trackinginfo")
+		}
+		return trackingInfoReply(mdb.tracking.Info(c))
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + sub + "'. Try CLIENT HELP.")
+	}
+}
+
+// execClientTracking 例：CLIENT TRACKING on|off [REDIRECT id] [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func execClientTracking(mdb *Database, c resp.Connection, args [][]byte) resp.Reply {
+	var opts tracking.Options
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "redirect":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			id, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || id <= 0 {
+				return reply.MakeErrReply("ERR The client ID you want redirect to does not exist")
+			}
+			opts.Redirect = id
+			i++
+		case "prefix":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			opts.Prefixes = append(opts.Prefixes, string(args[i+1]))
+			i++
+		case "bcast":
+			opts.BCast = true
+		case "optin":
+			opts.OptIn = true
+		case "optout":
+			opts.OptOut = true
+		case "noloop":
+			opts.NoLoop = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "on":
+		if err := mdb.tracking.Enable(c, opts); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+	case "off":
+		mdb.tracking.Disable(c)
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	return reply.MakeOkReply()
+}
+
+// trackingInfoReply 编码 CLIENT TRACKINGINFO 的回复：{flags: [...], redirect: id, prefixes: [...]}
+func trackingInfoReply(info tracking.Info, on bool) resp.Reply {
+	var flags []resp.Reply
+	redirect := int64(-1)
+	if !on {
+		flags = append(flags, reply.MakeBulkReply([]byte("off")))
+	} else {
+		flags = append(flags, reply.MakeBulkReply([]byte("on")))
+		redirect = info.Redirect
+		for _, flag := range []struct {
+			set  bool
+			name string
+		}{
+			{info.BCast, "bcast"},
+			{info.OptIn, "optin"},
+			{info.OptOut, "optout"},
+			{info.Caching == "yes", "caching-yes"},
+			{info.Caching == "no", "caching-no"},
+			{info.NoLoop, "noloop"},
+			{info.BrokenRedirect, "broken_redirect"},
+		} {
+			if flag.set {
+				flags = append(flags, reply.MakeBulkReply([]byte(flag.name)))
+			}
+		}
+	}
+	prefixes := make([][]byte, len(info.Prefixes))
+	for i, prefix := range info.Prefixes {
+		prefixes[i] = []byte(prefix)
+	}
+	return reply.MakeMapReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("flags")), reply.MakeSetReply(flags),
+		reply.MakeBulkReply([]byte("redirect")), reply.MakeIntReply(redirect),
+		reply.MakeBulkReply([]byte("prefixes")), reply.MakeMultiBulkReply(prefixes),
+	})
+}
@@ -0,0 +1,82 @@
+package database
+
+/*
+ * CONFIG GET / CONFIG SET：目前只有运行时可以修改的配置项
+ */
+
+import (
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/wildcard"
+	"GoMiniCache/resp/reply"
+	"strings"
+)
+
+// configParam 一个可以在运行时查看和修改的配置项
+type configParam struct {
+	name string
+	get  func(mdb *Database) string
+	set  func(mdb *Database, value string) error
+}
+
+// configParams 按名称排序
+var configParams = []configParam{
+	{
+		name: "notify-keyspace-events",
+		get:  (*Database).NotifyKeyspaceEvents,
+		set:  (*Database).SetNotifyKeyspaceEvents,
+	},
+}
+
+// findConfigParam 按名称查找配置项，名称不区分大小写
+func findConfigParam(name string) *configParam {
+	name = strings.ToLower(name)
+	for i := range configParams {
+		if configParams[i].name == name {
+			return &configParams[i]
+		}
+	}
+	return nil
+}
+
+// execConfig 例：CONFIG GET pattern [pattern ...]，CONFIG SET parameter value [parameter value ...]
+func execConfig(mdb *Database, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply("config")
+	}
+	args := cmdLine[2:]
+	switch strings.ToLower(string(cmdLine[1])) {
+	case "get":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply("config|get")
+		}
+		var result []resp.Reply
+		for _, param := range configParams {
+			for _, arg := range args {
+				if wildcard.CompilePattern(strings.ToLower(string(arg))).IsMatch(param.name) {
+					result = append(result, reply.MakeBulkReply([]byte(param.name)), reply.MakeBulkReply([]byte(param.get(mdb))))
+					break
+				}
+			}
+		}
+		return reply.MakeMapReply(result)
+	case "set":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return reply.MakeArgNumErrReply("config|set")
+		}
+		params := make([]*configParam, 0, len(args)/2)
+		for i := 0; i < len(args); i += 2 { // 先检查所有的名称，不认识的名称一个都不修改
+			param := findConfigParam(string(args[i]))
+			if param == nil {
+				return reply.MakeErrReply("ERR Unknown option or number of arguments for CONFIG SET - '" + string(args[i]) + "'")
+			}
+			params = append(params, param)
+		}
+		for i, param := range params {
+			if err := param.set(mdb, string(args[i*2+1])); err != nil {
+				return reply.MakeErrReply("ERR CONFIG SET failed (possibly related to argument '" + param.name + "') - " + err.Error())
+			}
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(cmdLine[1]) + "'. Try CONFIG GET, CONFIG SET.")
+}
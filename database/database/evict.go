@@ -0,0 +1,247 @@
+package database
+
+/*
+ * 内存淘汰：used memory 超过 maxmemory 时，在执行写命令之前按 maxmemory-policy 删除一些键
+ * LRU/LFU/TTL 都是近似的：每轮从每个数据库随机取 maxmemory-samples 个键放进淘汰池，淘汰池里分数最高的键先被删除
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/database/structure"
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/notify"
+	"GoMiniCache/resp/reply"
+	"math"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+)
+
+// 淘汰策略
+const (
+	PolicyNoEviction     = "noeviction"
+	PolicyAllKeysLRU     = "allkeys-lru"
+	PolicyAllKeysLFU     = "allkeys-lfu"
+	PolicyAllKeysRandom  = "allkeys-random"
+	PolicyVolatileLRU    = "volatile-lru"
+	PolicyVolatileLFU    = "volatile-lfu"
+	PolicyVolatileRandom = "volatile-random"
+	PolicyVolatileTTL    = "volatile-ttl"
+)
+
+const (
+	evictionPoolSize       = 16 // 淘汰池的大小
+	defaultEvictionSamples = 5  // maxmemory-samples 的默认值
+)
+
+// oomReply 内存不够并且无法淘汰时，拒绝会增加内存的命令
+var oomReply = reply.MakeErrReply("OOM command not allowed when used memory > 'maxmemory'.")
+
+// crossDBCommandFlags 在 Database 这一层处理的命令的标记，其他命令的标记在 structure.CmdTable 里
+var crossDBCommandFlags = map[string]int{
+	"flushall": structure.FlagWrite,
+	"swapdb":   structure.FlagWrite,
+	"move":     structure.FlagWrite,
+	"copy":     structure.FlagWrite | structure.FlagDenyOOM,
+}
+
+// evictionCandidate 淘汰池里的候选键，分数越高越先被淘汰
+type evictionCandidate struct {
+	db    *structure.DB
+	key   string
+	score int64
+}
+
+// commandFlags 返回命令的标记
+func commandFlags(cmdName string) int {
+	if flags, ok := crossDBCommandFlags[cmdName]; ok {
+		return flags
+	}
+	return structure.CommandFlags(cmdName)
+}
+
+// checkMemory 执行写命令之前检查内存，需要时淘汰一些键；淘汰不掉并且命令会增加内存时返回 OOM 错误
+// 和 Redis 一样，DEL 这类只会减少内存的写命令总是允许执行
+func (mdb *Database) checkMemory(cmdName string) *reply.StandardErrReply {
+	if config.Properties.MaxMemory <= 0 || mdb.loading.Get() {
+		return nil
+	}
+	flags := commandFlags(cmdName)
+	if flags&structure.FlagWrite == 0 {
+		return nil
+	}
+	if !mdb.freeMemoryIfNeeded() && flags&structure.FlagDenyOOM != 0 {
+		return oomReply
+	}
+	return nil
+}
+
+// UsedMemory 返回所有数据库估算占用的内存
+func (mdb *Database) UsedMemory() int64 {
+	mdb.dbSetMu.RLock()
+	defer mdb.dbSetMu.RUnlock()
+	var used int64
+	for _, db := range mdb.dbSet {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// EvictedKeys 返回因为内存不够而被淘汰的键的个数
+func (mdb *Database) EvictedKeys() int64 {
+	return atomic.LoadInt64(&mdb.evictedKeys)
+}
+
+// freeMemoryIfNeeded 淘汰键直到内存降到 maxmemory 以下，返回是否成功
+func (mdb *Database) freeMemoryIfNeeded() bool {
+	maxMemory := int64(config.Properties.MaxMemory)
+	if mdb.UsedMemory() <= maxMemory {
+		return true
+	}
+	policy := strings.ToLower(config.Properties.MaxMemoryPolicy)
+	mdb.evictMu.Lock()
+	defer mdb.evictMu.Unlock()
+	for mdb.UsedMemory() > maxMemory {
+		var db *structure.DB
+		var key string
+		var ok bool
+		switch policy {
+		case PolicyAllKeysRandom, PolicyVolatileRandom:
+			db, key, ok = mdb.randomCandidate(policy == PolicyVolatileRandom)
+		case PolicyAllKeysLRU, PolicyAllKeysLFU, PolicyVolatileLRU, PolicyVolatileLFU, PolicyVolatileTTL:
+			db, key, ok = mdb.poolCandidate(policy)
+		default: // noeviction 以及不认识的策略
+			return false
+		}
+		if !ok {
+			return false
+		}
+		if db.Spill(key) { // 开启了磁盘层时先把值写到磁盘上，只有已经在磁盘上的键才会被删除
+			continue
+		}
+		if mdb.writeBehind != nil { // 淘汰不回写删除，还没写出的修改要在键被删除之前读出来
+			mdb.writeBehind.Retain(db.Index, key)
+		}
+		if db.RemoveEntity(key, config.Properties.LazyfreeLazyEviction) {
+			db.AddAofLocal(utils.ToCmdLine("del", key))
+			db.Notify(notify.Evicted, "evicted", key)
+			atomic.AddInt64(&mdb.evictedKeys, 1)
+		}
+	}
+	return true
+}
+
+// snapshotDBs 拷贝一份数据库列表，避免淘汰时一直持有 dbSetMu
+func (mdb *Database) snapshotDBs() []*structure.DB {
+	mdb.dbSetMu.RLock()
+	defer mdb.dbSetMu.RUnlock()
+	dbSet := make([]*structure.DB, len(mdb.dbSet))
+	copy(dbSet, mdb.dbSet)
+	return dbSet
+}
+
+// randomCandidate 从下一个非空的数据库里随机挑一个键，volatile 表示只挑设置了过期时间的键
+func (mdb *Database) randomCandidate(volatile bool) (*structure.DB, string, bool) {
+	dbSet := mdb.snapshotDBs()
+	for i := 0; i < len(dbSet); i++ {
+		mdb.nextEvictDB = (mdb.nextEvictDB + 1) % len(dbSet) // 轮流从每个数据库淘汰
+		db := dbSet[mdb.nextEvictDB]
+		var keys []string
+		if volatile {
+			keys = db.RandomVolatileKeys(1)
+		} else {
+			keys = db.SampleKeys(1)
+		}
+		if len(keys) > 0 {
+			return db, keys[0], true
+		}
+	}
+	return nil, "", false
+}
+
+// poolCandidate 采样填充淘汰池，然后取出分数最高并且还存在的键
+func (mdb *Database) poolCandidate(policy string) (*structure.DB, string, bool) {
+	samples := config.Properties.MaxMemorySamples
+	if samples <= 0 {
+		samples = defaultEvictionSamples
+	}
+	volatile := strings.HasPrefix(policy, "volatile-")
+	for _, db := range mdb.snapshotDBs() {
+		var keys []string
+		if volatile {
+			keys = db.RandomVolatileKeys(samples)
+		} else {
+			keys = db.SampleKeys(samples)
+		}
+		for _, key := range keys {
+			score, ok := evictionScore(db, key, policy)
+			if ok {
+				mdb.poolInsert(&evictionCandidate{db: db, key: key, score: score})
+			}
+		}
+	}
+	// 淘汰池按分数从低到高排列，从后往前取；池里的键可能已经被删除了，需要再检查一次
+	for len(mdb.evictionPool) > 0 {
+		last := len(mdb.evictionPool) - 1
+		candidate := mdb.evictionPool[last]
+		mdb.evictionPool[last] = nil
+		mdb.evictionPool = mdb.evictionPool[:last]
+		if _, exists := candidate.db.PeekEntity(candidate.key); exists {
+			return candidate.db, candidate.key, true
+		}
+	}
+	return nil, "", false
+}
+
+// evictionScore 计算键的淘汰分数：LRU 用空闲时间，LFU 用计数器的反数，TTL 用剩余存活时间的反数
+func evictionScore(db *structure.DB, key string, policy string) (int64, bool) {
+	entity, exists := db.PeekEntity(key)
+	if !exists {
+		return 0, false
+	}
+	if structure.IsColdEntity(entity) { // 值已经在磁盘上，删除它释放不了多少内存，最后再考虑
+		return 0, true
+	}
+	switch policy {
+	case PolicyAllKeysLRU, PolicyVolatileLRU:
+		return structure.IdleTime(entity), true
+	case PolicyAllKeysLFU, PolicyVolatileLFU:
+		return 255 - int64(structure.LFUCount(entity)), true
+	case PolicyVolatileTTL:
+		expireAt, ok := db.ExpireTime(key)
+		if !ok {
+			return 0, false
+		}
+		return math.MaxInt64 - expireAt.UnixNano(), true // 越早过期分数越高
+	}
+	return rand.Int63(), true
+}
+
+// poolInsert 把候选键按分数插入淘汰池，池满时挤掉分数最低的键；同一个键只保留最新的分数
+func (mdb *Database) poolInsert(candidate *evictionCandidate) {
+	pool := mdb.evictionPool
+	for i, c := range pool {
+		if c.db == candidate.db && c.key == candidate.key {
+			pool = append(pool[:i], pool[i+1:]...)
+			break
+		}
+	}
+	i := 0
+	for i < len(pool) && pool[i].score < candidate.score {
+		i++
+	}
+	if len(pool) >= evictionPoolSize {
+		if i == 0 { // 比池里所有的键分数都低
+			mdb.evictionPool = pool
+			return
+		}
+		copy(pool, pool[1:i]) // 挤掉分数最低的键
+		pool[i-1] = candidate
+		mdb.evictionPool = pool
+		return
+	}
+	pool = append(pool, nil)
+	copy(pool[i+1:], pool[i:])
+	pool[i] = candidate
+	mdb.evictionPool = pool
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/resp/connection"
+	"testing"
+	"time"
+)
+
+// limitMemory 把 maxmemory 设成比当前用量少一个字节，下一个写命令之前要淘汰一个键；测试结束后恢复配置
+func limitMemory(t *testing.T, mdb *Database, policy string) {
+	saved := *config.Properties
+	t.Cleanup(func() { *config.Properties = saved })
+	config.Properties.MaxMemory = int(mdb.UsedMemory()) - 1
+	config.Properties.MaxMemoryPolicy = policy
+	config.Properties.MaxMemorySamples = 100 // 比键的个数多，每次都能采到所有的键
+}
+
+// setupEvictKeys 写入 a 到 e 五个键
+func setupEvictKeys(mdb *Database, c *connection.Connection) {
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		execLine(mdb, c, "set", key, "value-"+key)
+	}
+}
+
+// assertEvicted 检查只有 victim 被淘汰了
+func assertEvicted(t *testing.T, mdb *Database, c *connection.Connection, victim string) {
+	t.Helper()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		expected := ":1\r\n"
+		if key == victim {
+			expected = ":0\r\n"
+		}
+		assertReply(t, execLine(mdb, c, "exists", key), expected)
+	}
+	if evicted := mdb.EvictedKeys(); evicted != 1 {
+		t.Errorf("expected 1 evicted key, got %d", evicted)
+	}
+}
+
+func TestEvictNoEviction(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	setupEvictKeys(mdb, c)
+	limitMemory(t, mdb, PolicyNoEviction)
+	assertReply(t, execLine(mdb, c, "set", "new", "v"), "-OOM command not allowed when used memory > 'maxmemory'.\r\n")
+	assertReply(t, execLine(mdb, c, "get", "a"), "$7\r\nvalue-a\r\n") // 读命令不受影响
+	assertReply(t, execLine(mdb, c, "del", "a"), ":1\r\n")            // 只会减少内存的写命令总是允许
+}
+
+func TestEvictAllKeysLRU(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	setupEvictKeys(mdb, c)
+	entity, _ := mdb.selectDB(0).PeekEntity("c")
+	entity.AccessTime -= int64(time.Hour / time.Millisecond)
+	limitMemory(t, mdb, PolicyAllKeysLRU)
+	assertReply(t, execLine(mdb, c, "set", "new", "v"), "+OK\r\n")
+	assertEvicted(t, mdb, c, "c")
+}
+
+func TestEvictAllKeysLFU(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	setupEvictKeys(mdb, c)
+	db := mdb.selectDB(0)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		entity, _ := db.PeekEntity(key)
+		entity.LFUCounter = 100
+	}
+	entity, _ := db.PeekEntity("d")
+	entity.LFUCounter = 0
+	limitMemory(t, mdb, PolicyAllKeysLFU)
+	assertReply(t, execLine(mdb, c, "set", "new", "v"), "+OK\r\n")
+	assertEvicted(t, mdb, c, "d")
+}
+
+func TestEvictVolatileTTL(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	setupEvictKeys(mdb, c)
+	execLine(mdb, c, "expire", "a", "1000")
+	execLine(mdb, c, "expire", "b", "100") // 最早过期
+	limitMemory(t, mdb, PolicyVolatileTTL)
+	assertReply(t, execLine(mdb, c, "set", "new", "v"), "+OK\r\n")
+	assertEvicted(t, mdb, c, "b")
+}
+
+func TestEvictVolatileWithoutTTL(t *testing.T) {
+	for _, policy := range []string{PolicyVolatileLRU, PolicyVolatileLFU, PolicyVolatileRandom, PolicyVolatileTTL} {
+		mdb := NewDatabase()
+		c := &connection.Connection{}
+		setupEvictKeys(mdb, c)
+		limitMemory(t, mdb, policy)
+		// 所有的键都没有过期时间，volatile 策略没有可以淘汰的键
+		assertReply(t, execLine(mdb, c, "set", "new", "v"), "-OOM command not allowed when used memory > 'maxmemory'.\r\n")
+		assertReply(t, execLine(mdb, c, "dbsize"), ":5\r\n")
+		mdb.Close()
+	}
+}
+
+func TestEvictAllKeysRandom(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	setupEvictKeys(mdb, c)
+	limitMemory(t, mdb, PolicyAllKeysRandom)
+	assertReply(t, execLine(mdb, c, "set", "new", "v"), "+OK\r\n")
+	if evicted := mdb.EvictedKeys(); evicted != 1 {
+		t.Errorf("expected 1 evicted key, got %d", evicted)
+	}
+	assertReply(t, execLine(mdb, c, "dbsize"), ":5\r\n") // 淘汰了一个键，写入了一个键
+}
@@ -0,0 +1,63 @@
+package database
+
+/*
+ * 过期键的定期删除（active expire）：和 Redis 的 activeExpireCycle 一样，后台任务每 100 毫秒从每个数据库
+ * 随机检查一些设置了过期时间的键，删除已经过期的；一轮中超过 1/4 的键已经过期时继续下一轮
+ * 只靠读取时的惰性删除的话，写入以后不再被读取的键会一直占用内存
+ */
+
+import (
+	"GoMiniCache/database/structure"
+	"time"
+)
+
+const (
+	expireCronInterval = 100 * time.Millisecond
+	expireSamples      = 20 // 每轮从每个数据库检查的键的个数
+	expireMaxRounds    = 16 // 每次最多检查的轮数，一轮中超过 1/4 的键已经过期时继续下一轮
+)
+
+// startExpireCron 启动定期删除的后台任务
+func (mdb *Database) startExpireCron() {
+	mdb.stopExpire = make(chan struct{})
+	go mdb.expireCron()
+}
+
+// stopExpireCron 停止定期删除的后台任务
+func (mdb *Database) stopExpireCron() {
+	if mdb.stopExpire != nil {
+		close(mdb.stopExpire)
+	}
+}
+
+// expireCron 后台任务：定期删除过期的键
+func (mdb *Database) expireCron() {
+	ticker := time.NewTicker(expireCronInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mdb.stopExpire:
+			return
+		case <-ticker.C:
+		}
+		if mdb.loading.Get() { // 加载 AOF 期间不删除
+			continue
+		}
+		for _, db := range mdb.snapshotDBs() {
+			for round := 0; round < expireMaxRounds; round++ {
+				if !mdb.expireRound(db) {
+					break
+				}
+			}
+		}
+	}
+}
+
+// expireRound 持有 execMu 的读锁检查一轮，和命令中的惰性删除一样，不会和 SWAPDB、AOF 重写的快照同时执行
+// 返回是否需要继续下一轮
+func (mdb *Database) expireRound(db *structure.DB) bool {
+	mdb.execMu.RLock()
+	defer mdb.execMu.RUnlock()
+	sampled, expired := db.ExpireSample(expireSamples)
+	return sampled == expireSamples && expired > expireSamples/4
+}
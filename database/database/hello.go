@@ -0,0 +1,134 @@
+package database
+
+/*
+ * 连接的握手和密码验证：HELLO、AUTH
+ * 设置了 requirepass 时，没有通过验证的连接只能执行 AUTH 和 HELLO
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/reply"
+	"crypto/subtle"
+	"strconv"
+	"strings"
+)
+
+// defaultUser 没有 ACL，只有这一个用户
+const defaultUser = "default"
+
+// HELLO 回复中的服务器信息，客户端按它们判断可以使用哪些特性，所以和 Redis 7 保持一致
+const (
+	helloServer  = "redis"
+	helloVersion = "7.0.0"
+)
+
+// checkAuth 设置了 requirepass 时，没有通过验证的连接只能执行 AUTH 和 HELLO
+func checkAuth(c resp.Connection, cmdName string) resp.Reply {
+	if config.Properties.RequirePass == "" || c.IsAuthenticated() || cmdName == "auth" || cmdName == "hello" {
+		return nil
+	}
+	return reply.MakeErrReply("NOAUTH Authentication required.")
+}
+
+// authenticate 验证用户名和密码，成功时把连接标记为已验证
+func authenticate(c resp.Connection, username string, password []byte) resp.Reply {
+	requirePass := config.Properties.RequirePass
+	ok := username == defaultUser &&
+		(requirePass == "" || subtle.ConstantTimeCompare(password, []byte(requirePass)) == 1)
+	if !ok {
+		return reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	c.SetAuthenticated(true)
+	return nil
+}
+
+// execAuth 例：AUTH password，AUTH username password
+func execAuth(c resp.Connection, args [][]byte) resp.Reply {
+	switch len(args) {
+	case 1:
+		if config.Properties.RequirePass == "" {
+			return reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. " +
+				"Are you sure your configuration is correct?")
+		}
+		if errReply := authenticate(c, defaultUser, args[0]); errReply != nil {
+			return errReply
+		}
+	case 2:
+		if errReply := authenticate(c, string(args[0]), args[1]); errReply != nil {
+			return errReply
+		}
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	return reply.MakeOkReply()
+}
+
+// checkClientName 客户端的名称只能包含可见字符，不能有空格
+func checkClientName(name []byte) resp.Reply {
+	for _, b := range name {
+		if b < '!' || b > '~' {
+			return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+	}
+	return nil
+}
+
+// execHello 切换协议版本并返回服务器信息，例：HELLO [protover [AUTH username password] [SETNAME clientname]]
+func execHello(c resp.Connection, args [][]byte) resp.Reply {
+	protocol := c.GetProtocol()
+	if len(args) > 0 {
+		version, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return reply.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if version != 2 && version != 3 {
+			return reply.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		protocol = version
+	}
+
+	var auth [][]byte
+	var name []byte
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "auth" && i+2 < len(args):
+			auth = args[i+1 : i+3]
+			i += 2
+		case option == "setname" && i+1 < len(args):
+			name = args[i+1]
+			if errReply := checkClientName(name); errReply != nil {
+				return errReply
+			}
+			i++
+		default:
+			return reply.MakeErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+
+	if auth != nil {
+		if errReply := authenticate(c, string(auth[0]), auth[1]); errReply != nil {
+			return errReply
+		}
+	}
+	if config.Properties.RequirePass != "" && !c.IsAuthenticated() {
+		return reply.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+	}
+	if name != nil {
+		c.SetName(string(name))
+	}
+	c.SetProtocol(protocol)
+
+	return reply.MakeMapReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("server")), reply.MakeBulkReply([]byte(helloServer)),
+		reply.MakeBulkReply([]byte("version")), reply.MakeBulkReply([]byte(helloVersion)),
+		reply.MakeBulkReply([]byte("proto")), reply.MakeIntReply(int64(protocol)),
+		reply.MakeBulkReply([]byte("id")), reply.MakeIntReply(c.GetID()),
+		reply.MakeBulkReply([]byte("mode")), reply.MakeBulkReply([]byte("standalone")),
+		reply.MakeBulkReply([]byte("role")), reply.MakeBulkReply([]byte("master")),
+		reply.MakeBulkReply([]byte("modules")), reply.MakeEmptyMultiBulkReply(),
+	})
+}
@@ -0,0 +1,154 @@
+package database
+
+/*
+ * 跨数据库的键空间命令: FLUSHALL, SWAPDB, MOVE, COPY
+ * SWAPDB、MOVE、COPY 要同时操作两个数据库，独占执行（见 isExclusiveCommand），和 Redis 单线程执行的效果一样
+ */
+
+import (
+	"GoMiniCache/database/structure"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/notify"
+	"GoMiniCache/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// isExclusiveCommand 返回命令是否需要独占执行
+func isExclusiveCommand(cmdName string) bool {
+	return cmdName == "swapdb" || cmdName == "move" || cmdName == "copy"
+}
+
+// parseDBIndex 解析数据库编号并检查范围
+func (mdb *Database) parseDBIndex(arg []byte) (int, resp.Reply) {
+	dbIndex, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR invalid DB index")
+	}
+	if dbIndex < 0 || dbIndex >= len(mdb.dbSet) {
+		return 0, reply.MakeErrReply("ERR DB index is out of range")
+	}
+	return dbIndex, nil
+}
+
+// execFlushAll 清空所有数据库，例：FLUSHALL [ASYNC|SYNC]
+func execFlushAll(c resp.Connection, mdb *Database, cmdLine [][]byte) resp.Reply {
+	lazy, errReply := structure.ParseFlushMode(cmdLine[1:])
+	if errReply != nil {
+		return errReply
+	}
+	mdb.dbSetMu.RLock()
+	defer mdb.dbSetMu.RUnlock()
+	for _, db := range mdb.dbSet {
+		if lazy {
+			db.FlushAsync()
+		} else {
+			db.Flush()
+		}
+	}
+	mdb.addAof(c.GetDBIndex(), cmdLine)
+	return reply.MakeOkReply()
+}
+
+// execSwapDB 交换两个数据库，例：SWAPDB 0 1
+// 客户端只记录了数据库编号，交换之后所有连接都会立刻看到对方的数据
+// 独占执行：没有命令在执行，也就没有命令在读取 Index（写 AOF、发出通知时都会用到）
+func execSwapDB(c resp.Connection, mdb *Database, cmdLine [][]byte) resp.Reply {
+	first, errReply := mdb.parseDBIndex(cmdLine[1])
+	if errReply != nil {
+		return errReply
+	}
+	second, errReply := mdb.parseDBIndex(cmdLine[2])
+	if errReply != nil {
+		return errReply
+	}
+	if lsmEnabled() { // 每个数据库的目录是固定的，交换以后重启会对不上
+		return reply.MakeErrReply("ERR SWAPDB is not supported with dict-backend lsm")
+	}
+	mdb.dbSetMu.Lock()
+	if first != second {
+		mdb.dbSet[first], mdb.dbSet[second] = mdb.dbSet[second], mdb.dbSet[first]
+		// 编号跟着位置走，这样写 AOF 时用的还是正确的编号
+		mdb.dbSet[first].Index = first
+		mdb.dbSet[second].Index = second
+	}
+	// 在锁里写 AOF，保证交换前后的命令在 AOF 中的顺序正确
+	mdb.addAof(c.GetDBIndex(), cmdLine)
+	mdb.dbSetMu.Unlock()
+	return reply.MakeOkReply()
+}
+
+// execMove 把当前数据库的键移动到另一个数据库，例：MOVE key db
+// 目标数据库已经存在这个键时不移动，返回 0；独占执行，并发的写入不会丢失，值也不会出现在两个数据库中
+func execMove(c resp.Connection, mdb *Database, cmdLine [][]byte) resp.Reply {
+	key := string(cmdLine[1])
+	dstIndex, errReply := mdb.parseDBIndex(cmdLine[2])
+	if errReply != nil {
+		return errReply
+	}
+	if dstIndex == c.GetDBIndex() {
+		return reply.MakeErrReply("ERR source and destination objects are the same")
+	}
+	srcDB := mdb.selectDB(c.GetDBIndex())
+	dstDB := mdb.selectDB(dstIndex)
+	entity, exists := srcDB.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if dstDB.PutIfAbsent(key, entity) == 0 {
+		return reply.MakeIntReply(0)
+	}
+	if expireAt, ok := srcDB.ExpireTime(key); ok { // 过期时间跟着键走
+		dstDB.Expire(key, expireAt)
+	}
+	srcDB.Remove(key)
+	mdb.addAof(c.GetDBIndex(), cmdLine)
+	srcDB.Notify(notify.Generic, "move_from", key)
+	dstDB.Notify(notify.Generic, "move_to", key)
+	return reply.MakeIntReply(1)
+}
+
+// execCopy 把键的值深拷贝到另一个键，例：COPY source destination [DB destination-db] [REPLACE]
+// 目标键已经存在且没有 REPLACE 时不拷贝，返回 0
+func execCopy(c resp.Connection, mdb *Database, cmdLine [][]byte) resp.Reply {
+	src := string(cmdLine[1])
+	dst := string(cmdLine[2])
+	dstIndex := c.GetDBIndex()
+	replace := false
+	for i := 3; i < len(cmdLine); i++ {
+		arg := strings.ToLower(string(cmdLine[i]))
+		if arg == "db" && i+1 < len(cmdLine) {
+			var errReply resp.Reply
+			dstIndex, errReply = mdb.parseDBIndex(cmdLine[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			i++
+		} else if arg == "replace" {
+			replace = true
+		} else {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if src == dst && dstIndex == c.GetDBIndex() {
+		return reply.MakeErrReply("ERR source and destination objects are the same")
+	}
+	srcDB := mdb.selectDB(c.GetDBIndex())
+	dstDB := mdb.selectDB(dstIndex)
+	entity, exists := srcDB.GetEntity(src)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	copied := structure.CopyEntity(entity)
+	expireAt, _ := srcDB.ExpireTime(src) // 没有过期时间时是零值
+	if replace {
+		dstDB.PutEntityTTL(dst, copied, expireAt)
+	} else if dstDB.PutIfAbsent(dst, copied) == 0 {
+		return reply.MakeIntReply(0)
+	} else if !expireAt.IsZero() {
+		dstDB.Expire(dst, expireAt)
+	}
+	mdb.addAof(c.GetDBIndex(), cmdLine)
+	dstDB.Notify(notify.Generic, "copy_to", dst)
+	return reply.MakeIntReply(1)
+}
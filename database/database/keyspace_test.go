@@ -0,0 +1,185 @@
+package database
+
+import (
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/resp/connection"
+	"GoMiniCache/resp/reply"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// execLine 用 c 执行一条命令，例：execLine(mdb, c, "set", "k", "v")
+func execLine(mdb *Database, c resp.Connection, args ...string) resp.Reply {
+	return mdb.Exec(c, utils.ToCmdLine(args...))
+}
+
+// assertReply 比较回复的 RESP2 编码
+func assertReply(t *testing.T, result resp.Reply, expected string) {
+	t.Helper()
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, got %q", expected, result.ToBytes())
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	for _, mode := range []string{"async", "sync"} {
+		mdb := NewDatabase()
+		c := &connection.Connection{}
+		for _, index := range []string{"0", "1", "2"} {
+			execLine(mdb, c, "select", index)
+			execLine(mdb, c, "set", "k", index)
+		}
+		assertReply(t, execLine(mdb, c, "flushall", mode), "+OK\r\n")
+		for _, index := range []string{"0", "1", "2"} {
+			execLine(mdb, c, "select", index)
+			assertReply(t, execLine(mdb, c, "dbsize"), ":0\r\n")
+		}
+		execLine(mdb, c, "set", "k", "v")
+		assertReply(t, execLine(mdb, c, "get", "k"), "$1\r\nv\r\n")
+		mdb.Close()
+	}
+}
+
+func TestSwapDB(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	execLine(mdb, c, "set", "a", "0")
+	execLine(mdb, c, "select", "1")
+	execLine(mdb, c, "set", "b", "1")
+	execLine(mdb, c, "set", "c", "1")
+
+	assertReply(t, execLine(mdb, c, "swapdb", "0", "1"), "+OK\r\n")
+	assertReply(t, execLine(mdb, c, "dbsize"), ":1\r\n")
+	assertReply(t, execLine(mdb, c, "get", "a"), "$1\r\n0\r\n")
+	execLine(mdb, c, "select", "0")
+	assertReply(t, execLine(mdb, c, "dbsize"), ":2\r\n")
+	assertReply(t, execLine(mdb, c, "get", "b"), "$1\r\n1\r\n")
+	for i, db := range mdb.dbSet { // 编号跟着位置走
+		if db.Index != i {
+			t.Errorf("db at %d has index %d", i, db.Index)
+		}
+	}
+
+	assertReply(t, execLine(mdb, c, "swapdb", "0", "0"), "+OK\r\n")
+	assertReply(t, execLine(mdb, c, "swapdb", "0", "x"), "-ERR invalid DB index\r\n")
+	assertReply(t, execLine(mdb, c, "swapdb", "0", "100"), "-ERR DB index is out of range\r\n")
+}
+
+// TestSwapDBConcurrentWrites 交换的同时写入，配合 go test -race 检查
+func TestSwapDBConcurrentWrites(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := &connection.Connection{}
+			execLine(mdb, c, "select", strconv.Itoa(i%2))
+			for n := 0; n < 200; n++ {
+				execLine(mdb, c, "set", strconv.Itoa(n), "v")
+			}
+		}(i)
+	}
+	c := &connection.Connection{}
+	for n := 0; n < 100; n++ {
+		execLine(mdb, c, "swapdb", "0", "1")
+	}
+	wg.Wait()
+}
+
+func TestMove(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	execLine(mdb, c, "set", "k", "v")
+	execLine(mdb, c, "expire", "k", "100")
+	assertReply(t, execLine(mdb, c, "move", "k", "1"), ":1\r\n")
+	assertReply(t, execLine(mdb, c, "exists", "k"), ":0\r\n")
+	assertReply(t, execLine(mdb, c, "move", "k", "1"), ":0\r\n")
+	assertReply(t, execLine(mdb, c, "move", "k", "0"), "-ERR source and destination objects are the same\r\n")
+
+	execLine(mdb, c, "select", "1")
+	assertReply(t, execLine(mdb, c, "get", "k"), "$1\r\nv\r\n")
+	if ttl := execLine(mdb, c, "ttl", "k").(*reply.IntReply).Code; ttl <= 0 {
+		t.Errorf("ttl should move with the key, got %d", ttl)
+	}
+
+	// 目标数据库已经有这个键时不移动
+	execLine(mdb, c, "select", "0")
+	execLine(mdb, c, "set", "k", "other")
+	assertReply(t, execLine(mdb, c, "move", "k", "1"), ":0\r\n")
+	assertReply(t, execLine(mdb, c, "get", "k"), "$5\r\nother\r\n")
+}
+
+// TestMoveConcurrent 多个客户端同时来回移动同一个键，键始终只在一个数据库中
+func TestMoveConcurrent(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	execLine(mdb, &connection.Connection{}, "set", "k", "v")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := &connection.Connection{}
+			execLine(mdb, c, "select", strconv.Itoa(i%2))
+			for n := 0; n < 200; n++ {
+				execLine(mdb, c, "move", "k", strconv.Itoa(1-i%2))
+			}
+		}(i)
+	}
+	wg.Wait()
+	if total := mdb.selectDB(0).Len() + mdb.selectDB(1).Len(); total != 1 {
+		t.Errorf("expected the key in exactly one db, got %d copies", total)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	execLine(mdb, c, "set", "src", "v")
+	assertReply(t, execLine(mdb, c, "copy", "src", "dst"), ":1\r\n")
+	assertReply(t, execLine(mdb, c, "get", "dst"), "$1\r\nv\r\n")
+	assertReply(t, execLine(mdb, c, "copy", "src", "dst"), ":0\r\n")
+	assertReply(t, execLine(mdb, c, "copy", "src", "src"), "-ERR source and destination objects are the same\r\n")
+	assertReply(t, execLine(mdb, c, "copy", "missing", "dst"), ":0\r\n")
+
+	// 深拷贝：修改拷贝不影响原来的值
+	execLine(mdb, c, "set", "dst", "changed")
+	assertReply(t, execLine(mdb, c, "get", "src"), "$1\r\nv\r\n")
+
+	execLine(mdb, c, "set", "src", "new")
+	assertReply(t, execLine(mdb, c, "copy", "src", "dst", "replace"), ":1\r\n")
+	assertReply(t, execLine(mdb, c, "get", "dst"), "$3\r\nnew\r\n")
+
+	assertReply(t, execLine(mdb, c, "copy", "src", "src", "db", "2"), ":1\r\n")
+	execLine(mdb, c, "select", "2")
+	assertReply(t, execLine(mdb, c, "get", "src"), "$3\r\nnew\r\n")
+	assertReply(t, execLine(mdb, c, "copy", "src", "x", "bogus"), "-Err syntax error\r\n")
+}
+
+func TestDBSizeAndRandomKey(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	assertReply(t, execLine(mdb, c, "dbsize"), ":0\r\n")
+	assertReply(t, execLine(mdb, c, "randomkey"), "$-1\r\n")
+	keys := map[string]bool{"a": true, "b": true, "c": true}
+	for key := range keys {
+		execLine(mdb, c, "set", key, "v")
+	}
+	assertReply(t, execLine(mdb, c, "dbsize"), ":3\r\n")
+	for i := 0; i < 10; i++ {
+		result, ok := execLine(mdb, c, "randomkey").(*reply.BulkReply)
+		if !ok || !keys[string(result.Arg)] {
+			t.Fatalf("unexpected randomkey reply %v", result)
+		}
+	}
+	execLine(mdb, c, "del", "a")
+	assertReply(t, execLine(mdb, c, "dbsize"), ":2\r\n")
+}
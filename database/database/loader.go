@@ -0,0 +1,67 @@
+package database
+
+/*
+ * 读穿透：嵌入 GoMiniCache 的程序按键的前缀注册 Loader，GET 未命中时调用它加载值
+ * Loader 在 execMu 外面调用，慢的数据源只让这次 GET 等待，不会挡住其他命令；加载到的值再持有读锁写回数据库，之后 GET 照常执行
+ */
+
+import (
+	"GoMiniCache/database/structure"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/loader"
+	"GoMiniCache/resp/reply"
+)
+
+// RegisterLoader 给键的前缀注册 Loader，前缀已经注册过时替换
+func (mdb *Database) RegisterLoader(prefix string, l loader.Loader, opts loader.Options) {
+	mdb.loaders.Register(prefix, l, opts)
+}
+
+// UnregisterLoader 删除前缀上的 Loader，返回是否注册过
+func (mdb *Database) UnregisterLoader(prefix string) bool {
+	return mdb.loaders.Unregister(prefix)
+}
+
+// LoaderStats 返回读穿透的统计
+func (mdb *Database) LoaderStats() loader.Stats {
+	return mdb.loaders.Stats()
+}
+
+// loadMissing GET 的键不存在并且有匹配的 Loader 时，不持有 execMu 调用 Loader，再持有读锁把值写回数据库
+// Loader 出错时返回错误的回复，否则返回 nil
+func (mdb *Database) loadMissing(c resp.Connection, cmdName string, cmdLine [][]byte) resp.Reply {
+	if cmdName != "get" || len(cmdLine) != 2 {
+		return nil
+	}
+	if c.SubsCount() > 0 && c.GetProtocol() < 3 { // 订阅模式下 GET 会被拒绝
+		return nil
+	}
+	key := string(cmdLine[1])
+	mdb.execMu.RLock()
+	needed := mdb.selectDB(c.GetDBIndex()).NeedsLoad(key)
+	mdb.execMu.RUnlock()
+	if !needed {
+		return nil
+	}
+	val, ttl, found, err := mdb.loaders.Load(c.GetDBIndex(), key)
+	if err != nil {
+		return reply.MakeErrReply("ERR loader: " + err.Error())
+	}
+	if !found {
+		return nil
+	}
+	mdb.execMu.RLock()
+	defer mdb.execMu.RUnlock()
+	mdb.selectDB(c.GetDBIndex()).StoreLoaded(key, val, ttl) // 加载期间 SWAPDB 过也写入连接现在选择的数据库
+	return nil
+}
+
+// forgetMissing 写命令写入的键可能也写进了数据源（例如开启了回写），删除它们的负缓存（持有 execMu）
+func (mdb *Database) forgetMissing(c resp.Connection, cmdName string, cmdLine [][]byte) {
+	if structure.CommandFlags(cmdName)&structure.FlagWrite == 0 {
+		return
+	}
+	for _, key := range structure.CommandKeys(cmdLine) {
+		mdb.loaders.Forget(c.GetDBIndex(), key)
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"GoMiniCache/loader"
+	"GoMiniCache/resp/connection"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetReadThrough(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	var source sync.Map // 模拟的数据源
+	source.Store("user:1", "alice")
+	var calls int64
+	mdb.RegisterLoader("user:", loader.Func(func(key string) ([]byte, time.Duration, error) {
+		atomic.AddInt64(&calls, 1)
+		if key == "user:err" {
+			return nil, 0, errors.New("source is down")
+		}
+		val, ok := source.Load(key)
+		if !ok {
+			return nil, 0, loader.ErrNotFound
+		}
+		return []byte(val.(string)), time.Minute, nil
+	}), loader.Options{NegativeTTL: time.Hour})
+
+	assertReply(t, execLine(mdb, c, "get", "user:1"), "$5\r\nalice\r\n")
+	assertReply(t, execLine(mdb, c, "get", "user:1"), "$5\r\nalice\r\n") // 第二次命中缓存
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("expected the loader to be called once, got %d", n)
+	}
+	if ttl := string(execLine(mdb, c, "ttl", "user:1").ToBytes()); ttl != ":60\r\n" && ttl != ":59\r\n" {
+		t.Errorf("expected the loaded ttl, got %q", ttl)
+	}
+	assertReply(t, execLine(mdb, c, "get", "other"), "$-1\r\n") // 没有匹配的 Loader
+	assertReply(t, execLine(mdb, c, "get", "user:err"), "-ERR loader: source is down\r\n")
+
+	// 数据源里没有的键进入负缓存，写命令写入这个键以后负缓存失效
+	assertReply(t, execLine(mdb, c, "get", "user:2"), "$-1\r\n")
+	source.Store("user:2", "bob")
+	assertReply(t, execLine(mdb, c, "get", "user:2"), "$-1\r\n")
+	if stats := mdb.LoaderStats(); stats.NotFound != 1 || stats.NegativeHits != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	assertReply(t, execLine(mdb, c, "set", "user:2", "x"), "+OK\r\n")
+	assertReply(t, execLine(mdb, c, "del", "user:2"), ":1\r\n")
+	assertReply(t, execLine(mdb, c, "get", "user:2"), "$3\r\nbob\r\n")
+}
+
+// TestSlowLoaderDoesNotHoldExecMu Loader 在 execMu 外面调用，慢的数据源不会挡住独占的命令
+func TestSlowLoaderDoesNotHoldExecMu(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	started, release := make(chan struct{}), make(chan struct{})
+	mdb.RegisterLoader("", loader.Func(func(key string) ([]byte, time.Duration, error) {
+		close(started)
+		<-release
+		return []byte("v"), 0, nil
+	}), loader.Options{})
+
+	c := &connection.Connection{}
+	got := make(chan string)
+	go func() {
+		got <- string(execLine(mdb, c, "get", "k").ToBytes())
+	}()
+	<-started
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		execLine(mdb, &connection.Connection{}, "swapdb", "0", "1")
+		execLine(mdb, &connection.Connection{}, "swapdb", "0", "1")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SWAPDB blocked on a slow loader")
+	}
+	close(release)
+	if result := <-got; result != "$1\r\nv\r\n" {
+		t.Errorf("expected the loaded value, got %q", result)
+	}
+}
@@ -0,0 +1,48 @@
+package database
+
+/*
+ * dict-backend lsm: 每个数据库在 <lsm-dir>/db<编号> 下打开自己的 LSM-tree 存储
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/datastruct/lsm"
+	"GoMiniCache/lib/logger"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const defaultLsmDir = "data"
+
+// lsmEnabled 返回是否使用 LSM-tree 存储
+func lsmEnabled() bool {
+	return strings.EqualFold(config.Properties.DictBackend, "lsm")
+}
+
+// openLSM 给每个数据库打开 LSM-tree 存储
+func (mdb *Database) openLSM() error {
+	dir := config.Properties.LsmDir
+	if dir == "" {
+		dir = defaultLsmDir
+	}
+	opts := lsm.Options{
+		MemtableSize: int64(config.Properties.LsmMemtableSize),
+		SyncWrites:   config.Properties.LsmSyncWrites,
+	}
+	for _, db := range mdb.dbSet {
+		if err := db.OpenLSM(filepath.Join(dir, "db"+strconv.Itoa(db.Index)), opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeLSM 把所有数据库的内存表刷盘并关闭 LSM-tree 存储
+func (mdb *Database) closeLSM() {
+	for _, db := range mdb.snapshotDBs() {
+		if err := db.CloseLSM(); err != nil {
+			logger.Error("lsm: close failed: " + err.Error())
+		}
+	}
+}
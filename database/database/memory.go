@@ -0,0 +1,248 @@
+package database
+
+/*
+ * 实现 MEMORY 命令: MEMORY USAGE key [SAMPLES n], MEMORY STATS, MEMORY DOCTOR
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/database/structure"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/connection"
+	"GoMiniCache/resp/parser"
+	"GoMiniCache/resp/reply"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultUsageSamples = 5 // MEMORY USAGE 默认采样的元素个数
+
+	clientOverhead = 512 // 一个客户端连接除了读缓冲区以外的大致开销（连接对象、解析协程的栈）
+
+	doctorMinMemory       = 5 << 20 // 堆上分配的内存少于这个值时不做检查，小实例的比例没有意义
+	doctorBigKeyBytes     = 1 << 20 // 超过这个大小的键会被 MEMORY DOCTOR 列出来
+	doctorBigKeyCount     = 5       // MEMORY DOCTOR 最多列出的大键个数
+	doctorBigKeySamples   = 1000    // MEMORY DOCTOR 在每个数据库中抽查的键的个数，不遍历整个数据库
+	doctorFragmentation   = 1.4     // 碎片率超过这个值时提示
+	doctorMaxMemoryFactor = 0.9     // 使用的内存超过 maxmemory 的这个比例时提示
+)
+
+// execMemory 根据子命令分发
+func execMemory(c resp.Connection, mdb *Database, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply("memory")
+	}
+	subCmd := strings.ToLower(string(cmdLine[1]))
+	switch subCmd {
+	case "usage":
+		return execMemoryUsage(c, mdb, cmdLine[2:])
+	case "stats":
+		if len(cmdLine) != 2 {
+			return reply.MakeArgNumErrReply("memory|stats")
+		}
+		return mdb.memoryStats().reply()
+	case "doctor":
+		if len(cmdLine) != 2 {
+			return reply.MakeArgNumErrReply("memory|doctor")
+		}
+		return reply.MakeBulkReply([]byte(mdb.memoryDoctor()))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try MEMORY USAGE, MEMORY STATS or MEMORY DOCTOR.")
+}
+
+// execMemoryUsage 返回键占用的内存，例：MEMORY USAGE key [SAMPLES n]
+// 集合类型只采样 n 个元素来估算，n 为 0 时统计所有的元素
+func execMemoryUsage(c resp.Connection, mdb *Database, args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 3 {
+		return reply.MakeArgNumErrReply("memory|usage")
+	}
+	samples := defaultUsageSamples
+	if len(args) == 3 {
+		if strings.ToLower(string(args[1])) != "samples" {
+			return reply.MakeSyntaxErrReply()
+		}
+		n, err := strconv.Atoi(string(args[2]))
+		if err != nil || n < 0 {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		samples = n
+	}
+	db := mdb.selectDB(c.GetDBIndex())
+	size, exists := db.MemoryUsage(string(args[0]), samples)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeIntReply(size)
+}
+
+// dbMemoryStats 一个数据库的内存统计
+type dbMemoryStats struct {
+	index    int
+	keys     int64
+	main     int64 // 主字典的开销
+	expires  int64 // 过期字典的开销
+	dataset  int64 // 键值本身
+	volatile int64 // 设置了过期时间的键的个数
+}
+
+// memoryStats 整个实例的内存统计
+type memoryStats struct {
+	totalAllocated  int64 // Go 堆上分配的内存
+	heapRetained    int64 // 向操作系统申请并且还没有归还的堆内存
+	clients         int64 // 客户端连接的缓冲区
+	aofBuffer       int64 // AOF 还没有写入的命令
+	lazyfreePending int64 // 等待释放的对象个数
+	lazyfreed       int64 // 惰性释放的对象个数
+	dbs             []*dbMemoryStats
+	overhead        int64 // 所有不属于数据本身的开销
+	keys            int64
+	dataset         int64
+}
+
+// memoryStats 统计每个数据库的开销和数据大小，以及客户端、AOF 的缓冲区
+func (mdb *Database) memoryStats() *memoryStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stats := &memoryStats{
+		totalAllocated: int64(ms.HeapAlloc),
+		heapRetained:   int64(ms.HeapSys - ms.HeapReleased),
+		clients:        connection.ActiveClients() * (clientOverhead + parser.ReadBufferSize),
+	}
+	if mdb.aofHandler != nil {
+		stats.aofBuffer = mdb.aofHandler.BufferedBytes()
+	}
+	stats.lazyfreePending, stats.lazyfreed = structure.LazyfreeStats()
+	stats.overhead = stats.clients + stats.aofBuffer
+	for _, db := range mdb.snapshotDBs() {
+		if db.Len() == 0 {
+			continue
+		}
+		main, expires := db.MemoryOverhead()
+		dbStats := &dbMemoryStats{
+			index:    db.Index,
+			keys:     int64(db.Len()),
+			main:     main,
+			expires:  expires,
+			dataset:  db.DatasetMemory(),
+			volatile: int64(db.VolatileLen()),
+		}
+		stats.dbs = append(stats.dbs, dbStats)
+		stats.overhead += main + expires
+		stats.keys += dbStats.keys
+		stats.dataset += dbStats.dataset
+	}
+	return stats
+}
+
+// fragmentation 返回碎片率：申请的堆内存和实际使用的堆内存之比
+func (stats *memoryStats) fragmentation() float64 {
+	if stats.totalAllocated == 0 {
+		return 0
+	}
+	return float64(stats.heapRetained) / float64(stats.totalAllocated)
+}
+
+// reply 按 Redis MEMORY STATS 的格式返回：名称到数值的字典，RESP2 中是名称和数值交替出现的数组
+func (stats *memoryStats) reply() resp.Reply {
+	replies := make([]resp.Reply, 0, 32)
+	add := func(name string, value resp.Reply) {
+		replies = append(replies, reply.MakeBulkReply([]byte(name)), value)
+	}
+	add("total.allocated", reply.MakeIntReply(stats.totalAllocated))
+	add("heap.retained", reply.MakeIntReply(stats.heapRetained))
+	add("clients.normal", reply.MakeIntReply(stats.clients))
+	add("aof.buffer", reply.MakeIntReply(stats.aofBuffer))
+	add("lazyfree.pending.objects", reply.MakeIntReply(stats.lazyfreePending))
+	add("lazyfreed.objects", reply.MakeIntReply(stats.lazyfreed))
+	for _, db := range stats.dbs {
+		add("db."+strconv.Itoa(db.index), reply.MakeMapReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("overhead.hashtable.main")), reply.MakeIntReply(db.main),
+			reply.MakeBulkReply([]byte("overhead.hashtable.expires")), reply.MakeIntReply(db.expires),
+			reply.MakeBulkReply([]byte("keys.count")), reply.MakeIntReply(db.keys),
+			reply.MakeBulkReply([]byte("keys.volatile")), reply.MakeIntReply(db.volatile),
+			reply.MakeBulkReply([]byte("dataset.bytes")), reply.MakeIntReply(db.dataset),
+		}))
+	}
+	add("overhead.total", reply.MakeIntReply(stats.overhead))
+	add("keys.count", reply.MakeIntReply(stats.keys))
+	bytesPerKey := int64(0)
+	if stats.keys > 0 {
+		bytesPerKey = (stats.overhead + stats.dataset) / stats.keys
+	}
+	add("keys.bytes-per-key", reply.MakeIntReply(bytesPerKey))
+	add("dataset.bytes", reply.MakeIntReply(stats.dataset))
+	percentage := float64(0)
+	if total := stats.overhead + stats.dataset; total > 0 {
+		percentage = float64(stats.dataset) * 100 / float64(total)
+	}
+	add("dataset.percentage", reply.MakeDoubleReply(percentage))
+	add("fragmentation", reply.MakeDoubleReply(stats.fragmentation()))
+	add("fragmentation.bytes", reply.MakeIntReply(stats.heapRetained-stats.totalAllocated))
+	return reply.MakeMapReply(replies)
+}
+
+// bigKey MEMORY DOCTOR 发现的大键
+type bigKey struct {
+	dbIndex int
+	key     string
+	size    int64
+}
+
+// findBigKeys 在每个数据库中抽查一些键，找出占用内存最多的几个大键
+// 遍历所有的键会让命令卡住 O(N) 的时间，所以只抽查 doctorBigKeySamples 个，大键可能会漏掉
+func (mdb *Database) findBigKeys() []bigKey {
+	result := make([]bigKey, 0)
+	for _, db := range mdb.snapshotDBs() {
+		for _, key := range db.SampleKeys(doctorBigKeySamples) {
+			size, exists := db.MemoryUsage(key, defaultUsageSamples)
+			if exists && size >= doctorBigKeyBytes {
+				result = append(result, bigKey{dbIndex: db.Index, key: key, size: size})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].size > result[j].size
+	})
+	if len(result) > doctorBigKeyCount {
+		result = result[:doctorBigKeyCount]
+	}
+	return result
+}
+
+// memoryDoctor 检查内存使用中常见的问题，返回给人看的报告
+func (mdb *Database) memoryDoctor() string {
+	stats := mdb.memoryStats()
+	if stats.keys == 0 || stats.totalAllocated < doctorMinMemory {
+		return "This instance is empty or is using very little memory, nothing to report."
+	}
+	var issues []string
+	for _, big := range mdb.findBigKeys() {
+		issues = append(issues, fmt.Sprintf(" * Big key: db%d '%s' uses about %d bytes. "+
+			"Consider splitting it into smaller keys, and delete it with UNLINK instead of DEL.",
+			big.dbIndex, big.key, big.size))
+	}
+	if frag := stats.fragmentation(); frag > doctorFragmentation {
+		issues = append(issues, fmt.Sprintf(" * High fragmentation: the heap retains %.2f times the memory in use (%d bytes wasted). "+
+			"This is usually caused by deleting many keys; the Go runtime returns the memory to the OS gradually.",
+			frag, stats.heapRetained-stats.totalAllocated))
+	}
+	if maxMemory := int64(config.Properties.MaxMemory); maxMemory > 0 {
+		used := mdb.UsedMemory()
+		if float64(used) > float64(maxMemory)*doctorMaxMemoryFactor {
+			issues = append(issues, fmt.Sprintf(" * Close to maxmemory: %d of %d bytes used, policy '%s', %d keys evicted so far.",
+				used, maxMemory, config.Properties.MaxMemoryPolicy, mdb.EvictedKeys()))
+		}
+	}
+	if stats.aofBuffer > doctorBigKeyBytes {
+		issues = append(issues, fmt.Sprintf(" * AOF buffer holds %d bytes of commands not yet written. The disk may be too slow.",
+			stats.aofBuffer))
+	}
+	if len(issues) == 0 {
+		return "No memory issues detected in this instance."
+	}
+	return "Memory issues detected:\n\n" + strings.Join(issues, "\n")
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/connection"
+	"GoMiniCache/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// mapField 返回 MapReply 中名称对应的值
+func mapField(t *testing.T, result resp.Reply, name string) resp.Reply {
+	t.Helper()
+	m, ok := result.(*reply.MapReply)
+	if !ok {
+		t.Fatalf("expected a map reply, got %T", result)
+	}
+	for i := 0; i+1 < len(m.Pairs); i += 2 {
+		if string(m.Pairs[i].(*reply.BulkReply).Arg) == name {
+			return m.Pairs[i+1]
+		}
+	}
+	t.Fatalf("field %s not found", name)
+	return nil
+}
+
+func TestMemoryUsage(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	execLine(mdb, c, "set", "small", "v")
+	execLine(mdb, c, "set", "large", strings.Repeat("x", 1000))
+	small := execLine(mdb, c, "memory", "usage", "small").(*reply.IntReply).Code
+	large := execLine(mdb, c, "memory", "usage", "large", "samples", "0").(*reply.IntReply).Code
+	if small <= 0 || large < 1000 || large <= small {
+		t.Errorf("unexpected usage: small %d, large %d", small, large)
+	}
+	assertReply(t, execLine(mdb, c, "memory", "usage", "missing"), "$-1\r\n")
+	assertReply(t, execLine(mdb, c, "memory", "usage", "small", "count", "1"), "-Err syntax error\r\n")
+	assertReply(t, execLine(mdb, c, "memory", "usage", "small", "samples", "-1"),
+		"-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execLine(mdb, c, "memory", "bogus"),
+		"-ERR unknown subcommand 'bogus'. Try MEMORY USAGE, MEMORY STATS or MEMORY DOCTOR.\r\n")
+}
+
+func TestMemoryStats(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	for i := 0; i < 10; i++ {
+		execLine(mdb, c, "set", strconv.Itoa(i), "value")
+	}
+	execLine(mdb, c, "expire", "0", "100")
+	execLine(mdb, c, "select", "2")
+	execLine(mdb, c, "set", "k", "v")
+
+	stats := execLine(mdb, c, "memory", "stats")
+	if keys := mapField(t, stats, "keys.count").(*reply.IntReply).Code; keys != 11 {
+		t.Errorf("expected 11 keys, got %d", keys)
+	}
+	db0 := mapField(t, stats, "db.0")
+	if n := mapField(t, db0, "keys.count").(*reply.IntReply).Code; n != 10 {
+		t.Errorf("expected 10 keys in db0, got %d", n)
+	}
+	if n := mapField(t, db0, "keys.volatile").(*reply.IntReply).Code; n != 1 {
+		t.Errorf("expected 1 volatile key in db0, got %d", n)
+	}
+	mapField(t, stats, "db.2")
+	if dataset := mapField(t, stats, "dataset.bytes").(*reply.IntReply).Code; dataset <= 0 {
+		t.Errorf("expected a positive dataset size, got %d", dataset)
+	}
+	assertReply(t, execLine(mdb, c, "memory", "stats", "x"), "-ERR wrong number of arguments for 'memory|stats' command\r\n")
+}
+
+func TestMemoryDoctor(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	doctor := string(execLine(mdb, c, "memory", "doctor").(*reply.BulkReply).Arg)
+	if !strings.Contains(doctor, "nothing to report") {
+		t.Errorf("unexpected report for an empty instance: %s", doctor)
+	}
+
+	// 大键超过 doctorBigKeyBytes，总的内存超过 doctorMinMemory
+	execLine(mdb, c, "set", "big", strings.Repeat("x", 3*doctorBigKeyBytes))
+	for i := 0; i < 4; i++ {
+		execLine(mdb, c, "set", "medium:"+strconv.Itoa(i), strings.Repeat("x", doctorBigKeyBytes*3/4))
+	}
+	doctor = string(execLine(mdb, c, "memory", "doctor").(*reply.BulkReply).Arg)
+	if !strings.Contains(doctor, "Big key: db0 'big'") || strings.Contains(doctor, "medium") {
+		t.Errorf("expected only the big key to be reported, got: %s", doctor)
+	}
+}
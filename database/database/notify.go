@@ -0,0 +1,52 @@
+package database
+
+/*
+ * 键空间通知：数据库把事件发到 notify.Bus，这里把事件发布到 __keyspace@ 和 __keyevent@ 频道
+ * 嵌入 GoMiniCache 的程序可以用 SubscribeEvents 直接监听事件，不需要经过发布订阅
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/notify"
+	"strconv"
+	"strings"
+)
+
+// parseNotifyFlags 解析 notify-keyspace-events，配置文件里的值可以带引号
+func parseNotifyFlags(value string) (int, error) {
+	return notify.ParseFlags(strings.Trim(value, `"'`))
+}
+
+// publishEvent 按 K、E 把事件发布给订阅了对应频道的客户端
+func (mdb *Database) publishEvent(event notify.Event) {
+	flags := mdb.events.Flags()
+	prefix := "@" + strconv.Itoa(event.DB) + "__:"
+	if flags&notify.Keyspace != 0 {
+		mdb.hub.Publish([]byte("__keyspace"+prefix+event.Key), []byte(event.Name))
+	}
+	if flags&notify.Keyevent != 0 {
+		mdb.hub.Publish([]byte("__keyevent"+prefix+event.Name), []byte(event.Key))
+	}
+}
+
+// SubscribeEvents 注册键空间事件的监听者，返回取消注册的函数
+// 监听者在修改键的协程里同步调用，不能阻塞，也不能再执行数据库的命令
+func (mdb *Database) SubscribeEvents(listener notify.Listener) (unsubscribe func()) {
+	return mdb.events.Subscribe(listener)
+}
+
+// SetNotifyKeyspaceEvents 修改 notify-keyspace-events，立即生效
+func (mdb *Database) SetNotifyKeyspaceEvents(value string) error {
+	flags, err := parseNotifyFlags(value)
+	if err != nil {
+		return err
+	}
+	mdb.events.SetFlags(flags)
+	config.Properties.NotifyKeyspaceEvents = notify.FormatFlags(flags)
+	return nil
+}
+
+// NotifyKeyspaceEvents 返回当前的 notify-keyspace-events
+func (mdb *Database) NotifyKeyspaceEvents() string {
+	return notify.FormatFlags(mdb.events.Flags())
+}
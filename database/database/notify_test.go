@@ -0,0 +1,72 @@
+package database
+
+import (
+	"GoMiniCache/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyspaceNotifications(t *testing.T) {
+	events := config.Properties.NotifyKeyspaceEvents
+	defer func() { config.Properties.NotifyKeyspaceEvents = events }()
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c, other := &recordConn{}, &recordConn{}
+
+	run(mdb, c, "subscribe", "__keyspace@0__:k", "__keyevent@0__:del")
+	run(mdb, other, "set", "k", "v")
+	assertOutput(t, run(mdb, c, "ping"), "*2 $4 pong $0  ") // 默认关闭通知
+
+	assertOutput(t, run(mdb, other, "config", "set", "notify-keyspace-events", "KEA"), "+OK ")
+	run(mdb, other, "set", "k", "v")
+	run(mdb, other, "del", "k")
+	assertOutput(t, run(mdb, c, "ping"),
+		"*3 $7 message $16 __keyspace@0__:k $3 set "+
+			"*3 $7 message $16 __keyspace@0__:k $3 del "+
+			"*3 $7 message $18 __keyevent@0__:del $1 k "+
+			"*2 $4 pong $0  ")
+
+	// 只开启 K 和字符串类别：__keyevent@ 频道和 DEL 之类的通用事件都不再发布
+	assertOutput(t, run(mdb, other, "config", "set", "notify-keyspace-events", "K$"), "+OK ")
+	run(mdb, other, "set", "k", "v")
+	run(mdb, other, "del", "k")
+	assertOutput(t, run(mdb, c, "ping"), "*3 $7 message $16 __keyspace@0__:k $3 set *2 $4 pong $0  ")
+
+	// 其他数据库的事件发布到自己编号的频道
+	assertOutput(t, run(mdb, other, "config", "set", "notify-keyspace-events", "KEA"), "+OK ")
+	run(mdb, other, "select", "1")
+	run(mdb, other, "del", "k")
+	run(mdb, other, "set", "k", "v")
+	run(mdb, other, "del", "k")
+	assertOutput(t, run(mdb, c, "ping"), "*2 $4 pong $0  ")
+}
+
+// TestActiveExpire 写入以后不再被读取的过期键由后台任务删除，并发出 expired 事件
+func TestActiveExpire(t *testing.T) {
+	events := config.Properties.NotifyKeyspaceEvents
+	defer func() { config.Properties.NotifyKeyspaceEvents = events }()
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c, other := &recordConn{}, &recordConn{}
+	run(mdb, other, "config", "set", "notify-keyspace-events", "Ex")
+	run(mdb, c, "subscribe", "__keyevent@0__:expired")
+
+	for i := 0; i < 100; i++ {
+		key := "k" + strings.Repeat("x", i)
+		run(mdb, other, "set", key, "v")
+		run(mdb, other, "pexpire", key, "10")
+	}
+	run(mdb, other, "set", "keep", "v")
+	deadline := time.Now().Add(2 * time.Second)
+	for run(mdb, other, "dbsize") != ":1 " {
+		if time.Now().After(deadline) {
+			t.Fatalf("expired keys were not removed, dbsize %q", run(mdb, other, "dbsize"))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := strings.Count(run(mdb, c, "ping"), "__keyevent@0__:expired"); n != 100 {
+		t.Errorf("expected 100 expired events, got %d", n)
+	}
+	assertOutput(t, run(mdb, other, "get", "keep"), "$1 v ")
+}
@@ -0,0 +1,68 @@
+package database
+
+/*
+ * 发布订阅命令的路由：订阅模式下的连接只能执行订阅相关的命令
+ */
+
+import (
+	"GoMiniCache/database/pubsub"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/reply"
+)
+
+// subscriberCommands 订阅模式下允许执行的命令
+var subscriberCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+}
+
+// execPubSubCommand 执行发布订阅相关的命令，不是这些命令时返回 false
+// RESP3 的消息是推送类型，不会和回复混淆，所以订阅模式的限制只对 RESP2 的连接有效
+func execPubSubCommand(mdb *Database, c resp.Connection, cmdName string, cmdLine [][]byte) (resp.Reply, bool) {
+	subscribed := c.SubsCount() > 0 && c.GetProtocol() < 3
+	if subscribed && !subscriberCommands[cmdName] {
+		return reply.MakeErrReply("ERR Can't execute '" + cmdName +
+			"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"), true
+	}
+	args := cmdLine[1:]
+	switch cmdName {
+	case "subscribe":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply(cmdName), true
+		}
+		return mdb.hub.Subscribe(c, args), true
+	case "psubscribe":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply(cmdName), true
+		}
+		return mdb.hub.PSubscribe(c, args), true
+	case "unsubscribe":
+		return mdb.hub.Unsubscribe(c, args), true
+	case "punsubscribe":
+		return mdb.hub.PUnsubscribe(c, args), true
+	case "publish":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply(cmdName), true
+		}
+		return reply.MakeIntReply(int64(mdb.hub.Publish(args[0], args[1]))), true
+	case "pubsub":
+		return pubsub.ExecPubSub(mdb.hub, args), true
+	case "ping":
+		if !subscribed {
+			return nil, false // 不在订阅模式时按普通的 PING 执行
+		}
+		if len(args) > 1 {
+			return reply.MakeArgNumErrReply(cmdName), true
+		}
+		pong := [][]byte{[]byte("pong"), {}} // 订阅模式下 PING 返回 [pong, 参数]
+		if len(args) == 1 {
+			pong[1] = args[0]
+		}
+		return reply.MakeMultiBulkReply(pong), true // 和其他回复一样经过发件箱
+	}
+	return nil, false
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/resp/connection"
+	"GoMiniCache/resp/reply"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordConn 把写给客户端的内容记下来
+type recordConn struct {
+	connection.Connection
+	mu      sync.Mutex
+	out     strings.Builder
+	unblock chan struct{} // 不为 nil 时写入一直阻塞到它被关闭，模拟不读取的客户端
+}
+
+func (c *recordConn) Write(b []byte) error {
+	if c.unblock != nil {
+		<-c.unblock
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out.Write(b)
+	return nil
+}
+
+// run 像 handler 一样执行命令并写回复，等发件箱写完以后返回这个连接收到的所有内容
+func run(mdb *Database, c *recordConn, args ...string) string {
+	result := mdb.Exec(c, utils.ToCmdLine(args...))
+	mdb.outboxes.Wait(c)
+	if result != nil {
+		_ = c.Write(reply.Encode(result, c.GetProtocol()))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := strings.ReplaceAll(c.out.String(), "\r\n", " ")
+	c.out.Reset()
+	return out
+}
+
+func assertOutput(t *testing.T, got string, expected string) {
+	t.Helper()
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSubscriberModeRESP2(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c, other := &recordConn{}, &recordConn{}
+	run(mdb, other, "set", "k", "v")
+
+	assertOutput(t, run(mdb, c, "subscribe", "news"), "*3 $9 subscribe $4 news :1 ")
+	assertOutput(t, run(mdb, c, "get", "k"),
+		"-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context ")
+	assertOutput(t, run(mdb, c, "set", "k", "x"),
+		"-ERR Can't execute 'set': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context ")
+	assertOutput(t, run(mdb, c, "ping"), "*2 $4 pong $0  ")
+	assertOutput(t, run(mdb, c, "ping", "hi"), "*2 $4 pong $2 hi ")
+	assertOutput(t, run(mdb, c, "psubscribe", "n*"), "*3 $10 psubscribe $2 n* :2 ")
+
+	assertOutput(t, run(mdb, other, "publish", "news", "hello"), ":2 ")
+	assertOutput(t, run(mdb, c, "ping"),
+		"*3 $7 message $4 news $5 hello *4 $8 pmessage $2 n* $4 news $5 hello *2 $4 pong $0  ")
+
+	assertOutput(t, run(mdb, c, "unsubscribe"), "*3 $11 unsubscribe $4 news :1 ")
+	assertOutput(t, run(mdb, c, "get", "k"),
+		"-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context ")
+	assertOutput(t, run(mdb, c, "punsubscribe"), "*3 $12 punsubscribe $2 n* :0 ")
+	assertOutput(t, run(mdb, c, "get", "k"), "$1 v ") // 退出订阅模式以后恢复正常
+	assertOutput(t, run(mdb, c, "ping"), "+PONG ")
+}
+
+func TestSubscriberModeRESP3(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c, other := &recordConn{}, &recordConn{}
+	c.SetProtocol(3)
+	run(mdb, other, "set", "k", "v")
+
+	assertOutput(t, run(mdb, c, "subscribe", "news"), ">3 $9 subscribe $4 news :1 ")
+	assertOutput(t, run(mdb, c, "get", "k"), "$1 v ") // 推送不会和回复混淆，可以执行普通命令
+	run(mdb, other, "publish", "news", "hello")
+	assertOutput(t, run(mdb, c, "get", "k"), ">3 $7 message $4 news $5 hello $1 v ")
+}
+
+// TestUnsubscribeSlowClient 退订不等待积压的消息写给客户端，不会在持有 execMu 时被慢的客户端阻塞
+func TestUnsubscribeSlowClient(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &recordConn{unblock: make(chan struct{})}
+	other := &recordConn{}
+	mdb.Exec(c, utils.ToCmdLine("subscribe", "news"))
+	for i := 0; i < 10; i++ {
+		run(mdb, other, "publish", "news", "hello")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mdb.Exec(c, utils.ToCmdLine("unsubscribe"))
+		mdb.Exec(c, utils.ToCmdLine("get", "k"))
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("UNSUBSCRIBE blocked on a client that does not read")
+	}
+	assertOutput(t, run(mdb, other, "set", "k", "v"), "+OK ") // 其他客户端不受影响
+
+	close(c.unblock)
+	out := run(mdb, c, "ping")
+	if strings.Count(out, "message") != 10 || !strings.HasSuffix(out, "*3 $11 unsubscribe $4 news :0 $-1 +PONG ") {
+		t.Errorf("messages and replies out of order: %q", out)
+	}
+}
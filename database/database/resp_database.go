@@ -7,25 +7,62 @@ package database
 import (
 	"GoMiniCache/aof"
 	"GoMiniCache/config"
+	"GoMiniCache/database/outbox"
+	"GoMiniCache/database/pubsub"
 	"GoMiniCache/database/structure"
+	"GoMiniCache/database/tracking"
+	"GoMiniCache/interface/database"
 	"GoMiniCache/interface/resp"
 	"GoMiniCache/lib/logger"
+	"GoMiniCache/lib/sync/atomic"
+	"GoMiniCache/loader"
+	"GoMiniCache/notify"
 	"GoMiniCache/resp/reply"
+	"GoMiniCache/writebehind"
 	"fmt"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // Database 数据库集合
 type Database struct {
 	dbSet      []*structure.DB
+	dbSetMu    sync.RWMutex // SWAPDB 会交换 dbSet 中的两个数据库
+	execMu     sync.RWMutex // 执行命令时持有读锁；跨数据库的命令和重写 AOF 的快照持有写锁，期间没有其他命令在执行
 	aofHandler *aof.HandlerAof
+	loading    atomic.Boolean // 正在加载 AOF，加载期间不淘汰
+
+	evictMu      sync.Mutex // 同一时间只有一个客户端在淘汰
+	evictionPool []*evictionCandidate
+	nextEvictDB  int   // 随机淘汰时下一个数据库
+	evictedKeys  int64 // 被淘汰的键的个数（原子操作）
+
+	stopTier   chan struct{} // 停止冷数据分层的后台任务，没有开启分层时为 nil
+	stopExpire chan struct{} // 停止定期删除过期键的后台任务
+
+	loaders  *loader.Registry // GET 未命中时的读穿透
+	outboxes *outbox.Registry // 连接的发件箱，发布订阅的消息和客户端缓存的失效通知经过它异步地写给客户端
+	hub      *pubsub.Hub      // 发布订阅
+	events   *notify.Bus      // 键空间通知
+	tracking *tracking.Table  // 客户端缓存的失效通知
+
+	writeBehind *writebehind.Writer // 把写入异步地回写到数据源，没有开启时为 nil
 }
 
 // NewDatabase 创建一个类 Redis 数据库
 func NewDatabase() *Database {
-	mdb := &Database{}
+	mdb := &Database{loaders: loader.NewRegistry(), outboxes: outbox.NewRegistry()}
+	mdb.hub = pubsub.MakeHub(mdb.outboxes)
+	flags, err := parseNotifyFlags(config.Properties.NotifyKeyspaceEvents)
+	if err != nil {
+		panic(err)
+	}
+	mdb.events = notify.NewBus(flags)
+	mdb.events.Subscribe(mdb.publishEvent)
+	mdb.tracking = tracking.NewTable(lookupClient, mdb.hub.SendMessage, mdb.outboxes)
+	mdb.events.Watch(mdb.invalidateEvent)
 	if config.Properties.Databases == 0 {
 		config.Properties.Databases = 16 // 默认 16 个
 	}
@@ -33,21 +70,55 @@ func NewDatabase() *Database {
 	for i := range mdb.dbSet {
 		singleDB := structure.MakeDB() // 创建好底层存储
 		singleDB.Index = i
+		singleDB.Loaders = mdb.loaders
 		mdb.dbSet[i] = singleDB
 	}
+	if lsmEnabled() { // 数据本来就在磁盘上，不需要冷数据分层
+		if err := mdb.openLSM(); err != nil {
+			panic(err)
+		}
+	} else if config.Properties.TieredStorage {
+		if err := mdb.openTiers(); err != nil {
+			panic(err)
+		}
+	}
 	if config.Properties.AppendOnly {
-		aofHandler, err := aof.NewAOFHandler(mdb) // 启用 AOF 持久化
+		opts, err := aof.MakeOptions(config.Properties) // 根据配置选择 AOF 的存储介质
+		if err != nil {
+			panic(err)
+		}
+		mdb.loading.Set(true)
+		aofHandler, err := aof.NewAOFHandler(mdb, opts) // 启用 AOF 持久化
+		mdb.loading.Set(false)
 		if err != nil {
 			panic(err)
 		}
+		if aofHandler.PartialLoad() { // 按时间点或条数恢复，AOF 里剩下的命令没有回放，用加载到的数据重写掉，下次启动不会再回放它们
+			if err := aofHandler.Rewrite(mdb.pauseCommands, mdb.dumpAof); err != nil {
+				panic(err)
+			}
+		}
 		mdb.aofHandler = aofHandler
-		for _, db := range mdb.dbSet {
-			singleDB := db
-			singleDB.AddAof = func(line [][]byte) {
+	}
+	if config.Properties.WriteBehind {
+		if err := mdb.openWriteBehind(); err != nil {
+			panic(err)
+		}
+	}
+	// 加载完 AOF 以后再挂上，回放的命令不需要再写 AOF、回写和发出通知
+	for _, db := range mdb.dbSet {
+		singleDB := db
+		singleDB.AddAof = func(line [][]byte) { // 在命令中调用，持有 execMu 的读锁，SWAPDB 不会同时修改 Index
+			mdb.addAof(singleDB.Index, line)
+		}
+		singleDB.AddAofLocal = func(line [][]byte) {
+			if mdb.aofHandler != nil {
 				mdb.aofHandler.AddAof(singleDB.Index, line)
 			}
 		}
+		singleDB.Events = mdb.events
 	}
+	mdb.startExpireCron()
 	return mdb
 }
 
@@ -59,27 +130,130 @@ func (mdb *Database) Exec(c resp.Connection, cmdLine [][]byte) (result resp.Repl
 			logger.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
 		}
 	}()
+	// 连接有发件箱时回复也经过它，不会跑到已经放进去的消息前面；在 execMu 外面，不会等待客户端读取
+	return mdb.outboxes.Reply(c, mdb.execLocked(c, cmdLine))
+}
 
+// execLocked 验证密码，持有 execMu 执行命令
+func (mdb *Database) execLocked(c resp.Connection, cmdLine [][]byte) resp.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	if errReply := checkAuth(c, cmdName); errReply != nil { // 设置了 requirepass 时先验证密码
+		return errReply
+	}
+	if errReply := mdb.loadMissing(c, cmdName, cmdLine); errReply != nil { // GET 未命中时读穿透，不持有 execMu
+		return errReply
+	}
+	if isExclusiveCommand(cmdName) { // 跨数据库的命令独占执行，其他命令看不到执行到一半的状态
+		mdb.execMu.Lock()
+		defer mdb.execMu.Unlock()
+	} else {
+		mdb.execMu.RLock()
+		defer mdb.execMu.RUnlock()
+	}
+	defer mdb.forgetMissing(c, cmdName, cmdLine)
+	if mdb.tracking.Active() { // 有客户端开启了客户端缓存
+		return mdb.execTracking(c, cmdName, cmdLine)
+	}
+	return mdb.exec(c, cmdName, cmdLine)
+}
+
+// exec 按命令名路由并执行命令
+func (mdb *Database) exec(c resp.Connection, cmdName string, cmdLine [][]byte) resp.Reply {
+	if result, ok := execPubSubCommand(mdb, c, cmdName, cmdLine); ok { // 发布订阅，订阅模式下只能执行这些命令
+		return result
+	}
+	if errReply := mdb.checkMemory(cmdName); errReply != nil { // 写命令之前检查内存
+		return errReply
+	}
+	if cmdName == "auth" { // 密码验证
+		return execAuth(c, cmdLine[1:])
+	}
+	if cmdName == "hello" { // 握手，切换协议版本
+		return execHello(c, cmdLine[1:])
+	}
 	if cmdName == "select" { // 选择数据库
 		if len(cmdLine) != 2 {
 			return reply.MakeArgNumErrReply("select")
 		}
 		return execSelect(c, mdb, cmdLine[1:])
 	}
+	if cmdName == "bgrewriteaof" { // 在后台重写 AOF
+		return execBGRewriteAof(mdb)
+	}
+	if cmdName == "flushall" { // 清空所有数据库
+		return execFlushAll(c, mdb, cmdLine)
+	}
+	if cmdName == "swapdb" { // 交换两个数据库
+		if len(cmdLine) != 3 {
+			return reply.MakeArgNumErrReply("swapdb")
+		}
+		return execSwapDB(c, mdb, cmdLine)
+	}
+	if cmdName == "move" { // 把键移动到另一个数据库
+		if len(cmdLine) != 3 {
+			return reply.MakeArgNumErrReply("move")
+		}
+		return execMove(c, mdb, cmdLine)
+	}
+	if cmdName == "copy" { // 把键拷贝到另一个键（可以在另一个数据库）
+		if len(cmdLine) < 3 {
+			return reply.MakeArgNumErrReply("copy")
+		}
+		return execCopy(c, mdb, cmdLine)
+	}
+	if cmdName == "memory" { // 内存统计
+		return execMemory(c, mdb, cmdLine)
+	}
+	if cmdName == "tier" { // 冷数据分层
+		return execTier(mdb, cmdLine)
+	}
+	if cmdName == "writebehind" { // 异步回写
+		return execWriteBehind(mdb, cmdLine)
+	}
+	if cmdName == "config" { // 查看和修改配置
+		return execConfig(mdb, cmdLine)
+	}
+	if cmdName == "client" { // 客户端连接的管理和客户端缓存
+		return execClient(mdb, c, cmdLine)
+	}
 
-	selectedDB := mdb.dbSet[c.GetDBIndex()]
+	selectedDB := mdb.selectDB(c.GetDBIndex())
 	return selectedDB.Exec(cmdLine) // 执行命令
 }
 
-// Close 关闭数据库（暂无逻辑）
-func (mdb *Database) Close() {
+// selectDB 返回编号对应的数据库
+func (mdb *Database) selectDB(dbIndex int) *structure.DB {
+	mdb.dbSetMu.RLock()
+	defer mdb.dbSetMu.RUnlock()
+	return mdb.dbSet[dbIndex]
+}
 
+// addAof 把已经提交的写命令写入 AOF，开启回写时记下被修改的键
+func (mdb *Database) addAof(dbIndex int, cmdLine [][]byte) {
+	if mdb.aofHandler != nil {
+		mdb.aofHandler.AddAof(dbIndex, cmdLine)
+	}
+	if mdb.writeBehind != nil {
+		mdb.observeWrite(dbIndex, cmdLine)
+	}
 }
 
-// AfterClientClose 关闭客户端之后的操作
-func (mdb *Database) AfterClientClose(resp.Connection) {
+// Close 关闭数据库，把 AOF 缓冲的命令写完
+func (mdb *Database) Close() {
+	mdb.stopExpireCron()
+	mdb.closeWriteBehind() // 回写时要读数据库，最先关闭
+	if mdb.aofHandler != nil {
+		mdb.aofHandler.Close()
+	}
+	mdb.closeTiers()
+	mdb.closeLSM()
+}
 
+// AfterClientClose 关闭客户端之后的操作：退订所有的频道和模式，关闭客户端缓存，丢掉发件箱中积压的消息
+func (mdb *Database) AfterClientClose(c resp.Connection) {
+	mdb.hub.UnsubscribeAll(c)
+	mdb.tracking.Disable(c)
+	mdb.outboxes.Drop(c)
 }
 
 // execSelect 选择数据库
@@ -94,3 +268,52 @@ func execSelect(c resp.Connection, mdb *Database, args [][]byte) resp.Reply {
 	c.SelectDB(dbIndex)
 	return reply.MakeOkReply()
 }
+
+// execBGRewriteAof 在后台用当前的数据重写 AOF
+func execBGRewriteAof(mdb *Database) resp.Reply {
+	if mdb.aofHandler == nil {
+		return reply.MakeErrReply("ERR append only file is disabled")
+	}
+	go func() {
+		if err := mdb.aofHandler.Rewrite(mdb.pauseCommands, mdb.dumpAof); err != nil {
+			logger.Error("aof rewrite failed: " + err.Error())
+			return
+		}
+		logger.Info("aof rewrite finished")
+	}()
+	return reply.MakeStatusReply("Background append only file rewriting started")
+}
+
+// pauseCommands 等正在执行的命令结束，暂停执行新的命令，返回恢复执行的函数
+func (mdb *Database) pauseCommands() func() {
+	mdb.execMu.Lock()
+	return mdb.execMu.Unlock
+}
+
+// dumpAof 交出重建所有数据库所需的命令
+func (mdb *Database) dumpAof(emit func(dbIndex int, cmdLine [][]byte)) {
+	mdb.dbSetMu.RLock()
+	dbSet := make([]*structure.DB, len(mdb.dbSet))
+	copy(dbSet, mdb.dbSet)
+	mdb.dbSetMu.RUnlock()
+	for _, db := range dbSet {
+		db.ForEach(func(key string, entity *database.DataEntity) bool {
+			if db.IsExpired(key) { // 已经过期的键不用写入
+				return true
+			}
+			entity, ok := db.ResolveEntity(key, entity) // 值在磁盘上时读出来
+			if !ok {
+				return true
+			}
+			cmdLine := aof.EntityToCmd(key, entity)
+			if cmdLine == nil {
+				return true
+			}
+			emit(db.Index, cmdLine)
+			if expireAt, ok := db.ExpireTime(key); ok {
+				emit(db.Index, aof.ExpireToCmd(key, expireAt))
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package database
+
+/*
+ * 冷数据分层的后台任务和 TIER 命令: TIER STATS, TIER COMPACT
+ * 后台任务每秒从每个数据库随机检查一些键，把空闲时间超过 tier-idle-time 的值写到磁盘上，并压缩垃圾太多的 value log 段
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/database/structure"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/logger"
+	"GoMiniCache/resp/reply"
+	"GoMiniCache/tiered"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTierDir     = "tier"
+	tierCronInterval   = time.Second
+	tierSpillSamples   = 20  // 每轮从每个数据库检查的键的个数
+	tierSpillMaxRounds = 16  // 每次最多检查的轮数，一轮中超过 1/4 的键被写到磁盘上时继续下一轮
+	tierCompactRatio   = 0.5 // 段里的垃圾超过这个比例时压缩
+)
+
+// openTiers 给每个数据库打开自己的 value log，目录为 <tier-dir>/db<编号>
+func (mdb *Database) openTiers() error {
+	dir := config.Properties.TierDir
+	if dir == "" {
+		dir = defaultTierDir
+	}
+	for _, db := range mdb.dbSet {
+		store, err := tiered.Open(filepath.Join(dir, "db"+strconv.Itoa(db.Index)), int64(config.Properties.TierSegmentSize))
+		if err != nil {
+			return err
+		}
+		db.EnableTier(store)
+	}
+	mdb.stopTier = make(chan struct{})
+	go mdb.tierCron()
+	return nil
+}
+
+// closeTiers 停止后台任务并关闭所有的 value log
+func (mdb *Database) closeTiers() {
+	if mdb.stopTier == nil {
+		return
+	}
+	close(mdb.stopTier)
+	for _, db := range mdb.snapshotDBs() {
+		if err := db.CloseTier(); err != nil {
+			logger.Error("tier: close failed: " + err.Error())
+		}
+	}
+}
+
+// tierCron 后台任务：写出空闲的值，压缩 value log
+func (mdb *Database) tierCron() {
+	ticker := time.NewTicker(tierCronInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mdb.stopTier:
+			return
+		case <-ticker.C:
+		}
+		idle := time.Duration(config.Properties.TierIdleTime) * time.Second
+		for _, db := range mdb.snapshotDBs() {
+			if idle > 0 {
+				for round := 0; round < tierSpillMaxRounds; round++ {
+					if db.SpillIdle(tierSpillSamples, idle) <= tierSpillSamples/4 {
+						break
+					}
+				}
+			}
+			if _, err := db.CompactTier(tierCompactRatio); err != nil {
+				logger.Error("tier: compaction failed: " + err.Error())
+			}
+		}
+	}
+}
+
+// execTier 根据子命令分发
+func execTier(mdb *Database, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) != 2 {
+		return reply.MakeArgNumErrReply("tier")
+	}
+	subCmd := strings.ToLower(string(cmdLine[1]))
+	switch subCmd {
+	case "stats":
+		return mdb.tierStats()
+	case "compact":
+		if mdb.stopTier == nil {
+			return reply.MakeErrReply("ERR tiered storage is disabled")
+		}
+		compacted := 0
+		for _, db := range mdb.snapshotDBs() {
+			for { // 把所有有垃圾的段都压缩掉
+				ok, err := db.CompactTier(0)
+				if err != nil {
+					return reply.MakeErrReply("ERR " + err.Error())
+				}
+				if !ok {
+					break
+				}
+				compacted++
+			}
+		}
+		return reply.MakeIntReply(int64(compacted))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try TIER STATS or TIER COMPACT.")
+}
+
+// tierStats 汇总所有数据库的分层统计，按名称到数值的字典返回
+func (mdb *Database) tierStats() resp.Reply {
+	var total structure.TierStats
+	for _, db := range mdb.snapshotDBs() {
+		stats := db.TierStats()
+		total.MemoryHits += stats.MemoryHits
+		total.DiskHits += stats.DiskHits
+		total.Misses += stats.Misses
+		total.Spilled += stats.Spilled
+		total.Loaded += stats.Loaded
+		total.Store.Keys += stats.Store.Keys
+		total.Store.Segments += stats.Store.Segments
+		total.Store.TotalBytes += stats.Store.TotalBytes
+		total.Store.LiveBytes += stats.Store.LiveBytes
+		total.Store.Compactions += stats.Store.Compactions
+	}
+	replies := make([]resp.Reply, 0, 20)
+	add := func(name string, value int64) {
+		replies = append(replies, reply.MakeBulkReply([]byte(name)), reply.MakeIntReply(value))
+	}
+	add("memory.hits", total.MemoryHits)
+	add("disk.hits", total.DiskHits)
+	add("misses", total.Misses)
+	add("spilled", total.Spilled)
+	add("loaded", total.Loaded)
+	add("disk.keys", int64(total.Store.Keys))
+	add("vlog.segments", int64(total.Store.Segments))
+	add("vlog.bytes", total.Store.TotalBytes)
+	add("vlog.live.bytes", total.Store.LiveBytes)
+	add("vlog.compactions", total.Store.Compactions)
+	return reply.MakeMapReply(replies)
+}
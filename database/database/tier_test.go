@@ -0,0 +1,122 @@
+package database
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/resp/connection"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTierDB 开启磁盘层创建数据库，只手动写出值（tier-idle-time 为 0）
+func openTierDB(t *testing.T) *Database {
+	t.Helper()
+	saved := *config.Properties
+	t.Cleanup(func() { *config.Properties = saved })
+	config.Properties.TieredStorage = true
+	config.Properties.TierDir = t.TempDir()
+	config.Properties.TierIdleTime = 0
+	config.Properties.TierMinValueSize = 0
+	return NewDatabase()
+}
+
+func TestTierColdReads(t *testing.T) {
+	mdb := openTierDB(t)
+	defer mdb.Close()
+	c := &connection.Connection{}
+	big := strings.Repeat("v", 100)
+	execLine(mdb, c, "set", "big", big)
+	execLine(mdb, c, "set", "small", "v")
+	assertReply(t, execLine(mdb, c, "bf.reserve", "filter", "0.01", "1000"), "+OK\r\n")
+
+	db := mdb.selectDB(0)
+	if !db.Spill("big") {
+		t.Fatal("expected the string value to be spilled")
+	}
+	if db.Spill("small") || db.Spill("filter") || db.Spill("big") {
+		t.Error("small values, filters and cold values should not be spilled")
+	}
+
+	// 不读取值的命令不会把值加载回内存
+	assertReply(t, execLine(mdb, c, "type", "big"), "+string\r\n")
+	assertReply(t, execLine(mdb, c, "exists", "big"), ":1\r\n")
+	assertReply(t, execLine(mdb, c, "object", "encoding", "big"), "$3\r\nraw\r\n")
+	if stats := db.TierStats(); stats.Loaded != 0 || stats.Store.Keys != 1 {
+		t.Errorf("value loaded by a command that does not read it: %+v", stats)
+	}
+
+	assertReply(t, execLine(mdb, c, "get", "big"), "$100\r\n"+big+"\r\n")
+	assertReply(t, execLine(mdb, c, "get", "big"), "$100\r\n"+big+"\r\n")
+	if stats := db.TierStats(); stats.DiskHits != 1 || stats.Loaded != 1 || stats.Store.Keys != 0 {
+		t.Errorf("unexpected stats after the cold read: %+v", stats)
+	}
+}
+
+func TestTierSpilledKeyspaceCommands(t *testing.T) {
+	mdb := openTierDB(t)
+	defer mdb.Close()
+	c := &connection.Connection{}
+	big := strings.Repeat("v", 100)
+	db := mdb.selectDB(0)
+
+	execLine(mdb, c, "set", "a", big)
+	db.Spill("a")
+	assertReply(t, execLine(mdb, c, "rename", "a", "b"), "+OK\r\n")
+	assertReply(t, execLine(mdb, c, "exists", "a"), ":0\r\n")
+	assertReply(t, execLine(mdb, c, "get", "b"), "$100\r\n"+big+"\r\n")
+
+	db.Spill("b")
+	assertReply(t, execLine(mdb, c, "copy", "b", "c"), ":1\r\n")
+	assertReply(t, execLine(mdb, c, "get", "c"), "$100\r\n"+big+"\r\n")
+	assertReply(t, execLine(mdb, c, "get", "b"), "$100\r\n"+big+"\r\n")
+
+	db.Spill("b")
+	db.Spill("c")
+	assertReply(t, execLine(mdb, c, "del", "b", "c"), ":2\r\n")
+	assertReply(t, execLine(mdb, c, "dbsize"), ":0\r\n")
+	if keys := db.TierStats().Store.Keys; keys != 0 {
+		t.Errorf("deleted keys left %d values on disk", keys)
+	}
+
+	execLine(mdb, c, "set", "d", big)
+	db.Spill("d")
+	assertReply(t, execLine(mdb, c, "set", "d", "new"), "+OK\r\n") // 覆盖冷的值
+	assertReply(t, execLine(mdb, c, "get", "d"), "$3\r\nnew\r\n")
+	if keys := db.TierStats().Store.Keys; keys != 0 {
+		t.Errorf("overwritten key left %d values on disk", keys)
+	}
+}
+
+// TestTierAofRewrite 重写 AOF 时把磁盘上的值读出来写进去，重启以后不会丢
+func TestTierAofRewrite(t *testing.T) {
+	mdb := openTierDB(t)
+	config.Properties.AppendOnly = true
+	config.Properties.AofBackend = "file"
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	mdb.Close()
+	mdb = NewDatabase()
+	c := &connection.Connection{}
+	big := strings.Repeat("v", 100)
+	execLine(mdb, c, "set", "big", big)
+	execLine(mdb, c, "pexpire", "big", "3600000")
+	execLine(mdb, c, "set", "small", "v")
+	db := mdb.selectDB(0)
+	if !db.Spill("big") {
+		t.Fatal("expected the value to be spilled")
+	}
+	if err := mdb.aofHandler.Rewrite(mdb.pauseCommands, mdb.dumpAof); err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.TierStats(); stats.Loaded != 0 {
+		t.Errorf("rewrite should not load cold values back into memory: %+v", stats)
+	}
+	mdb.Close()
+
+	mdb = NewDatabase()
+	defer mdb.Close()
+	assertReply(t, execLine(mdb, c, "dbsize"), ":2\r\n")
+	assertReply(t, execLine(mdb, c, "get", "big"), "$100\r\n"+big+"\r\n")
+	if ttl := string(execLine(mdb, c, "ttl", "big").ToBytes()); ttl != ":3600\r\n" && ttl != ":3599\r\n" {
+		t.Errorf("expected the ttl to survive the rewrite, got %q", ttl)
+	}
+}
@@ -0,0 +1,160 @@
+package database
+
+/*
+ * 异步回写：和 AOF 在同一个位置观察已经提交的写命令，把被修改的键交给 writebehind.Writer
+ * WRITEBEHIND STATS 返回积压的修改和延迟
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/database/structure"
+	"GoMiniCache/interface/database"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/logger"
+	"GoMiniCache/resp/reply"
+	"GoMiniCache/writebehind"
+	"strings"
+	"time"
+)
+
+const defaultWriteBehindFile = "writebehind.json"
+
+// openWriteBehind 按配置打开 JSON 文件作为数据源并开始回写
+func (mdb *Database) openWriteBehind() error {
+	path := config.Properties.WriteBehindFile
+	if path == "" {
+		path = defaultWriteBehindFile
+	}
+	store, err := writebehind.OpenFileStore(path)
+	if err != nil {
+		return err
+	}
+	mdb.StartWriteBehind(store, writebehind.Options{
+		BatchSize:     config.Properties.WriteBehindBatchSize,
+		FlushInterval: time.Duration(config.Properties.WriteBehindInterval) * time.Millisecond,
+		MaxRetries:    config.Properties.WriteBehindMaxRetries,
+	})
+	return nil
+}
+
+// StartWriteBehind 开始把写入回写到 store，需要在数据库开始执行命令之前调用
+func (mdb *Database) StartWriteBehind(store writebehind.Store, opts writebehind.Options) {
+	mdb.writeBehind = writebehind.New(store, mdb.readForWriteBehind, opts)
+}
+
+// WriteBehindStats 返回回写的统计，没有开启回写时返回 false
+func (mdb *Database) WriteBehindStats() (writebehind.Stats, bool) {
+	if mdb.writeBehind == nil {
+		return writebehind.Stats{}, false
+	}
+	return mdb.writeBehind.Stats(), true
+}
+
+// closeWriteBehind 写出积压的修改并关闭数据源
+func (mdb *Database) closeWriteBehind() {
+	if mdb.writeBehind == nil {
+		return
+	}
+	if err := mdb.writeBehind.Close(); err != nil {
+		logger.Error("write-behind: close failed: " + err.Error())
+	}
+}
+
+// readForWriteBehind 读取键当前的值和过期时间，不记录访问
+func (mdb *Database) readForWriteBehind(dbIndex int, key string) ([]byte, time.Time, bool) {
+	db := mdb.selectDB(dbIndex)
+	entity, ok := db.PeekEntity(key)
+	if !ok || db.IsExpired(key) {
+		return nil, time.Time{}, false
+	}
+	if entity, ok = db.ResolveEntity(key, entity); !ok {
+		return nil, time.Time{}, false
+	}
+	val, ok := structure.EntityBytes(entity)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	expireAt, _ := db.ExpireTime(key)
+	return val, expireAt, true
+}
+
+// observeWrite 从写命令中找出被修改的键，SWAPDB 在持有 dbSetMu 的写锁时调用
+func (mdb *Database) observeWrite(dbIndex int, cmdLine [][]byte) {
+	wb := mdb.writeBehind
+	args := cmdLine[1:]
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "select":
+	case "flushdb":
+		wb.FlushDB(dbIndex)
+	case "flushall":
+		for i := range mdb.dbSet {
+			wb.FlushDB(i)
+		}
+	case "del", "unlink":
+		for _, key := range args {
+			wb.Touch(dbIndex, string(key))
+		}
+	case "rename", "renamenx":
+		wb.Touch(dbIndex, string(args[0]))
+		wb.Touch(dbIndex, string(args[1]))
+	case "move": // MOVE key db
+		wb.Touch(dbIndex, string(args[0]))
+		if dst, errReply := mdb.parseDBIndex(args[1]); errReply == nil {
+			wb.Touch(dst, string(args[0]))
+		}
+	case "copy": // COPY source destination [DB destination-db] [REPLACE]
+		dst := dbIndex
+		for i := 2; i+1 < len(args); i++ {
+			if strings.EqualFold(string(args[i]), "db") {
+				if index, errReply := mdb.parseDBIndex(args[i+1]); errReply == nil {
+					dst = index
+				}
+			}
+		}
+		wb.Touch(dst, string(args[1]))
+	case "swapdb": // 两个数据库的内容整个换了，清空以后重新写出所有的键
+		for _, arg := range args {
+			index, errReply := mdb.parseDBIndex(arg)
+			if errReply != nil {
+				continue
+			}
+			wb.FlushDB(index)
+			mdb.dbSet[index].ForEach(func(key string, _ *database.DataEntity) bool {
+				wb.Touch(index, key)
+				return true
+			})
+		}
+	default: // 其余的写命令都只修改第一个参数
+		if len(args) > 0 {
+			wb.Touch(dbIndex, string(args[0]))
+		}
+	}
+}
+
+// execWriteBehind 执行 WRITEBEHIND STATS
+func execWriteBehind(mdb *Database, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) != 2 {
+		return reply.MakeArgNumErrReply("writebehind")
+	}
+	subCmd := strings.ToLower(string(cmdLine[1]))
+	if subCmd != "stats" {
+		return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try WRITEBEHIND STATS.")
+	}
+	stats, ok := mdb.WriteBehindStats()
+	if !ok {
+		return reply.MakeErrReply("ERR write-behind is disabled")
+	}
+	replies := make([]resp.Reply, 0, 16)
+	add := func(name string, value int64) {
+		replies = append(replies, reply.MakeBulkReply([]byte(name)), reply.MakeIntReply(value))
+	}
+	add("pending", int64(stats.Pending))
+	add("lag.ms", stats.Lag.Milliseconds())
+	add("coalesced", stats.Coalesced)
+	add("written", stats.Written)
+	add("batches", stats.Batches)
+	add("retries", stats.Retries)
+	add("dropped", stats.Dropped)
+	replies = append(replies, reply.MakeBulkReply([]byte("last.error")), reply.MakeBulkReply([]byte(stats.LastError)))
+	return reply.MakeMapReply(replies)
+}
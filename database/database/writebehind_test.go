@@ -0,0 +1,62 @@
+package database
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/resp/connection"
+	"GoMiniCache/writebehind"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordStore 记录写出的所有记录
+type recordStore struct {
+	mu      sync.Mutex
+	records []writebehind.Record
+}
+
+func (s *recordStore) Write(records []writebehind.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *recordStore) Close() error { return nil }
+
+// TestWriteBehindSkipsEvictionAndExpiry 淘汰和过期只是把键从缓存里去掉，不在数据源里删除；DEL 才会删除
+func TestWriteBehindSkipsEvictionAndExpiry(t *testing.T) {
+	mdb := NewDatabase()
+	defer mdb.Close()
+	c := &connection.Connection{}
+	mdb.StartWriteBehind(&recordStore{}, writebehind.Options{FlushInterval: time.Hour})
+	setupEvictKeys(mdb, c)
+	execLine(mdb, c, "set", "t", "v")
+	execLine(mdb, c, "pexpire", "t", "20")
+	_ = mdb.writeBehind.Close() // 先把上面的写入写出去
+
+	store := &recordStore{}
+	mdb.StartWriteBehind(store, writebehind.Options{FlushInterval: time.Hour})
+	time.Sleep(30 * time.Millisecond)
+	assertReply(t, execLine(mdb, c, "get", "t"), "$-1\r\n") // 过期删除
+	execLine(mdb, c, "set", "c", "changed")                 // 还没写出就被淘汰
+	entity, _ := mdb.selectDB(0).PeekEntity("c")
+	entity.AccessTime -= int64(time.Hour / time.Millisecond)
+	limitMemory(t, mdb, PolicyAllKeysLRU)
+	assertReply(t, execLine(mdb, c, "set", "new", "v"), "+OK\r\n")
+	assertReply(t, execLine(mdb, c, "exists", "c"), ":0\r\n")
+	config.Properties.MaxMemory = 0 // 之后的写命令不再淘汰
+	execLine(mdb, c, "del", "a")
+	if err := mdb.writeBehind.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mdb.writeBehind = nil // 已经关闭了
+
+	records := store.records
+	if len(records) != 3 ||
+		records[0].Op != writebehind.OpPut || records[0].Key != "c" || string(records[0].Value) != "changed" ||
+		records[1].Op != writebehind.OpPut || records[1].Key != "new" ||
+		records[2].Op != writebehind.OpDelete || records[2].Key != "a" {
+		t.Errorf("unexpected records %+v", records)
+	}
+}
@@ -0,0 +1,211 @@
+package outbox
+
+/*
+ * 连接的发件箱：带缓冲的管道和一个写协程，服务器主动发给连接的消息（发布订阅的消息、客户端缓存的失效通知）只放进发件箱，
+ * 不会在执行命令时（持有 execMu）等待慢的客户端读取
+ * 连接有发件箱的时候，给它的所有回复也都经过发件箱，保证回复和消息按产生的顺序写出
+ * 发件箱满了说明客户端读得太慢，和 Redis 的 client-output-buffer-limit 一样断开它
+ *
+ * 发布订阅和客户端缓存各自 Acquire/Release，计数为 0 以后积压的消息写完，写协程才退出，
+ * 在这之前的回复仍然经过发件箱，不会跑到积压的消息前面
+ */
+
+import (
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/reply"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const size = 1024 // 每个连接最多积压的消息个数
+
+// item 发件箱中的一项：一条消息，或者 Wait 放进去的标记
+type item struct {
+	msg    []byte
+	marker chan struct{} // 不为 nil 时是标记，写协程处理到这里时关闭它
+}
+
+// Box 一个连接的发件箱
+type Box struct {
+	conn       resp.Connection
+	ch         chan item
+	wake       chan struct{} // 计数变成 0 时唤醒写协程，检查是否可以退出
+	done       chan struct{} // 写协程退出时关闭
+	refs       int           // 由 Registry.mu 保护
+	overflowed atomic.Bool
+}
+
+// Send 把消息放进发件箱，不会阻塞；满了就断开连接
+func (b *Box) Send(msg []byte) {
+	select {
+	case b.ch <- item{msg: msg}:
+	default:
+		if b.overflowed.CompareAndSwap(false, true) {
+			if closer, ok := b.conn.(io.Closer); ok {
+				go func() { _ = closer.Close() }() // Close 会等待正在写的回复，不能阻塞发送者
+			}
+		}
+	}
+}
+
+// Registry 连接到发件箱的映射，并发安全
+type Registry struct {
+	mu     sync.RWMutex
+	boxes  map[resp.Connection]*Box
+	active atomic.Int64 // 发件箱的个数，为 0 时回复直接返回，不需要加锁
+}
+
+// NewRegistry 创建 Registry
+func NewRegistry() *Registry {
+	return &Registry{boxes: make(map[resp.Connection]*Box)}
+}
+
+// Acquire 连接需要经过发件箱写消息，没有发件箱时创建并启动写协程；和 Release 成对调用
+func (r *Registry) Acquire(c resp.Connection) *Box {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.boxes[c]
+	if !ok {
+		b = &Box{
+			conn: c,
+			ch:   make(chan item, size),
+			wake: make(chan struct{}, 1),
+			done: make(chan struct{}),
+		}
+		r.boxes[c] = b
+		r.active.Add(1)
+		go r.loop(b)
+	}
+	b.refs++
+	return b
+}
+
+// Release 不再需要发件箱，计数为 0 时积压的消息写完以后关闭发件箱，不等待
+func (r *Registry) Release(c resp.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.boxes[c]
+	if !ok || b.refs == 0 {
+		return
+	}
+	b.refs--
+	if b.refs == 0 {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Drop 连接关闭了，丢掉积压的消息并关闭发件箱
+func (r *Registry) Drop(c resp.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.boxes[c]
+	if !ok {
+		return
+	}
+	r.removeLocked(b)
+}
+
+// removeLocked 删除发件箱并让写协程退出（调用者需要持有写锁）
+func (r *Registry) removeLocked(b *Box) {
+	delete(r.boxes, b.conn)
+	r.active.Add(-1)
+	close(b.done)
+}
+
+// loop 写协程：依次写出消息，计数为 0 并且没有积压的消息时退出
+func (r *Registry) loop(b *Box) {
+	releasing := false // 计数变成过 0，积压的消息写完以后检查能不能退出
+	for {
+		if releasing && len(b.ch) == 0 {
+			removed, retry := r.tryRemove(b)
+			if removed {
+				return
+			}
+			releasing = retry
+		}
+		select {
+		case it := <-b.ch:
+			if it.marker != nil {
+				close(it.marker)
+				continue
+			}
+			_ = b.conn.Write(it.msg) // 写失败说明连接断开了，AfterClientClose 会清理
+		case <-b.wake:
+			releasing = true
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// tryRemove 没有人需要发件箱并且积压的消息都写完了时删除它，返回是否删除了，以及没删除时是否还要再检查
+// 放进发件箱的一方持有读锁，所以持有写锁时看到的空管道不会再有新的消息
+func (r *Registry) tryRemove(b *Box) (removed bool, retry bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-b.done: // 已经被 Drop 了
+		return true, false
+	default:
+	}
+	if b.refs > 0 { // 又有人需要了
+		return false, false
+	}
+	if len(b.ch) > 0 { // 检查之后又放进了消息，写完以后再检查
+		return false, true
+	}
+	r.removeLocked(b)
+	return true, false
+}
+
+// Send 把消息放进连接的发件箱，连接没有发件箱时返回 false
+func (r *Registry) Send(c resp.Connection, msg []byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.boxes[c]
+	if !ok {
+		return false
+	}
+	b.Send(msg)
+	return true
+}
+
+// Reply 连接有发件箱时把回复放进发件箱并返回 NoReply，否则原样返回
+func (r *Registry) Reply(c resp.Connection, result resp.Reply) resp.Reply {
+	if r.active.Load() == 0 || result == nil {
+		return result
+	}
+	if _, ok := result.(*reply.NoReply); ok {
+		return result
+	}
+	if r.Send(c, reply.Encode(result, c.GetProtocol())) {
+		return &reply.NoReply{}
+	}
+	return result
+}
+
+// Wait 等待已经放进发件箱的消息都写给客户端，连接没有发件箱时直接返回
+func (r *Registry) Wait(c resp.Connection) {
+	r.mu.RLock()
+	b, ok := r.boxes[c]
+	if !ok {
+		r.mu.RUnlock()
+		return
+	}
+	marker := make(chan struct{})
+	select {
+	case b.ch <- item{marker: marker}:
+	default: // 满了，连接会被断开
+		r.mu.RUnlock()
+		return
+	}
+	r.mu.RUnlock()
+	select {
+	case <-marker:
+	case <-b.done:
+	}
+}
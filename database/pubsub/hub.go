@@ -0,0 +1,322 @@
+package pubsub
+
+/*
+ * 发布订阅: SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE, PUNSUBSCRIBE, PUBLISH, PUBSUB
+ *
+ * 每个订阅者在订阅期间持有连接的发件箱（见 database/outbox），PUBLISH 只把消息放进发件箱，不会被慢的订阅者阻塞
+ * 订阅模式下给这个连接的所有回复（包括订阅的确认）都经过发件箱，保证和消息的顺序一致
+ * RESP3 的连接收到的消息和订阅的确认是推送类型（>），订阅模式下也可以执行其他的命令
+ */
+
+import (
+	"GoMiniCache/database/outbox"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/wildcard"
+	"GoMiniCache/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// push 把消息和订阅的确认放进发件箱，RESP3 的连接把数组换成推送类型
+func push(box *outbox.Box, c resp.Connection, msg []byte) {
+	if c.GetProtocol() >= 3 {
+		msg = append([]byte{'>'}, msg[1:]...) // 消息都是 * 开头的数组，只有类型不同
+	}
+	box.Send(msg)
+}
+
+// patternSubs 订阅了同一个模式的连接
+type patternSubs struct {
+	pattern *wildcard.Pattern
+	subs    map[resp.Connection]struct{}
+}
+
+// Hub 频道和模式到订阅者的映射
+type Hub struct {
+	mu       sync.RWMutex
+	channels map[string]map[resp.Connection]struct{}
+	patterns map[string]*patternSubs
+	outboxes *outbox.Registry
+	boxes    map[resp.Connection]*outbox.Box // 订阅模式下的连接持有的发件箱
+}
+
+// MakeHub 创建 Hub，订阅者的发件箱从 outboxes 中获取
+func MakeHub(outboxes *outbox.Registry) *Hub {
+	return &Hub{
+		channels: make(map[string]map[resp.Connection]struct{}),
+		patterns: make(map[string]*patternSubs),
+		outboxes: outboxes,
+		boxes:    make(map[resp.Connection]*outbox.Box),
+	}
+}
+
+// boxLocked 返回连接的发件箱，刚进入订阅模式时获取一个（调用者需要持有写锁）
+func (hub *Hub) boxLocked(c resp.Connection) *outbox.Box {
+	if box, ok := hub.boxes[c]; ok {
+		return box
+	}
+	box := hub.outboxes.Acquire(c)
+	hub.boxes[c] = box
+	return box
+}
+
+// releaseBoxLocked 连接退出订阅模式，不再持有发件箱（调用者需要持有写锁）
+func (hub *Hub) releaseBoxLocked(c resp.Connection) {
+	if _, ok := hub.boxes[c]; !ok {
+		return
+	}
+	delete(hub.boxes, c)
+	hub.outboxes.Release(c)
+}
+
+// makeMsg 编码推送给订阅者的消息
+func makeMsg(kind string, args ...[]byte) []byte {
+	return reply.MakeMultiBulkReply(append([][]byte{[]byte(kind)}, args...)).ToBytes()
+}
+
+// makeCountMsg 编码订阅和退订的确认：[kind, name, 订阅的总个数]，name 为 nil 时编码成空
+func makeCountMsg(kind string, name []byte, count int) []byte {
+	buf := []byte("*3\r\n$" + strconv.Itoa(len(kind)) + "\r\n" + kind + "\r\n")
+	if name == nil {
+		buf = append(buf, "$-1\r\n"...)
+	} else {
+		buf = append(buf, "$"+strconv.Itoa(len(name))+"\r\n"...)
+		buf = append(buf, name...)
+		buf = append(buf, "\r\n"...)
+	}
+	return append(buf, ":"+strconv.Itoa(count)+"\r\n"...)
+}
+
+// Subscribe 订阅频道，例：SUBSCRIBE channel [channel ...]
+func (hub *Hub) Subscribe(c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	box := hub.boxLocked(c)
+	for _, arg := range args {
+		channel := string(arg)
+		if c.Subscribe(channel) {
+			subs, ok := hub.channels[channel]
+			if !ok {
+				subs = make(map[resp.Connection]struct{})
+				hub.channels[channel] = subs
+			}
+			subs[c] = struct{}{}
+		}
+		push(box, c, makeCountMsg("subscribe", arg, c.SubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+// PSubscribe 订阅模式，例：PSUBSCRIBE pattern [pattern ...]
+func (hub *Hub) PSubscribe(c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	box := hub.boxLocked(c)
+	for _, arg := range args {
+		pattern := string(arg)
+		if c.PSubscribe(pattern) {
+			ps, ok := hub.patterns[pattern]
+			if !ok {
+				ps = &patternSubs{pattern: wildcard.CompilePattern(pattern), subs: make(map[resp.Connection]struct{})}
+				hub.patterns[pattern] = ps
+			}
+			ps.subs[c] = struct{}{}
+		}
+		push(box, c, makeCountMsg("psubscribe", arg, c.SubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+// Unsubscribe 退订频道，没有参数时退订所有的频道，例：UNSUBSCRIBE [channel ...]
+func (hub *Hub) Unsubscribe(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		args = toArgs(c.GetChannels())
+	}
+	return hub.unsubscribe(c, "unsubscribe", args, func(name string) {
+		if !c.Unsubscribe(name) {
+			return
+		}
+		if subs, ok := hub.channels[name]; ok {
+			delete(subs, c)
+			if len(subs) == 0 {
+				delete(hub.channels, name)
+			}
+		}
+	})
+}
+
+// PUnsubscribe 退订模式，没有参数时退订所有的模式，例：PUNSUBSCRIBE [pattern ...]
+func (hub *Hub) PUnsubscribe(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		args = toArgs(c.GetPatterns())
+	}
+	return hub.unsubscribe(c, "punsubscribe", args, func(name string) {
+		if !c.PUnsubscribe(name) {
+			return
+		}
+		if ps, ok := hub.patterns[name]; ok {
+			delete(ps.subs, c)
+			if len(ps.subs) == 0 {
+				delete(hub.patterns, name)
+			}
+		}
+	})
+}
+
+// unsubscribe 退订并发送确认，退出订阅模式时只释放发件箱，不等待积压的消息写完
+// 积压的消息写完之前发件箱还在，之后的回复仍然经过它，不会跑到这些消息前面
+func (hub *Hub) unsubscribe(c resp.Connection, kind string, args [][]byte, remove func(name string)) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	box := hub.boxLocked(c)
+	if len(args) == 0 { // 本来就没有订阅
+		push(box, c, makeCountMsg(kind, nil, c.SubsCount()))
+	}
+	for _, arg := range args {
+		remove(string(arg))
+		push(box, c, makeCountMsg(kind, arg, c.SubsCount()))
+	}
+	if c.SubsCount() == 0 {
+		hub.releaseBoxLocked(c)
+	}
+	return &reply.NoReply{}
+}
+
+// UnsubscribeAll 连接关闭时退订所有的频道和模式
+func (hub *Hub) UnsubscribeAll(c resp.Connection) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, channel := range c.GetChannels() {
+		c.Unsubscribe(channel)
+		if subs, ok := hub.channels[channel]; ok {
+			delete(subs, c)
+			if len(subs) == 0 {
+				delete(hub.channels, channel)
+			}
+		}
+	}
+	for _, pattern := range c.GetPatterns() {
+		c.PUnsubscribe(pattern)
+		if ps, ok := hub.patterns[pattern]; ok {
+			delete(ps.subs, c)
+			if len(ps.subs) == 0 {
+				delete(hub.patterns, pattern)
+			}
+		}
+	}
+	hub.releaseBoxLocked(c) // 连接已经关闭，积压的消息由 Registry.Drop 丢掉
+}
+
+// Publish 把消息发给频道和匹配的模式的订阅者，返回收到消息的订阅者个数，例：PUBLISH channel message
+func (hub *Hub) Publish(channel []byte, message []byte) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	received := 0
+	if subs, ok := hub.channels[string(channel)]; ok {
+		msg := makeMsg("message", channel, message)
+		for c := range subs {
+			push(hub.boxes[c], c, msg)
+			received++
+		}
+	}
+	for name, ps := range hub.patterns {
+		if !ps.pattern.IsMatch(string(channel)) {
+			continue
+		}
+		msg := makeMsg("pmessage", []byte(name), channel, message)
+		for c := range ps.subs {
+			push(hub.boxes[c], c, msg)
+			received++
+		}
+	}
+	return received
+}
+
+// SendMessage 把已经编码好的消息发给订阅模式下的连接，连接不在订阅模式时返回 false
+// 用于服务器自己产生的消息，例：客户端缓存的失效通知以 __redis__:invalidate 频道的消息发给 REDIRECT 的目标
+func (hub *Hub) SendMessage(c resp.Connection, msg []byte) bool {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	box, ok := hub.boxes[c]
+	if !ok {
+		return false
+	}
+	box.Send(msg)
+	return true
+}
+
+// Channels 返回有订阅者的频道，pattern 不为空时只返回匹配的频道
+func (hub *Hub) Channels(pattern string) []string {
+	var matcher *wildcard.Pattern
+	if pattern != "" {
+		matcher = wildcard.CompilePattern(pattern)
+	}
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	channels := make([]string, 0, len(hub.channels))
+	for channel := range hub.channels {
+		if matcher == nil || matcher.IsMatch(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// NumSub 返回频道的订阅者个数（不包括模式的订阅者）
+func (hub *Hub) NumSub(channel string) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.channels[channel])
+}
+
+// NumPat 返回被订阅的模式的个数
+func (hub *Hub) NumPat() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.patterns)
+}
+
+// toArgs 把字符串转换成命令参数
+func toArgs(names []string) [][]byte {
+	sort.Strings(names)
+	args := make([][]byte, len(names))
+	for i, name := range names {
+		args[i] = []byte(name)
+	}
+	return args
+}
+
+// ExecPubSub 执行 PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func ExecPubSub(hub *Hub, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("pubsub")
+	}
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "channels":
+		if len(args) > 2 {
+			return reply.MakeArgNumErrReply("pubsub|channels")
+		}
+		pattern := ""
+		if len(args) == 2 {
+			pattern = string(args[1])
+		}
+		return reply.MakeMultiBulkReply(toArgs(hub.Channels(pattern)))
+	case "numsub":
+		replies := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, channel := range args[1:] {
+			replies = append(replies, reply.MakeBulkReply(channel), reply.MakeIntReply(int64(hub.NumSub(string(channel)))))
+		}
+		return reply.MakeMapReply(replies)
+	case "numpat":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("pubsub|numpat")
+		}
+		return reply.MakeIntReply(int64(hub.NumPat()))
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + sub + "'. Try PUBSUB CHANNELS, PUBSUB NUMSUB or PUBSUB NUMPAT.")
+	}
+}
@@ -0,0 +1,73 @@
+package pubsub
+
+import (
+	"GoMiniCache/database/outbox"
+	"GoMiniCache/resp/connection"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn 把写给客户端的内容记下来
+type fakeConn struct {
+	connection.Connection
+	mu  sync.Mutex
+	out strings.Builder
+}
+
+func (c *fakeConn) Write(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out.Write(b)
+	return nil
+}
+
+func (c *fakeConn) output() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return strings.ReplaceAll(c.out.String(), "\r\n", " ")
+}
+
+// waitOutput 等待写协程把消息写完
+func waitOutput(t *testing.T, c *fakeConn, want string) {
+	deadline := time.Now().Add(time.Second)
+	for c.output() != want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := c.output(); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	outboxes := outbox.NewRegistry()
+	hub := MakeHub(outboxes)
+	a, b := &fakeConn{}, &fakeConn{}
+	hub.Subscribe(a, [][]byte{[]byte("news")})
+	hub.PSubscribe(b, [][]byte{[]byte("n*")})
+	if n := hub.Publish([]byte("news"), []byte("hi")); n != 2 {
+		t.Errorf("expected 2 receivers, got %d", n)
+	}
+	if n := hub.Publish([]byte("other"), []byte("hi")); n != 0 {
+		t.Errorf("expected no receivers, got %d", n)
+	}
+	waitOutput(t, a, "*3 $9 subscribe $4 news :1 *3 $7 message $4 news $2 hi ")
+	waitOutput(t, b, "*3 $10 psubscribe $2 n* :1 *4 $8 pmessage $2 n* $4 news $2 hi ")
+	if hub.NumSub("news") != 1 || hub.NumPat() != 1 || len(hub.Channels("n*")) != 1 {
+		t.Error("unexpected introspection")
+	}
+
+	hub.Unsubscribe(a, nil) // 不等待积压的消息写完
+	outboxes.Wait(a)
+	if got := a.output(); !strings.HasSuffix(got, "*3 $11 unsubscribe $4 news :0 ") {
+		t.Errorf("unexpected output %q", got)
+	}
+	hub.UnsubscribeAll(b)
+	if hub.NumSub("news") != 0 || hub.NumPat() != 0 || a.SubsCount() != 0 || b.SubsCount() != 0 {
+		t.Error("subscriptions should be cleaned up")
+	}
+	if n := hub.Publish([]byte("news"), []byte("hi")); n != 0 {
+		t.Errorf("expected no receivers after unsubscribe, got %d", n)
+	}
+}
@@ -0,0 +1,291 @@
+package structure
+
+/*
+ * 实现布隆过滤器的命令 BF.*，和 RedisBloom 兼容
+ * BF.ADD、BF.MADD 写入不存在的键时按默认参数创建过滤器
+ * BF.SCANDUMP、BF.LOADCHUNK 导出和导入过滤器的全部状态，整个过滤器只有一块，重写 AOF 时也用它们保存过滤器
+ * 创建和扩展过滤器之前检查新分配的大小，不能超过每层的上限和 maxmemory
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/datastruct/bloom"
+	"GoMiniCache/interface/database"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/notify"
+	"GoMiniCache/resp/reply"
+	"encoding"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultBloomErrorRate = 0.01
+	defaultBloomCapacity  = 100
+	defaultBloomExpansion = 2
+)
+
+// bloomInfoFields BF.INFO 可以单独查询的项，和返回全部信息时的顺序相同
+var bloomInfoFields = []string{"capacity", "size", "filters", "items", "expansion"}
+
+// getBloom 返回键对应的布隆过滤器，键不存在时返回 nil
+func (db *DB) getBloom(key string) (*bloom.Filter, *database.DataEntity, reply.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil, nil
+	}
+	filter, ok := entity.Data.(*bloom.Filter)
+	if !ok {
+		return nil, nil, &reply.WrongTypeErrReply{}
+	}
+	return filter, entity, nil
+}
+
+// getOrCreateBloom 返回键对应的布隆过滤器，键不存在时按默认参数创建
+func (db *DB) getOrCreateBloom(key string) (*bloom.Filter, *database.DataEntity, reply.ErrorReply) {
+	filter, entity, errReply := db.getBloom(key)
+	if errReply != nil || filter != nil {
+		return filter, entity, errReply
+	}
+	entity = &database.DataEntity{Data: bloom.New(defaultBloomErrorRate, defaultBloomCapacity, defaultBloomExpansion)}
+	if db.PutIfAbsent(key, entity) == 0 { // 别的客户端先创建了
+		return db.getBloom(key)
+	}
+	return entity.Data.(*bloom.Filter), entity, nil
+}
+
+// filterMemoryLimit 过滤器新的一层最多占用的内存：设置了 maxmemory 时不超过它，0 表示只受过滤器自己的上限限制
+func filterMemoryLimit() uint64 {
+	if config.Properties.MaxMemory > 0 {
+		return uint64(config.Properties.MaxMemory)
+	}
+	return 0
+}
+
+// checkFilterBytes 创建过滤器之前检查第一层的大小，max 是过滤器自己的上限
+func checkFilterBytes(size uint64, max uint64) reply.ErrorReply {
+	if size > max {
+		return reply.MakeErrReply("ERR filter is too large, each layer can use at most " + strconv.FormatUint(max, 10) + " bytes")
+	}
+	if limit := filterMemoryLimit(); limit > 0 && size > limit {
+		return reply.MakeErrReply("ERR filter needs " + strconv.FormatUint(size, 10) + " bytes, more than 'maxmemory'")
+	}
+	return nil
+}
+
+// bloomAddReply 把插入的结果转换成回复，RESP2 中是 1 和 0
+func bloomAddReply(added bool, err error) resp.Reply {
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeBoolReply(added)
+}
+
+// execBFReserve 创建布隆过滤器，例：BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+func execBFReserve(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	errorRate, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || !(errorRate > 0 && errorRate < 1) {
+		return reply.MakeErrReply("ERR (0 < error rate range < 1)")
+	}
+	capacity, err := strconv.ParseUint(string(args[2]), 10, 64)
+	if err != nil || capacity == 0 {
+		return reply.MakeErrReply("ERR (capacity should be larger than 0)")
+	}
+	if capacity > bloom.MaxCapacity {
+		return reply.MakeErrReply("ERR (capacity should be at most " + strconv.FormatUint(bloom.MaxCapacity, 10) + ")")
+	}
+	expansion := uint64(defaultBloomExpansion)
+	nonScaling := false
+	for i := 3; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "expansion" && i+1 < len(args) {
+			expansion, err = strconv.ParseUint(string(args[i+1]), 10, 32)
+			if err != nil || expansion == 0 {
+				return reply.MakeErrReply("ERR expansion should be greater or equal to 1")
+			}
+			if expansion > bloom.MaxExpansion {
+				return reply.MakeErrReply("ERR expansion should be at most " + strconv.Itoa(bloom.MaxExpansion))
+			}
+			i++
+		} else if arg == "nonscaling" {
+			nonScaling = true
+		} else {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if nonScaling {
+		expansion = 0
+	}
+	if errReply := checkFilterBytes(bloom.LayerBytes(errorRate, capacity), bloom.MaxLayerBytes); errReply != nil {
+		return errReply
+	}
+	entity := &database.DataEntity{Data: bloom.New(errorRate, capacity, uint32(expansion))}
+	if db.PutIfAbsent(key, entity) == 0 {
+		return reply.MakeErrReply("ERR item exists")
+	}
+	db.AddAof(utils.ToCmdLine2("bf.reserve", args...))
+	db.Notify(notify.Generic, "bf.reserve", key)
+	return reply.MakeOkReply()
+}
+
+// execBFAdd 插入元素，返回 1 表示元素原来一定不存在，例：BF.ADD key item
+func execBFAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	filter, entity, errReply := db.getOrCreateBloom(key)
+	if errReply != nil {
+		return errReply
+	}
+	added, err := filter.Add(args[1], filterMemoryLimit())
+	if added {
+		db.updateEntity(key, entity)
+		db.AddAof(utils.ToCmdLine2("bf.add", args...))
+		db.Notify(notify.Generic, "bf.add", key)
+	}
+	return bloomAddReply(added, err)
+}
+
+// execBFMAdd 插入多个元素，例：BF.MADD key item [item ...]
+func execBFMAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	filter, entity, errReply := db.getOrCreateBloom(key)
+	if errReply != nil {
+		return errReply
+	}
+	results := make([]resp.Reply, len(args)-1)
+	changed := false
+	for i, item := range args[1:] {
+		added, err := filter.Add(item, filterMemoryLimit())
+		changed = changed || added
+		results[i] = bloomAddReply(added, err)
+	}
+	if changed {
+		db.updateEntity(key, entity)
+		db.AddAof(utils.ToCmdLine2("bf.madd", args...))
+		db.Notify(notify.Generic, "bf.madd", key)
+	}
+	return reply.MakeMultiRawReply(results)
+}
+
+// execBFExists 返回元素是否可能存在，例：BF.EXISTS key item
+func execBFExists(db *DB, args [][]byte) resp.Reply {
+	filter, _, errReply := db.getBloom(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeBoolReply(filter != nil && filter.Exists(args[1]))
+}
+
+// execBFMExists 返回多个元素是否可能存在，例：BF.MEXISTS key item [item ...]
+func execBFMExists(db *DB, args [][]byte) resp.Reply {
+	filter, _, errReply := db.getBloom(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	results := make([]resp.Reply, len(args)-1)
+	for i, item := range args[1:] {
+		results[i] = reply.MakeBoolReply(filter != nil && filter.Exists(item))
+	}
+	return reply.MakeMultiRawReply(results)
+}
+
+// execBFInfo 返回过滤器的信息，例：BF.INFO key [CAPACITY | SIZE | FILTERS | ITEMS | EXPANSION]
+func execBFInfo(db *DB, args [][]byte) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply("bf.info")
+	}
+	filter, _, errReply := db.getBloom(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeErrReply("ERR not found")
+	}
+	info := filter.Info()
+	var expansion resp.Reply = reply.MakeIntReply(int64(info.Expansion))
+	if info.Expansion == 0 { // 不扩展的过滤器没有扩展倍数
+		expansion = reply.MakeNullReply()
+	}
+	names := []string{"Capacity", "Size", "Number of filters", "Number of items inserted", "Expansion rate"}
+	values := []resp.Reply{
+		reply.MakeIntReply(int64(info.Capacity)),
+		reply.MakeIntReply(info.Size),
+		reply.MakeIntReply(int64(info.Filters)),
+		reply.MakeIntReply(int64(info.Items)),
+		expansion,
+	}
+	if len(args) == 2 { // 只返回一项
+		i := slices.Index(bloomInfoFields, strings.ToLower(string(args[1])))
+		if i < 0 {
+			return reply.MakeErrReply("ERR Invalid information value")
+		}
+		return reply.MakeMultiRawReply([]resp.Reply{values[i]})
+	}
+	result := make([]resp.Reply, 0, len(names)*2)
+	for i, name := range names {
+		result = append(result, reply.MakeStatusReply(name), values[i])
+	}
+	return reply.MakeMapReply(result)
+}
+
+// execBFScanDump 导出过滤器的状态，例：BF.SCANDUMP key iterator
+// 整个过滤器只有一块：iterator 为 0 时返回 1 和全部的数据，之后返回 0 表示结束
+func execBFScanDump(db *DB, args [][]byte) resp.Reply {
+	filter, _, errReply := db.getBloom(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeErrReply("ERR not found")
+	}
+	return scanDump(filter, args[1])
+}
+
+// execBFLoadChunk 导入 BF.SCANDUMP 导出的数据，覆盖原来的值，例：BF.LOADCHUNK key iterator data
+func execBFLoadChunk(db *DB, args [][]byte) resp.Reply {
+	if errReply := checkChunkIterator(args[1]); errReply != nil {
+		return errReply
+	}
+	filter, err := bloom.Unmarshal(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	db.PutEntity(string(args[0]), &database.DataEntity{Data: filter})
+	db.AddAof(utils.ToCmdLine2("bf.loadchunk", args...))
+	return reply.MakeOkReply()
+}
+
+// scanDump 实现 BF.SCANDUMP 和 CF.SCANDUMP
+func scanDump(filter encoding.BinaryMarshaler, iterArg []byte) resp.Reply {
+	iter, errReply := parseInt64(iterArg)
+	if errReply != nil {
+		return errReply
+	}
+	if iter != 0 {
+		return reply.MakeMultiRawReply([]resp.Reply{reply.MakeIntReply(0), reply.MakeNullBulkReply()})
+	}
+	data, _ := filter.MarshalBinary()
+	return reply.MakeMultiRawReply([]resp.Reply{reply.MakeIntReply(1), reply.MakeBulkReply(data)})
+}
+
+// checkChunkIterator LOADCHUNK 只接受 SCANDUMP 返回的第一块
+func checkChunkIterator(iterArg []byte) reply.ErrorReply {
+	iter, err := strconv.ParseInt(string(iterArg), 10, 64)
+	if err != nil || iter != 1 {
+		return reply.MakeErrReply("ERR invalid iterator")
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand("BF.Reserve", execBFReserve, -4, FlagWrite|FlagDenyOOM)
+	RegisterCommand("BF.Add", execBFAdd, 3, FlagWrite|FlagDenyOOM)
+	RegisterCommand("BF.MAdd", execBFMAdd, -3, FlagWrite|FlagDenyOOM)
+	RegisterCommand("BF.Exists", execBFExists, 3, FlagReadOnly)
+	RegisterCommand("BF.MExists", execBFMExists, -3, FlagReadOnly)
+	RegisterCommand("BF.Info", execBFInfo, -2, FlagReadOnly)
+	RegisterCommand("BF.ScanDump", execBFScanDump, 3, FlagReadOnly)
+	RegisterCommand("BF.LoadChunk", execBFLoadChunk, 4, FlagWrite|FlagDenyOOM)
+}
@@ -0,0 +1,36 @@
+package structure
+
+import (
+	"GoMiniCache/config"
+	"strings"
+	"testing"
+)
+
+// TestFilterSizeLimits 过大的参数在分配内存之前被拒绝，扩展时不超过 maxmemory
+func TestFilterSizeLimits(t *testing.T) {
+	maxMemory := config.Properties.MaxMemory
+	defer func() { config.Properties.MaxMemory = maxMemory }()
+	config.Properties.MaxMemory = 0
+	db := MakeDB()
+
+	assertReply(t, execLine(db, "bf.reserve", "b", "0.01", "99999999999999"), "-ERR (capacity should be at most 4294967296)\r\n")
+	assertReply(t, execLine(db, "bf.reserve", "b", "0.01", "100", "expansion", "4000000000"), "-ERR expansion should be at most 32768\r\n")
+	assertReply(t, execLine(db, "bf.reserve", "b", "1e-300", "4294967296"), "-ERR filter is too large, each layer can use at most 1073741824 bytes\r\n")
+	assertReply(t, execLine(db, "cf.reserve", "c", "99999999999999"), "-ERR (capacity should be at most 4294967296)\r\n")
+	assertReply(t, execLine(db, "cf.reserve", "c", "4294967296", "bucketsize", "255"), "-ERR filter is too large, each layer can use at most 1073741824 bytes\r\n")
+
+	config.Properties.MaxMemory = 1 << 20
+	assertReply(t, execLine(db, "bf.reserve", "b", "0.01", "10000000"), "-ERR filter needs 11981328 bytes, more than 'maxmemory'\r\n")
+	assertReply(t, execLine(db, "cf.reserve", "c", "10000000"), "-ERR filter needs 16777216 bytes, more than 'maxmemory'\r\n")
+	assertReply(t, execLine(db, "dbsize"), ":0\r\n") // 被拒绝的过滤器没有创建
+
+	assertReply(t, execLine(db, "bf.reserve", "b", "0.01", "1000", "expansion", "32768"), "+OK\r\n")
+	var result string
+	for i := 0; i < 2000 && !strings.HasPrefix(result, "-"); i++ {
+		result = string(execLine(db, "bf.add", "b", strings.Repeat("x", i)).ToBytes())
+	}
+	if !strings.HasPrefix(result, "-ERR filter is full and the next layer would exceed the memory limit") {
+		t.Fatalf("expected growth beyond maxmemory to be refused, got %q", result)
+	}
+	assertReply(t, execLine(db, "bf.info", "b", "filters"), "*1\r\n:1\r\n")
+}
@@ -5,28 +5,72 @@ package structure
  */
 
 import (
+	"GoMiniCache/config"
 	"GoMiniCache/datastruct/dict"
+	"GoMiniCache/datastruct/gdict"
 	"GoMiniCache/interface/database"
 	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/loader"
+	"GoMiniCache/notify"
 	"GoMiniCache/resp/reply"
+	"GoMiniCache/tiered"
+	"hash/maphash"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // DB 存储数据并执行用户命令
 type DB struct {
-	Index  int       // 使用哪个数据库
-	Data   dict.Dict // 我们的底层可以在这里换实现
+	Index  int                                      // 使用哪个数据库，SWAPDB 独占执行时会修改，执行命令期间可以放心读取
+	Data   gdict.Dict[string, *database.DataEntity] // 我们的底层可以在这里换实现
 	AddAof func([][]byte)
+	// AddAofLocal 只写 AOF、不回写到外部的数据源：淘汰和过期只是把键从缓存里去掉，数据源里的值还要保留
+	AddAofLocal func([][]byte)
+
+	ttlMap     gdict.Dict[string, time.Time] // 键的过期时间
+	ttlLocks   *keyLocks                     // 同时修改值和过期时间（PutEntityTTL）、删除过期的键时持有
+	keyCount   int64                         // 键的个数（原子操作），DBSIZE 不需要遍历整个字典
+	usedMemory int64                         // 估算的所有键值占用的内存（原子操作），淘汰时使用
+
+	tier         *tiered.Store // 冷数据的磁盘层，没有开启时为 nil
+	tierMu       sync.RWMutex  // 值在内存和磁盘之间搬动时持有写锁，其他写操作持有读锁
+	tierCounters tierCounters
+
+	lsmData    *dict.LSMDict // dict-backend lsm 时键值和过期时间的存储，否则为 nil
+	lsmExpires *dict.LSMDict
+	lsmLocks   *keyLocks // dict-backend lsm 时写命令的键锁，读出来的值是副本，读-改-写要按键串行
+
+	Loaders *loader.Registry // GET 未命中时的读穿透，所有数据库共用一个，为 nil 时不加载
+	Events  *notify.Bus      // 键空间通知，所有数据库共用一个，为 nil 时不发出事件
 }
 
 // MakeDB 创建 DB 实例
 func MakeDB() *DB {
 	db := &DB{
-		Data: dict.MakeSyncDict(), // 底层存储（可改）
+		Data:        makeDict[*database.DataEntity](), // 底层存储（可改）
+		ttlMap:      makeDict[time.Time](),
+		ttlLocks:    &keyLocks{seed: maphash.MakeSeed()},
+		AddAof:      func(line [][]byte) {}, // 没有开启 AOF 时什么都不做
+		AddAofLocal: func(line [][]byte) {},
 	}
 	return db
 }
 
+// makeDict 根据 dict-backend 配置创建底层存储
+// 默认是分段的哈希表，支持 SCAN 的游标遍历；分段锁字典本身就是泛型的，其余的实现通过 dict.Typed 包装成带类型的字典
+func makeDict[V any]() gdict.Dict[string, V] {
+	switch strings.ToLower(config.Properties.DictBackend) {
+	case "concurrent":
+		return gdict.MakeConcurrentDict[string, V](config.Properties.DictShards)
+	case "sync":
+		return dict.Typed[V](dict.MakeSyncDict())
+	}
+	return dict.Typed[V](dict.MakeShardedHashDict(config.Properties.DictShards))
+}
+
 // Exec 执行命令（使用我们实现好的命令执行方法）
 func (db *DB) Exec(cmdLine [][]byte) resp.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0])) // 统一执行小写的命令
@@ -37,6 +81,17 @@ func (db *DB) Exec(cmdLine [][]byte) resp.Reply {
 	if validateArity(cmd.Arity, cmdLine) == false { // 校验参数个数是否合法
 		return reply.MakeArgNumErrReply(cmdName)
 	}
+	if db.lsmLocks != nil && cmd.Flags&FlagWrite != 0 {
+		defer db.lsmLocks.lockKeys(CommandKeys(cmdLine))()
+		if err := db.lsmErr(); err != nil { // 磁盘写入失败过，不再接受写命令
+			return lsmErrReply(err)
+		}
+		result := cmd.Executor(db, cmdLine[1:])
+		if err := db.lsmErr(); err != nil { // 这条命令的写入可能失败了，不能回复成功
+			return lsmErrReply(err)
+		}
+		return result
+	}
 	fun := cmd.Executor
 	// SET K V （这里 set 就不需要了）
 	return fun(db, cmdLine[1:])
@@ -56,50 +111,304 @@ func validateArity(arity int, cmdArgs [][]byte) bool {
 
 /* ---- 解析命令的实现 ----- */
 
-// GetEntity 返回绑定的 DataEntity
+// GetEntity 返回绑定的 DataEntity，并记录这次访问（LRU/LFU）；已经过期的键会被删除
 func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
-	raw, ok := db.Data.Get(key)
+	return db.getEntity(key, true)
+}
+
+// getEntity 返回绑定的 DataEntity，已经过期的键会被删除，touch 表示是否记录这次访问
+func (db *DB) getEntity(key string, touch bool) (*database.DataEntity, bool) {
+	entity, ok := db.PeekEntity(key)
 	if !ok {
+		if touch {
+			atomic.AddInt64(&db.tierCounters.misses, 1)
+			db.Notify(notify.KeyMiss, "keymiss", key)
+		}
 		return nil, false
 	}
-	entity, _ := raw.(*database.DataEntity)
+	if db.IsExpired(key) {
+		if db.expireKey(key) {
+			if touch {
+				atomic.AddInt64(&db.tierCounters.misses, 1)
+				db.Notify(notify.KeyMiss, "keymiss", key)
+			}
+			return nil, false
+		}
+		if entity, ok = db.PeekEntity(key); !ok { // 同时执行的 SET 等命令写入了新的值，重新读取
+			return nil, false
+		}
+	}
+	if !touch { // 不读取值的命令不需要把冷数据加载回内存
+		return entity, true
+	}
+	if isCold(entity) {
+		if entity, ok = db.loadCold(key); !ok {
+			return nil, false
+		}
+		atomic.AddInt64(&db.tierCounters.diskHits, 1)
+	} else {
+		atomic.AddInt64(&db.tierCounters.memoryHits, 1)
+	}
+	touchEntity(entity)
 	return entity, true
 }
 
+// PeekEntity 返回绑定的 DataEntity，不记录访问也不检查过期，给淘汰、遍历等内部逻辑使用
+func (db *DB) PeekEntity(key string) (*database.DataEntity, bool) {
+	return db.Data.Get(key)
+}
+
 // PutEntity 调用存入
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	return db.Data.Put(key, entity)
+	defer db.lockTierShared()()
+	initEntity(entity)
+	old, _ := db.PeekEntity(key)
+	result := db.Data.Put(key, entity)
+	if result > 0 { // 新插入的键
+		old = nil
+	}
+	db.dropCold(key, old)
+	atomic.AddInt64(&db.keyCount, int64(result))
+	atomic.AddInt64(&db.usedMemory, entrySize(key, entity)-entrySize(key, old))
+	if result > 0 {
+		db.Notify(notify.New, "new", key)
+	}
+	return result
 }
 
 // PutIfExists 调用存入
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
-	return db.Data.PutIfExists(key, entity)
+	defer db.lockTierShared()()
+	initEntity(entity)
+	old, _ := db.PeekEntity(key)
+	result := db.Data.PutIfExists(key, entity)
+	if result > 0 {
+		db.dropCold(key, old)
+		atomic.AddInt64(&db.usedMemory, entrySize(key, entity)-entrySize(key, old))
+	}
+	return result
 }
 
 // PutIfAbsent 调用存入
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	return db.Data.PutIfAbsent(key, entity)
+	defer db.lockTierShared()()
+	initEntity(entity)
+	result := db.Data.PutIfAbsent(key, entity)
+	if result > 0 {
+		atomic.AddInt64(&db.keyCount, 1)
+		atomic.AddInt64(&db.usedMemory, entrySize(key, entity))
+		db.Notify(notify.New, "new", key)
+	}
+	return result
+}
+
+// Notify 发出键空间事件，例：db.Notify(notify.Generic, "del", key)
+func (db *DB) Notify(class int, name string, key string) {
+	if db.Events != nil {
+		db.Events.Notify(db.Index, class, name, key)
+	}
+}
+
+// updateEntity 值被原地修改以后调用：重新估算值占用的内存，dict-backend lsm 时把修改写回磁盘
+// 键在这期间被删除或者覆盖时什么都不做
+func (db *DB) updateEntity(key string, entity *database.DataEntity) {
+	defer db.lockTierShared()()
+	old, ok := db.PeekEntity(key)
+	if !ok {
+		return
+	}
+	size := ValueSize(entity.Data, defaultMemorySamples)
+	if db.lsmData != nil { // 读出来的实体是解码出来的副本，需要重新写入
+		oldSize := old.Size
+		entity.Size = size
+		if db.Data.PutIfExists(key, entity) > 0 {
+			atomic.AddInt64(&db.usedMemory, size-oldSize)
+		}
+		return
+	}
+	if old != entity {
+		return
+	}
+	atomic.AddInt64(&db.usedMemory, size-entity.Size)
+	entity.Size = size
 }
 
 // Remove 调用删除
 func (db *DB) Remove(key string) {
-	db.Data.Remove(key)
+	db.removeEntity(key)
+}
+
+// removeEntity 删除键和它的过期时间，返回被删除的实体
+func (db *DB) removeEntity(key string) (*database.DataEntity, bool) {
+	defer db.lockTierShared()()
+	entity, exists := db.PeekEntity(key)
+	if !exists || db.Data.Remove(key) == 0 {
+		return nil, false
+	}
+	db.dropCold(key, entity)
+	db.ttlMap.Remove(key)
+	atomic.AddInt64(&db.keyCount, -1)
+	atomic.AddInt64(&db.usedMemory, -entrySize(key, entity))
+	return entity, true
+}
+
+// RemoveEntity 删除键并释放它的值，lazy 为 true 时大对象交给后台释放
+func (db *DB) RemoveEntity(key string, lazy bool) bool {
+	entity, exists := db.removeEntity(key)
+	if !exists {
+		return false
+	}
+	freeEntity(entity, lazy)
+	return true
 }
 
 // Removes 删除多个键值
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		_, exists := db.Data.Get(key)
-		if exists == true {
-			db.Remove(key)
+		if db.IsExpired(key) && db.expireKey(key) { // 已经过期的键当作不存在
+			continue
+		}
+		if db.RemoveEntity(key, false) {
+			db.Notify(notify.Generic, "del", key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Unlinks 删除多个键值，值在后台释放
+func (db *DB) Unlinks(keys ...string) (deleted int) {
+	deleted = 0
+	for _, key := range keys {
+		if db.IsExpired(key) && db.expireKey(key) {
+			continue
+		}
+		if db.RemoveEntity(key, true) {
+			db.Notify(notify.Generic, "del", key)
 			deleted++
 		}
 	}
 	return deleted
 }
 
+// ForEach 遍历所有的键值，consumer 返回 false 时停止
+func (db *DB) ForEach(consumer func(key string, entity *database.DataEntity) bool) {
+	db.Data.Range(consumer)
+}
+
+// Len 返回键的个数
+func (db *DB) Len() int {
+	return int(atomic.LoadInt64(&db.keyCount))
+}
+
+// UsedMemory 返回估算的所有键值占用的内存（字节）
+func (db *DB) UsedMemory() int64 {
+	return atomic.LoadInt64(&db.usedMemory)
+}
+
+// RandomKey 随机返回一个键，数据库为空时返回 false
+func (db *DB) RandomKey() (string, bool) {
+	keys := db.Data.RandomKeys(1)
+	if len(keys) == 0 {
+		return "", false
+	}
+	return keys[0], true
+}
+
 // Flush 清空字典
 func (db *DB) Flush() {
+	db.tierMu.Lock()
+	defer db.tierMu.Unlock()
+	db.clearTier()
 	db.Data.Clear()
+	db.ttlMap.Clear()
+	atomic.StoreInt64(&db.keyCount, 0)
+	atomic.StoreInt64(&db.usedMemory, 0)
+}
+
+// FlushAsync 清空数据库，大的数据库记作惰性释放
+// 字典在自己的锁里换上新的存储，读取的命令看到的总是一个完整的字典，旧的存储由 GC 回收
+func (db *DB) FlushAsync() {
+	if !db.Persistent() && freeEffort(db.Data) > lazyfreeThreshold {
+		atomic.AddInt64(&lazyfreeFreed, 1)
+	}
+	db.Flush()
+}
+
+/* ---- 过期时间 ----- */
+
+// Expire 设置键的过期时间
+func (db *DB) Expire(key string, expireAt time.Time) {
+	db.ttlMap.Put(key, expireAt)
+}
+
+// PutEntityTTL 存入键值并设置过期时间，expireAt 为零值时清除原来的过期时间，例：SET、RENAME
+// 和删除过期的键持有同一把键锁：新的值带着旧的过期时间被其他命令看到时，不会因为旧的过期时间已经到了被删掉
+func (db *DB) PutEntityTTL(key string, entity *database.DataEntity, expireAt time.Time) int {
+	defer db.ttlLocks.lockKeys([]string{key})()
+	result := db.PutEntity(key, entity)
+	if expireAt.IsZero() {
+		db.Persist(key)
+	} else {
+		db.Expire(key, expireAt)
+	}
+	return result
+}
+
+// Persist 删除键的过期时间，返回键原来是否有过期时间
+func (db *DB) Persist(key string) bool {
+	return db.ttlMap.Remove(key) > 0
+}
+
+// ExpireTime 返回键的过期时间，没有设置过期时间时返回 false
+func (db *DB) ExpireTime(key string) (time.Time, bool) {
+	return db.ttlMap.Get(key)
+}
+
+// IsExpired 返回键是否已经过期
+func (db *DB) IsExpired(key string) bool {
+	expireAt, ok := db.ExpireTime(key)
+	return ok && time.Now().After(expireAt)
+}
+
+// SampleKeys 随机返回一些键（不重复），淘汰时从中挑选候选
+func (db *DB) SampleKeys(limit int) []string {
+	return db.Data.RandomDistinctKeys(limit)
+}
+
+// VolatileLen 返回设置了过期时间的键的个数
+func (db *DB) VolatileLen() int {
+	return db.ttlMap.Len()
+}
+
+// RandomVolatileKeys 随机返回设置了过期时间的键（不重复）
+func (db *DB) RandomVolatileKeys(limit int) []string {
+	return db.ttlMap.RandomDistinctKeys(limit)
+}
+
+// ExpireSample 随机检查一些设置了过期时间的键，删除其中已经过期的，返回检查和删除的个数
+// 后台的定期删除调用它，不被读取的过期键也能释放内存
+func (db *DB) ExpireSample(samples int) (sampled int, expired int) {
+	keys := db.RandomVolatileKeys(samples)
+	for _, key := range keys {
+		if db.IsExpired(key) && db.expireKey(key) {
+			expired++
+		}
+	}
+	return len(keys), expired
+}
+
+// expireKey 删除已经过期的键，开启 lazyfree-lazy-expire 时在后台释放
+// 持有键锁再检查一次，过期时间被同时执行的 PutEntityTTL 清除或者改掉时不删除，返回 false
+func (db *DB) expireKey(key string) bool {
+	defer db.ttlLocks.lockKeys([]string{key})()
+	if !db.IsExpired(key) {
+		return false
+	}
+	if db.RemoveEntity(key, config.Properties.LazyfreeLazyExpire) {
+		db.AddAofLocal(utils.ToCmdLine("del", key))
+		db.Notify(notify.Expired, "expired", key)
+	}
+	return true
 }
@@ -9,6 +9,13 @@ import (
 	"strings"
 )
 
+// 命令的标记
+const (
+	FlagReadOnly = 1 << iota // 只读命令
+	FlagWrite                // 会修改数据的命令，执行前需要检查内存，必要时先淘汰一些键
+	FlagDenyOOM              // 可能增加内存使用的命令，内存超过上限且无法淘汰时拒绝执行
+)
+
 // CmdTable 给每个指令对应一个 command 结构体
 var CmdTable = make(map[string]*command)
 
@@ -18,14 +25,60 @@ type ExecFunc func(db *DB, args [][]byte) resp.Reply
 type command struct {
 	Executor ExecFunc // 这个命令的执行方法
 	Arity    int      // 这个命令的参数数量
+	Flags    int      // 这个命令的标记
+
+	// 键在命令行中的位置（命令名是第 0 个），LastKey 为负数时从末尾数，-1 是最后一个参数
+	// FirstKey 为 0 表示命令没有键
+	FirstKey int
+	LastKey  int
+	KeyStep  int
 }
 
 // RegisterCommand 注册一个新命令（这样每个指令就能有他自己的实现了）
-// name 是命令的名称，executor 是执行的方法，arity 是命令的参数数量
-func RegisterCommand(name string, executor ExecFunc, arity int) {
+// name 是命令的名称，executor 是执行的方法，arity 是命令的参数数量，flags 是命令的标记
+// 默认第 1 个参数是键，键的位置不同时用返回值的 keys 修改
+func RegisterCommand(name string, executor ExecFunc, arity int, flags int) *command {
 	name = strings.ToLower(name)
-	CmdTable[name] = &command{
+	cmd := &command{
 		Executor: executor,
 		Arity:    arity,
+		Flags:    flags,
+	}
+	if arity >= 2 || arity <= -2 {
+		cmd.FirstKey, cmd.LastKey, cmd.KeyStep = 1, 1, 1
+	}
+	CmdTable[name] = cmd
+	return cmd
+}
+
+// keys 设置键在命令行中的位置，first 为 0 表示命令没有键
+func (cmd *command) keys(first int, last int, step int) *command {
+	cmd.FirstKey, cmd.LastKey, cmd.KeyStep = first, last, step
+	return cmd
+}
+
+// CommandFlags 返回命令的标记，未知的命令返回 0
+func CommandFlags(name string) int {
+	cmd, ok := CmdTable[strings.ToLower(name)]
+	if !ok {
+		return 0
+	}
+	return cmd.Flags
+}
+
+// CommandKeys 返回命令行中的键，未知的命令和没有键的命令返回 nil
+func CommandKeys(cmdLine [][]byte) []string {
+	cmd, ok := CmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || cmd.FirstKey == 0 || !validateArity(cmd.Arity, cmdLine) {
+		return nil
+	}
+	last := cmd.LastKey
+	if last < 0 {
+		last += len(cmdLine)
+	}
+	var keys []string
+	for i := cmd.FirstKey; i <= last && i < len(cmdLine); i += cmd.KeyStep {
+		keys = append(keys, string(cmdLine[i]))
 	}
+	return keys
 }
@@ -0,0 +1,222 @@
+package structure
+
+/*
+ * 实现布谷鸟过滤器的命令 CF.*，和 RedisBloom 兼容，和布隆过滤器相比支持删除
+ * CF.ADD、CF.ADDNX 写入不存在的键时按默认参数创建过滤器
+ */
+
+import (
+	"GoMiniCache/datastruct/cuckoo"
+	"GoMiniCache/interface/database"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/notify"
+	"GoMiniCache/resp/reply"
+	"strconv"
+	"strings"
+)
+
+const defaultCuckooCapacity = 1024
+
+// getCuckoo 返回键对应的布谷鸟过滤器，键不存在时返回 nil
+func (db *DB) getCuckoo(key string) (*cuckoo.Filter, *database.DataEntity, reply.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil, nil
+	}
+	filter, ok := entity.Data.(*cuckoo.Filter)
+	if !ok {
+		return nil, nil, &reply.WrongTypeErrReply{}
+	}
+	return filter, entity, nil
+}
+
+// getOrCreateCuckoo 返回键对应的布谷鸟过滤器，键不存在时按默认参数创建
+func (db *DB) getOrCreateCuckoo(key string) (*cuckoo.Filter, *database.DataEntity, reply.ErrorReply) {
+	filter, entity, errReply := db.getCuckoo(key)
+	if errReply != nil || filter != nil {
+		return filter, entity, errReply
+	}
+	filter = cuckoo.New(defaultCuckooCapacity, cuckoo.DefaultBucketSize, cuckoo.DefaultMaxIterations, cuckoo.DefaultExpansion)
+	entity = &database.DataEntity{Data: filter}
+	if db.PutIfAbsent(key, entity) == 0 { // 别的客户端先创建了
+		return db.getCuckoo(key)
+	}
+	return filter, entity, nil
+}
+
+// parseCuckooOption 解析 CF.RESERVE 的可选参数，值需要在 [lower, upper] 之间
+func parseCuckooOption(arg []byte, lower uint64, upper uint64, name string) (uint64, reply.ErrorReply) {
+	v, err := strconv.ParseUint(string(arg), 10, 64)
+	if err != nil || v < lower || v > upper {
+		return 0, reply.MakeErrReply("ERR " + name + " must be in range [" +
+			strconv.FormatUint(lower, 10) + ", " + strconv.FormatUint(upper, 10) + "]")
+	}
+	return v, nil
+}
+
+// execCFReserve 创建布谷鸟过滤器，例：CF.RESERVE key capacity [BUCKETSIZE size] [MAXITERATIONS n] [EXPANSION expansion]
+func execCFReserve(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	capacity, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil || capacity == 0 {
+		return reply.MakeErrReply("ERR (capacity should be larger than 0)")
+	}
+	if capacity > cuckoo.MaxCapacity {
+		return reply.MakeErrReply("ERR (capacity should be at most " + strconv.FormatUint(cuckoo.MaxCapacity, 10) + ")")
+	}
+	bucketSize := uint64(cuckoo.DefaultBucketSize)
+	maxIterations := uint64(cuckoo.DefaultMaxIterations)
+	expansion := uint64(cuckoo.DefaultExpansion)
+	for i := 2; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		var errReply reply.ErrorReply
+		switch strings.ToLower(string(args[i])) {
+		case "bucketsize":
+			bucketSize, errReply = parseCuckooOption(args[i+1], 1, 255, "BUCKETSIZE")
+		case "maxiterations":
+			maxIterations, errReply = parseCuckooOption(args[i+1], 1, 65535, "MAXITERATIONS")
+		case "expansion":
+			expansion, errReply = parseCuckooOption(args[i+1], 0, 32768, "EXPANSION")
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+		if errReply != nil {
+			return errReply
+		}
+		i++
+	}
+	if errReply := checkFilterBytes(cuckoo.TableBytes(capacity, uint8(bucketSize)), cuckoo.MaxTableBytes); errReply != nil {
+		return errReply
+	}
+	filter := cuckoo.New(capacity, uint8(bucketSize), uint16(maxIterations), uint16(expansion))
+	if db.PutIfAbsent(key, &database.DataEntity{Data: filter}) == 0 {
+		return reply.MakeErrReply("ERR item exists")
+	}
+	db.AddAof(utils.ToCmdLine2("cf.reserve", args...))
+	db.Notify(notify.Generic, "cf.reserve", key)
+	return reply.MakeOkReply()
+}
+
+// cuckooAddErrReply 插入失败时的回复
+func cuckooAddErrReply(err error) reply.ErrorReply {
+	if err == cuckoo.ErrFull {
+		return reply.MakeErrReply("ERR Filter is full")
+	}
+	return reply.MakeErrReply("ERR " + err.Error())
+}
+
+// execCFAdd 插入元素，同一个元素可以插入多次，例：CF.ADD key item
+func execCFAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	filter, entity, errReply := db.getOrCreateCuckoo(key)
+	if errReply != nil {
+		return errReply
+	}
+	if err := filter.Add(args[1], filterMemoryLimit()); err != nil {
+		return cuckooAddErrReply(err)
+	}
+	db.updateEntity(key, entity)
+	db.AddAof(utils.ToCmdLine2("cf.add", args...))
+	db.Notify(notify.Generic, "cf.add", key)
+	return reply.MakeBoolReply(true)
+}
+
+// execCFAddNX 元素不存在时插入，返回是否插入了，例：CF.ADDNX key item
+func execCFAddNX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	filter, entity, errReply := db.getOrCreateCuckoo(key)
+	if errReply != nil {
+		return errReply
+	}
+	added, err := filter.AddNX(args[1], filterMemoryLimit())
+	if err != nil {
+		return cuckooAddErrReply(err)
+	}
+	if !added {
+		return reply.MakeBoolReply(false)
+	}
+	db.updateEntity(key, entity)
+	db.AddAof(utils.ToCmdLine2("cf.addnx", args...))
+	db.Notify(notify.Generic, "cf.addnx", key)
+	return reply.MakeBoolReply(true)
+}
+
+// execCFDel 删除元素的一次插入，例：CF.DEL key item
+func execCFDel(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	filter, entity, errReply := db.getCuckoo(key)
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeErrReply("ERR Not found")
+	}
+	if !filter.Delete(args[1]) {
+		return reply.MakeBoolReply(false)
+	}
+	db.updateEntity(key, entity)
+	db.AddAof(utils.ToCmdLine2("cf.del", args...))
+	db.Notify(notify.Generic, "cf.del", key)
+	return reply.MakeBoolReply(true)
+}
+
+// execCFExists 返回元素是否可能存在，例：CF.EXISTS key item
+func execCFExists(db *DB, args [][]byte) resp.Reply {
+	filter, _, errReply := db.getCuckoo(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeBoolReply(filter != nil && filter.Exists(args[1]))
+}
+
+// execCFCount 返回元素可能被插入的次数，例：CF.COUNT key item
+func execCFCount(db *DB, args [][]byte) resp.Reply {
+	filter, _, errReply := db.getCuckoo(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(filter.Count(args[1])))
+}
+
+// execCFScanDump 导出过滤器的状态，和 BF.SCANDUMP 相同，例：CF.SCANDUMP key iterator
+func execCFScanDump(db *DB, args [][]byte) resp.Reply {
+	filter, _, errReply := db.getCuckoo(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeErrReply("ERR not found")
+	}
+	return scanDump(filter, args[1])
+}
+
+// execCFLoadChunk 导入 CF.SCANDUMP 导出的数据，覆盖原来的值，例：CF.LOADCHUNK key iterator data
+func execCFLoadChunk(db *DB, args [][]byte) resp.Reply {
+	if errReply := checkChunkIterator(args[1]); errReply != nil {
+		return errReply
+	}
+	filter, err := cuckoo.Unmarshal(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	db.PutEntity(string(args[0]), &database.DataEntity{Data: filter})
+	db.AddAof(utils.ToCmdLine2("cf.loadchunk", args...))
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("CF.Reserve", execCFReserve, -3, FlagWrite|FlagDenyOOM)
+	RegisterCommand("CF.Add", execCFAdd, 3, FlagWrite|FlagDenyOOM)
+	RegisterCommand("CF.AddNX", execCFAddNX, 3, FlagWrite|FlagDenyOOM)
+	RegisterCommand("CF.Del", execCFDel, 3, FlagWrite)
+	RegisterCommand("CF.Exists", execCFExists, 3, FlagReadOnly)
+	RegisterCommand("CF.Count", execCFCount, 3, FlagReadOnly)
+	RegisterCommand("CF.ScanDump", execCFScanDump, 3, FlagReadOnly)
+	RegisterCommand("CF.LoadChunk", execCFLoadChunk, 4, FlagWrite|FlagDenyOOM)
+}
@@ -0,0 +1,71 @@
+package structure
+
+/*
+ * 值的紧凑编码
+ * 规范的整数字符串按 int64 保存，0 到 9999 直接使用预先分配好的共享对象，不再为每个键分配内存
+ * 访问时间和 LFU 计数器保存在每个键自己的 DataEntity 里，所以共享整数不影响淘汰（Redis 开启 LRU/LFU 时会关闭共享整数）
+ */
+
+import (
+	"GoMiniCache/interface/database"
+	"GoMiniCache/lib/utils"
+	"math"
+	"strconv"
+)
+
+const (
+	sharedIntegers    = 10000         // 共享整数的范围 [0, sharedIntegers)，和 Redis 的 OBJ_SHARED_INTEGERS 一致
+	sharedRefCount    = math.MaxInt32 // 共享对象的引用计数，OBJECT REFCOUNT 返回这个值
+	integerValueBytes = 8             // 不共享的整数装箱以后占用的内存
+)
+
+// sharedIntegerPool 预先装箱的整数对象，把 int64 放进 interface{} 时不需要再分配内存
+var sharedIntegerPool [sharedIntegers]interface{}
+
+func init() {
+	for i := range sharedIntegerPool {
+		sharedIntegerPool[i] = int64(i)
+	}
+}
+
+// stringValue 把字符串转换成保存在 DataEntity 中的值：规范的整数保存为 int64，其他的保持 []byte
+func stringValue(b []byte) interface{} {
+	v, ok := utils.ParseInteger(b)
+	if !ok {
+		return b
+	}
+	if v >= 0 && v < sharedIntegers {
+		return sharedIntegerPool[v]
+	}
+	return v
+}
+
+// stringBytes 把字符串类型的值转换回 []byte，值不是字符串时返回 false
+func stringBytes(val interface{}) ([]byte, bool) {
+	switch v := val.(type) {
+	case []byte:
+		return v, true
+	case int64:
+		return strconv.AppendInt(nil, v, 10), true
+	}
+	return nil, false
+}
+
+// EntityBytes 返回字符串类型的实体的值，其他类型返回 false
+func EntityBytes(entity *database.DataEntity) ([]byte, bool) {
+	return stringBytes(entity.Data)
+}
+
+// isSharedInteger 返回值是否是共享的整数对象
+func isSharedInteger(val interface{}) bool {
+	v, ok := val.(int64)
+	return ok && v >= 0 && v < sharedIntegers
+}
+
+// integerSize 估算整数值占用的内存，共享对象不属于任何一个键
+func integerSize(v int64) int64 {
+	if v >= 0 && v < sharedIntegers {
+		return 0
+	}
+	return integerValueBytes
+}
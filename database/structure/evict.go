@@ -0,0 +1,89 @@
+package structure
+
+/*
+ * 内存淘汰需要的每个键的元数据：最近访问时间（LRU）和对数访问计数器（LFU）
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/interface/database"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	lfuInitVal     = 5   // 新键的 LFU 计数器初始值，避免刚写入的键马上被淘汰
+	lfuMaxVal      = 255 // LFU 计数器的最大值
+	defaultLogFact = 10  // lfu-log-factor 的默认值
+)
+
+// nowMs 返回当前的 Unix 毫秒时间
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// initEntity 存入数据库前计算实体占用的内存，新的实体从现在开始计算访问时间
+func initEntity(entity *database.DataEntity) {
+	entity.Size = ValueSize(entity.Data, defaultMemorySamples)
+	if atomic.LoadInt64(&entity.AccessTime) == 0 {
+		atomic.StoreInt64(&entity.AccessTime, nowMs())
+		atomic.StoreUint32(&entity.LFUCounter, lfuInitVal)
+	}
+}
+
+// touchEntity 记录一次访问：更新访问时间，按概率增加 LFU 计数器
+func touchEntity(entity *database.DataEntity) {
+	now := nowMs()
+	counter := lfuDecr(entity, now)
+	counter = lfuLogIncr(counter)
+	atomic.StoreUint32(&entity.LFUCounter, counter)
+	atomic.StoreInt64(&entity.AccessTime, now)
+}
+
+// lfuLogIncr 对数地增加计数器：计数器越大增加的概率越小，lfu-log-factor 越大增加得越慢
+func lfuLogIncr(counter uint32) uint32 {
+	if counter >= lfuMaxVal {
+		return lfuMaxVal
+	}
+	factor := config.Properties.LfuLogFactor
+	if factor <= 0 {
+		factor = defaultLogFact
+	}
+	baseVal := float64(0)
+	if counter > lfuInitVal {
+		baseVal = float64(counter - lfuInitVal)
+	}
+	p := 1.0 / (baseVal*float64(factor) + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecr 返回衰减后的计数器：距离上次访问每过 lfu-decay-time 分钟计数器减一，lfu-decay-time 为 0 时不衰减
+func lfuDecr(entity *database.DataEntity, now int64) uint32 {
+	counter := atomic.LoadUint32(&entity.LFUCounter)
+	decay := config.Properties.LfuDecayTime
+	if decay <= 0 {
+		return counter
+	}
+	periods := (now - atomic.LoadInt64(&entity.AccessTime)) / int64(time.Minute/time.Millisecond) / int64(decay)
+	if periods <= 0 {
+		return counter
+	}
+	if periods >= int64(counter) {
+		return 0
+	}
+	return counter - uint32(periods)
+}
+
+// LFUCount 返回实体衰减后的 LFU 计数器，不会修改实体
+func LFUCount(entity *database.DataEntity) uint32 {
+	return lfuDecr(entity, nowMs())
+}
+
+// IdleTime 返回实体距离上次访问过去的毫秒数
+func IdleTime(entity *database.DataEntity) int64 {
+	return nowMs() - atomic.LoadInt64(&entity.AccessTime)
+}
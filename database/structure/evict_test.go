@@ -0,0 +1,36 @@
+package structure
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/interface/database"
+	"testing"
+	"time"
+)
+
+func TestLFUDecay(t *testing.T) {
+	decayTime := config.Properties.LfuDecayTime
+	defer func() { config.Properties.LfuDecayTime = decayTime }()
+
+	now := nowMs()
+	entity := &database.DataEntity{
+		LFUCounter: 10,
+		AccessTime: now - int64(3*time.Minute/time.Millisecond),
+	}
+	config.Properties.LfuDecayTime = 1
+	if counter := lfuDecr(entity, now); counter != 7 {
+		t.Errorf("expected 7 after 3 periods, got %d", counter)
+	}
+	config.Properties.LfuDecayTime = 2
+	if counter := lfuDecr(entity, now); counter != 9 {
+		t.Errorf("expected 9 after 1 period, got %d", counter)
+	}
+	config.Properties.LfuDecayTime = 0 // 0 表示不衰减
+	if counter := lfuDecr(entity, now); counter != 10 {
+		t.Errorf("expected no decay, got %d", counter)
+	}
+	entity.AccessTime = now - int64(time.Hour/time.Millisecond)
+	config.Properties.LfuDecayTime = 1
+	if counter := lfuDecr(entity, now); counter != 0 {
+		t.Errorf("expected counter to decay to 0, got %d", counter)
+	}
+}
@@ -5,32 +5,58 @@ package structure
  */
 
 import (
+	"GoMiniCache/config"
+	"GoMiniCache/datastruct/bloom"
+	"GoMiniCache/datastruct/cuckoo"
+	"GoMiniCache/interface/database"
 	"GoMiniCache/interface/resp"
 	"GoMiniCache/lib/utils"
 	"GoMiniCache/lib/wildcard"
+	"GoMiniCache/notify"
 	"GoMiniCache/resp/reply"
+	"strings"
 )
 
 // execDel 删除键值，例：K1 K2 K3
+// 开启 lazyfree-lazy-user-del 时和 UNLINK 一样在后台释放
 func execDel(db *DB, args [][]byte) resp.Reply {
 	keys := make([]string, len(args))
 	for i, v := range args {
 		keys[i] = string(v)
 	}
 
-	deleted := db.Removes(keys...)
+	var deleted int
+	if config.Properties.LazyfreeLazyUserDel {
+		deleted = db.Unlinks(keys...)
+	} else {
+		deleted = db.Removes(keys...)
+	}
 	if deleted > 0 {
 		db.AddAof(utils.ToCmdLine2("del", args...))
 	}
 	return reply.MakeIntReply(int64(deleted)) // 回复有多少个操作
 }
 
+// execUnlink 删除键值，和 DEL 不同的是值会在后台释放，例：K1 K2 K3
+func execUnlink(db *DB, args [][]byte) resp.Reply {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+
+	deleted := db.Unlinks(keys...)
+	if deleted > 0 {
+		db.AddAof(utils.ToCmdLine2("unlink", args...))
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
 // execExists 查看键是否存在
 func execExists(db *DB, args [][]byte) resp.Reply {
 	result := int64(0)
 	for _, arg := range args {
 		key := string(arg)
-		_, exists := db.GetEntity(key)
+		_, exists := db.getEntity(key, false)
 		if exists {
 			result++
 		}
@@ -38,26 +64,65 @@ func execExists(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeIntReply(result)
 }
 
-// execFlushDB 删除所有键值
+// execFlushDB 删除所有键值，例：FLUSHDB [ASYNC|SYNC]
 func execFlushDB(db *DB, args [][]byte) resp.Reply {
-	db.Flush()
+	lazy, errReply := ParseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	if lazy {
+		db.FlushAsync()
+	} else {
+		db.Flush()
+	}
 	db.AddAof(utils.ToCmdLine2("flushdb", args...))
 	return reply.MakeOkReply()
 }
 
+// ParseFlushMode 解析 FLUSHDB/FLUSHALL 的 ASYNC|SYNC 参数，没有参数时由 lazyfree-lazy-user-flush 决定
+func ParseFlushMode(args [][]byte) (lazy bool, errReply resp.Reply) {
+	if len(args) == 0 {
+		return config.Properties.LazyfreeLazyUserFlush, nil
+	}
+	if len(args) > 1 {
+		return false, reply.MakeSyntaxErrReply()
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "async":
+		return true, nil
+	case "sync":
+		return false, nil
+	}
+	return false, reply.MakeSyntaxErrReply()
+}
+
 // execType 返回实体的类型，包括: string, list, hash, set and zset
 func execType(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	entity, exists := db.GetEntity(key)
+	entity, exists := db.getEntity(key, false)
 	if exists == false {
 		return reply.MakeStatusReply("none")
 	}
-	switch entity.Data.(type) { // 目前只实现了 string
-	case []byte: // string 存的是字节的切片
-		return reply.MakeStatusReply("string")
+	typeName := entityType(entity)
+	if typeName == "" { // TODO: 其他的数据结构的实现
+		return reply.MakeUnknownErrReply()
+	}
+	return reply.MakeStatusReply(typeName)
+}
+
+// entityType 返回实体的类型名称，未知的类型返回空字符串
+func entityType(entity *database.DataEntity) string {
+	switch val := entity.Data.(type) {
+	case []byte, int64: // string 存的是字节的切片，规范的整数存的是 int64
+		return "string"
+	case *bloom.Filter: // 和 RedisBloom 的模块类型名相同
+		return "MBbloom--"
+	case *cuckoo.Filter:
+		return "MBbloomCF"
+	case *coldValue: // 值在磁盘上
+		return val.typeName
 	}
-	// TODO: 其他的数据结构的实现 case
-	return reply.MakeUnknownErrReply()
+	return ""
 }
 
 // execRename 给 key 改名称（底层是删除原键值，插入新键值）
@@ -72,9 +137,12 @@ func execRename(db *DB, args [][]byte) resp.Reply {
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
-	db.PutEntity(dest, entity)
+	expireAt, _ := db.ExpireTime(src) // 过期时间跟着键走，没有过期时间时是零值
 	db.Remove(src)
+	db.PutEntityTTL(dest, entity, expireAt)
 	db.AddAof(utils.ToCmdLine2("rename", args...))
+	db.Notify(notify.Generic, "rename_from", src)
+	db.Notify(notify.Generic, "rename_to", dest)
 	return reply.MakeOkReply()
 }
 
@@ -83,7 +151,7 @@ func execRenameNx(db *DB, args [][]byte) resp.Reply {
 	src := string(args[0])
 	dest := string(args[1])
 
-	_, ok := db.GetEntity(dest)
+	_, ok := db.getEntity(dest, false)
 	if ok == true {
 		return reply.MakeIntReply(0)
 	}
@@ -92,9 +160,15 @@ func execRenameNx(db *DB, args [][]byte) resp.Reply {
 	if ok == false {
 		return reply.MakeErrReply("no such key")
 	}
+	expireAt, hasTTL := db.ExpireTime(src)
 	db.Remove(src)
 	db.PutEntity(dest, entity)
+	if hasTTL {
+		db.Expire(dest, expireAt)
+	}
 	db.AddAof(utils.ToCmdLine2("renamenx", args...))
+	db.Notify(notify.Generic, "rename_from", src)
+	db.Notify(notify.Generic, "rename_to", dest)
 	return reply.MakeIntReply(1)
 }
 
@@ -102,8 +176,8 @@ func execRenameNx(db *DB, args [][]byte) resp.Reply {
 func execKeys(db *DB, args [][]byte) resp.Reply {
 	pattern := wildcard.CompilePattern(string(args[0])) // 将通配符取出转换成 pattern
 	result := make([][]byte, 0)
-	db.Data.ForEach(func(key string, val interface{}) bool {
-		if pattern.IsMatch(key) { // 判断该字符串是否匹配
+	db.ForEach(func(key string, entity *database.DataEntity) bool {
+		if pattern.IsMatch(key) && !db.IsExpired(key) { // 判断该字符串是否匹配
 			result = append(result, []byte(key))
 		}
 		return true
@@ -111,12 +185,44 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeMultiBulkReply(result)
 }
 
+// execDBSize 返回当前数据库中键的个数
+func execDBSize(db *DB, args [][]byte) resp.Reply {
+	return reply.MakeIntReply(int64(db.Len()))
+}
+
+// execRandomKey 随机返回一个键，数据库为空时返回空
+func execRandomKey(db *DB, args [][]byte) resp.Reply {
+	key, ok := db.RandomKey()
+	if !ok {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply([]byte(key))
+}
+
+// CopyEntity 深拷贝一个实体，拷贝出来的值和原来的值互不影响
+func CopyEntity(entity *database.DataEntity) *database.DataEntity {
+	switch val := entity.Data.(type) {
+	case []byte:
+		bytes := make([]byte, len(val))
+		copy(bytes, val)
+		return &database.DataEntity{Data: bytes}
+	case *bloom.Filter:
+		return &database.DataEntity{Data: val.Clone()}
+	case *cuckoo.Filter:
+		return &database.DataEntity{Data: val.Clone()}
+	}
+	return &database.DataEntity{Data: entity.Data} // int64 之类不可变的值可以直接共用
+}
+
 func init() {
-	RegisterCommand("Del", execDel, -2)          // 删除键值的参数数量需要 >=2
-	RegisterCommand("Exists", execExists, -2)    // 判断是否存在的参数需要 >=2
-	RegisterCommand("Keys", execKeys, 2)         // 判断键是否存在参数需要 ==2
-	RegisterCommand("FlushDB", execFlushDB, -1)  // 清空字典参数需要 >=1
-	RegisterCommand("Type", execType, 2)         // 判断键值类型参数需要 ==2
-	RegisterCommand("Rename", execRename, 3)     // 修改键名的参数需要 ==3
-	RegisterCommand("RenameNx", execRenameNx, 3) // 修改键名的参数需要 ==3
+	RegisterCommand("Del", execDel, -2, FlagWrite).keys(1, -1, 1)          // 删除键值的参数数量需要 >=2
+	RegisterCommand("Unlink", execUnlink, -2, FlagWrite).keys(1, -1, 1)    // 删除键值的参数数量需要 >=2
+	RegisterCommand("Exists", execExists, -2, FlagReadOnly).keys(1, -1, 1) // 判断是否存在的参数需要 >=2
+	RegisterCommand("Keys", execKeys, 2, FlagReadOnly).keys(0, 0, 0)       // 判断键是否存在参数需要 ==2
+	RegisterCommand("FlushDB", execFlushDB, -1, FlagWrite)                 // 清空字典参数需要 >=1
+	RegisterCommand("Type", execType, 2, FlagReadOnly)                     // 判断键值类型参数需要 ==2
+	RegisterCommand("Rename", execRename, 3, FlagWrite).keys(1, 2, 1)      // 修改键名的参数需要 ==3
+	RegisterCommand("RenameNx", execRenameNx, 3, FlagWrite).keys(1, 2, 1)  // 修改键名的参数需要 ==3
+	RegisterCommand("DBSize", execDBSize, 1, FlagReadOnly)                 // 返回键的个数参数需要 ==1
+	RegisterCommand("RandomKey", execRandomKey, 1, FlagReadOnly)           // 随机返回一个键参数需要 ==1
 }
@@ -0,0 +1,128 @@
+package structure
+
+import (
+	"GoMiniCache/datastruct/dict"
+	"GoMiniCache/interface/database"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/utils"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// execLine 在 db 上执行一条命令，例：execLine(db, "set", "k", "v")
+func execLine(db *DB, args ...string) resp.Reply {
+	return db.Exec(utils.ToCmdLine(args...))
+}
+
+// assertReply 比较回复的 RESP2 编码
+func assertReply(t *testing.T, result resp.Reply, expected string) {
+	t.Helper()
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, got %q", expected, result.ToBytes())
+	}
+}
+
+// bigEntity 元素个数超过 lazyfreeThreshold 的值
+func bigEntity() *database.DataEntity {
+	d := dict.MakeSyncDict()
+	for i := 0; i <= lazyfreeThreshold; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	return &database.DataEntity{Data: d}
+}
+
+// waitLazyfree 等待释放协程处理完，惰性释放的对象个数达到 freed
+func waitLazyfree(t *testing.T, freed int64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		pending, n := LazyfreeStats()
+		if pending == 0 && n >= freed {
+			if n != freed {
+				t.Errorf("expected %d lazyfreed objects, got %d", freed, n)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lazyfree worker did not finish: pending %d, freed %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	db := MakeDB()
+	var aof [][][]byte
+	db.AddAof = func(line [][]byte) { aof = append(aof, line) }
+	execLine(db, "set", "a", "1")
+	execLine(db, "set", "b", "2")
+	db.PutEntity("big", bigEntity())
+
+	_, freed := LazyfreeStats()
+	assertReply(t, execLine(db, "unlink", "a", "big", "missing"), ":2\r\n")
+	waitLazyfree(t, freed+1)
+	assertReply(t, execLine(db, "exists", "a", "b", "big"), ":1\r\n")
+	assertReply(t, execLine(db, "dbsize"), ":1\r\n")
+	last := aof[len(aof)-1]
+	if string(last[0]) != "unlink" || len(last) != 4 {
+		t.Errorf("unexpected aof line %q", last)
+	}
+
+	// 没有删除任何键时不写 AOF
+	count := len(aof)
+	assertReply(t, execLine(db, "unlink", "missing"), ":0\r\n")
+	if len(aof) != count {
+		t.Error("unlink of missing keys should not be written to aof")
+	}
+}
+
+func TestFlushDBAsync(t *testing.T) {
+	for _, mode := range []string{"async", "sync"} {
+		db := MakeDB()
+		for i := 0; i < 100; i++ {
+			execLine(db, "set", strconv.Itoa(i), "v")
+			execLine(db, "expire", strconv.Itoa(i), "100")
+		}
+		assertReply(t, execLine(db, "flushdb", mode), "+OK\r\n")
+		assertReply(t, execLine(db, "dbsize"), ":0\r\n")
+		assertReply(t, execLine(db, "ttl", "1"), ":-2\r\n")
+		if db.UsedMemory() != 0 {
+			t.Errorf("%s: used memory should be 0, got %d", mode, db.UsedMemory())
+		}
+		// 清空以后数据库可以继续使用
+		execLine(db, "set", "k", "v")
+		assertReply(t, execLine(db, "get", "k"), "$1\r\nv\r\n")
+	}
+	assertReply(t, execLine(MakeDB(), "flushdb", "later"), "-Err syntax error\r\n")
+}
+
+// TestFlushDBAsyncConcurrentReads 清空的同时读写数据库，配合 go test -race 检查
+func TestFlushDBAsyncConcurrentReads(t *testing.T) {
+	db := MakeDB()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := strconv.Itoa(n % 50)
+				execLine(db, "set", key, "v")
+				execLine(db, "get", key)
+				db.ForEach(func(key string, entity *database.DataEntity) bool { return true })
+			}
+		}(i)
+	}
+	for i := 0; i < 100; i++ {
+		execLine(db, "flushdb", "async")
+	}
+	close(stop)
+	wg.Wait()
+}
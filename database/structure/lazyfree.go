@@ -0,0 +1,89 @@
+package structure
+
+/*
+ * 惰性释放：UNLINK、FLUSHDB ASYNC 等命令只把值从数据库中摘下来，大的对象交给后台的释放协程
+ * Go 的内存由 GC 回收，丢掉最后一个引用就是释放，不需要像 Redis 那样逐个释放元素；
+ * 值可能还被正在执行的命令持有，释放协程也不能修改它，只是接过引用，按批丢掉并更新统计
+ * 队列满了就在调用者中直接丢掉引用，命令不会因为释放而阻塞
+ * FLUSHDB ASYNC 的字典在 Clear 中换上新的存储，旧的存储已经没有引用，只记录统计
+ */
+
+import (
+	"GoMiniCache/datastruct/dict"
+	"GoMiniCache/datastruct/gdict"
+	"GoMiniCache/interface/database"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	lazyfreeThreshold = 64   // 元素个数超过这个值的对象才交给释放协程，和 Redis 的 LAZYFREE_THRESHOLD 一致
+	lazyfreeQueueSize = 1024 // 最多等待释放的对象个数
+	lazyfreeBatch     = 64   // 释放协程每次最多处理的对象个数
+)
+
+var (
+	lazyfreeQueue   = make(chan interface{}, lazyfreeQueueSize)
+	lazyfreeOnce    sync.Once
+	lazyfreePending int64 // 等待释放的对象个数（原子操作）
+	lazyfreeFreed   int64 // 惰性释放的对象个数（原子操作）
+)
+
+// LazyfreeStats 返回等待释放和已经惰性释放的对象个数
+func LazyfreeStats() (pending int64, freed int64) {
+	return atomic.LoadInt64(&lazyfreePending), atomic.LoadInt64(&lazyfreeFreed)
+}
+
+// lazyfree 把摘下来的对象交给释放协程，队列满了时直接丢掉引用
+func lazyfree(obj interface{}) {
+	lazyfreeOnce.Do(func() { go lazyfreeLoop() })
+	atomic.AddInt64(&lazyfreePending, 1)
+	select {
+	case lazyfreeQueue <- obj:
+	default:
+		atomic.AddInt64(&lazyfreePending, -1)
+		atomic.AddInt64(&lazyfreeFreed, 1)
+	}
+}
+
+// lazyfreeLoop 释放协程：一次取出一批对象，丢掉引用以后更新统计
+func lazyfreeLoop() {
+	batch := make([]interface{}, 0, lazyfreeBatch)
+	for obj := range lazyfreeQueue {
+		batch = append(batch, obj)
+	collect:
+		for len(batch) < lazyfreeBatch {
+			select {
+			case obj := <-lazyfreeQueue:
+				batch = append(batch, obj)
+			default:
+				break collect
+			}
+		}
+		clear(batch) // 丢掉引用，之后由 GC 回收
+		atomic.AddInt64(&lazyfreeFreed, int64(len(batch)))
+		atomic.AddInt64(&lazyfreePending, -int64(len(batch)))
+		batch = batch[:0]
+	}
+}
+
+// freeEffort 估算释放一个对象的代价（大约是需要释放的元素个数）
+func freeEffort(obj interface{}) int {
+	switch val := obj.(type) {
+	case *database.DataEntity:
+		return freeEffort(val.Data)
+	case dict.Dict:
+		return val.Len()
+	case gdict.Dict[string, *database.DataEntity]: // FLUSHDB ASYNC 清空的整个数据库
+		return val.Len()
+	}
+	return 1 // string 之类的对象一次就能释放
+}
+
+// freeEntity 释放已经从数据库中摘下来的实体，lazy 为 true 时大的实体交给释放协程
+// 实体可能还被正在执行的命令持有，所以不修改它，只丢掉数据库对它的引用
+func freeEntity(entity *database.DataEntity, lazy bool) {
+	if entity != nil && lazy && freeEffort(entity) > lazyfreeThreshold {
+		lazyfree(entity)
+	}
+}
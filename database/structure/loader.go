@@ -0,0 +1,43 @@
+package structure
+
+/*
+ * GET 未命中时的读穿透：Database 在 execMu 外面调用注册的 Loader，这里只负责判断要不要加载和把加载到的值写入数据库和 AOF
+ * Loader 可能要访问远端的数据源，不能在持有 execMu 时调用，否则慢的数据源会挡住所有的独占命令
+ */
+
+import (
+	"GoMiniCache/interface/database"
+	"GoMiniCache/lib/utils"
+	"strconv"
+	"time"
+)
+
+// NeedsLoad 返回 GET 这个键时是否需要调用 Loader：注册了匹配的 Loader 并且键不存在（或者已经过期）
+func (db *DB) NeedsLoad(key string) bool {
+	if db.Loaders == nil || !db.Loaders.Match(key) {
+		return false
+	}
+	if _, ok := db.PeekEntity(key); !ok {
+		return true
+	}
+	return db.IsExpired(key)
+}
+
+// StoreLoaded 把 Loader 加载到的值写入数据库和 AOF，ttl 为 0 表示不过期
+// 加载期间其他客户端写入了这个键时以数据库里的值为准，不覆盖
+func (db *DB) StoreLoaded(key string, val []byte, ttl time.Duration) {
+	if db.IsExpired(key) { // 过期的旧值先按过期删除，写 AOF 和发出通知
+		db.expireKey(key)
+	}
+	defer db.ttlLocks.lockKeys([]string{key})() // 值和过期时间一起写入，其他命令看不到没有过期时间的值
+	if db.PutIfAbsent(key, &database.DataEntity{Data: stringValue(val)}) == 0 {
+		return
+	}
+	db.AddAof(utils.ToCmdLine2("set", []byte(key), val))
+	if ttl > 0 {
+		at := time.Now().Add(ttl)
+		db.Expire(key, at)
+		ms := at.UnixNano() / int64(time.Millisecond)
+		db.AddAof(utils.ToCmdLine("pexpireat", key, strconv.FormatInt(ms, 10)))
+	}
+}
@@ -0,0 +1,198 @@
+package structure
+
+/*
+ * dict-backend lsm: 键值和过期时间保存在磁盘上的 LSM-tree 引擎里，重启时直接打开，不需要重放 AOF
+ * 值编码成 类型(1 字节) + 内容: s 表示字节数组，i 表示按 int64 保存的整数，b、c 分别是布隆过滤器和布谷鸟过滤器的全部状态
+ * 过滤器是原地修改的，修改以后通过 updateEntity 重新写入；每次读出的都是副本，所以写命令按键加锁（keyLocks），
+ * 否则两个并发的 BF.ADD 各自修改自己的副本，后写回的会覆盖先写回的
+ * 访问时间和 LFU 计数器不写到磁盘上，每次读出来的实体都从现在开始计算
+ */
+
+import (
+	"GoMiniCache/datastruct/bloom"
+	"GoMiniCache/datastruct/cuckoo"
+	"GoMiniCache/datastruct/dict"
+	"GoMiniCache/datastruct/lsm"
+	"GoMiniCache/interface/database"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/reply"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	lsmTypeBytes   = 's'
+	lsmTypeInteger = 'i'
+	lsmTypeBloom   = 'b'
+	lsmTypeCuckoo  = 'c'
+)
+
+// keyLockStripes 键锁的分段个数
+const keyLockStripes = 256
+
+var errLSMCorrupted = errors.New("lsm: corrupted value")
+
+// keyLocks 按键的哈希分段的锁，dict-backend lsm 时写命令执行期间持有；DB.ttlLocks 也用它
+type keyLocks struct {
+	seed  maphash.Seed
+	locks [keyLockStripes]sync.Mutex
+}
+
+// lockKeys 给命令的键加锁，返回解锁的函数
+// 分段按顺序加锁，同一个分段只锁一次，多个键的命令之间不会死锁
+func (kl *keyLocks) lockKeys(keys []string) func() {
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, int(maphash.String(kl.seed, key)%keyLockStripes))
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+	for _, i := range stripes {
+		kl.locks[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			kl.locks[i].Unlock()
+		}
+	}
+}
+
+// entityCodec DataEntity 和字节数组之间的转换
+type entityCodec struct{}
+
+// Encode 编码实体的值
+func (entityCodec) Encode(val interface{}) ([]byte, error) {
+	entity, ok := val.(*database.DataEntity)
+	if !ok || entity == nil {
+		return nil, fmt.Errorf("lsm: unexpected value %T", val)
+	}
+	switch data := entity.Data.(type) {
+	case []byte:
+		return append([]byte{lsmTypeBytes}, data...), nil
+	case int64:
+		return binary.AppendVarint([]byte{lsmTypeInteger}, data), nil
+	case *bloom.Filter:
+		state, _ := data.MarshalBinary()
+		return append([]byte{lsmTypeBloom}, state...), nil
+	case *cuckoo.Filter:
+		state, _ := data.MarshalBinary()
+		return append([]byte{lsmTypeCuckoo}, state...), nil
+	}
+	return nil, fmt.Errorf("lsm: unsupported value type %T", entity.Data)
+}
+
+// Decode 解码出一个新的实体
+func (entityCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, errLSMCorrupted
+	}
+	entity := &database.DataEntity{}
+	switch data[0] {
+	case lsmTypeBytes:
+		entity.Data = data[1:]
+	case lsmTypeInteger:
+		v, n := binary.Varint(data[1:])
+		if n <= 0 {
+			return nil, errLSMCorrupted
+		}
+		entity.Data = int64(v)
+		if v >= 0 && v < sharedIntegers {
+			entity.Data = sharedIntegerPool[v]
+		}
+	case lsmTypeBloom:
+		filter, err := bloom.Unmarshal(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		entity.Data = filter
+	case lsmTypeCuckoo:
+		filter, err := cuckoo.Unmarshal(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		entity.Data = filter
+	default:
+		return nil, errLSMCorrupted
+	}
+	initEntity(entity)
+	return entity, nil
+}
+
+// timeCodec 过期时间和字节数组之间的转换，保存 Unix 纳秒
+type timeCodec struct{}
+
+// Encode 编码过期时间
+func (timeCodec) Encode(val interface{}) ([]byte, error) {
+	t, ok := val.(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("lsm: unexpected value %T", val)
+	}
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano())), nil
+}
+
+// Decode 解码过期时间
+func (timeCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) != 8 {
+		return nil, errLSMCorrupted
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), nil
+}
+
+// OpenLSM 把键值和过期时间换成 dir 下的 LSM-tree 存储，需要在数据库开始执行命令之前调用
+func (db *DB) OpenLSM(dir string, opts lsm.Options) error {
+	data, err := dict.OpenLSMDict(filepath.Join(dir, "data"), opts, entityCodec{})
+	if err != nil {
+		return err
+	}
+	expires, err := dict.OpenLSMDict(filepath.Join(dir, "expires"), opts, timeCodec{})
+	if err != nil {
+		_ = data.Close()
+		return err
+	}
+	db.lsmData, db.lsmExpires = data, expires
+	db.lsmLocks = &keyLocks{seed: maphash.MakeSeed()}
+	db.Data = dict.Typed[*database.DataEntity](data)
+	db.ttlMap = dict.Typed[time.Time](expires)
+	// 不读出所有的值，按键的个数和键值的总长度估算内存
+	count := int64(data.Len())
+	atomic.StoreInt64(&db.keyCount, count)
+	atomic.StoreInt64(&db.usedMemory, data.Engine().Bytes()+count*(dictEntryOverhead+entityOverhead))
+	return nil
+}
+
+// Persistent 返回数据是否保存在磁盘上的 LSM-tree 存储里
+func (db *DB) Persistent() bool {
+	return db.lsmData != nil
+}
+
+// lsmErr 返回键值或过期时间第一次写入磁盘失败的错误
+func (db *DB) lsmErr() error {
+	if err := db.lsmData.Err(); err != nil {
+		return err
+	}
+	return db.lsmExpires.Err()
+}
+
+// lsmErrReply 写入磁盘失败时回复的错误
+func lsmErrReply(err error) resp.Reply {
+	return reply.MakeErrReply("MISCONF lsm write failed, write commands are disabled: " + err.Error())
+}
+
+// CloseLSM 把内存表刷盘并关闭 LSM-tree 存储
+func (db *DB) CloseLSM() error {
+	if db.lsmData == nil {
+		return nil
+	}
+	err := db.lsmData.Close()
+	if expErr := db.lsmExpires.Close(); err == nil {
+		err = expErr
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package structure
+
+import (
+	"GoMiniCache/datastruct/lsm"
+	"GoMiniCache/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestLSMConcurrentInPlaceUpdates 读出来的过滤器是副本，并发的 BF.ADD 不能互相覆盖
+func TestLSMConcurrentInPlaceUpdates(t *testing.T) {
+	db := MakeDB()
+	if err := db.OpenLSM(t.TempDir(), lsm.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseLSM()
+	assertReply(t, execLine(db, "bf.reserve", "f", "0.001", "10000"), "+OK\r\n")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				execLine(db, "bf.add", "f", strconv.Itoa(i)+":"+strconv.Itoa(n))
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 4; i++ {
+		for n := 0; n < 100; n++ {
+			item := strconv.Itoa(i) + ":" + strconv.Itoa(n)
+			if string(execLine(db, "bf.exists", "f", item).ToBytes()) != ":1\r\n" {
+				t.Fatalf("lost update for %s", item)
+			}
+		}
+	}
+}
+
+// TestLSMWriteError 磁盘写入失败以后写命令回复错误，不能回复 OK
+func TestLSMWriteError(t *testing.T) {
+	db := MakeDB()
+	if err := db.OpenLSM(t.TempDir(), lsm.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseLSM()
+	assertReply(t, execLine(db, "set", "a", "1"), "+OK\r\n")
+	_ = db.lsmData.Engine().Close() // 模拟引擎不能再写入
+
+	result := execLine(db, "set", "b", "2")
+	if !reply.IsErrorReply(result) || !strings.HasPrefix(string(result.ToBytes()), "-MISCONF") {
+		t.Fatalf("expected MISCONF after a failed write, got %q", result.ToBytes())
+	}
+	result = execLine(db, "del", "a")
+	if !strings.HasPrefix(string(result.ToBytes()), "-MISCONF") {
+		t.Errorf("expected later writes to be refused, got %q", result.ToBytes())
+	}
+}
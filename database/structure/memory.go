@@ -0,0 +1,101 @@
+package structure
+
+/*
+ * 估算键值占用的内存，给 maxmemory 淘汰和 MEMORY 命令使用
+ * 这里的数字是 Go 运行时里对象大小的近似值，只用来比较大小和发现大键，和进程实际占用的内存会有出入
+ */
+
+import (
+	"GoMiniCache/datastruct/bloom"
+	"GoMiniCache/datastruct/cuckoo"
+	"GoMiniCache/datastruct/dict"
+	"GoMiniCache/interface/database"
+)
+
+const (
+	defaultMemorySamples = 5 // 集合类型默认采样的元素个数，和 MEMORY USAGE 的默认值一致
+
+	dictEntryOverhead = 48 // 字典中一个节点的大致开销（键的字符串头、值的接口、链表指针）
+	dictOverhead      = 64 // 字典结构体本身的大致开销（锁、桶数组的切片头、计数）
+	bucketOverhead    = 8  // 哈希表中一个桶的开销（桶的个数大约和元素个数相同）
+	entityOverhead    = 40 // DataEntity 结构体本身的大致开销
+	sliceOverhead     = 24 // 切片头的开销
+	expireOverhead    = 24 // 过期时间 time.Time 的开销
+)
+
+// ValueSize 估算值占用的内存（字节）
+// 集合类型随机采样 samples 个元素，用它们的平均大小乘以元素个数；samples 为 0 时统计所有的元素
+func ValueSize(val interface{}, samples int) int64 {
+	switch v := val.(type) {
+	case []byte:
+		return sliceOverhead + int64(cap(v))
+	case int64:
+		return integerSize(v)
+	case *coldValue: // 值在磁盘上，内存里只有占位对象
+		return coldValueOverhead
+	case dict.Dict:
+		return dictSize(v, samples)
+	case *bloom.Filter:
+		return v.Bytes()
+	case *cuckoo.Filter:
+		return v.Bytes()
+	}
+	return 0
+}
+
+// dictSize 估算字典占用的内存：字典本身、桶、节点，以及采样得到的键值大小
+func dictSize(d dict.Dict, samples int) int64 {
+	length := d.Len()
+	size := dictOverhead + int64(length)*(bucketOverhead+dictEntryOverhead)
+	if length == 0 {
+		return size
+	}
+	var keys []string
+	if samples <= 0 || samples >= length {
+		keys = d.Keys()
+	} else {
+		keys = d.RandomDistinctKeys(samples)
+	}
+	if len(keys) == 0 {
+		return size
+	}
+	var sampled int64
+	for _, key := range keys {
+		val, _ := d.Get(key)
+		sampled += int64(len(key)) + ValueSize(val, samples)
+	}
+	return size + sampled*int64(length)/int64(len(keys))
+}
+
+// entrySize 估算一个键值在数据库中占用的内存（字节），使用存入时算好的值的大小
+func entrySize(key string, entity *database.DataEntity) int64 {
+	if entity == nil {
+		return 0
+	}
+	return int64(len(key)) + dictEntryOverhead + entityOverhead + entity.Size
+}
+
+// MemoryUsage 重新估算一个键占用的内存（包括过期时间），键不存在时返回 false
+func (db *DB) MemoryUsage(key string, samples int) (int64, bool) {
+	entity, exists := db.getEntity(key, false)
+	if !exists {
+		return 0, false
+	}
+	size := int64(len(key)) + dictEntryOverhead + entityOverhead + ValueSize(entity.Data, samples)
+	if _, ok := db.ExpireTime(key); ok {
+		size += dictEntryOverhead + expireOverhead
+	}
+	return size, true
+}
+
+// MemoryOverhead 返回数据库中不属于数据本身的内存：主字典的开销和过期字典的开销
+func (db *DB) MemoryOverhead() (main int64, expires int64) {
+	main = dictOverhead + int64(db.Len())*(bucketOverhead+dictEntryOverhead+entityOverhead)
+	expires = dictOverhead + int64(db.VolatileLen())*(bucketOverhead+dictEntryOverhead+expireOverhead)
+	return main, expires
+}
+
+// DatasetMemory 返回键值本身占用的内存，也就是去掉字典节点和 DataEntity 开销之后的部分
+func (db *DB) DatasetMemory() int64 {
+	return db.UsedMemory() - int64(db.Len())*(dictEntryOverhead+entityOverhead)
+}
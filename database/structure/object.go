@@ -0,0 +1,105 @@
+package structure
+
+/*
+ * 实现 OBJECT 命令: OBJECT ENCODING|IDLETIME|FREQ|REFCOUNT key
+ * 查看值的编码和访问情况，OBJECT 本身不算一次访问
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/datastruct/bloom"
+	"GoMiniCache/datastruct/cuckoo"
+	"GoMiniCache/datastruct/dict"
+	"GoMiniCache/interface/database"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/reply"
+	"strings"
+)
+
+const embstrSizeLimit = 44 // 不超过这个长度的字符串在 Redis 中和对象头一起分配（embstr）
+
+// objectHelp OBJECT HELP 的内容
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified <key>.",
+}
+
+// ObjectEncoding 返回值的编码，和 Redis 的 OBJECT ENCODING 使用相同的名称
+func ObjectEncoding(val interface{}) string {
+	switch v := val.(type) {
+	case int64: // 规范的整数字符串存成了 int64
+		return "int"
+	case []byte:
+		if len(v) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case *coldValue: // 值在磁盘上，返回写到磁盘之前的编码
+		return v.encoding
+	case dict.Dict:
+		return "hashtable"
+	case *bloom.Filter, *cuckoo.Filter: // Redis 的模块类型都是 raw
+		return "raw"
+	}
+	return "unknown"
+}
+
+// isLFUPolicy 返回当前的淘汰策略是否是 LFU
+func isLFUPolicy() bool {
+	return strings.HasSuffix(strings.ToLower(config.Properties.MaxMemoryPolicy), "-lfu")
+}
+
+// execObject 根据子命令分发
+func execObject(db *DB, args [][]byte) resp.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	if subCmd == "help" {
+		lines := make([][]byte, len(objectHelp))
+		for i, line := range objectHelp {
+			lines[i] = []byte(line)
+		}
+		return reply.MakeMultiBulkReply(lines)
+	}
+	if len(args) != 2 {
+		return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + subCmd + "'. Try OBJECT HELP.")
+	}
+	entity, exists := db.getEntity(string(args[1]), false)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	switch subCmd {
+	case "encoding":
+		return reply.MakeBulkReply([]byte(ObjectEncoding(entity.Data)))
+	case "idletime":
+		if isLFUPolicy() {
+			return reply.MakeErrReply("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return reply.MakeIntReply(IdleTime(entity) / 1000)
+	case "freq":
+		if !isLFUPolicy() {
+			return reply.MakeErrReply("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return reply.MakeIntReply(int64(LFUCount(entity)))
+	case "refcount":
+		return reply.MakeIntReply(refCount(entity))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + subCmd + "'. Try OBJECT HELP.")
+}
+
+// refCount 返回值被引用的次数，共享的整数和 Redis 一样返回 INT_MAX，其他的值每个键都持有自己的
+func refCount(entity *database.DataEntity) int64 {
+	if isSharedInteger(entity.Data) {
+		return sharedRefCount
+	}
+	return 1
+}
+
+func init() {
+	RegisterCommand("Object", execObject, -2, FlagReadOnly).keys(2, 2, 1) // OBJECT subcommand [key]
+}
@@ -0,0 +1,69 @@
+package structure
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestObjectEncoding(t *testing.T) {
+	db := MakeDB()
+	tests := []struct {
+		value    string
+		encoding string
+		refCount int64
+	}{
+		{"100", "int", sharedRefCount},  // 共享整数
+		{"0", "int", sharedRefCount},    // 共享整数
+		{"9999", "int", sharedRefCount}, // 共享整数的上限
+		{"10000", "int", 1},             // 超出共享的范围
+		{"-5", "int", 1},                // 负数不共享
+		{"01", "embstr", 1},             // 不是规范的整数
+		{"hello", "embstr", 1},          // 短字符串
+		{strings.Repeat("a", embstrSizeLimit), "embstr", 1},
+		{strings.Repeat("a", embstrSizeLimit+1), "raw", 1},
+	}
+	for _, tt := range tests {
+		execLine(db, "set", "k", tt.value)
+		assertReply(t, execLine(db, "object", "encoding", "k"), "$"+strconv.Itoa(len(tt.encoding))+"\r\n"+tt.encoding+"\r\n")
+		assertReply(t, execLine(db, "object", "refcount", "k"), ":"+strconv.FormatInt(tt.refCount, 10)+"\r\n")
+		// 紧凑编码不改变读出来的值
+		assertReply(t, execLine(db, "get", "k"), "$"+strconv.Itoa(len(tt.value))+"\r\n"+tt.value+"\r\n")
+	}
+	assertReply(t, execLine(db, "object", "encoding", "missing"), "$-1\r\n")
+	assertReply(t, execLine(db, "object", "encoding"), "-ERR unknown subcommand or wrong number of arguments for 'encoding'. Try OBJECT HELP.\r\n")
+}
+
+func TestObjectFreq(t *testing.T) {
+	policy := config.Properties.MaxMemoryPolicy
+	defer func() { config.Properties.MaxMemoryPolicy = policy }()
+
+	db := MakeDB()
+	execLine(db, "set", "k", "v")
+	config.Properties.MaxMemoryPolicy = "allkeys-lru"
+	if _, ok := execLine(db, "object", "freq", "k").(*reply.StandardErrReply); !ok {
+		t.Error("expected an error when the policy is not LFU")
+	}
+
+	config.Properties.MaxMemoryPolicy = "allkeys-lfu"
+	assertReply(t, execLine(db, "object", "freq", "k"), ":"+strconv.Itoa(lfuInitVal)+"\r\n")
+	if _, ok := execLine(db, "object", "idletime", "k").(*reply.StandardErrReply); !ok {
+		t.Error("expected an error for IDLETIME under an LFU policy")
+	}
+	// 计数器等于初始值时下一次访问一定会增加；OBJECT 本身不算访问
+	execLine(db, "get", "k")
+	assertReply(t, execLine(db, "object", "freq", "k"), ":"+strconv.Itoa(lfuInitVal+1)+"\r\n")
+	assertReply(t, execLine(db, "object", "freq", "k"), ":"+strconv.Itoa(lfuInitVal+1)+"\r\n")
+	for i := 0; i < 1000; i++ {
+		execLine(db, "get", "k")
+	}
+	freq := execLine(db, "object", "freq", "k").(*reply.IntReply).Code
+	if freq <= lfuInitVal+1 || freq > lfuMaxVal {
+		t.Errorf("expected freq to grow with accesses, got %d", freq)
+	}
+
+	config.Properties.MaxMemoryPolicy = "volatile-lfu"
+	assertReply(t, execLine(db, "object", "freq", "k"), ":"+strconv.FormatInt(freq, 10)+"\r\n")
+}
@@ -15,5 +15,5 @@ func Ping(db *DB, args [][]byte) resp.Reply {
 }
 
 func init() {
-	RegisterCommand("ping", Ping, -1) // PING 需要参数 >=1
+	RegisterCommand("ping", Ping, -1, FlagReadOnly) // PING 需要参数 >=1
 }
@@ -0,0 +1,116 @@
+package structure
+
+/*
+ * 实现基于游标的遍历: SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/datastruct/gdict"
+	"GoMiniCache/interface/database"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/wildcard"
+	"GoMiniCache/resp/reply"
+	"strconv"
+	"strings"
+)
+
+const defaultScanCount = 10 // 没有 COUNT 参数时每次大约返回的个数
+
+// scanArgs SCAN 类命令的公共参数
+type scanArgs struct {
+	cursor   uint64
+	count    int
+	pattern  *wildcard.Pattern // 为 nil 时不过滤
+	typeName string            // 为空时不过滤，只有 SCAN 支持
+}
+
+// parseScanArgs 解析 cursor [MATCH pattern] [COUNT count] [TYPE type]，allowType 表示是否支持 TYPE
+func parseScanArgs(args [][]byte, allowType bool) (*scanArgs, resp.Reply) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, reply.MakeErrReply("ERR invalid cursor")
+	}
+	result := &scanArgs{
+		cursor: cursor,
+		count:  defaultScanCount,
+	}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, reply.MakeSyntaxErrReply()
+		}
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			if value != "*" {
+				result.pattern = wildcard.CompilePattern(value)
+			}
+		case "count":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			result.count = count
+		case "type":
+			if !allowType {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			result.typeName = strings.ToLower(value)
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return result, nil
+}
+
+// ScanDict 从游标开始遍历字典的一部分，返回下一次的游标和匹配的键值
+// 字典不支持 gdict.Scanner 时返回 false：一次遍历完整个字典和 KEYS 一样会卡住服务器，不能当成 SCAN 使用
+// 目前还没有 set、hash、zset 类型，所以没有 SSCAN、HSCAN、ZSCAN；
+// 以后加上这些类型时，它们的 xSCAN 命令用 dict.Typed 包装自己的字典后用它遍历
+func ScanDict[V any](d gdict.Dict[string, V], cursor uint64, count int, pattern *wildcard.Pattern, consumer gdict.Consumer[string, V]) (uint64, bool) {
+	scanner, ok := d.(gdict.Scanner[string, V])
+	if !ok {
+		return 0, false
+	}
+	return scanner.Scan(cursor, count, func(key string, val V) bool {
+		if pattern == nil || pattern.IsMatch(key) {
+			return consumer(key, val)
+		}
+		return true
+	}), true
+}
+
+// execScan 分批遍历数据库中的键，例：SCAN 0 MATCH user:* COUNT 100 TYPE string
+func execScan(db *DB, args [][]byte) resp.Reply {
+	scan, errReply := parseScanArgs(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([][]byte, 0, min(scan.count, defaultScanCount)) // COUNT 来自客户端，不按它预先分配
+	next, ok := ScanDict(db.Data, scan.cursor, scan.count, scan.pattern, func(key string, entity *database.DataEntity) bool {
+		if db.IsExpired(key) { // 已经过期但还没被删除的键
+			return true
+		}
+		if scan.typeName != "" {
+			if entity == nil || !strings.EqualFold(entityType(entity), scan.typeName) {
+				return true
+			}
+		}
+		keys = append(keys, []byte(key))
+		return true
+	})
+	if !ok {
+		return reply.MakeErrReply("ERR SCAN is not supported by dict-backend '" + config.Properties.DictBackend + "', use hashtable")
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(next, 10))),
+		reply.MakeMultiBulkReply(keys),
+	})
+}
+
+func init() {
+	RegisterCommand("Scan", execScan, -2, FlagReadOnly).keys(0, 0, 0) // SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+}
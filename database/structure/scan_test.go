@@ -0,0 +1,98 @@
+package structure
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/resp/reply"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// scanOnce 执行一次 SCAN，返回下一次的游标和这一批的键
+func scanOnce(t *testing.T, db *DB, args ...string) (string, []string) {
+	t.Helper()
+	result, ok := execLine(db, append([]string{"scan"}, args...)...).(*reply.MultiRawReply)
+	if !ok || len(result.Replies) != 2 {
+		t.Fatalf("unexpected scan reply %v", result)
+	}
+	cursor := string(result.Replies[0].(*reply.BulkReply).Arg)
+	var keys []string
+	for _, key := range result.Replies[1].(*reply.MultiBulkReply).Args {
+		keys = append(keys, string(key))
+	}
+	return cursor, keys
+}
+
+// scanAll 从游标 0 开始一直遍历到游标回到 0，返回去重排序后的键和调用次数
+func scanAll(t *testing.T, db *DB, args ...string) ([]string, int) {
+	t.Helper()
+	var all []string
+	cursor, calls := "0", 0
+	for {
+		next, keys := scanOnce(t, db, append([]string{cursor}, args...)...)
+		all = append(all, keys...)
+		calls++
+		if next == "0" {
+			break
+		}
+		cursor = next
+	}
+	slices.Sort(all)
+	return slices.Compact(all), calls
+}
+
+func TestScan(t *testing.T) {
+	db := MakeDB()
+	var expected []string
+	for i := 0; i < 100; i++ {
+		key := "user:" + strconv.Itoa(i)
+		execLine(db, "set", key, "v")
+		expected = append(expected, key)
+	}
+	execLine(db, "bf.reserve", "filter", "0.01", "100")
+	slices.Sort(expected)
+
+	// 游标往返：分批遍历，每个键至少返回一次
+	keys, calls := scanAll(t, db, "match", "user:*")
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected %d keys, got %d", len(expected), len(keys))
+	}
+	if calls < 2 {
+		t.Errorf("expected several batches with the default count, got %d", calls)
+	}
+
+	// COUNT 足够大时一次就能遍历完
+	if _, calls = scanAll(t, db, "count", "1000"); calls != 1 {
+		t.Errorf("expected a single batch with count 1000, got %d", calls)
+	}
+
+	// MATCH
+	if keys, _ = scanAll(t, db, "match", "user:1?"); len(keys) != 10 {
+		t.Errorf("expected 10 keys for user:1?, got %v", keys)
+	}
+
+	// TYPE
+	if keys, _ = scanAll(t, db, "type", "MBbloom--"); !slices.Equal(keys, []string{"filter"}) {
+		t.Errorf("expected only the bloom filter, got %v", keys)
+	}
+	if keys, _ = scanAll(t, db, "type", "string"); len(keys) != 100 {
+		t.Errorf("expected 100 strings, got %d", len(keys))
+	}
+
+	assertReply(t, execLine(db, "scan", "x"), "-ERR invalid cursor\r\n")
+	assertReply(t, execLine(db, "scan", "0", "count", "0"), "-Err syntax error\r\n")
+	assertReply(t, execLine(db, "scan", "0", "match"), "-Err syntax error\r\n")
+}
+
+func TestScanUnsupportedBackend(t *testing.T) {
+	backend := config.Properties.DictBackend
+	defer func() { config.Properties.DictBackend = backend }()
+
+	for _, name := range []string{"sync", "concurrent"} {
+		config.Properties.DictBackend = name
+		db := MakeDB()
+		execLine(db, "set", "k", "v")
+		assertReply(t, execLine(db, "scan", "0"),
+			"-ERR SCAN is not supported by dict-backend '"+name+"', use hashtable\r\n")
+	}
+}
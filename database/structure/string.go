@@ -7,7 +7,10 @@ package structure
 import (
 	"GoMiniCache/interface/database"
 	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/notify"
 	"GoMiniCache/resp/reply"
+	"time"
 )
 
 func (db *DB) getAsString(key string) ([]byte, reply.ErrorReply) {
@@ -15,14 +18,14 @@ func (db *DB) getAsString(key string) ([]byte, reply.ErrorReply) {
 	if !ok {
 		return nil, nil
 	}
-	bytes, ok := entity.Data.([]byte)
+	bytes, ok := stringBytes(entity.Data)
 	if !ok {
 		return nil, &reply.WrongTypeErrReply{}
 	}
 	return bytes, nil
 }
 
-// execGet 返回绑定 string 的键值
+// execGet 返回绑定 string 的键值；有匹配的 Loader 时 Database 在执行前已经加载了未命中的键
 func execGet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 	bytes, err := db.getAsString(key)
@@ -38,54 +41,62 @@ func execGet(db *DB, args [][]byte) resp.Reply {
 // execSet 设置字符串值和给定键的存活时间
 func execSet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	value := args[1]
 	entity := &database.DataEntity{
-		Data: value,
+		Data: stringValue(args[1]),
 	}
-	db.PutEntity(key, entity)
+	db.PutEntityTTL(key, entity, time.Time{}) // SET 会清除原来的过期时间
+	db.AddAof(utils.ToCmdLine2("set", args...))
+	db.Notify(notify.String, "set", key)
 	return reply.MakeOkReply()
 }
 
 // execSetNX sets string if not exists
 func execSetNX(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	value := args[1]
 	entity := &database.DataEntity{
-		Data: value,
+		Data: stringValue(args[1]),
 	}
 	result := db.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.AddAof(utils.ToCmdLine2("setnx", args...))
+		db.Notify(notify.String, "set", key)
+	}
 	return reply.MakeIntReply(int64(result))
 }
 
 // execGetSet 设置新键值并返回旧键值
 func execGetSet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	value := args[1]
-
-	entity, exists := db.GetEntity(key)
-	db.PutEntity(key, &database.DataEntity{Data: value})
-	if !exists {
+	old, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	db.PutEntityTTL(key, &database.DataEntity{Data: stringValue(args[1])}, time.Time{})
+	db.AddAof(utils.ToCmdLine2("getset", args...))
+	db.Notify(notify.String, "set", key)
+	if old == nil {
 		return reply.MakeNullBulkReply()
 	}
-	old := entity.Data.([]byte)
 	return reply.MakeBulkReply(old)
 }
 
 // execStrLen 返回 key 对应 val 的长度
 func execStrLen(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	entity, exists := db.GetEntity(key)
-	if !exists {
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	if bytes == nil {
 		return reply.MakeNullBulkReply()
 	}
-	old := entity.Data.([]byte)
-	return reply.MakeIntReply(int64(len(old)))
+	return reply.MakeIntReply(int64(len(bytes)))
 }
 
 func init() {
-	RegisterCommand("Get", execGet, 2)
-	RegisterCommand("Set", execSet, -3)
-	RegisterCommand("SetNx", execSetNX, 3)
-	RegisterCommand("GetSet", execGetSet, 3)
-	RegisterCommand("StrLen", execStrLen, 2)
+	RegisterCommand("Get", execGet, 2, FlagReadOnly)
+	RegisterCommand("Set", execSet, -3, FlagWrite|FlagDenyOOM)
+	RegisterCommand("SetNx", execSetNX, 3, FlagWrite|FlagDenyOOM)
+	RegisterCommand("GetSet", execGetSet, 3, FlagWrite|FlagDenyOOM)
+	RegisterCommand("StrLen", execStrLen, 2, FlagReadOnly)
 }
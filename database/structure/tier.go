@@ -0,0 +1,246 @@
+package structure
+
+/*
+ * 冷数据分层：长时间没有访问的值（或者被淘汰策略选中的值）写到磁盘上的 value log，内存里只留下键和一个占位的 coldValue
+ * GetEntity 访问到冷的键时透明地把值读回内存，TYPE、EXISTS、OBJECT 这些不读取值的命令不会触发加载
+ *
+ * 值在内存和磁盘之间搬动时需要持有 tierMu 的写锁，其他的写操作持有读锁，保证搬动期间键不会被修改
+ */
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/interface/database"
+	"GoMiniCache/lib/logger"
+	"GoMiniCache/tiered"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	coldValueOverhead   = 32 // 占位的 coldValue 在内存中的大致开销
+	defaultTierMinValue = 64 // tier-min-value-size 的默认值，更小的值写到磁盘上省不了多少内存
+)
+
+// coldValue 值已经写到磁盘上时，DataEntity.Data 中的占位对象，记下 TYPE 和 OBJECT ENCODING 需要的信息
+type coldValue struct {
+	typeName string
+	encoding string
+	size     int64 // 值的字节数
+}
+
+// TierStats 一个数据库的分层统计
+type TierStats struct {
+	MemoryHits int64 // 读取时值在内存中
+	DiskHits   int64 // 读取时值在磁盘上，加载回了内存
+	Misses     int64 // 读取时键不存在
+	Spilled    int64 // 写到磁盘上的值的个数
+	Loaded     int64 // 从磁盘加载回内存的值的个数
+	Store      tiered.Stats
+}
+
+// tierCounters 分层统计的计数器（原子操作）
+type tierCounters struct {
+	memoryHits int64
+	diskHits   int64
+	misses     int64
+	spilled    int64
+	loaded     int64
+}
+
+// EnableTier 开启磁盘层，需要在数据库开始执行命令之前调用
+func (db *DB) EnableTier(store *tiered.Store) {
+	db.tier = store
+}
+
+// TierEnabled 返回是否开启了磁盘层
+func (db *DB) TierEnabled() bool {
+	return db.tier != nil
+}
+
+// CloseTier 关闭磁盘层
+func (db *DB) CloseTier() error {
+	if db.tier == nil {
+		return nil
+	}
+	return db.tier.Close()
+}
+
+// isCold 返回实体的值是否在磁盘上
+func isCold(entity *database.DataEntity) bool {
+	_, ok := entity.Data.(*coldValue)
+	return ok
+}
+
+// IsColdEntity 返回实体的值是否在磁盘上，淘汰时优先处理还在内存中的值
+func IsColdEntity(entity *database.DataEntity) bool {
+	return isCold(entity)
+}
+
+// lockTierShared 写操作开始前调用，开启了磁盘层时持有读锁，返回解锁的函数
+func (db *DB) lockTierShared() func() {
+	if db.tier == nil {
+		return func() {}
+	}
+	db.tierMu.RLock()
+	return db.tierMu.RUnlock
+}
+
+// dropCold 键被覆盖或删除时，删除磁盘上的旧值（调用者需要持有 tierMu）
+func (db *DB) dropCold(key string, old *database.DataEntity) {
+	if old != nil && isCold(old) {
+		db.tier.Delete(key)
+	}
+}
+
+// replaceEntity 把键换成新的实体，保留访问时间和 LFU 计数器（调用者需要持有 tierMu 的写锁）
+func (db *DB) replaceEntity(key string, old *database.DataEntity, data interface{}) *database.DataEntity {
+	entity := &database.DataEntity{
+		Data:       data,
+		Size:       ValueSize(data, defaultMemorySamples),
+		AccessTime: atomic.LoadInt64(&old.AccessTime),
+		LFUCounter: atomic.LoadUint32(&old.LFUCounter),
+	}
+	db.Data.Put(key, entity)
+	atomic.AddInt64(&db.usedMemory, entrySize(key, entity)-entrySize(key, old))
+	return entity
+}
+
+// spillable 返回值是否可以写到磁盘上：目前只有字符串，并且不能太小
+func spillable(val interface{}) ([]byte, bool) {
+	bytes, ok := val.([]byte)
+	if !ok {
+		return nil, false
+	}
+	minSize := config.Properties.TierMinValueSize
+	if minSize <= 0 {
+		minSize = defaultTierMinValue
+	}
+	return bytes, len(bytes) >= minSize
+}
+
+// Spill 把键的值写到磁盘上，内存里只留下占位对象，返回是否写了
+// 键不存在、值已经在磁盘上或者值不适合写到磁盘上时返回 false
+func (db *DB) Spill(key string) bool {
+	if db.tier == nil {
+		return false
+	}
+	db.tierMu.Lock()
+	defer db.tierMu.Unlock()
+	entity, exists := db.PeekEntity(key)
+	if !exists || isCold(entity) {
+		return false
+	}
+	bytes, ok := spillable(entity.Data)
+	if !ok {
+		return false
+	}
+	if err := db.tier.Put(key, bytes); err != nil {
+		logger.Error("tier: spill failed: " + err.Error())
+		return false
+	}
+	db.replaceEntity(key, entity, &coldValue{
+		typeName: entityType(entity),
+		encoding: ObjectEncoding(entity.Data),
+		size:     int64(len(bytes)),
+	})
+	atomic.AddInt64(&db.tierCounters.spilled, 1)
+	return true
+}
+
+// SpillIdle 随机检查 samples 个键，把空闲时间超过 idle 的值写到磁盘上，返回写了多少个
+func (db *DB) SpillIdle(samples int, idle time.Duration) int {
+	if db.tier == nil {
+		return 0
+	}
+	spilled := 0
+	for _, key := range db.SampleKeys(samples) {
+		entity, exists := db.PeekEntity(key)
+		if !exists || isCold(entity) || IdleTime(entity) < idle.Milliseconds() {
+			continue
+		}
+		if db.Spill(key) {
+			spilled++
+		}
+	}
+	return spilled
+}
+
+// loadCold 把磁盘上的值读回内存，返回加载后的实体；读取失败时返回 false
+func (db *DB) loadCold(key string) (*database.DataEntity, bool) {
+	db.tierMu.Lock()
+	defer db.tierMu.Unlock()
+	entity, exists := db.PeekEntity(key)
+	if !exists {
+		return nil, false
+	}
+	if !isCold(entity) { // 等锁的时候已经被别的命令加载或者覆盖了
+		return entity, true
+	}
+	bytes, ok, err := db.tier.Get(key)
+	if err != nil {
+		logger.Error("tier: load '" + key + "' failed: " + err.Error())
+		return nil, false
+	}
+	if !ok {
+		logger.Error("tier: value of '" + key + "' is missing on disk")
+		return nil, false
+	}
+	warm := db.replaceEntity(key, entity, bytes)
+	db.tier.Delete(key)
+	atomic.AddInt64(&db.tierCounters.loaded, 1)
+	return warm, true
+}
+
+// ResolveEntity 返回带有真实值的实体：值在磁盘上时读出来，但不加载回内存，给重写 AOF 这类遍历使用
+func (db *DB) ResolveEntity(key string, entity *database.DataEntity) (*database.DataEntity, bool) {
+	if !isCold(entity) {
+		return entity, true
+	}
+	db.tierMu.RLock()
+	defer db.tierMu.RUnlock()
+	current, exists := db.PeekEntity(key)
+	if !exists {
+		return nil, false
+	}
+	if !isCold(current) {
+		return current, true
+	}
+	bytes, ok, err := db.tier.Get(key)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return &database.DataEntity{Data: bytes}, true
+}
+
+// CompactTier 压缩磁盘层中垃圾比例不低于 minGarbageRatio 的一个段，返回是否压缩了
+func (db *DB) CompactTier(minGarbageRatio float64) (bool, error) {
+	if db.tier == nil {
+		return false, nil
+	}
+	return db.tier.Compact(minGarbageRatio)
+}
+
+// TierStats 返回分层统计
+func (db *DB) TierStats() TierStats {
+	stats := TierStats{
+		MemoryHits: atomic.LoadInt64(&db.tierCounters.memoryHits),
+		DiskHits:   atomic.LoadInt64(&db.tierCounters.diskHits),
+		Misses:     atomic.LoadInt64(&db.tierCounters.misses),
+		Spilled:    atomic.LoadInt64(&db.tierCounters.spilled),
+		Loaded:     atomic.LoadInt64(&db.tierCounters.loaded),
+	}
+	if db.tier != nil {
+		stats.Store = db.tier.Stats()
+	}
+	return stats
+}
+
+// clearTier 清空磁盘层（调用者需要持有 tierMu 的写锁）
+func (db *DB) clearTier() {
+	if db.tier == nil {
+		return
+	}
+	if err := db.tier.Clear(); err != nil {
+		logger.Error("tier: clear failed: " + err.Error())
+	}
+}
@@ -0,0 +1,137 @@
+package structure
+
+/*
+ * 实现键的过期时间: EXPIRE, PEXPIRE, PEXPIREAT, TTL, PTTL, PERSIST
+ * 过期的键在被访问时删除；AOF 中统一记录为 PEXPIREAT，重放时不会因为时间推移而延长存活时间
+ */
+
+import (
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/notify"
+	"GoMiniCache/resp/reply"
+	"math"
+	"strconv"
+	"time"
+)
+
+// maxExpireMs 过期时间（Unix 毫秒）的上限，换成 time.Time 的纳秒时不能溢出
+const maxExpireMs = math.MaxInt64 / int64(time.Millisecond)
+
+// expireAt 给已经存在的键设置过期时间，过期时间已经过去时直接删除键
+func (db *DB) expireAt(key string, at time.Time) resp.Reply {
+	if _, exists := db.GetEntity(key); !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !at.After(time.Now()) {
+		db.RemoveEntity(key, false)
+		db.AddAof(utils.ToCmdLine("del", key))
+		db.Notify(notify.Generic, "del", key)
+		return reply.MakeIntReply(1)
+	}
+	db.Expire(key, at)
+	ms := at.UnixNano() / int64(time.Millisecond)
+	db.AddAof(utils.ToCmdLine("pexpireat", key, strconv.FormatInt(ms, 10)))
+	db.Notify(notify.Generic, "expire", key)
+	return reply.MakeIntReply(1)
+}
+
+// parseInt64 解析整数参数
+func parseInt64(arg []byte) (int64, resp.Reply) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return n, nil
+}
+
+// parseExpireMs 把过期时间参数换成 Unix 毫秒时间：base 加上 unit 毫秒的 n 倍
+// 超出范围时和 Redis 一样返回 invalid expire time，不能让乘法或者加法溢出成一个别的时间
+func parseExpireMs(arg []byte, base, unit int64, cmd string) (int64, resp.Reply) {
+	n, errReply := parseInt64(arg)
+	if errReply != nil {
+		return 0, errReply
+	}
+	invalid := reply.MakeErrReply("ERR invalid expire time in '" + cmd + "' command")
+	if n > maxExpireMs/unit || n < -maxExpireMs/unit {
+		return 0, invalid
+	}
+	ms := base + n*unit // base 在 [0, maxExpireMs] 之间，加起来不会溢出 int64
+	if ms > maxExpireMs || ms < -maxExpireMs {
+		return 0, invalid
+	}
+	return ms, nil
+}
+
+// execExpire 设置键在多少秒之后过期，例：EXPIRE K 10
+func execExpire(db *DB, args [][]byte) resp.Reply {
+	ms, errReply := parseExpireMs(args[1], time.Now().UnixMilli(), 1000, "expire")
+	if errReply != nil {
+		return errReply
+	}
+	return db.expireAt(string(args[0]), time.UnixMilli(ms))
+}
+
+// execPExpire 设置键在多少毫秒之后过期，例：PEXPIRE K 10000
+func execPExpire(db *DB, args [][]byte) resp.Reply {
+	ms, errReply := parseExpireMs(args[1], time.Now().UnixMilli(), 1, "pexpire")
+	if errReply != nil {
+		return errReply
+	}
+	return db.expireAt(string(args[0]), time.UnixMilli(ms))
+}
+
+// execPExpireAt 设置键在某个 Unix 毫秒时间过期，例：PEXPIREAT K 1700000000000
+func execPExpireAt(db *DB, args [][]byte) resp.Reply {
+	ms, errReply := parseExpireMs(args[1], 0, 1, "pexpireat")
+	if errReply != nil {
+		return errReply
+	}
+	return db.expireAt(string(args[0]), time.UnixMilli(ms))
+}
+
+// ttlReply 返回键剩余的存活时间，键不存在返回 -2，没有过期时间返回 -1
+func (db *DB) ttlReply(key string, unit time.Duration) resp.Reply {
+	if _, exists := db.getEntity(key, false); !exists {
+		return reply.MakeIntReply(-2)
+	}
+	at, ok := db.ExpireTime(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	remain := time.Until(at)
+	return reply.MakeIntReply(int64((remain + unit - 1) / unit)) // 向上取整，和 Redis 一样不会提前返回 0
+}
+
+// execTTL 返回键剩余的存活时间（秒）
+func execTTL(db *DB, args [][]byte) resp.Reply {
+	return db.ttlReply(string(args[0]), time.Second)
+}
+
+// execPTTL 返回键剩余的存活时间（毫秒）
+func execPTTL(db *DB, args [][]byte) resp.Reply {
+	return db.ttlReply(string(args[0]), time.Millisecond)
+}
+
+// execPersist 删除键的过期时间
+func execPersist(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	if _, exists := db.GetEntity(key); !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !db.Persist(key) {
+		return reply.MakeIntReply(0)
+	}
+	db.AddAof(utils.ToCmdLine2("persist", args...))
+	db.Notify(notify.Generic, "persist", key)
+	return reply.MakeIntReply(1)
+}
+
+func init() {
+	RegisterCommand("Expire", execExpire, 3, FlagWrite)
+	RegisterCommand("PExpire", execPExpire, 3, FlagWrite)
+	RegisterCommand("PExpireAt", execPExpireAt, 3, FlagWrite)
+	RegisterCommand("TTL", execTTL, 2, FlagReadOnly)
+	RegisterCommand("PTTL", execPTTL, 2, FlagReadOnly)
+	RegisterCommand("Persist", execPersist, 2, FlagWrite)
+}
@@ -0,0 +1,93 @@
+package structure
+
+import (
+	"GoMiniCache/interface/database"
+	"GoMiniCache/resp/reply"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpireAndTTL(t *testing.T) {
+	db := MakeDB()
+	var aof [][][]byte
+	db.AddAof = func(line [][]byte) { aof = append(aof, line) }
+	assertReply(t, execLine(db, "ttl", "k"), ":-2\r\n")
+	assertReply(t, execLine(db, "expire", "k", "10"), ":0\r\n")
+
+	execLine(db, "set", "k", "v")
+	assertReply(t, execLine(db, "ttl", "k"), ":-1\r\n")
+	assertReply(t, execLine(db, "expire", "k", "100"), ":1\r\n")
+	assertReply(t, execLine(db, "ttl", "k"), ":100\r\n")
+	if pttl := execLine(db, "pttl", "k").(*reply.IntReply).Code; pttl <= 99000 || pttl > 100000 {
+		t.Errorf("unexpected pttl %d", pttl)
+	}
+	// AOF 中记录成绝对时间
+	if last := aof[len(aof)-1]; string(last[0]) != "pexpireat" {
+		t.Errorf("expected pexpireat in aof, got %s", last[0])
+	}
+
+	assertReply(t, execLine(db, "pexpire", "k", "100000"), ":1\r\n")
+	assertReply(t, execLine(db, "ttl", "k"), ":100\r\n")
+	at := time.Now().Add(time.Hour).UnixMilli()
+	assertReply(t, execLine(db, "pexpireat", "k", strconv.FormatInt(at, 10)), ":1\r\n")
+	assertReply(t, execLine(db, "ttl", "k"), ":3600\r\n")
+
+	assertReply(t, execLine(db, "persist", "k"), ":1\r\n")
+	assertReply(t, execLine(db, "persist", "k"), ":0\r\n")
+	assertReply(t, execLine(db, "ttl", "k"), ":-1\r\n")
+
+	// 过期时间已经过去时直接删除
+	assertReply(t, execLine(db, "expire", "k", "-1"), ":1\r\n")
+	assertReply(t, execLine(db, "exists", "k"), ":0\r\n")
+	execLine(db, "set", "k", "v")
+	assertReply(t, execLine(db, "pexpireat", "k", "1"), ":1\r\n")
+	assertReply(t, execLine(db, "exists", "k"), ":0\r\n")
+}
+
+func TestExpireInvalidTime(t *testing.T) {
+	db := MakeDB()
+	execLine(db, "set", "k", "v")
+	huge := strconv.FormatInt(math.MaxInt64, 10)
+	for _, cmd := range []string{"expire", "pexpire", "pexpireat"} {
+		assertReply(t, execLine(db, cmd, "k", huge), "-ERR invalid expire time in '"+cmd+"' command\r\n")
+		assertReply(t, execLine(db, cmd, "k", "-"+huge), "-ERR invalid expire time in '"+cmd+"' command\r\n")
+		assertReply(t, execLine(db, cmd, "k", "x"), "-ERR value is not an integer or out of range\r\n")
+	}
+	// 乘以 1000 才溢出的秒数
+	assertReply(t, execLine(db, "expire", "k", strconv.FormatInt(math.MaxInt64/100, 10)), "-ERR invalid expire time in 'expire' command\r\n")
+	assertReply(t, execLine(db, "ttl", "k"), ":-1\r\n") // 出错时不修改过期时间
+}
+
+// TestSetClearsTTLAtomically SET 写入的值不能带着旧的过期时间被同时执行的 GET 当成过期删掉
+func TestSetClearsTTLAtomically(t *testing.T) {
+	db := MakeDB()
+	for i := 0; i < 200; i++ {
+		db.PutEntity("k", &database.DataEntity{Data: []byte("old")})
+		db.Expire("k", time.Now().Add(-time.Second)) // 已经过期，还没有被删除
+
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					execLine(db, "get", "k")
+				}
+			}
+		}()
+		execLine(db, "set", "k", "new")
+		close(stop)
+		wg.Wait()
+		assertReply(t, execLine(db, "get", "k"), "$3\r\nnew\r\n")
+		if t.Failed() {
+			t.Fatalf("SET was lost at iteration %d", i)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package tracking
+
+/*
+ * 客户端缓存（client-side caching）的失效通知: CLIENT TRACKING
+ *   - 默认模式：记下每个客户端读过的键，键被修改时通知读过它的客户端；通知一次以后就忘掉，客户端再读时重新记下
+ *   - BCAST 模式：不记录读过的键，被修改的键以客户端关心的前缀开头时就通知，没有前缀时关心所有的键
+ *   - OPTIN：只记录 CLIENT CACHING yes 之后的那一个命令读的键；OPTOUT：CLIENT CACHING no 之后的那一个命令不记录
+ *   - NOLOOP：客户端自己修改的键不通知自己
+ *   - RESP3 的客户端收到推送 [invalidate, [键...]]；RESP2 的连接不能推送，
+ *     需要 REDIRECT 到另一个订阅了 __redis__:invalidate 的连接，以发布订阅的消息收到通知
+ *   - 和 Redis 一样不区分数据库，清空数据库时键列表为空，表示所有的键都失效了
+ *
+ * 客户端读到旧值，却先收到了失效通知的话会一直缓存旧值，所以读命令从执行到把回复放进发件箱都持有这个客户端的 deliverMu，
+ * 给它发通知时也要先拿到这把锁，保证键被修改以后的通知排在读到旧值的回复之后
+ * 回复和通知都只放进连接的发件箱（outbox），由发件箱的写协程写出，写命令持有 execMu 时不会等待慢的客户端
+ */
+
+import (
+	"GoMiniCache/database/outbox"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/reply"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// InvalidateChannel RESP2 的客户端通过订阅这个频道收到失效通知
+const InvalidateChannel = "__redis__:invalidate"
+
+// CLIENT CACHING 设置的值，只对下一个命令有效
+const (
+	cachingUnset = iota
+	cachingYes
+	cachingNo
+)
+
+// Options CLIENT TRACKING on 的选项
+type Options struct {
+	Redirect int64    // 失效通知发给这个客户端，0 表示发给自己
+	Prefixes []string // BCAST 模式关心的前缀
+	BCast    bool
+	OptIn    bool
+	OptOut   bool
+	NoLoop   bool
+}
+
+// Info CLIENT TRACKINGINFO 返回的信息
+type Info struct {
+	Options
+	Caching        string // CLIENT CACHING 设置的值：yes、no，没有设置时为空
+	BrokenRedirect bool   // REDIRECT 的目标已经断开了
+}
+
+// client 开启了客户端缓存的连接
+type client struct {
+	conn     resp.Connection
+	box      *outbox.Box // 自己的发件箱，开启期间一直持有
+	opts     Options     // 下面三个字段和 opts 都由 Table.mu 保护
+	caching  int
+	redirect resp.Connection // REDIRECT 的目标，没有 REDIRECT 时为 nil
+	redirBox *outbox.Box     // REDIRECT 的目标的发件箱，开启期间一直持有
+
+	deliverMu   sync.Mutex
+	brokenRedir atomic.Bool
+}
+
+// delivery 一次要发出的失效通知
+type delivery struct {
+	cl       *client
+	redirect int64
+	redirBox *outbox.Box
+	keys     []string // nil 表示所有的键
+}
+
+// Table 记录开启了客户端缓存的连接和它们关心的键，并发安全
+type Table struct {
+	mu       sync.Mutex
+	clients  map[int64]*client
+	keys     map[string]map[int64]struct{} // 默认模式：键到读过它的客户端
+	prefixes map[string]map[int64]struct{} // BCAST 模式：前缀到关心它的客户端
+	active   atomic.Int64                  // 开启了客户端缓存的连接的个数，为 0 时跳过所有的记录
+
+	lookup   func(id int64) (resp.Connection, bool) // 按编号找到 REDIRECT 的目标
+	publish  func(c resp.Connection, msg []byte) bool
+	outboxes *outbox.Registry
+}
+
+// NewTable 创建 Table
+// lookup 按编号找到打开的连接；publish 把消息发给订阅模式下的连接，连接不在订阅模式时返回 false；
+// outboxes 是所有连接共用的发件箱，开启了客户端缓存的连接和 REDIRECT 的目标的回复都经过它
+func NewTable(lookup func(id int64) (resp.Connection, bool), publish func(c resp.Connection, msg []byte) bool,
+	outboxes *outbox.Registry) *Table {
+	return &Table{
+		clients:  make(map[int64]*client),
+		keys:     make(map[string]map[int64]struct{}),
+		prefixes: make(map[string]map[int64]struct{}),
+		lookup:   lookup,
+		publish:  publish,
+		outboxes: outboxes,
+	}
+}
+
+// Active 返回是否有连接开启了客户端缓存
+func (t *Table) Active() bool {
+	return t.active.Load() > 0
+}
+
+// Enable 开启客户端缓存，已经开启时可以修改 REDIRECT、NOLOOP 和增加前缀，但不能切换模式
+func (t *Table) Enable(c resp.Connection, opts Options) error {
+	var target resp.Connection
+	if opts.Redirect != 0 {
+		conn, ok := t.lookup(opts.Redirect)
+		if !ok {
+			return errors.New("The client ID you want redirect to does not exist")
+		}
+		target = conn
+	}
+	if !opts.BCast && len(opts.Prefixes) > 0 {
+		return errors.New("PREFIX option requires BCAST mode to be enabled")
+	}
+	if opts.OptIn && opts.OptOut {
+		return errors.New("You can't use both OPTIN and OPTOUT")
+	}
+	if opts.BCast && (opts.OptIn || opts.OptOut) {
+		return errors.New("OPTIN and OPTOUT are not compatible with BCAST")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := c.GetID()
+	cl, ok := t.clients[id]
+	if ok {
+		if cl.opts.BCast != opts.BCast {
+			return errors.New("You can't switch BCAST mode on/off before disabling tracking for this client, " +
+				"and then re-enabling it with a different mode.")
+		}
+		if cl.opts.OptIn != opts.OptIn || cl.opts.OptOut != opts.OptOut {
+			return errors.New("You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, " +
+				"and then re-enabling it with a different mode.")
+		}
+	}
+	var existing []string
+	if ok {
+		existing = cl.opts.Prefixes
+	}
+	prefixes, err := mergePrefixes(existing, opts.Prefixes)
+	if err != nil {
+		return err
+	}
+	if opts.BCast && len(prefixes) == 0 {
+		prefixes = []string{""} // 没有前缀时关心所有的键
+	}
+
+	if !ok {
+		cl = &client{conn: c, box: t.outboxes.Acquire(c)}
+		t.clients[id] = cl
+		t.active.Add(1)
+	}
+	if cl.opts.Redirect != opts.Redirect {
+		t.releaseRedirectLocked(cl)
+		if target != nil {
+			cl.redirect, cl.redirBox = target, t.outboxes.Acquire(target)
+		}
+	}
+	cl.opts = opts
+	cl.opts.Prefixes = prefixes
+	cl.brokenRedir.Store(false)
+	for _, prefix := range prefixes {
+		ids, ok := t.prefixes[prefix]
+		if !ok {
+			ids = make(map[int64]struct{})
+			t.prefixes[prefix] = ids
+		}
+		ids[id] = struct{}{}
+	}
+	return nil
+}
+
+// mergePrefixes 把新的前缀加入已有的前缀，同一个客户端的前缀不能互相重叠，否则一个键会通知两次
+func mergePrefixes(existing []string, added []string) ([]string, error) {
+	prefixes := slices.Clone(existing)
+	for _, prefix := range added {
+		duplicate := false
+		for i, other := range prefixes {
+			if prefix == other {
+				duplicate = true
+				break
+			}
+			if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+				if i < len(existing) {
+					return nil, fmt.Errorf("Prefix '%s' overlaps with an existing prefix '%s'. "+
+						"Prefixes for a single client must not overlap.", prefix, other)
+				}
+				return nil, fmt.Errorf("Prefix '%s' overlaps with another provided prefix '%s'. "+
+					"Prefixes for a single client must not overlap.", prefix, other)
+			}
+		}
+		if !duplicate {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, nil
+}
+
+// Disable 关闭客户端缓存，连接关闭时也要调用
+// 和 Redis 一样，读过的键不会立刻清理，这些键下次被修改时再删掉
+func (t *Table) Disable(c resp.Connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := c.GetID()
+	cl, ok := t.clients[id]
+	if !ok {
+		return
+	}
+	for _, prefix := range cl.opts.Prefixes {
+		delete(t.prefixes[prefix], id)
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+	delete(t.clients, id)
+	t.active.Add(-1)
+	t.releaseRedirectLocked(cl)
+	t.outboxes.Release(c)
+}
+
+// releaseRedirectLocked 不再向原来的 REDIRECT 目标发通知（调用者需要持有锁）
+func (t *Table) releaseRedirectLocked(cl *client) {
+	if cl.redirect == nil {
+		return
+	}
+	t.outboxes.Release(cl.redirect) // 目标已经断开时它的发件箱已经删除了，什么都不做
+	cl.redirect, cl.redirBox = nil, nil
+}
+
+// SetCaching 实现 CLIENT CACHING yes|no，只对下一个命令有效
+func (t *Table) SetCaching(c resp.Connection, yes bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cl, ok := t.clients[c.GetID()]
+	if !ok || !(cl.opts.OptIn || cl.opts.OptOut) {
+		return errors.New("CLIENT CACHING can be called only when the client is in tracking mode " +
+			"with OPTIN or OPTOUT mode enabled")
+	}
+	if yes && !cl.opts.OptIn {
+		return errors.New("CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+	}
+	if !yes && !cl.opts.OptOut {
+		return errors.New("CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+	}
+	if yes {
+		cl.caching = cachingYes
+	} else {
+		cl.caching = cachingNo
+	}
+	return nil
+}
+
+// EndCommand 一个命令执行完了，清除 CLIENT CACHING 设置的值
+func (t *Table) EndCommand(c resp.Connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cl, ok := t.clients[c.GetID()]; ok {
+		cl.caching = cachingUnset
+	}
+}
+
+// Read 执行读命令，记下读的键，并在发给这个客户端的失效通知之前把回复放进发件箱
+// 开启了客户端缓存的连接返回 NoReply，没有开启时原样返回 exec 的结果
+func (t *Table) Read(c resp.Connection, keys []string, exec func() resp.Reply) resp.Reply {
+	t.mu.Lock()
+	id := c.GetID()
+	cl, ok := t.clients[id]
+	if !ok {
+		t.mu.Unlock()
+		return exec()
+	}
+	if !cl.opts.BCast && cl.tracksNext() {
+		for _, key := range keys {
+			ids, ok := t.keys[key]
+			if !ok {
+				ids = make(map[int64]struct{})
+				t.keys[key] = ids
+			}
+			ids[id] = struct{}{}
+		}
+	}
+	t.mu.Unlock()
+
+	cl.deliverMu.Lock()
+	defer cl.deliverMu.Unlock()
+	result := exec()
+	if result == nil {
+		return nil
+	}
+	if msg := reply.Encode(result, c.GetProtocol()); len(msg) > 0 {
+		cl.box.Send(msg)
+	}
+	return &reply.NoReply{}
+}
+
+// tracksNext 返回这个命令读的键是否需要记下（调用者需要持有 Table 的锁）
+func (cl *client) tracksNext() bool {
+	if cl.opts.OptIn {
+		return cl.caching == cachingYes
+	}
+	if cl.opts.OptOut {
+		return cl.caching != cachingNo
+	}
+	return true
+}
+
+// Invalidate 键被修改了，通知读过它们的客户端和关心它们的前缀的客户端
+// writer 是修改键的连接，用于 NOLOOP；键过期或者被淘汰时为 nil
+func (t *Table) Invalidate(writer resp.Connection, keys []string) {
+	if !t.Active() || len(keys) == 0 {
+		return
+	}
+	var writerID int64 = -1
+	if writer != nil {
+		writerID = writer.GetID()
+	}
+	pending := make(map[int64]*delivery)
+	add := func(id int64, key string) {
+		cl, ok := t.clients[id]
+		if !ok || (cl.opts.NoLoop && id == writerID) {
+			return
+		}
+		d, ok := pending[id]
+		if !ok {
+			d = &delivery{cl: cl, redirect: cl.opts.Redirect, redirBox: cl.redirBox}
+			pending[id] = d
+		}
+		d.keys = append(d.keys, key)
+	}
+
+	t.mu.Lock()
+	for _, key := range keys {
+		if ids, ok := t.keys[key]; ok {
+			delete(t.keys, key) // 通知一次以后就忘掉，客户端再读的时候会重新记下
+			for id := range ids {
+				if cl, ok := t.clients[id]; ok && !cl.opts.BCast {
+					add(id, key)
+				}
+			}
+		}
+		for prefix, ids := range t.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				for id := range ids {
+					add(id, key)
+				}
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	for _, d := range pending {
+		t.deliver(d)
+	}
+}
+
+// InvalidateAll 清空了数据库，通知所有开启了客户端缓存的连接丢掉全部的缓存
+func (t *Table) InvalidateAll() {
+	if !t.Active() {
+		return
+	}
+	t.mu.Lock()
+	t.keys = make(map[string]map[int64]struct{})
+	pending := make([]*delivery, 0, len(t.clients))
+	for _, cl := range t.clients {
+		pending = append(pending, &delivery{cl: cl, redirect: cl.opts.Redirect, redirBox: cl.redirBox})
+	}
+	t.mu.Unlock()
+
+	for _, d := range pending {
+		t.deliver(d)
+	}
+}
+
+// deliver 把失效通知放进客户端或者它 REDIRECT 的目标的发件箱
+func (t *Table) deliver(d *delivery) {
+	d.cl.deliverMu.Lock()
+	defer d.cl.deliverMu.Unlock()
+	target, box := d.cl.conn, d.cl.box
+	if d.redirect != 0 {
+		conn, ok := t.lookup(d.redirect)
+		if !ok || d.redirBox == nil {
+			// 目标断开了，只提醒一次，RESP2 的客户端没有办法收到提醒
+			if d.cl.brokenRedir.CompareAndSwap(false, true) && d.cl.conn.GetProtocol() >= 3 {
+				d.cl.box.Send(reply.Encode(reply.MakePushReply([]resp.Reply{
+					reply.MakeBulkReply([]byte("tracking-redir-broken")),
+					reply.MakeIntReply(d.redirect),
+				}), 3))
+			}
+			return
+		}
+		target, box = conn, d.redirBox
+	}
+
+	var payload resp.Reply = reply.MakeNullReply()
+	if d.keys != nil {
+		args := make([][]byte, len(d.keys))
+		for i, key := range d.keys {
+			args[i] = []byte(key)
+		}
+		payload = reply.MakeMultiBulkReply(args)
+	}
+	if target.GetProtocol() >= 3 {
+		box.Send(reply.Encode(reply.MakePushReply([]resp.Reply{reply.MakeBulkReply([]byte("invalidate")), payload}), 3))
+		return
+	}
+	if d.redirect != 0 { // RESP2 的目标需要在订阅模式，否则收不到
+		t.publish(target, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("message")),
+			reply.MakeBulkReply([]byte(InvalidateChannel)),
+			payload,
+		}).ToBytes())
+	}
+	// 没有 REDIRECT 的 RESP2 客户端不能推送，和 Redis 一样丢掉
+}
+
+// Info 返回连接的客户端缓存的设置，没有开启时返回 false
+func (t *Table) Info(c resp.Connection) (Info, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cl, ok := t.clients[c.GetID()]
+	if !ok {
+		return Info{}, false
+	}
+	info := Info{Options: cl.opts, BrokenRedirect: cl.brokenRedir.Load()}
+	if info.BCast && len(info.Prefixes) == 1 && info.Prefixes[0] == "" {
+		info.Prefixes = nil // 关心所有的键
+	}
+	switch cl.caching {
+	case cachingYes:
+		info.Caching = "yes"
+	case cachingNo:
+		info.Caching = "no"
+	}
+	return info, true
+}
@@ -0,0 +1,217 @@
+package tracking
+
+import (
+	"GoMiniCache/database/outbox"
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/connection"
+	"GoMiniCache/resp/reply"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn 把写给客户端的内容记下来
+type fakeConn struct {
+	connection.Connection
+	id       int64
+	protocol int
+	mu       sync.Mutex
+	out      strings.Builder
+	outboxes *outbox.Registry // 由 makeTable 设置
+}
+
+func (c *fakeConn) GetID() int64     { return c.id }
+func (c *fakeConn) GetProtocol() int { return c.protocol }
+
+func (c *fakeConn) Write(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out.Write(b)
+	return nil
+}
+
+// take 等发件箱中的内容写完，返回写过的内容并清空
+func (c *fakeConn) take() string {
+	c.outboxes.Wait(c)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := strings.ReplaceAll(c.out.String(), "\r\n", " ")
+	c.out.Reset()
+	return out
+}
+
+// makeTable 创建 Table，published 记下发给 RESP2 重定向目标的消息
+func makeTable(conns ...*fakeConn) (*Table, *strings.Builder) {
+	published := &strings.Builder{}
+	lookup := func(id int64) (resp.Connection, bool) {
+		for _, c := range conns {
+			if c.id == id {
+				return c, true
+			}
+		}
+		return nil, false
+	}
+	publish := func(c resp.Connection, msg []byte) bool {
+		published.WriteString(strings.ReplaceAll(string(msg), "\r\n", " "))
+		return true
+	}
+	outboxes := outbox.NewRegistry()
+	for _, c := range conns {
+		c.outboxes = outboxes
+	}
+	return NewTable(lookup, publish, outboxes), published
+}
+
+func execOK() resp.Reply {
+	return reply.MakeOkReply()
+}
+
+func read(t *Table, c *fakeConn, keys ...string) {
+	t.Read(c, keys, execOK)
+	t.EndCommand(c)
+}
+
+func TestDefaultMode(t *testing.T) {
+	a := &fakeConn{id: 1, protocol: 3}
+	b := &fakeConn{id: 2, protocol: 3}
+	table, _ := makeTable(a, b)
+	if err := table.Enable(a, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	read(table, a, "k1", "k2")
+	if r := table.Read(b, []string{"k1"}, execOK); r == nil || string(r.ToBytes()) != "+OK\r\n" {
+		t.Fatal("reply of untracked client should be returned") // b 没有开启客户端缓存
+	}
+	if got := a.take(); got != "+OK " {
+		t.Fatalf("reply should be written by Read, got %q", got)
+	}
+	table.Invalidate(b, []string{"k1", "k3"})
+	if got := a.take(); got != ">2 $10 invalidate *1 $2 k1 " {
+		t.Fatalf("unexpected push %q", got)
+	}
+	table.Invalidate(b, []string{"k1"}) // 已经通知过了，要再读一次才会再通知
+	if got := a.take(); got != "" {
+		t.Fatalf("unexpected push %q", got)
+	}
+	if got := b.take(); got != "" {
+		t.Fatalf("unexpected output for untracked client %q", got)
+	}
+	table.InvalidateAll()
+	if got := a.take(); got != ">2 $10 invalidate _ " {
+		t.Fatalf("unexpected flush push %q", got)
+	}
+}
+
+func TestBCastAndNoLoop(t *testing.T) {
+	a := &fakeConn{id: 1, protocol: 3}
+	table, _ := makeTable(a)
+	if err := table.Enable(a, Options{BCast: true, NoLoop: true, Prefixes: []string{"user:", "order:"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Enable(a, Options{BCast: true, Prefixes: []string{"user:1"}}); err == nil {
+		t.Fatal("overlapping prefixes should be rejected")
+	}
+	table.Invalidate(nil, []string{"user:1", "item:1", "order:2"})
+	if got := a.take(); got != ">2 $10 invalidate *2 $6 user:1 $7 order:2 " {
+		t.Fatalf("unexpected push %q", got)
+	}
+	table.Invalidate(a, []string{"user:1"}) // NOLOOP
+	if got := a.take(); got != "" {
+		t.Fatalf("unexpected push %q", got)
+	}
+	table.Disable(a)
+	if table.Active() {
+		t.Fatal("table should be inactive")
+	}
+}
+
+func TestOptInOptOut(t *testing.T) {
+	a := &fakeConn{id: 1, protocol: 3}
+	b := &fakeConn{id: 2, protocol: 3}
+	table, _ := makeTable(a, b)
+	if err := table.Enable(a, Options{OptIn: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Enable(b, Options{OptOut: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SetCaching(a, false); err == nil {
+		t.Fatal("CACHING no is invalid in OPTIN mode")
+	}
+	read(table, a, "k1") // 没有 CACHING yes，不记录
+	_ = table.SetCaching(a, true)
+	read(table, a, "k2")
+	read(table, a, "k3") // 只对下一个命令有效
+	_ = table.SetCaching(b, false)
+	read(table, b, "k1")
+	read(table, b, "k2")
+	a.take()
+	b.take()
+	table.Invalidate(nil, []string{"k1", "k2", "k3"})
+	if got := a.take(); got != ">2 $10 invalidate *1 $2 k2 " {
+		t.Fatalf("unexpected push for optin client %q", got)
+	}
+	if got := b.take(); got != ">2 $10 invalidate *1 $2 k2 " {
+		t.Fatalf("unexpected push for optout client %q", got)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	a := &fakeConn{id: 1, protocol: 2}
+	sub := &fakeConn{id: 2, protocol: 2}
+	table, published := makeTable(a, sub)
+	if err := table.Enable(a, Options{Redirect: 3}); err == nil {
+		t.Fatal("redirect to a missing client should be rejected")
+	}
+	if err := table.Enable(a, Options{Redirect: 2}); err != nil {
+		t.Fatal(err)
+	}
+	read(table, a, "k1")
+	table.Invalidate(nil, []string{"k1"})
+	want := "*3 $7 message $20 __redis__:invalidate *1 $2 k1 "
+	if got := published.String(); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got := a.take(); got != "+OK " {
+		t.Fatalf("RESP2 client should not receive pushes, got %q", got)
+	}
+}
+
+// blockedConn 不读取的客户端，写入一直阻塞
+type blockedConn struct {
+	fakeConn
+	unblock chan struct{}
+}
+
+func (c *blockedConn) Write(b []byte) error {
+	<-c.unblock
+	return c.fakeConn.Write(b)
+}
+
+func TestSlowClientDoesNotBlockWriters(t *testing.T) {
+	slow := &blockedConn{fakeConn: fakeConn{id: 1, protocol: 3}, unblock: make(chan struct{})}
+	outboxes := outbox.NewRegistry()
+	slow.outboxes = outboxes
+	table := NewTable(func(int64) (resp.Connection, bool) { return nil, false }, nil, outboxes)
+	if err := table.Enable(slow, Options{BCast: true}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			table.Invalidate(nil, []string{"k"}) // 执行写命令的协程
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Invalidate blocked on a client that does not read")
+	}
+	close(slow.unblock)
+	outboxes.Wait(slow)
+	if got := strings.Count(slow.take(), "invalidate"); got != 10 {
+		t.Fatalf("expected 10 pushes after the client catches up, got %d", got)
+	}
+}
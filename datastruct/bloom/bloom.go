@@ -0,0 +1,312 @@
+package bloom
+
+/*
+ * 可扩展的布隆过滤器（Scalable Bloom Filter），和 RedisBloom 的 BF.* 使用相同的参数
+ *
+ * 过滤器由若干层普通的布隆过滤器组成，最后一层装满（插入的元素达到容量）以后追加新的一层，
+ * 新一层的容量是上一层的 expansion 倍，误判率是上一层的一半，整体的误判率不超过 errorRate 的两倍
+ * expansion 为 0 表示不扩展，装满以后再插入返回 ErrFull
+ *
+ * 每层使用 k 个哈希函数，由 64 位哈希的高低两半按 h1 + i*h2 组合出来，同一个元素在每层的哈希相同
+ *
+ * 容量、扩展倍数、k 和每层的大小都有上限：扩展到超过上限（或者调用者给的内存上限）时不再追加新的层，返回 ErrTooLarge
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+const (
+	// MaxCapacity 每一层最大的容量，扩展时超过它的按它计算
+	MaxCapacity = 1 << 32
+	// MaxExpansion 最大的扩展倍数
+	MaxExpansion = 32768
+	// MaxLayerBytes 每一层的位数组最多占用的内存（字节）
+	MaxLayerBytes = 1 << 30
+
+	maxHashes       = 64  // 每一层最多的哈希函数个数
+	tighteningRatio = 0.5 // 每一层的误判率是上一层的多少倍
+	headerSize      = 16  // errorRate 8 字节 + expansion 4 字节 + 层数 4 字节
+	layerHeaderSize = 28  // 位数 8 字节 + k 4 字节 + 容量 8 字节 + 元素个数 8 字节
+	sliceOverhead   = 24
+	layerOverhead   = 48 // layer 结构体和指针的大致开销
+)
+
+var (
+	// ErrFull 不扩展的过滤器已经装满
+	ErrFull = errors.New("non scaling filter is full")
+	// ErrTooLarge 最后一层装满了，新的一层超过了大小的上限
+	ErrTooLarge = errors.New("filter is full and the next layer would exceed the memory limit")
+	// ErrCorrupted 序列化的数据格式不对
+	ErrCorrupted = errors.New("bloom: corrupted data")
+)
+
+// layer 一层普通的布隆过滤器
+type layer struct {
+	bits     []uint64
+	m        uint64 // 位数
+	k        uint32 // 哈希函数的个数
+	capacity uint64
+	count    uint64 // 插入的元素个数
+}
+
+// Filter 可扩展的布隆过滤器，并发安全
+type Filter struct {
+	mu        sync.RWMutex
+	errorRate float64
+	expansion uint32
+	layers    []*layer
+}
+
+// Info BF.INFO 返回的信息
+type Info struct {
+	Capacity  uint64 // 所有层的容量之和
+	Size      int64  // 占用的内存（字节）
+	Filters   int    // 层数
+	Items     uint64 // 插入的元素个数
+	Expansion uint32 // 为 0 表示不扩展
+}
+
+// New 创建一个过滤器，errorRate 在 (0, 1) 之间，capacity 在 (0, MaxCapacity] 之间，expansion 为 0 表示不扩展
+// 调用者需要先用 LayerBytes 检查第一层的大小不超过 MaxLayerBytes
+func New(errorRate float64, capacity uint64, expansion uint32) *Filter {
+	f := &Filter{errorRate: errorRate, expansion: expansion}
+	f.layers = []*layer{newLayer(capacity, errorRate)}
+	return f
+}
+
+// LayerBytes 返回按容量和误判率创建的一层占用的内存（字节），超过 MaxLayerBytes 时返回 math.MaxUint64
+func LayerBytes(errorRate float64, capacity uint64) uint64 {
+	m, ok := layerBits(capacity, errorRate)
+	if !ok {
+		return math.MaxUint64
+	}
+	return m / 8
+}
+
+// layerBits 按容量和误判率计算位数，按 64 位对齐；超过 MaxLayerBytes 时返回 false
+func layerBits(capacity uint64, errorRate float64) (uint64, bool) {
+	bitsPerItem := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	bits := math.Ceil(float64(capacity) * bitsPerItem)
+	if !(bits <= MaxLayerBytes*8) { // 先用浮点数比较，转换成整数不会溢出
+		return 0, false
+	}
+	m := (uint64(bits) + 63) / 64 * 64
+	if m == 0 {
+		m = 64
+	}
+	return m, m <= MaxLayerBytes*8
+}
+
+// newLayer 按容量和误判率计算位数和哈希函数的个数，调用者需要保证位数没有超过上限
+func newLayer(capacity uint64, errorRate float64) *layer {
+	m, _ := layerBits(capacity, errorRate)
+	k := uint32(min(math.Ceil(-math.Log2(errorRate)), maxHashes))
+	if k == 0 {
+		k = 1
+	}
+	return &layer{bits: make([]uint64, m/64), m: m, k: k, capacity: capacity}
+}
+
+// nextCapacity 返回下一层的容量，不超过 MaxCapacity
+func nextCapacity(capacity uint64, expansion uint32) uint64 {
+	if capacity >= MaxCapacity/uint64(expansion) {
+		return MaxCapacity
+	}
+	return capacity * uint64(expansion)
+}
+
+// hash 计算元素的 64 位哈希，FNV 的结果再经过 murmur3 的 fmix64 打散
+func hash(item []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(item)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// add 把元素的 k 个位都置 1，返回是否有位原来是 0
+func (l *layer) add(h uint64) bool {
+	h1, h2 := h&math.MaxUint32, h>>32
+	changed := false
+	for i := uint64(0); i < uint64(l.k); i++ {
+		pos := (h1 + i*h2) % l.m
+		word, mask := pos/64, uint64(1)<<(pos%64)
+		if l.bits[word]&mask == 0 {
+			l.bits[word] |= mask
+			changed = true
+		}
+	}
+	return changed
+}
+
+// test 返回元素的 k 个位是否都是 1
+func (l *layer) test(h uint64) bool {
+	h1, h2 := h&math.MaxUint32, h>>32
+	for i := uint64(0); i < uint64(l.k); i++ {
+		pos := (h1 + i*h2) % l.m
+		if l.bits[pos/64]&(uint64(1)<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add 插入元素，返回 true 表示元素原来一定不存在，false 表示元素可能已经存在
+// 需要追加新的一层时，新的一层超过 MaxLayerBytes 或者 limit（大于 0 时）就返回 ErrTooLarge
+func (f *Filter) Add(item []byte, limit uint64) (bool, error) {
+	h := hash(item)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.containsLocked(h) {
+		return false, nil
+	}
+	last := f.layers[len(f.layers)-1]
+	if last.count >= last.capacity {
+		if f.expansion == 0 {
+			return false, ErrFull
+		}
+		errorRate := f.errorRate * math.Pow(tighteningRatio, float64(len(f.layers)))
+		capacity := nextCapacity(last.capacity, f.expansion)
+		if size := LayerBytes(errorRate, capacity); size == math.MaxUint64 || (limit > 0 && size > limit) {
+			return false, ErrTooLarge
+		}
+		last = newLayer(capacity, errorRate)
+		f.layers = append(f.layers, last)
+	}
+	last.add(h)
+	last.count++
+	return true, nil
+}
+
+// Exists 返回元素是否可能存在，false 表示一定不存在
+func (f *Filter) Exists(item []byte) bool {
+	h := hash(item)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.containsLocked(h)
+}
+
+func (f *Filter) containsLocked(h uint64) bool {
+	for i := len(f.layers) - 1; i >= 0; i-- { // 新的层元素更多，先查
+		if f.layers[i].test(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Info 返回过滤器的容量、大小等信息
+func (f *Filter) Info() Info {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	info := Info{Size: f.bytesLocked(), Filters: len(f.layers), Expansion: f.expansion}
+	for _, l := range f.layers {
+		info.Capacity += l.capacity
+		info.Items += l.count
+	}
+	return info
+}
+
+// Bytes 估算过滤器占用的内存（字节）
+func (f *Filter) Bytes() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.bytesLocked()
+}
+
+func (f *Filter) bytesLocked() int64 {
+	size := int64(sliceOverhead)
+	for _, l := range f.layers {
+		size += layerOverhead + int64(len(l.bits))*8
+	}
+	return size
+}
+
+// Clone 深拷贝过滤器
+func (f *Filter) Clone() *Filter {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	clone := &Filter{errorRate: f.errorRate, expansion: f.expansion, layers: make([]*layer, len(f.layers))}
+	for i, l := range f.layers {
+		copied := *l
+		copied.bits = append([]uint64(nil), l.bits...)
+		clone.layers[i] = &copied
+	}
+	return clone
+}
+
+// MarshalBinary 把过滤器的全部状态编码成字节数组，都是小端
+// 布局: <errorRate> <expansion> <层数> 每层 <位数> <k> <容量> <元素个数> <位数组>
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	size := headerSize
+	for _, l := range f.layers {
+		size += layerHeaderSize + len(l.bits)*8
+	}
+	buf := make([]byte, 0, size)
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(f.errorRate))
+	buf = binary.LittleEndian.AppendUint32(buf, f.expansion)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(f.layers)))
+	for _, l := range f.layers {
+		buf = binary.LittleEndian.AppendUint64(buf, l.m)
+		buf = binary.LittleEndian.AppendUint32(buf, l.k)
+		buf = binary.LittleEndian.AppendUint64(buf, l.capacity)
+		buf = binary.LittleEndian.AppendUint64(buf, l.count)
+		for _, word := range l.bits {
+			buf = binary.LittleEndian.AppendUint64(buf, word)
+		}
+	}
+	return buf, nil
+}
+
+// Unmarshal 从 MarshalBinary 的结果恢复过滤器
+func Unmarshal(data []byte) (*Filter, error) {
+	if len(data) < headerSize {
+		return nil, ErrCorrupted
+	}
+	f := &Filter{
+		errorRate: math.Float64frombits(binary.LittleEndian.Uint64(data)),
+		expansion: binary.LittleEndian.Uint32(data[8:]),
+	}
+	if !(f.errorRate > 0 && f.errorRate < 1) || f.expansion > MaxExpansion {
+		return nil, ErrCorrupted
+	}
+	n := binary.LittleEndian.Uint32(data[12:])
+	data = data[headerSize:]
+	for i := uint32(0); i < n; i++ {
+		if len(data) < layerHeaderSize {
+			return nil, ErrCorrupted
+		}
+		l := &layer{
+			m:        binary.LittleEndian.Uint64(data),
+			k:        binary.LittleEndian.Uint32(data[8:]),
+			capacity: binary.LittleEndian.Uint64(data[12:]),
+			count:    binary.LittleEndian.Uint64(data[20:]),
+		}
+		data = data[layerHeaderSize:]
+		if l.m == 0 || l.m%64 != 0 || l.m > MaxLayerBytes*8 || l.k == 0 || l.k > maxHashes ||
+			l.capacity == 0 || l.capacity > MaxCapacity || uint64(len(data)) < l.m/8 {
+			return nil, ErrCorrupted
+		}
+		l.bits = make([]uint64, l.m/64)
+		for j := range l.bits {
+			l.bits[j] = binary.LittleEndian.Uint64(data[j*8:])
+		}
+		data = data[l.m/8:]
+		f.layers = append(f.layers, l)
+	}
+	if len(f.layers) == 0 || len(data) != 0 {
+		return nil, ErrCorrupted
+	}
+	return f, nil
+}
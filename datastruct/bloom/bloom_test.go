@@ -0,0 +1,97 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestScaling(t *testing.T) {
+	f := New(0.01, 100, 2)
+	for i := 0; i < 1000; i++ {
+		added, err := f.Add([]byte("item"+strconv.Itoa(i)), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added && i < 100 { // 第一层还没满的时候出现误判的概率很小
+			t.Logf("false positive at %d", i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Exists([]byte("item" + strconv.Itoa(i))) {
+			t.Fatalf("item%d should exist", i)
+		}
+	}
+	info := f.Info()
+	if info.Filters < 4 || info.Capacity < 1000 || info.Items > 1000 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.Exists([]byte("other" + strconv.Itoa(i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 { // 整体误判率大约是 errorRate 的两倍，留一些余量
+		t.Fatalf("too many false positives: %d", falsePositives)
+	}
+}
+
+func TestNonScaling(t *testing.T) {
+	f := New(0.01, 10, 0)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = f.Add([]byte(strconv.Itoa(i)), 0)
+	}
+	if err != ErrFull {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f := New(0.001, 50, 4)
+	for i := 0; i < 200; i++ {
+		_, _ = f.Add([]byte(strconv.Itoa(i)), 0)
+	}
+	data, _ := f.MarshalBinary()
+	restored, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Info() != f.Info() {
+		t.Fatalf("info mismatch: %+v %+v", restored.Info(), f.Info())
+	}
+	for i := 0; i < 200; i++ {
+		if !restored.Exists([]byte(strconv.Itoa(i))) {
+			t.Fatalf("%d should exist", i)
+		}
+	}
+	if _, err := Unmarshal(data[:len(data)-1]); err != ErrCorrupted {
+		t.Fatalf("expected ErrCorrupted, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	if c := nextCapacity(MaxCapacity/2+1, MaxExpansion); c != MaxCapacity { // 相乘会溢出
+		t.Fatalf("expected capacity to be capped, got %d", c)
+	}
+	if LayerBytes(1e-300, MaxCapacity) != math.MaxUint64 {
+		t.Fatal("oversized layer should be reported")
+	}
+
+	f := New(0.01, 10, 1000)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = f.Add([]byte(strconv.Itoa(i)), 64) // 第二层超过 64 字节
+	}
+	if err != ErrTooLarge || f.Info().Filters != 1 {
+		t.Fatalf("expected ErrTooLarge without growing, got %v", err)
+	}
+
+	data, _ := f.MarshalBinary()
+	binary.LittleEndian.PutUint32(data[headerSize+8:], 1000) // 第一层的 k
+	if _, err := Unmarshal(data); err != ErrCorrupted {
+		t.Fatalf("expected ErrCorrupted for a huge k, got %v", err)
+	}
+}
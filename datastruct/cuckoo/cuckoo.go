@@ -0,0 +1,388 @@
+package cuckoo
+
+/*
+ * 布谷鸟过滤器（Cuckoo Filter），和 RedisBloom 的 CF.* 使用相同的参数，和布隆过滤器相比支持删除
+ *
+ * 每个元素只保存 8 位的指纹，可以放在两个候选桶中的任意一个：
+ *   i1 = hash & (桶数 - 1)
+ *   i2 = (i1 ^ hash(指纹)) & (桶数 - 1)
+ * 桶数是 2 的幂，所以从任意一个候选桶和指纹都能算出另一个。两个桶都满时随机踢出一个指纹，把它挪到它的另一个桶，
+ * 最多挪 maxIterations 次；还是放不下时撤销这些挪动，追加一个新的子过滤器（桶数是上一个的 expansion 倍）
+ * expansion 为 0 表示不扩展，放不下时返回 ErrFull；新的子过滤器超过 MaxTableBytes（或者调用者给的内存上限）时返回 ErrTooLarge
+ *
+ * 踢出时使用过滤器自己的伪随机数，随机数的状态也会序列化，重放同样的命令得到同样的过滤器
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math/bits"
+	"sync"
+)
+
+const (
+	// 没有指定时的默认参数，和 RedisBloom 相同
+	DefaultBucketSize    = 2
+	DefaultMaxIterations = 20
+	DefaultExpansion     = 1
+
+	// MaxCapacity 创建时最大的容量
+	MaxCapacity = 1 << 32
+	// MaxTableBytes 每个子过滤器最多占用的内存（字节），桶数 * 桶大小
+	MaxTableBytes = 1 << 30
+
+	emptySlot     = 0
+	headerSize    = 41 // 桶大小 1 + 最大挪动次数 2 + expansion 2 + 容量 8 + 元素个数 8 + 删除次数 8 + 随机数 8 + 子过滤器个数 4
+	sliceOverhead = 24
+	tableOverhead = 40 // table 结构体和指针的大致开销
+)
+
+var (
+	// ErrFull 过滤器装满了，并且不能扩展
+	ErrFull = errors.New("filter is full")
+	// ErrTooLarge 过滤器装满了，新的子过滤器超过了大小的上限
+	ErrTooLarge = errors.New("filter is full and the next sub-filter would exceed the memory limit")
+	// ErrCorrupted 序列化的数据格式不对
+	ErrCorrupted = errors.New("cuckoo: corrupted data")
+)
+
+// table 一个子过滤器，slots 按桶依次保存指纹，0 表示空位
+type table struct {
+	slots      []byte
+	numBuckets uint64
+}
+
+// Filter 可扩展的布谷鸟过滤器，并发安全
+type Filter struct {
+	mu            sync.RWMutex
+	bucketSize    uint8
+	maxIterations uint16
+	expansion     uint16
+	capacity      uint64 // 创建时指定的容量
+	items         uint64
+	deletes       uint64
+	rnd           uint64 // xorshift 的状态
+	tables        []*table
+}
+
+// New 创建一个过滤器，bucketSize、maxIterations 必须大于 0，capacity 不超过 MaxCapacity
+// 调用者需要先用 TableBytes 检查第一个子过滤器的大小不超过 MaxTableBytes
+func New(capacity uint64, bucketSize uint8, maxIterations uint16, expansion uint16) *Filter {
+	numBuckets := (capacity + uint64(bucketSize) - 1) / uint64(bucketSize)
+	f := &Filter{
+		bucketSize:    bucketSize,
+		maxIterations: maxIterations,
+		expansion:     expansion,
+		capacity:      capacity,
+		rnd:           0x9E3779B97F4A7C15,
+	}
+	f.tables = []*table{f.newTable(numBuckets)}
+	return f
+}
+
+// TableBytes 返回按容量和桶大小创建的子过滤器占用的内存（字节）
+func TableBytes(capacity uint64, bucketSize uint8) uint64 {
+	return roundBuckets((capacity+uint64(bucketSize)-1)/uint64(bucketSize)) * uint64(bucketSize)
+}
+
+// roundBuckets 桶数向上取整到 2 的幂；超过 MaxTableBytes 时返回 2*MaxTableBytes，乘以桶大小仍然超过上限并且不会溢出
+func roundBuckets(numBuckets uint64) uint64 {
+	if numBuckets <= 1 {
+		return 1
+	}
+	if numBuckets > MaxTableBytes {
+		return 2 * MaxTableBytes
+	}
+	return uint64(1) << (64 - bits.LeadingZeros64(numBuckets-1))
+}
+
+// newTable 创建一个子过滤器，桶数向上取整到 2 的幂，调用者需要保证大小没有超过上限
+func (f *Filter) newTable(numBuckets uint64) *table {
+	numBuckets = roundBuckets(numBuckets)
+	return &table{slots: make([]byte, numBuckets*uint64(f.bucketSize)), numBuckets: numBuckets}
+}
+
+// hash 计算元素的 64 位哈希，FNV 的结果再经过 murmur3 的 fmix64 打散
+func hash(item []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(item)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// fingerprint 取哈希的高 32 位算出 1~255 的指纹
+func fingerprint(h uint64) byte {
+	return byte((h>>32)%255 + 1)
+}
+
+// altIndex 返回指纹的另一个候选桶
+func (t *table) altIndex(i uint64, fp byte) uint64 {
+	return (i ^ (uint64(fp) * 0x5bd1e995)) & (t.numBuckets - 1)
+}
+
+// bucket 返回第 i 个桶
+func (f *Filter) bucket(t *table, i uint64) []byte {
+	size := uint64(f.bucketSize)
+	return t.slots[i*size : (i+1)*size]
+}
+
+// next 返回下一个伪随机数（xorshift64）
+func (f *Filter) next() uint64 {
+	f.rnd ^= f.rnd << 13
+	f.rnd ^= f.rnd >> 7
+	f.rnd ^= f.rnd << 17
+	return f.rnd
+}
+
+// insertInto 把指纹放进桶的空位，没有空位时返回 false
+func insertInto(bucket []byte, fp byte) bool {
+	for j, slot := range bucket {
+		if slot == emptySlot {
+			bucket[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// insert 把指纹放进子过滤器，放不下时撤销所有的挪动并返回 false
+func (f *Filter) insert(t *table, h uint64, fp byte) bool {
+	i1 := h & (t.numBuckets - 1)
+	i2 := t.altIndex(i1, fp)
+	if insertInto(f.bucket(t, i1), fp) || insertInto(f.bucket(t, i2), fp) {
+		return true
+	}
+	type move struct {
+		bucket uint64
+		slot   uint64
+	}
+	moves := make([]move, 0, f.maxIterations)
+	i := i1
+	if f.next()&1 == 1 {
+		i = i2
+	}
+	cur := fp
+	for n := uint16(0); n < f.maxIterations; n++ {
+		slot := f.next() % uint64(f.bucketSize)
+		b := f.bucket(t, i)
+		cur, b[slot] = b[slot], cur
+		moves = append(moves, move{bucket: i, slot: slot})
+		i = t.altIndex(i, cur)
+		if insertInto(f.bucket(t, i), cur) {
+			return true
+		}
+	}
+	for n := len(moves) - 1; n >= 0; n-- { // 倒着换回去，最后手里拿的是最开始的指纹
+		b := f.bucket(t, moves[n].bucket)
+		cur, b[moves[n].slot] = b[moves[n].slot], cur
+	}
+	return false
+}
+
+// Add 插入元素，同一个元素可以插入多次
+// 需要追加新的子过滤器时，新的子过滤器超过 MaxTableBytes 或者 limit（大于 0 时）就返回 ErrTooLarge
+func (f *Filter) Add(item []byte, limit uint64) error {
+	h := hash(item)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addLocked(h, limit)
+}
+
+func (f *Filter) addLocked(h uint64, limit uint64) error {
+	fp := fingerprint(h)
+	last := f.tables[len(f.tables)-1]
+	if !f.insert(last, h, fp) {
+		if f.expansion == 0 {
+			return ErrFull
+		}
+		numBuckets := roundBuckets(last.numBuckets * uint64(f.expansion)) // 桶数不超过 2^30，乘以 expansion 不会溢出
+		if size := numBuckets * uint64(f.bucketSize); size > MaxTableBytes || (limit > 0 && size > limit) {
+			return ErrTooLarge
+		}
+		last = f.newTable(numBuckets)
+		f.tables = append(f.tables, last)
+		f.insert(last, h, fp) // 新的子过滤器是空的，一定放得下
+	}
+	f.items++
+	return nil
+}
+
+// AddNX 元素不存在时插入，返回是否插入了，limit 和 Add 相同
+func (f *Filter) AddNX(item []byte, limit uint64) (bool, error) {
+	h := hash(item)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.countLocked(h, true) > 0 {
+		return false, nil
+	}
+	if err := f.addLocked(h, limit); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Exists 返回元素是否可能存在，false 表示一定不存在
+func (f *Filter) Exists(item []byte) bool {
+	h := hash(item)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.countLocked(h, true) > 0
+}
+
+// Count 返回元素可能被插入的次数（指纹相同的元素也会计算在内）
+func (f *Filter) Count(item []byte) uint64 {
+	h := hash(item)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.countLocked(h, false)
+}
+
+// countLocked 统计所有子过滤器中指纹出现的次数，first 为 true 时找到一个就返回
+func (f *Filter) countLocked(h uint64, first bool) uint64 {
+	fp := fingerprint(h)
+	var count uint64
+	for _, t := range f.tables {
+		i1 := h & (t.numBuckets - 1)
+		i2 := t.altIndex(i1, fp)
+		for _, i := range []uint64{i1, i2} {
+			for _, slot := range f.bucket(t, i) {
+				if slot == fp {
+					count++
+					if first {
+						return count
+					}
+				}
+			}
+			if i1 == i2 { // 两个候选桶相同时不要重复统计
+				break
+			}
+		}
+	}
+	return count
+}
+
+// Delete 删除元素的一次插入，返回是否找到了
+// 只能删除确实插入过的元素，否则可能删掉指纹相同的其他元素
+func (f *Filter) Delete(item []byte) bool {
+	h := hash(item)
+	fp := fingerprint(h)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for n := len(f.tables) - 1; n >= 0; n-- { // 从新到旧
+		t := f.tables[n]
+		i1 := h & (t.numBuckets - 1)
+		for _, i := range []uint64{i1, t.altIndex(i1, fp)} {
+			b := f.bucket(t, i)
+			for j, slot := range b {
+				if slot == fp {
+					b[j] = emptySlot
+					f.items--
+					f.deletes++
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Bytes 估算过滤器占用的内存（字节）
+func (f *Filter) Bytes() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	size := int64(sliceOverhead)
+	for _, t := range f.tables {
+		size += tableOverhead + int64(len(t.slots))
+	}
+	return size
+}
+
+// Clone 深拷贝过滤器
+func (f *Filter) Clone() *Filter {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	clone := &Filter{
+		bucketSize:    f.bucketSize,
+		maxIterations: f.maxIterations,
+		expansion:     f.expansion,
+		capacity:      f.capacity,
+		items:         f.items,
+		deletes:       f.deletes,
+		rnd:           f.rnd,
+		tables:        make([]*table, len(f.tables)),
+	}
+	for i, t := range f.tables {
+		clone.tables[i] = &table{slots: append([]byte(nil), t.slots...), numBuckets: t.numBuckets}
+	}
+	return clone
+}
+
+// MarshalBinary 把过滤器的全部状态编码成字节数组，都是小端
+// 布局: <桶大小> <最大挪动次数> <expansion> <容量> <元素个数> <删除次数> <随机数> <子过滤器个数> 每个子过滤器 <桶数> <指纹>
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	size := headerSize
+	for _, t := range f.tables {
+		size += 8 + len(t.slots)
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, f.bucketSize)
+	buf = binary.LittleEndian.AppendUint16(buf, f.maxIterations)
+	buf = binary.LittleEndian.AppendUint16(buf, f.expansion)
+	buf = binary.LittleEndian.AppendUint64(buf, f.capacity)
+	buf = binary.LittleEndian.AppendUint64(buf, f.items)
+	buf = binary.LittleEndian.AppendUint64(buf, f.deletes)
+	buf = binary.LittleEndian.AppendUint64(buf, f.rnd)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(f.tables)))
+	for _, t := range f.tables {
+		buf = binary.LittleEndian.AppendUint64(buf, t.numBuckets)
+		buf = append(buf, t.slots...)
+	}
+	return buf, nil
+}
+
+// Unmarshal 从 MarshalBinary 的结果恢复过滤器
+func Unmarshal(data []byte) (*Filter, error) {
+	if len(data) < headerSize {
+		return nil, ErrCorrupted
+	}
+	f := &Filter{
+		bucketSize:    data[0],
+		maxIterations: binary.LittleEndian.Uint16(data[1:]),
+		expansion:     binary.LittleEndian.Uint16(data[3:]),
+		capacity:      binary.LittleEndian.Uint64(data[5:]),
+		items:         binary.LittleEndian.Uint64(data[13:]),
+		deletes:       binary.LittleEndian.Uint64(data[21:]),
+		rnd:           binary.LittleEndian.Uint64(data[29:]),
+	}
+	n := binary.LittleEndian.Uint32(data[37:])
+	if f.bucketSize == 0 || f.maxIterations == 0 || f.rnd == 0 {
+		return nil, ErrCorrupted
+	}
+	data = data[headerSize:]
+	for i := uint32(0); i < n; i++ {
+		if len(data) < 8 {
+			return nil, ErrCorrupted
+		}
+		numBuckets := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		if numBuckets == 0 || numBuckets&(numBuckets-1) != 0 || numBuckets > MaxTableBytes/uint64(f.bucketSize) ||
+			uint64(len(data)) < numBuckets*uint64(f.bucketSize) {
+			return nil, ErrCorrupted
+		}
+		size := numBuckets * uint64(f.bucketSize)
+		f.tables = append(f.tables, &table{slots: append([]byte(nil), data[:size]...), numBuckets: numBuckets})
+		data = data[size:]
+	}
+	if len(f.tables) == 0 || len(data) != 0 {
+		return nil, ErrCorrupted
+	}
+	return f, nil
+}
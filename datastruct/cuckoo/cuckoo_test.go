@@ -0,0 +1,87 @@
+package cuckoo
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestAddDelete(t *testing.T) {
+	f := New(1000, DefaultBucketSize, DefaultMaxIterations, DefaultExpansion)
+	for i := 0; i < 1000; i++ {
+		if err := f.Add([]byte(strconv.Itoa(i)), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Exists([]byte(strconv.Itoa(i))) {
+			t.Fatalf("%d should exist", i)
+		}
+	}
+	_ = f.Add([]byte("dup"), 0)
+	_ = f.Add([]byte("dup"), 0)
+	if f.Count([]byte("dup")) < 2 {
+		t.Fatal("duplicates should be counted")
+	}
+	for i := 0; i < 500; i++ {
+		if !f.Delete([]byte(strconv.Itoa(i))) {
+			t.Fatalf("%d should be deleted", i)
+		}
+	}
+	for i := 500; i < 1000; i++ { // 删除别的元素不会影响剩下的元素
+		if !f.Exists([]byte(strconv.Itoa(i))) {
+			t.Fatalf("%d should still exist", i)
+		}
+	}
+	if added, _ := f.AddNX([]byte("999"), 0); added {
+		t.Fatal("AddNX should not add an existing item")
+	}
+}
+
+func TestFull(t *testing.T) {
+	f := New(8, 2, 5, 0)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = f.Add([]byte(strconv.Itoa(i)), 0)
+	}
+	if err != ErrFull {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	// 同样的插入顺序得到同样的状态，重放 AOF 依赖这一点
+	a := New(64, 4, 50, 2)
+	b := New(64, 4, 50, 2)
+	for i := 0; i < 500; i++ {
+		_ = a.Add([]byte(strconv.Itoa(i)), 0)
+		_ = b.Add([]byte(strconv.Itoa(i)), 0)
+	}
+	da, _ := a.MarshalBinary()
+	db, _ := b.MarshalBinary()
+	if !bytes.Equal(da, db) {
+		t.Fatal("filters should be identical")
+	}
+	restored, err := Unmarshal(da)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, _ := restored.MarshalBinary()
+	if !bytes.Equal(da, dr) {
+		t.Fatal("round trip mismatch")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	if size := TableBytes(MaxCapacity, 255); size <= MaxTableBytes {
+		t.Fatalf("expected oversized table, got %d bytes", size)
+	}
+	f := New(8, 2, 5, 32768)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = f.Add([]byte(strconv.Itoa(i)), 1024) // 第二个子过滤器有 4*32768*2 字节
+	}
+	if err != ErrTooLarge || len(f.tables) != 1 {
+		t.Fatalf("expected ErrTooLarge without growing, got %v", err)
+	}
+}
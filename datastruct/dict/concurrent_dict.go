@@ -0,0 +1,26 @@
+package dict
+
+/*
+ * 使用分段锁 map 作为数据库底层存储，实现在 gdict.ConcurrentDict 中，这里只是把值的类型固定为 interface{}
+ */
+
+import (
+	"GoMiniCache/datastruct/gdict"
+)
+
+// ConcurrentDict 分段加锁的并发安全字典
+type ConcurrentDict struct {
+	*gdict.ConcurrentDict[string, interface{}]
+}
+
+// MakeConcurrentDict 创建，shardCount 会被向上取到 2 的幂，小于等于 0 时使用默认值
+func MakeConcurrentDict(shardCount int) *ConcurrentDict {
+	return &ConcurrentDict{
+		ConcurrentDict: gdict.MakeConcurrentDict[string, interface{}](shardCount),
+	}
+}
+
+// ForEach 遍历字典，consumer 返回 false 时停止
+func (dict *ConcurrentDict) ForEach(consumer Consumer) {
+	dict.Range(gdict.Consumer[string, interface{}](consumer))
+}
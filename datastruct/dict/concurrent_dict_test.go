@@ -0,0 +1,153 @@
+package dict
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentDictBasic(t *testing.T) {
+	d := MakeConcurrentDict(0)
+	for i := 0; i < 1000; i++ {
+		if d.Put("k"+strconv.Itoa(i), i) != 1 {
+			t.Fatal("expected new key")
+		}
+	}
+	if d.Len() != 1000 || len(d.Keys()) != 1000 {
+		t.Errorf("expected 1000 keys, got %d", d.Len())
+	}
+	if d.PutIfAbsent("k1", 0) != 0 || d.PutIfExists("k1", -1) != 1 || d.PutIfExists("none", 0) != 0 {
+		t.Error("conditional put failed")
+	}
+	if val, ok := d.Get("k1"); !ok || val != -1 {
+		t.Errorf("unexpected value %v", val)
+	}
+	visited := 0
+	d.ForEach(func(key string, val interface{}) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Errorf("ForEach should stop when consumer returns false, visited %d", visited)
+	}
+	if d.Remove("k1") != 1 || d.Remove("k1") != 0 || d.Len() != 999 {
+		t.Error("remove failed")
+	}
+	d.Clear()
+	if d.Len() != 0 || len(d.Keys()) != 0 {
+		t.Error("clear failed")
+	}
+}
+
+// TestConcurrentDictPutIfAbsent 并发地插入同一批键，每个键只能有一次插入成功
+func TestConcurrentDictPutIfAbsent(t *testing.T) {
+	d := MakeConcurrentDict(16)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	inserted := 0
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := 0
+			for i := 0; i < 1000; i++ {
+				n += d.PutIfAbsent("k"+strconv.Itoa(i), i)
+			}
+			mu.Lock()
+			inserted += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if inserted != 1000 || d.Len() != 1000 {
+		t.Errorf("expected 1000 inserts, got %d (len %d)", inserted, d.Len())
+	}
+}
+
+func TestConcurrentDictRandomKeys(t *testing.T) {
+	d := MakeConcurrentDict(16)
+	for i := 0; i < 100; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		for _, key := range d.RandomKeys(10) {
+			seen[key] = true
+		}
+	}
+	if len(seen) < 50 { // 总是返回同一批键说明不是随机的
+		t.Errorf("random keys are not random, only %d distinct keys seen", len(seen))
+	}
+	for _, limit := range []int{5, 60, 100, 200} {
+		keys := d.RandomDistinctKeys(limit)
+		distinct := make(map[string]bool)
+		for _, key := range keys {
+			distinct[key] = true
+		}
+		expected := limit
+		if expected > 100 {
+			expected = 100
+		}
+		if len(keys) != expected || len(distinct) != expected {
+			t.Errorf("limit %d: expected %d distinct keys, got %d (%d distinct)", limit, expected, len(keys), len(distinct))
+		}
+	}
+}
+
+func TestConcurrentDictLocks(t *testing.T) {
+	d := MakeConcurrentDict(16)
+	d.Put("src", 1)
+	keys := []string{"src", "dest"}
+	d.Locks(keys...)
+	val, _ := d.GetWithLock("src")
+	d.RemoveWithLock("src")
+	d.PutIfAbsentWithLock("dest", val)
+	d.Unlocks(keys...)
+	if _, ok := d.Get("src"); ok {
+		t.Error("src should be removed")
+	}
+	if val, ok := d.Get("dest"); !ok || val != 1 || d.Len() != 1 {
+		t.Errorf("dest should be 1, got %v", val)
+	}
+}
+
+func benchmarkPut(b *testing.B, d Dict) {
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			d.Put("k"+strconv.Itoa(i%10000), i)
+			i++
+		}
+	})
+}
+
+func benchmarkGet(b *testing.B, d Dict) {
+	for i := 0; i < 10000; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			d.Get("k" + strconv.Itoa(i%10000))
+			i++
+		}
+	})
+}
+
+func benchmarkLen(b *testing.B, d Dict) {
+	for i := 0; i < 10000; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Len()
+	}
+}
+
+func BenchmarkConcurrentDictPut(b *testing.B) { benchmarkPut(b, MakeConcurrentDict(0)) }
+func BenchmarkSyncDictPut(b *testing.B)       { benchmarkPut(b, MakeSyncDict()) }
+func BenchmarkConcurrentDictGet(b *testing.B) { benchmarkGet(b, MakeConcurrentDict(0)) }
+func BenchmarkSyncDictGet(b *testing.B)       { benchmarkGet(b, MakeSyncDict()) }
+func BenchmarkConcurrentDictLen(b *testing.B) { benchmarkLen(b, MakeConcurrentDict(0)) }
+func BenchmarkSyncDictLen(b *testing.B)       { benchmarkLen(b, MakeSyncDict()) }
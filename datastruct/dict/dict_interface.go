@@ -21,3 +21,8 @@ type Dict interface {
 	RandomDistinctKeys(limit int) []string                // 返回多个不重复的键
 	Clear()                                               // 清空字典
 }
+
+// Scanner 支持游标遍历的字典（SCAN），游标在字典扩容、缩容之后依然有效
+type Scanner interface {
+	Scan(cursor uint64, count int, consumer Consumer) uint64 // 从 cursor 开始遍历一部分键值，返回下一次的游标，0 表示结束
+}
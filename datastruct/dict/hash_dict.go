@@ -0,0 +1,571 @@
+package dict
+
+/*
+ * 和 Redis 的 dict 一样的链式哈希表：两张表渐进式 rehash，桶的个数总是 2 的幂，支持反向二进制游标遍历（SCAN）
+ * 扩容或缩容时不会一次搬完所有节点，而是在之后的每次操作中顺便搬几个桶，避免一次操作耗时过长
+ */
+
+import (
+	"hash/maphash"
+	"math/rand"
+	"sync"
+)
+
+const (
+	hashDictInitSize = 4  // 哈希表最少的桶个数
+	rehashStepSize   = 1  // 每次操作顺便搬的桶的个数
+	rehashEmptyVisit = 10 // 每搬一个桶最多跳过的空桶个数，避免一次操作遍历太多空桶
+)
+
+// hashEntry 哈希表中的节点，同一个桶里的节点串成链表
+type hashEntry struct {
+	key  string
+	val  interface{}
+	next *hashEntry
+}
+
+// hashTable 一张哈希表
+type hashTable struct {
+	buckets []*hashEntry
+	used    int // 节点的个数
+}
+
+// mask 返回桶下标的掩码，空表返回 0
+func (ht *hashTable) mask() uint64 {
+	if len(ht.buckets) == 0 {
+		return 0
+	}
+	return uint64(len(ht.buckets) - 1)
+}
+
+// HashDict 渐进式 rehash 的链式哈希表
+// 键的个数超过桶的个数时扩容一倍，少于桶个数的 1/8 时缩容；rehash 期间新节点放进 ht[1]，查找时两张表都要看
+// rehash 的每一步都会修改表，所以读操作也需要加互斥锁
+// 每个字典有自己的随机种子，客户端无法构造出落在同一个桶里的大量键（hash flooding）
+type HashDict struct {
+	mu            sync.Mutex
+	seed          maphash.Seed
+	ht            [2]hashTable
+	rehashIdx     int // ht[0] 中下一个要搬的桶，-1 表示没有在 rehash
+	safeIterators int // 正在使用的安全迭代器个数，大于 0 时暂停 rehash
+}
+
+// MakeHashDict 创建
+func MakeHashDict() *HashDict {
+	return &HashDict{
+		seed:      maphash.MakeSeed(),
+		ht:        [2]hashTable{{buckets: make([]*hashEntry, hashDictInitSize)}},
+		rehashIdx: -1,
+	}
+}
+
+// hash 计算键的哈希值
+func (dict *HashDict) hash(key string) uint64 {
+	return maphash.String(dict.seed, key)
+}
+
+// nextPower 返回不小于 size 的 2 的幂（至少是 hashDictInitSize）
+func nextPower(size int) int {
+	n := hashDictInitSize
+	for n < size {
+		n *= 2
+	}
+	return n
+}
+
+// isRehashing 返回是否正在 rehash
+func (dict *HashDict) isRehashing() bool {
+	return dict.rehashIdx != -1
+}
+
+// rehash 搬 n 个桶，返回是否还有桶需要搬（调用者需要持有锁）
+func (dict *HashDict) rehash(n int) bool {
+	if !dict.isRehashing() {
+		return false
+	}
+	emptyVisits := n * rehashEmptyVisit
+	for ; n > 0 && dict.ht[0].used > 0; n-- {
+		for dict.ht[0].buckets[dict.rehashIdx] == nil {
+			dict.rehashIdx++
+			emptyVisits--
+			if emptyVisits == 0 {
+				return true
+			}
+		}
+		mask := dict.ht[1].mask()
+		e := dict.ht[0].buckets[dict.rehashIdx]
+		for e != nil {
+			next := e.next
+			index := dict.hash(e.key) & mask
+			e.next = dict.ht[1].buckets[index]
+			dict.ht[1].buckets[index] = e
+			dict.ht[0].used--
+			dict.ht[1].used++
+			e = next
+		}
+		dict.ht[0].buckets[dict.rehashIdx] = nil
+		dict.rehashIdx++
+	}
+	if dict.ht[0].used == 0 { // 搬完了，ht[1] 变成 ht[0]
+		dict.ht[0] = dict.ht[1]
+		dict.ht[1] = hashTable{}
+		dict.rehashIdx = -1
+		dict.resizeIfNeeded() // rehash 期间键的个数可能又变了很多
+		return dict.isRehashing()
+	}
+	return true
+}
+
+// rehashStep 每次操作顺便搬一个桶，有安全迭代器时暂停（调用者需要持有锁）
+func (dict *HashDict) rehashStep() {
+	if dict.safeIterators == 0 {
+		dict.rehash(rehashStepSize)
+	}
+}
+
+// resize 开始把节点搬到一张有 buckets 个桶的新表（调用者需要持有锁）
+func (dict *HashDict) resize(buckets int) {
+	if dict.isRehashing() {
+		return
+	}
+	buckets = nextPower(buckets)
+	if buckets == len(dict.ht[0].buckets) {
+		return
+	}
+	dict.ht[1] = hashTable{buckets: make([]*hashEntry, buckets)}
+	dict.rehashIdx = 0
+}
+
+// resizeIfNeeded 键的个数超过桶的个数时扩容，少于 1/8 时缩容（调用者需要持有锁）
+func (dict *HashDict) resizeIfNeeded() {
+	if dict.isRehashing() {
+		return
+	}
+	size := len(dict.ht[0].buckets)
+	used := dict.ht[0].used
+	if used > size {
+		dict.resize(used * 2)
+	} else if size > hashDictInitSize && used < size/8 {
+		dict.resize(used)
+	}
+}
+
+// find 返回键对应的节点，不存在时返回 nil（调用者需要持有锁）
+func (dict *HashDict) find(key string) *hashEntry {
+	hash := dict.hash(key)
+	for table := 0; table <= 1; table++ {
+		ht := &dict.ht[table]
+		if len(ht.buckets) == 0 {
+			break
+		}
+		for e := ht.buckets[hash&ht.mask()]; e != nil; e = e.next {
+			if e.key == key {
+				return e
+			}
+		}
+		if !dict.isRehashing() {
+			break
+		}
+	}
+	return nil
+}
+
+// insert 插入一个新的节点，rehash 期间放进 ht[1]（调用者需要持有锁并确认键不存在）
+func (dict *HashDict) insert(key string, val interface{}) {
+	ht := &dict.ht[0]
+	if dict.isRehashing() {
+		ht = &dict.ht[1]
+	}
+	index := dict.hash(key) & ht.mask()
+	ht.buckets[index] = &hashEntry{
+		key:  key,
+		val:  val,
+		next: ht.buckets[index],
+	}
+	ht.used++
+	dict.resizeIfNeeded()
+}
+
+// Get 返回绑定值以及键是否存在
+func (dict *HashDict) Get(key string) (val interface{}, exists bool) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	dict.rehashStep()
+	e := dict.find(key)
+	if e == nil {
+		return nil, false
+	}
+	return e.val, true
+}
+
+// Len 返回字典的个数
+func (dict *HashDict) Len() int {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	return dict.ht[0].used + dict.ht[1].used
+}
+
+// Put 将键值放入字典并返回新插入的键值的个数
+func (dict *HashDict) Put(key string, val interface{}) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	dict.rehashStep()
+	if e := dict.find(key); e != nil {
+		e.val = val
+		return 0
+	}
+	dict.insert(key, val)
+	return 1
+}
+
+// PutIfAbsent 如果键不存在，则放值，并返回更新的键值的个数
+func (dict *HashDict) PutIfAbsent(key string, val interface{}) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	dict.rehashStep()
+	if dict.find(key) != nil {
+		return 0
+	}
+	dict.insert(key, val)
+	return 1
+}
+
+// PutIfExists 如果键存在则放值，并返回插入的键值的个数
+func (dict *HashDict) PutIfExists(key string, val interface{}) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	dict.rehashStep()
+	if e := dict.find(key); e != nil {
+		e.val = val
+		return 1
+	}
+	return 0
+}
+
+// Remove 删除键并返回已删除的键值的个数
+func (dict *HashDict) Remove(key string) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	dict.rehashStep()
+	hash := dict.hash(key)
+	for table := 0; table <= 1; table++ {
+		ht := &dict.ht[table]
+		if len(ht.buckets) == 0 {
+			break
+		}
+		index := hash & ht.mask()
+		var prev *hashEntry
+		for e := ht.buckets[index]; e != nil; e = e.next {
+			if e.key == key {
+				if prev == nil {
+					ht.buckets[index] = e.next
+				} else {
+					prev.next = e.next
+				}
+				ht.used--
+				dict.resizeIfNeeded()
+				return 1
+			}
+			prev = e
+		}
+		if !dict.isRehashing() {
+			break
+		}
+	}
+	return 0
+}
+
+// ForEach 遍历字典
+// 先在锁里拷贝出所有的键值，再在锁外调用 consumer，这样 consumer 里也可以修改字典
+func (dict *HashDict) ForEach(consumer Consumer) {
+	dict.mu.Lock()
+	entries := make([]hashEntry, 0, dict.ht[0].used+dict.ht[1].used)
+	for table := 0; table <= 1; table++ {
+		for _, e := range dict.ht[table].buckets {
+			for ; e != nil; e = e.next {
+				entries = append(entries, hashEntry{key: e.key, val: e.val})
+			}
+		}
+	}
+	dict.mu.Unlock()
+	for _, e := range entries {
+		if !consumer(e.key, e.val) {
+			break
+		}
+	}
+}
+
+// Keys 返回字典中的所有键
+func (dict *HashDict) Keys() []string {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	result := make([]string, 0, dict.ht[0].used+dict.ht[1].used)
+	for table := 0; table <= 1; table++ {
+		for _, e := range dict.ht[table].buckets {
+			for ; e != nil; e = e.next {
+				result = append(result, e.key)
+			}
+		}
+	}
+	return result
+}
+
+// bucketAt 把两张表的桶看成一个数组，返回第 i 个桶
+func (dict *HashDict) bucketAt(i int) *hashEntry {
+	if i < len(dict.ht[0].buckets) {
+		return dict.ht[0].buckets[i]
+	}
+	return dict.ht[1].buckets[i-len(dict.ht[0].buckets)]
+}
+
+// randomEntry 随机挑一个非空的桶，再在桶里随机挑一个节点（调用者需要持有锁并确认字典不为空）
+// rehash 期间 ht[0] 中 rehashIdx 之前的桶都是空的，直接跳过
+func (dict *HashDict) randomEntry() *hashEntry {
+	var head *hashEntry
+	if dict.isRehashing() {
+		total := len(dict.ht[0].buckets) + len(dict.ht[1].buckets)
+		for head == nil {
+			head = dict.bucketAt(dict.rehashIdx + rand.Intn(total-dict.rehashIdx))
+		}
+	} else {
+		for head == nil {
+			head = dict.ht[0].buckets[rand.Intn(len(dict.ht[0].buckets))]
+		}
+	}
+	length := 0
+	for e := head; e != nil; e = e.next {
+		length++
+	}
+	e := head
+	for i := rand.Intn(length); i > 0; i-- {
+		e = e.next
+	}
+	return e
+}
+
+// RandomKeys 随机返回给定数字的键，可能包含重复的键
+func (dict *HashDict) RandomKeys(limit int) []string {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	if dict.ht[0].used+dict.ht[1].used == 0 || limit <= 0 {
+		return []string{}
+	}
+	dict.rehashStep()
+	result := make([]string, limit)
+	for i := range result {
+		result[i] = dict.randomEntry().key
+	}
+	return result
+}
+
+// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键
+// 从一个随机的桶开始依次往后取，和 Redis 的 dictGetSomeKeys 一样只保证近似随机
+func (dict *HashDict) RandomDistinctKeys(limit int) []string {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	if size := dict.ht[0].used + dict.ht[1].used; limit > size {
+		limit = size
+	}
+	if limit <= 0 {
+		return []string{}
+	}
+	dict.rehashStep()
+	result := make([]string, 0, limit)
+	total := len(dict.ht[0].buckets) + len(dict.ht[1].buckets)
+	start := rand.Intn(total)
+	for i := 0; i < total && len(result) < limit; i++ {
+		for e := dict.bucketAt((start + i) % total); e != nil && len(result) < limit; e = e.next {
+			result = append(result, e.key)
+		}
+	}
+	return result
+}
+
+// Clear 清空字典
+func (dict *HashDict) Clear() {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	dict.ht[0] = hashTable{buckets: make([]*hashEntry, hashDictInitSize)}
+	dict.ht[1] = hashTable{}
+	dict.rehashIdx = -1
+}
+
+// Scan 从 cursor 开始遍历，返回下一次调用使用的游标，返回 0 表示遍历结束
+// 每次至少遍历一个桶，直到拿到 count 个键或者遍历了 count*10 个桶为止
+// 游标按反向二进制递增（高位先加一），因为桶的个数总是 2 的幂，扩容或缩容之后已经遍历过的桶依然不需要重新遍历：
+// 从遍历开始到结束一直存在的键至少会被返回一次，缩容时可能会重复返回
+// rehash 期间先遍历小表中游标对应的桶，再遍历大表中所有由它展开的桶
+func (dict *HashDict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	if count <= 0 {
+		count = 10
+	}
+	dict.mu.Lock()
+	entries := make([]hashEntry, 0, count)
+	collect := func(e *hashEntry) {
+		for ; e != nil; e = e.next {
+			entries = append(entries, hashEntry{key: e.key, val: e.val})
+		}
+	}
+	for visited := 0; visited < count*10; visited++ {
+		if !dict.isRehashing() {
+			mask := dict.ht[0].mask()
+			collect(dict.ht[0].buckets[cursor&mask])
+			cursor = nextCursor(cursor, mask)
+		} else {
+			small, large := &dict.ht[0], &dict.ht[1]
+			if len(small.buckets) > len(large.buckets) {
+				small, large = large, small
+			}
+			smallMask, largeMask := small.mask(), large.mask()
+			collect(small.buckets[cursor&smallMask])
+			for { // 大表中低位和小表桶下标相同的桶
+				collect(large.buckets[cursor&largeMask])
+				cursor = nextCursor(cursor, largeMask)
+				if cursor&(smallMask^largeMask) == 0 {
+					break
+				}
+			}
+		}
+		if cursor == 0 || len(entries) >= count {
+			break
+		}
+	}
+	dict.mu.Unlock()
+	for _, e := range entries {
+		if !consumer(e.key, e.val) {
+			break
+		}
+	}
+	return cursor
+}
+
+// nextCursor 把游标中 mask 覆盖的部分按反向二进制加一
+func nextCursor(cursor uint64, mask uint64) uint64 {
+	cursor |= ^mask // 把高于 mask 的位全部置 1，反转后加一时进位能越过它们
+	cursor = reverseBits(cursor)
+	cursor++
+	return reverseBits(cursor)
+}
+
+// reverseBits 反转 64 位整数的二进制位
+func reverseBits(v uint64) uint64 {
+	v = (v>>1)&0x5555555555555555 | (v&0x5555555555555555)<<1
+	v = (v>>2)&0x3333333333333333 | (v&0x3333333333333333)<<2
+	v = (v>>4)&0x0F0F0F0F0F0F0F0F | (v&0x0F0F0F0F0F0F0F0F)<<4
+	v = (v>>8)&0x00FF00FF00FF00FF | (v&0x00FF00FF00FF00FF)<<8
+	v = (v>>16)&0x0000FFFF0000FFFF | (v&0x0000FFFF0000FFFF)<<16
+	return v>>32 | v<<32
+}
+
+/* ---- 迭代器 ----- */
+
+// Iterator 逐个返回字典中的节点
+// 安全迭代器在使用期间暂停 rehash，迭代过程中可以增删键（新插入的键不一定会被返回）
+// 非安全迭代器不暂停 rehash，迭代期间只能调用 Next，Release 时通过指纹检查字典是否被修改过，被修改过会 panic
+type Iterator struct {
+	dict        *HashDict
+	safe        bool
+	started     bool
+	table       int
+	index       int
+	entry       *hashEntry
+	nextEntry   *hashEntry // 提前记下下一个节点，这样调用者删除当前节点也不影响迭代
+	fingerprint uint64
+}
+
+// Iterator 返回一个非安全迭代器
+func (dict *HashDict) Iterator() *Iterator {
+	return &Iterator{dict: dict, index: -1}
+}
+
+// SafeIterator 返回一个安全迭代器
+func (dict *HashDict) SafeIterator() *Iterator {
+	return &Iterator{dict: dict, index: -1, safe: true}
+}
+
+// fingerprint 根据两张表的大小、节点个数和 rehash 进度计算指纹（调用者需要持有锁）
+// 迭代期间字典结构发生变化（插入、删除、rehash）时指纹就会改变
+func (dict *HashDict) fingerprint() uint64 {
+	integers := []uint64{
+		uint64(len(dict.ht[0].buckets)), uint64(dict.ht[0].used),
+		uint64(len(dict.ht[1].buckets)), uint64(dict.ht[1].used),
+		uint64(dict.rehashIdx),
+	}
+	var hash uint64
+	for _, n := range integers { // Tomas Wang 的 64 位整数哈希
+		hash += n
+		hash = (^hash) + (hash << 21)
+		hash = hash ^ (hash >> 24)
+		hash = (hash + (hash << 3)) + (hash << 8)
+		hash = hash ^ (hash >> 14)
+		hash = (hash + (hash << 2)) + (hash << 4)
+		hash = hash ^ (hash >> 28)
+		hash = hash + (hash << 31)
+	}
+	return hash
+}
+
+// Next 返回下一个键值，迭代结束时返回 false
+func (it *Iterator) Next() (key string, val interface{}, ok bool) {
+	dict := it.dict
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	if !it.started {
+		it.started = true
+		if it.safe {
+			dict.safeIterators++
+		} else {
+			it.fingerprint = dict.fingerprint()
+		}
+	}
+	for {
+		if it.entry == nil {
+			it.entry = it.nextBucket()
+			if it.entry == nil && it.table > 1 {
+				return "", nil, false
+			}
+		} else {
+			it.entry = it.nextEntry
+		}
+		if it.entry != nil {
+			it.nextEntry = it.entry.next
+			return it.entry.key, it.entry.val, true
+		}
+	}
+}
+
+// nextBucket 移动到下一个桶并返回桶里的第一个节点，遍历完两张表后 table 大于 1（调用者需要持有锁）
+func (it *Iterator) nextBucket() *hashEntry {
+	dict := it.dict
+	it.index++
+	for it.table <= 1 && it.index >= len(dict.ht[it.table].buckets) {
+		if it.table == 0 && dict.isRehashing() {
+			it.table = 1
+			it.index = 0
+			continue
+		}
+		it.table = 2
+	}
+	if it.table > 1 {
+		return nil
+	}
+	return dict.ht[it.table].buckets[it.index]
+}
+
+// Release 结束迭代：安全迭代器恢复 rehash，非安全迭代器检查字典没有被修改过
+func (it *Iterator) Release() {
+	if !it.started {
+		return
+	}
+	dict := it.dict
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	it.started = false
+	if it.safe {
+		dict.safeIterators--
+		return
+	}
+	if it.fingerprint != dict.fingerprint() {
+		panic("dict: modified during unsafe iteration")
+	}
+}
@@ -0,0 +1,156 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashDictBasic(t *testing.T) {
+	d := MakeHashDict()
+	for i := 0; i < 1000; i++ {
+		if d.Put("k"+strconv.Itoa(i), i) != 1 {
+			t.Fatal("expected new key")
+		}
+	}
+	if d.Len() != 1000 {
+		t.Errorf("expected 1000 keys, got %d", d.Len())
+	}
+	if d.PutIfAbsent("k1", 0) != 0 || d.PutIfExists("k1", -1) != 1 {
+		t.Error("conditional put failed")
+	}
+	if val, ok := d.Get("k1"); !ok || val != -1 {
+		t.Errorf("unexpected value %v", val)
+	}
+	for i := 0; i < 1000; i++ {
+		if d.Remove("k"+strconv.Itoa(i)) != 1 {
+			t.Fatal("expected removed key")
+		}
+	}
+	for i := 0; i < 100 && d.isRehashing(); i++ { // 缩容是渐进式的，后续的操作会继续搬桶
+		d.Get("k0")
+	}
+	if d.Len() != 0 || d.isRehashing() || len(d.ht[0].buckets) != hashDictInitSize {
+		t.Errorf("expected empty dict to shrink, size %d buckets %d", d.Len(), len(d.ht[0].buckets))
+	}
+}
+
+// TestHashDictScanDuringResize 遍历过程中字典不停扩容缩容，一直存在的键都要被返回
+func TestHashDictScanDuringResize(t *testing.T) {
+	d := MakeHashDict()
+	for i := 0; i < 500; i++ {
+		d.Put("stable"+strconv.Itoa(i), i)
+	}
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	round := 0
+	for {
+		cursor = d.Scan(cursor, 10, func(key string, val interface{}) bool {
+			seen[key] = true
+			return true
+		})
+		// 前半段大量插入触发扩容，后半段全部删掉触发缩容
+		if round < 20 {
+			for i := 0; i < 200; i++ {
+				d.Put("tmp"+strconv.Itoa(round)+"-"+strconv.Itoa(i), i)
+			}
+		} else if round == 20 {
+			for _, key := range d.Keys() {
+				if key[0] == 't' {
+					d.Remove(key)
+				}
+			}
+		}
+		round++
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 500; i++ {
+		if !seen["stable"+strconv.Itoa(i)] {
+			t.Fatalf("key stable%d was not returned by scan", i)
+		}
+	}
+}
+
+// TestHashDictIncrementalRehash 扩容时节点分几次搬到新表，rehash 期间两张表里的键都能读到
+func TestHashDictIncrementalRehash(t *testing.T) {
+	d := MakeHashDict()
+	rehashSeen := false
+	for i := 0; i < 1000; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+		if d.isRehashing() {
+			rehashSeen = true
+			if d.ht[0].used == 0 || d.ht[1].used == 0 {
+				continue
+			}
+			for j := 0; j <= i; j++ {
+				if val, ok := d.Get("k" + strconv.Itoa(j)); !ok || val != j {
+					t.Fatalf("key k%d lost during rehash", j)
+				}
+			}
+		}
+	}
+	if !rehashSeen {
+		t.Error("expected the dict to rehash incrementally")
+	}
+	if d.Len() != 1000 {
+		t.Errorf("expected 1000 keys, got %d", d.Len())
+	}
+}
+
+// TestHashDictSafeIterator 安全迭代器暂停 rehash，迭代时删除当前的键也能遍历到所有的键
+func TestHashDictSafeIterator(t *testing.T) {
+	d := MakeHashDict()
+	for i := 0; i < 100; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	for !d.isRehashing() { // 插入到刚好开始 rehash
+		d.Put("extra"+strconv.Itoa(d.Len()), 0)
+	}
+	total := d.Len()
+	rehashIdx := d.rehashIdx
+	it := d.SafeIterator()
+	visited := 0
+	for {
+		key, _, ok := it.Next()
+		if !ok {
+			break
+		}
+		visited++
+		d.Remove(key)
+		if d.rehashIdx != rehashIdx {
+			t.Fatal("rehash should be paused while a safe iterator is in use")
+		}
+	}
+	it.Release()
+	if visited != total || d.Len() != 0 {
+		t.Errorf("expected to visit %d keys, visited %d, %d left", total, visited, d.Len())
+	}
+}
+
+// TestHashDictUnsafeIterator 非安全迭代器期间修改字典，Release 时会 panic
+func TestHashDictUnsafeIterator(t *testing.T) {
+	d := MakeHashDict()
+	for i := 0; i < 100; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	it := d.Iterator()
+	visited := 0
+	for _, _, ok := it.Next(); ok; _, _, ok = it.Next() {
+		visited++
+	}
+	it.Release() // 没有修改，不会 panic
+	if visited != 100 {
+		t.Errorf("expected to visit 100 keys, visited %d", visited)
+	}
+
+	it = d.Iterator()
+	it.Next()
+	d.Put("new", 0)
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic when the dict is modified during unsafe iteration")
+		}
+	}()
+	it.Release()
+}
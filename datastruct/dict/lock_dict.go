@@ -5,6 +5,8 @@ package dict
  */
 
 import (
+	"math/rand"
+	"sort"
 	"sync"
 )
 
@@ -96,30 +98,43 @@ func (dict *LockDick) ForEach(consumer Consumer) {
 
 // RandomKeys 随机返回给定数字的键，可能包含重复的键
 func (dict *LockDick) RandomKeys(limit int) []string {
-	result := make([]string, limit)
-	for i := 0; i < limit; i++ {
-		for k := range dict.m {
-			result[i] = k
+	size := len(dict.m)
+	if size == 0 || limit <= 0 {
+		return []string{}
+	}
+	positions := make([]int, limit)
+	for i := range positions {
+		positions[i] = rand.Intn(size)
+	}
+	sort.Ints(positions)
+	result := make([]string, 0, limit)
+	pos := 0
+	for k := range dict.m {
+		for len(result) < limit && positions[len(result)] == pos {
+			result = append(result, k)
+		}
+		if len(result) == limit {
 			break
 		}
+		pos++
 	}
 	return result
 }
 
-// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键
+// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键（蓄水池抽样）
 func (dict *LockDick) RandomDistinctKeys(limit int) []string {
-	size := limit
-	if size > len(dict.m) {
-		size = len(dict.m)
+	if limit <= 0 {
+		return []string{}
 	}
-	result := make([]string, size)
-	i := 0
+	result := make([]string, 0, limit)
+	seen := 0
 	for k := range dict.m {
-		if i == limit {
-			break
+		seen++
+		if len(result) < limit {
+			result = append(result, k)
+		} else if j := rand.Intn(seen); j < limit {
+			result[j] = k
 		}
-		result[i] = k
-		i++
 	}
 	return result
 }
@@ -0,0 +1,208 @@
+package dict
+
+/*
+ * 使用 LSM-tree 引擎作为数据库底层存储，数据保存在磁盘上，重启后直接打开
+ * 值通过 Codec 编码成字节数组再写入引擎，Get 每次都会解码出一个新的值，所以值必须是不可变的（修改以后要重新 Put）
+ * 同一个键的读-改-写由调用者串行（见 structure 中的 keyLocks），否则并发的修改会互相覆盖
+ */
+
+import (
+	"GoMiniCache/datastruct/lsm"
+	"GoMiniCache/lib/logger"
+	"sync/atomic"
+)
+
+// maxSampleRounds RandomDistinctKeys 最多取样的轮数
+const maxSampleRounds = 4
+
+// Codec 值和字节数组之间的转换
+type Codec interface {
+	Encode(val interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// LSMDict 使用 LSM-tree 引擎作为底层存储
+// Dict 的接口没有返回错误，磁盘读写失败时记录日志并当作键不存在或没有写入；
+// 写入失败的错误还会记下来，通过 Err 交给执行命令的一方，不能再回复写入成功
+type LSMDict struct {
+	db       *lsm.DB
+	codec    Codec
+	writeErr atomic.Pointer[error] // 第一次写入失败的错误
+}
+
+// OpenLSMDict 打开目录中的引擎
+func OpenLSMDict(dir string, opts lsm.Options, codec Codec) (*LSMDict, error) {
+	db, err := lsm.Open(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &LSMDict{db: db, codec: codec}, nil
+}
+
+// Engine 返回底层的引擎
+func (dict *LSMDict) Engine() *lsm.DB {
+	return dict.db
+}
+
+// Err 返回第一次写入失败的错误，没有失败过时返回 nil
+func (dict *LSMDict) Err() error {
+	if err := dict.writeErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// fail 记录写入失败的错误，只保留第一个
+func (dict *LSMDict) fail(op string, key string, err error) {
+	logger.Error("lsm " + op + " " + key + ": " + err.Error())
+	dict.writeErr.CompareAndSwap(nil, &err)
+}
+
+// Close 关闭引擎
+func (dict *LSMDict) Close() error {
+	return dict.db.Close()
+}
+
+// Get 返回绑定值以及键是否存在
+func (dict *LSMDict) Get(key string) (val interface{}, exists bool) {
+	data, ok, err := dict.db.Get(key)
+	if err != nil {
+		logger.Error("lsm get " + key + ": " + err.Error())
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	if val, err = dict.codec.Decode(data); err != nil {
+		logger.Error("lsm decode " + key + ": " + err.Error())
+		return nil, false
+	}
+	return val, true
+}
+
+// Len 返回字典的个数
+func (dict *LSMDict) Len() int {
+	return dict.db.Len()
+}
+
+// put 编码以后按条件写入
+func (dict *LSMDict) put(key string, val interface{}, write func(string, []byte) (bool, error)) int {
+	data, err := dict.codec.Encode(val)
+	if err != nil {
+		dict.fail("encode", key, err)
+		return 0
+	}
+	ok, err := write(key, data)
+	if err != nil {
+		dict.fail("put", key, err)
+	}
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// Put 将键值放入字典并返回新插入的键值的个数
+func (dict *LSMDict) Put(key string, val interface{}) (result int) {
+	return dict.put(key, val, dict.db.Put)
+}
+
+// PutIfAbsent 如果键不存在，则放值，并返回更新的键值的个数
+func (dict *LSMDict) PutIfAbsent(key string, val interface{}) (result int) {
+	return dict.put(key, val, dict.db.PutIfAbsent)
+}
+
+// PutIfExists 如果键存在则放值，并返回插入的键值的个数
+func (dict *LSMDict) PutIfExists(key string, val interface{}) (result int) {
+	return dict.put(key, val, dict.db.PutIfExists)
+}
+
+// Remove 删除键并返回已删除的键值的个数
+func (dict *LSMDict) Remove(key string) (result int) {
+	ok, err := dict.db.Delete(key)
+	if err != nil {
+		dict.fail("delete", key, err)
+	}
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// ForEach 按键的顺序遍历字典，consumer 返回 false 时停止
+func (dict *LSMDict) ForEach(consumer Consumer) {
+	err := dict.db.ForEach(func(key string, data []byte) bool {
+		val, err := dict.codec.Decode(data)
+		if err != nil {
+			logger.Error("lsm decode " + key + ": " + err.Error())
+			return true
+		}
+		return consumer(key, val)
+	})
+	if err != nil {
+		logger.Error("lsm scan: " + err.Error())
+	}
+}
+
+// forEachKey 只遍历键，不解码值
+func (dict *LSMDict) forEachKey(consumer func(key string) bool) {
+	err := dict.db.ForEach(func(key string, data []byte) bool {
+		return consumer(key)
+	})
+	if err != nil {
+		logger.Error("lsm scan: " + err.Error())
+	}
+}
+
+// Keys 返回字典中的所有键
+func (dict *LSMDict) Keys() []string {
+	result := make([]string, 0, dict.Len())
+	dict.forEachKey(func(key string) bool {
+		result = append(result, key)
+		return true
+	})
+	return result
+}
+
+// RandomKeys 随机返回给定数字的键，可能包含重复的键
+// 引擎不能随机访问，由引擎按数据块取样，不遍历整个字典
+func (dict *LSMDict) RandomKeys(limit int) []string {
+	if limit <= 0 {
+		return []string{}
+	}
+	keys, err := dict.db.SampleKeys(limit)
+	if err != nil {
+		logger.Error("lsm sample: " + err.Error())
+		return []string{}
+	}
+	return keys
+}
+
+// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键
+// 键不多时直接全部返回；否则多取样几轮去重，最后可能不到 limit 个
+func (dict *LSMDict) RandomDistinctKeys(limit int) []string {
+	if limit <= 0 {
+		return []string{}
+	}
+	if dict.Len() <= limit {
+		return dict.Keys()
+	}
+	result := make([]string, 0, limit)
+	seen := make(map[string]struct{}, limit)
+	for round := 0; round < maxSampleRounds && len(result) < limit; round++ {
+		for _, key := range dict.RandomKeys(limit - len(result)) {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				result = append(result, key)
+			}
+		}
+	}
+	return result
+}
+
+// Clear 清空字典，磁盘上的文件也会删除
+func (dict *LSMDict) Clear() {
+	if err := dict.db.Clear(); err != nil {
+		dict.fail("clear", "", err)
+	}
+}
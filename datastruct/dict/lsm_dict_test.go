@@ -0,0 +1,64 @@
+package dict
+
+import (
+	"GoMiniCache/datastruct/lsm"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// stringCodec 测试用的编码，值都是 string
+type stringCodec struct{}
+
+func (stringCodec) Encode(val interface{}) ([]byte, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, errors.New("not a string")
+	}
+	return []byte(s), nil
+}
+
+func (stringCodec) Decode(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+func TestLSMDictReopen(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenLSMDict(dir, lsm.Options{}, stringCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if d.Put("k"+strconv.Itoa(i), strconv.Itoa(i)) != 1 {
+			t.Fatal("expected new key")
+		}
+	}
+	if d.Put("bad", 1) != 0 { // 编码失败的值不写入
+		t.Error("unencodable value should not be written")
+	}
+	if d.PutIfAbsent("k1", "x") != 0 || d.PutIfExists("k1", "one") != 1 || d.Remove("k2") != 1 {
+		t.Error("conditional put or remove failed")
+	}
+	if keys := d.RandomDistinctKeys(200); len(keys) != 99 {
+		t.Errorf("expected 99 distinct keys, got %d", len(keys))
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = OpenLSMDict(dir, lsm.Options{}, stringCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if val, ok := d.Get("k1"); !ok || val != "one" {
+		t.Errorf("unexpected value %v", val)
+	}
+	if _, ok := d.Get("k2"); ok || d.Len() != 99 || len(d.Keys()) != 99 {
+		t.Errorf("unexpected len %d", d.Len())
+	}
+	d.Clear()
+	if d.Len() != 0 || len(d.RandomKeys(3)) != 0 {
+		t.Error("clear failed")
+	}
+}
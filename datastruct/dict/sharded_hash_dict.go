@@ -0,0 +1,185 @@
+package dict
+
+/*
+ * 分段的哈希表：键按带随机种子的哈希值分到 2 的幂个 HashDict 里，每个分段有自己的锁，不同分段上的操作互不阻塞
+ * SCAN 的游标低位是分段的下标，高位是分段内 HashDict 的游标；一个分段遍历完再遍历下一个，
+ * 键始终在同一个分段里，所以 HashDict 的保证依然成立：从遍历开始到结束一直存在的键至少会被返回一次
+ */
+
+import (
+	"hash/maphash"
+	"math/rand"
+)
+
+const defaultHashShards = 16 // 没有指定分段个数时使用的值
+
+// ShardedHashDict 分段加锁的渐进式 rehash 哈希表
+type ShardedHashDict struct {
+	shards    []*HashDict
+	shardBits int // 分段个数是 1 << shardBits
+	seed      maphash.Seed
+}
+
+// MakeShardedHashDict 创建，shardCount 会被向上取到 2 的幂，小于等于 0 时使用默认值
+func MakeShardedHashDict(shardCount int) *ShardedHashDict {
+	if shardCount <= 0 {
+		shardCount = defaultHashShards
+	}
+	bits := 0
+	for 1<<bits < shardCount {
+		bits++
+	}
+	shards := make([]*HashDict, 1<<bits)
+	for i := range shards {
+		shards[i] = MakeHashDict() // 每个分段有自己的种子，和选择分段用的种子无关，分段里的键不会挤在一部分桶里
+	}
+	return &ShardedHashDict{
+		shards:    shards,
+		shardBits: bits,
+		seed:      maphash.MakeSeed(),
+	}
+}
+
+// mask 返回分段下标的掩码
+func (dict *ShardedHashDict) mask() uint64 {
+	return uint64(len(dict.shards) - 1)
+}
+
+// getShard 返回键所在的分段
+func (dict *ShardedHashDict) getShard(key string) *HashDict {
+	return dict.shards[maphash.String(dict.seed, key)&dict.mask()]
+}
+
+// Get 返回绑定值以及键是否存在
+func (dict *ShardedHashDict) Get(key string) (val interface{}, exists bool) {
+	return dict.getShard(key).Get(key)
+}
+
+// Len 返回字典的个数
+func (dict *ShardedHashDict) Len() int {
+	length := 0
+	for _, shard := range dict.shards {
+		length += shard.Len()
+	}
+	return length
+}
+
+// Put 将键值放入字典并返回新插入的键值的个数
+func (dict *ShardedHashDict) Put(key string, val interface{}) (result int) {
+	return dict.getShard(key).Put(key, val)
+}
+
+// PutIfAbsent 如果键不存在，则放值，并返回更新的键值的个数
+func (dict *ShardedHashDict) PutIfAbsent(key string, val interface{}) (result int) {
+	return dict.getShard(key).PutIfAbsent(key, val)
+}
+
+// PutIfExists 如果键存在则放值，并返回插入的键值的个数
+func (dict *ShardedHashDict) PutIfExists(key string, val interface{}) (result int) {
+	return dict.getShard(key).PutIfExists(key, val)
+}
+
+// Remove 删除键并返回已删除的键值的个数
+func (dict *ShardedHashDict) Remove(key string) (result int) {
+	return dict.getShard(key).Remove(key)
+}
+
+// ForEach 遍历字典，consumer 返回 false 时停止
+func (dict *ShardedHashDict) ForEach(consumer Consumer) {
+	stopped := false
+	for _, shard := range dict.shards {
+		shard.ForEach(func(key string, val interface{}) bool {
+			stopped = !consumer(key, val)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// Keys 返回字典中的所有键
+func (dict *ShardedHashDict) Keys() []string {
+	result := make([]string, 0, dict.Len())
+	for _, shard := range dict.shards {
+		result = append(result, shard.Keys()...)
+	}
+	return result
+}
+
+// RandomKeys 随机返回给定数字的键，可能包含重复的键
+// 每次随机挑一个分段，空的分段往后找；键按哈希值均匀地分到各个分段，偏差可以忽略
+func (dict *ShardedHashDict) RandomKeys(limit int) []string {
+	result := make([]string, 0, max(limit, 0))
+	for len(result) < limit {
+		key, ok := dict.randomKey()
+		if !ok {
+			break
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+// randomKey 从一个随机的非空分段里随机返回一个键，字典为空时返回 false
+func (dict *ShardedHashDict) randomKey() (string, bool) {
+	start := rand.Intn(len(dict.shards))
+	for i := range dict.shards {
+		keys := dict.shards[(start+i)&int(dict.mask())].RandomKeys(1)
+		if len(keys) > 0 {
+			return keys[0], true
+		}
+	}
+	return "", false
+}
+
+// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键
+// 从一个随机的分段开始依次往后取，和 HashDict 一样只保证近似随机
+func (dict *ShardedHashDict) RandomDistinctKeys(limit int) []string {
+	result := make([]string, 0, min(max(limit, 0), dict.Len()))
+	start := rand.Intn(len(dict.shards))
+	for i := 0; i < len(dict.shards) && len(result) < limit; i++ {
+		shard := dict.shards[(start+i)&int(dict.mask())]
+		result = append(result, shard.RandomDistinctKeys(limit-len(result))...) // 分段之间没有相同的键
+	}
+	return result
+}
+
+// Clear 清空字典
+func (dict *ShardedHashDict) Clear() {
+	for _, shard := range dict.shards {
+		shard.Clear()
+	}
+}
+
+// Scan 从 cursor 开始遍历，返回下一次调用使用的游标，返回 0 表示遍历结束
+// 在当前分段中继续遍历，分段遍历完以后从下一个分段的游标 0 开始，直到拿到 count 个键或者访问了 count*10 个分段为止
+func (dict *ShardedHashDict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	if count <= 0 {
+		count = 10
+	}
+	index := cursor & dict.mask()
+	inner := cursor >> dict.shardBits
+	found := 0
+	stopped := false
+	for visited := 0; visited < count*10; visited++ {
+		inner = dict.shards[index].Scan(inner, count-found, func(key string, val interface{}) bool {
+			if stopped {
+				return false
+			}
+			found++
+			stopped = !consumer(key, val)
+			return !stopped
+		})
+		if inner == 0 { // 这个分段遍历完了
+			index++
+			if index > dict.mask() {
+				return 0
+			}
+		}
+		if stopped || found >= count {
+			break
+		}
+	}
+	return inner<<dict.shardBits | index
+}
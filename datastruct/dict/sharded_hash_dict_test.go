@@ -0,0 +1,111 @@
+package dict
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestShardedHashDictBasic(t *testing.T) {
+	d := MakeShardedHashDict(3)
+	if len(d.shards) != 4 {
+		t.Fatalf("expected 4 shards, got %d", len(d.shards))
+	}
+	for i := 0; i < 1000; i++ {
+		if d.Put("k"+strconv.Itoa(i), i) != 1 {
+			t.Fatal("expected new key")
+		}
+	}
+	if d.Len() != 1000 || len(d.Keys()) != 1000 {
+		t.Errorf("expected 1000 keys, got %d", d.Len())
+	}
+	if d.PutIfAbsent("k1", 0) != 0 || d.PutIfExists("k1", -1) != 1 {
+		t.Error("conditional put failed")
+	}
+	if val, ok := d.Get("k1"); !ok || val != -1 {
+		t.Errorf("unexpected value %v", val)
+	}
+	for _, shard := range d.shards { // 键分到了所有的分段里
+		if shard.Len() == 0 {
+			t.Error("expected keys in every shard")
+		}
+	}
+	if keys := d.RandomDistinctKeys(2000); len(keys) != 1000 {
+		t.Errorf("expected 1000 distinct keys, got %d", len(keys))
+	}
+	if keys := d.RandomKeys(5); len(keys) != 5 {
+		t.Errorf("expected 5 random keys, got %d", len(keys))
+	}
+	count := 0
+	d.ForEach(func(key string, val interface{}) bool {
+		count++
+		return count < 10
+	})
+	if count != 10 {
+		t.Errorf("ForEach should stop when consumer returns false, visited %d", count)
+	}
+	d.Clear()
+	if d.Len() != 0 || len(d.RandomKeys(1)) != 0 {
+		t.Errorf("expected empty dict after clear, got %d", d.Len())
+	}
+}
+
+// TestShardedHashDictScan 遍历过程中不停增删键，一直存在的键都要被返回，游标最终回到 0
+func TestShardedHashDictScan(t *testing.T) {
+	d := MakeShardedHashDict(8)
+	for i := 0; i < 500; i++ {
+		d.Put("stable"+strconv.Itoa(i), i)
+	}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() { // 并发地触发各个分段扩容、缩容
+		defer wg.Done()
+		for round := 0; ; round++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := "tmp" + strconv.Itoa(round%2000)
+			if round%4000 < 2000 {
+				d.Put(key, round)
+			} else {
+				d.Remove(key)
+			}
+		}
+	}()
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for calls := 0; ; calls++ {
+		cursor = d.Scan(cursor, 10, func(key string, val interface{}) bool {
+			seen[key] = true
+			return true
+		})
+		if cursor == 0 {
+			break
+		}
+		if calls > 100000 {
+			t.Fatal("scan did not finish")
+		}
+	}
+	close(stop)
+	wg.Wait()
+	for i := 0; i < 500; i++ {
+		if !seen["stable"+strconv.Itoa(i)] {
+			t.Fatalf("key stable%d was not returned by scan", i)
+		}
+	}
+}
+
+// TestShardedHashDictScanEmpty 空字典一次调用就遍历完
+func TestShardedHashDictScanEmpty(t *testing.T) {
+	d := MakeShardedHashDict(16)
+	called := false
+	if cursor := d.Scan(0, 10, func(key string, val interface{}) bool {
+		called = true
+		return true
+	}); cursor != 0 || called {
+		t.Errorf("expected an empty scan to finish at once, got cursor %d", cursor)
+	}
+}
@@ -5,6 +5,8 @@ package dict
  */
 
 import (
+	"math/rand"
+	"sort"
 	"sync"
 )
 
@@ -86,36 +88,50 @@ func (dict *SyncDict) Keys() []string {
 	return result
 }
 
-// ForEach 遍历字典
+// ForEach 遍历字典，consumer 返回 false 时停止
 func (dict *SyncDict) ForEach(consumer Consumer) {
 	dict.m.Range(func(key, value interface{}) bool {
-		consumer(key.(string), value)
-		return true
+		return consumer(key.(string), value)
 	})
 }
 
 // RandomKeys 随机返回给定数字的键，可能包含重复的键
+// 先随机选好位置，再遍历一次把这些位置上的键取出来
 func (dict *SyncDict) RandomKeys(limit int) []string {
-	result := make([]string, limit)
-	for i := 0; i < limit; i++ {
-		dict.m.Range(func(key, value interface{}) bool {
-			result[i] = key.(string)
-			return false
-		})
+	size := dict.Len()
+	if size == 0 || limit <= 0 {
+		return []string{}
+	}
+	positions := make([]int, limit)
+	for i := range positions {
+		positions[i] = rand.Intn(size)
 	}
+	sort.Ints(positions)
+	result := make([]string, 0, limit)
+	pos := 0
+	dict.m.Range(func(key, value interface{}) bool {
+		for len(result) < limit && positions[len(result)] == pos {
+			result = append(result, key.(string))
+		}
+		pos++
+		return len(result) < limit
+	})
 	return result
-
 }
 
-// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键
+// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键（蓄水池抽样）
 func (dict *SyncDict) RandomDistinctKeys(limit int) []string {
-	result := make([]string, limit)
-	i := 0
+	if limit <= 0 {
+		return []string{}
+	}
+	result := make([]string, 0, limit)
+	seen := 0
 	dict.m.Range(func(key, value interface{}) bool {
-		result[i] = key.(string)
-		i++
-		if i == limit {
-			return false
+		seen++
+		if len(result) < limit {
+			result = append(result, key.(string))
+		} else if j := rand.Intn(seen); j < limit {
+			result[j] = key.(string)
 		}
 		return true
 	})
@@ -124,5 +140,5 @@ func (dict *SyncDict) RandomDistinctKeys(limit int) []string {
 
 // Clear 删除字典中的所有键
 func (dict *SyncDict) Clear() {
-	*dict = *MakeSyncDict()
+	dict.m.Clear() // 不能整个替换 sync.Map，其他协程可能正在读取
 }
@@ -0,0 +1,107 @@
+package dict
+
+/*
+ * 把 Dict 包装成带类型的 gdict.Dict[string, V]，让 SyncDict、HashDict 等实现也能给泛型的调用者使用
+ * 值的类型断言集中在这里，调用者不需要再做
+ */
+
+import (
+	"GoMiniCache/datastruct/gdict"
+)
+
+// TypedDict 带类型的 Dict 包装
+type TypedDict[V any] struct {
+	d Dict
+}
+
+// Typed 包装一个 Dict，字典中的值必须都是 V 类型
+// d 支持游标遍历时，返回的字典也实现 gdict.Scanner，否则调用者可以据此拒绝 SCAN
+func Typed[V any](d Dict) gdict.Dict[string, V] {
+	typed := &TypedDict[V]{d: d}
+	if scanner, ok := d.(Scanner); ok {
+		return &typedScanDict[V]{TypedDict: typed, scanner: scanner}
+	}
+	return typed
+}
+
+// Unwrap 返回被包装的 Dict
+func (dict *TypedDict[V]) Unwrap() Dict {
+	return dict.d
+}
+
+// Get 返回绑定值以及键是否存在
+func (dict *TypedDict[V]) Get(key string) (val V, exists bool) {
+	raw, exists := dict.d.Get(key)
+	if !exists {
+		return val, false
+	}
+	val, _ = raw.(V)
+	return val, true
+}
+
+// Len 返回字典的个数
+func (dict *TypedDict[V]) Len() int {
+	return dict.d.Len()
+}
+
+// Put 将键值放入字典并返回新插入的键值的个数
+func (dict *TypedDict[V]) Put(key string, val V) (result int) {
+	return dict.d.Put(key, val)
+}
+
+// PutIfAbsent 如果键不存在，则放值，并返回更新的键值的个数
+func (dict *TypedDict[V]) PutIfAbsent(key string, val V) (result int) {
+	return dict.d.PutIfAbsent(key, val)
+}
+
+// PutIfExists 如果键存在则放值，并返回插入的键值的个数
+func (dict *TypedDict[V]) PutIfExists(key string, val V) (result int) {
+	return dict.d.PutIfExists(key, val)
+}
+
+// Remove 删除键并返回已删除的键值的个数
+func (dict *TypedDict[V]) Remove(key string) (result int) {
+	return dict.d.Remove(key)
+}
+
+// Range 遍历字典，consumer 返回 false 时停止
+func (dict *TypedDict[V]) Range(consumer gdict.Consumer[string, V]) {
+	dict.d.ForEach(func(key string, raw interface{}) bool {
+		val, _ := raw.(V)
+		return consumer(key, val)
+	})
+}
+
+// Keys 返回字典中的所有键
+func (dict *TypedDict[V]) Keys() []string {
+	return dict.d.Keys()
+}
+
+// RandomKeys 随机返回给定数字的键，可能包含重复的键
+func (dict *TypedDict[V]) RandomKeys(limit int) []string {
+	return dict.d.RandomKeys(limit)
+}
+
+// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键
+func (dict *TypedDict[V]) RandomDistinctKeys(limit int) []string {
+	return dict.d.RandomDistinctKeys(limit)
+}
+
+// Clear 清空字典
+func (dict *TypedDict[V]) Clear() {
+	dict.d.Clear()
+}
+
+// typedScanDict 支持游标遍历的 TypedDict
+type typedScanDict[V any] struct {
+	*TypedDict[V]
+	scanner Scanner
+}
+
+// Scan 从 cursor 开始遍历一部分键值，返回下一次的游标，0 表示结束
+func (dict *typedScanDict[V]) Scan(cursor uint64, count int, consumer gdict.Consumer[string, V]) uint64 {
+	return dict.scanner.Scan(cursor, count, func(key string, raw interface{}) bool {
+		val, _ := raw.(V)
+		return consumer(key, val)
+	})
+}
@@ -0,0 +1,347 @@
+package gdict
+
+/*
+ * 分段加锁的并发安全字典：键按哈希值分到 2 的幂个分段里，每个分段有自己的读写锁
+ * 不同分段上的操作互不阻塞，Len 使用原子计数，不需要遍历
+ */
+
+import (
+	"hash/maphash"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultShardCount = 256 // 没有指定分段个数时使用的值
+
+// shard 一个分段
+type shard[K comparable, V any] struct {
+	m  map[K]V
+	mu sync.RWMutex
+}
+
+// ConcurrentDict 分段加锁的并发安全字典
+type ConcurrentDict[K comparable, V any] struct {
+	table []*shard[K, V]
+	seed  maphash.Seed
+	count int64 // 键的个数（原子操作）
+}
+
+// computeCapacity 把分段个数向上取到 2 的幂，例：3 -> 4，8 -> 8，100 -> 128
+func computeCapacity(param int) int {
+	if param <= 1 {
+		return 1
+	}
+	n := param - 1
+	n |= n >> 1
+	n |= n >> 2
+	n |= n >> 4
+	n |= n >> 8
+	n |= n >> 16
+	return n + 1
+}
+
+// MakeConcurrentDict 创建，shardCount 会被向上取到 2 的幂，小于等于 0 时使用默认值
+func MakeConcurrentDict[K comparable, V any](shardCount int) *ConcurrentDict[K, V] {
+	if shardCount <= 0 {
+		shardCount = defaultShardCount
+	}
+	shardCount = computeCapacity(shardCount)
+	table := make([]*shard[K, V], shardCount)
+	for i := range table {
+		table[i] = &shard[K, V]{
+			m: make(map[K]V),
+		}
+	}
+	return &ConcurrentDict[K, V]{
+		table: table,
+		seed:  maphash.MakeSeed(),
+	}
+}
+
+// spread 返回键所在分段的下标
+func (dict *ConcurrentDict[K, V]) spread(key K) uint32 {
+	return uint32(maphash.Comparable(dict.seed, key)) & uint32(len(dict.table)-1)
+}
+
+// getShard 返回键所在的分段
+func (dict *ConcurrentDict[K, V]) getShard(key K) *shard[K, V] {
+	return dict.table[dict.spread(key)]
+}
+
+// Get 返回绑定值以及键是否存在
+func (dict *ConcurrentDict[K, V]) Get(key K) (val V, exists bool) {
+	s := dict.getShard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, exists = s.m[key]
+	return val, exists
+}
+
+// Len 返回字典的个数
+func (dict *ConcurrentDict[K, V]) Len() int {
+	return int(atomic.LoadInt64(&dict.count))
+}
+
+// Put 将键值放入字典并返回新插入的键值的个数
+func (dict *ConcurrentDict[K, V]) Put(key K, val V) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return dict.put(s, key, val)
+}
+
+// put 在已经加锁的分段中放值
+func (dict *ConcurrentDict[K, V]) put(s *shard[K, V], key K, val V) int {
+	_, existed := s.m[key]
+	s.m[key] = val
+	if existed {
+		return 0
+	}
+	atomic.AddInt64(&dict.count, 1)
+	return 1
+}
+
+// PutIfAbsent 如果键不存在，则放值，并返回更新的键值的个数
+func (dict *ConcurrentDict[K, V]) PutIfAbsent(key K, val V) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return dict.putIfAbsent(s, key, val)
+}
+
+// putIfAbsent 在已经加锁的分段中放值
+func (dict *ConcurrentDict[K, V]) putIfAbsent(s *shard[K, V], key K, val V) int {
+	if _, existed := s.m[key]; existed {
+		return 0
+	}
+	s.m[key] = val
+	atomic.AddInt64(&dict.count, 1)
+	return 1
+}
+
+// PutIfExists 如果键存在则放值，并返回插入的键值的个数
+func (dict *ConcurrentDict[K, V]) PutIfExists(key K, val V) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, existed := s.m[key]; existed {
+		s.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+// Remove 删除键并返回已删除的键值的个数
+func (dict *ConcurrentDict[K, V]) Remove(key K) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return dict.remove(s, key)
+}
+
+// remove 在已经加锁的分段中删除键
+func (dict *ConcurrentDict[K, V]) remove(s *shard[K, V], key K) int {
+	if _, existed := s.m[key]; !existed {
+		return 0
+	}
+	delete(s.m, key)
+	atomic.AddInt64(&dict.count, -1)
+	return 1
+}
+
+// Range 遍历字典，consumer 返回 false 时停止
+// 每个分段先在锁里拷贝出键值，再在锁外调用 consumer，这样 consumer 里也可以修改字典
+func (dict *ConcurrentDict[K, V]) Range(consumer Consumer[K, V]) {
+	for _, s := range dict.table {
+		s.mu.RLock()
+		entries := make([]entry[K, V], 0, len(s.m))
+		for key, val := range s.m {
+			entries = append(entries, entry[K, V]{key: key, val: val})
+		}
+		s.mu.RUnlock()
+		for _, e := range entries {
+			if !consumer(e.key, e.val) {
+				return
+			}
+		}
+	}
+}
+
+// Keys 返回字典中的所有键
+func (dict *ConcurrentDict[K, V]) Keys() []K {
+	result := make([]K, 0, dict.Len())
+	for _, s := range dict.table {
+		s.mu.RLock()
+		for key := range s.m {
+			result = append(result, key)
+		}
+		s.mu.RUnlock()
+	}
+	return result
+}
+
+// randomKey 随机挑一个键，字典为空时返回 false
+// 按分段的大小加权挑选分段，再在分段里随机挑一个位置，这样每个键被挑中的概率相同
+func (dict *ConcurrentDict[K, V]) randomKey() (key K, ok bool) {
+	total := dict.Len()
+	if total <= 0 {
+		return key, false
+	}
+	target := rand.Intn(total)
+	for _, s := range dict.table {
+		s.mu.RLock()
+		if target >= len(s.m) {
+			target -= len(s.m)
+			s.mu.RUnlock()
+			continue
+		}
+		for k := range s.m {
+			if target == 0 {
+				s.mu.RUnlock()
+				return k, true
+			}
+			target--
+		}
+		s.mu.RUnlock()
+	}
+	return key, false // 挑选期间有键被删除了
+}
+
+// RandomKeys 随机返回给定数字的键，可能包含重复的键
+func (dict *ConcurrentDict[K, V]) RandomKeys(limit int) []K {
+	if limit <= 0 {
+		return []K{}
+	}
+	result := make([]K, 0, limit)
+	for attempts := 0; len(result) < limit && attempts < limit*2 && dict.Len() > 0; attempts++ {
+		if key, ok := dict.randomKey(); ok {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键
+// 需要的键比较少时逐个随机挑选，否则遍历一次做蓄水池抽样
+func (dict *ConcurrentDict[K, V]) RandomDistinctKeys(limit int) []K {
+	size := dict.Len()
+	if limit > size {
+		limit = size
+	}
+	if limit <= 0 {
+		return []K{}
+	}
+	if limit*2 > size {
+		return dict.reservoirSample(limit)
+	}
+	picked := make(map[K]struct{}, limit)
+	result := make([]K, 0, limit)
+	for attempts := 0; len(result) < limit && attempts < limit*8; attempts++ {
+		key, ok := dict.randomKey()
+		if !ok {
+			break
+		}
+		if _, seen := picked[key]; !seen {
+			picked[key] = struct{}{}
+			result = append(result, key)
+		}
+	}
+	if len(result) < limit { // 运气太差，退回到遍历
+		return dict.reservoirSample(limit)
+	}
+	return result
+}
+
+// reservoirSample 遍历一次整个字典，蓄水池抽样 limit 个不重复的键
+func (dict *ConcurrentDict[K, V]) reservoirSample(limit int) []K {
+	r := newReservoir[K](limit)
+	for _, s := range dict.table {
+		s.mu.RLock()
+		for key := range s.m {
+			r.offer(key)
+		}
+		s.mu.RUnlock()
+	}
+	return r.result
+}
+
+// Clear 删除字典中的所有键
+func (dict *ConcurrentDict[K, V]) Clear() {
+	for _, s := range dict.table {
+		s.mu.Lock()
+		atomic.AddInt64(&dict.count, -int64(len(s.m)))
+		s.m = make(map[K]V)
+		s.mu.Unlock()
+	}
+}
+
+/* ---- 多个键的原子操作 ----- */
+
+// toLockIndices 返回键所在的分段下标，去重后按从小到大排好，所有调用者按同样的顺序加锁就不会死锁
+func (dict *ConcurrentDict[K, V]) toLockIndices(keys []K) []uint32 {
+	indexMap := make(map[uint32]struct{}, len(keys))
+	for _, key := range keys {
+		indexMap[dict.spread(key)] = struct{}{}
+	}
+	indices := make([]uint32, 0, len(indexMap))
+	for index := range indexMap {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return indices[i] < indices[j]
+	})
+	return indices
+}
+
+// Locks 给多个键所在的分段加写锁，之后使用 xxxWithLock 方法操作这些键，最后调用 Unlocks
+func (dict *ConcurrentDict[K, V]) Locks(keys ...K) {
+	for _, index := range dict.toLockIndices(keys) {
+		dict.table[index].mu.Lock()
+	}
+}
+
+// Unlocks 释放 Locks 加的写锁
+func (dict *ConcurrentDict[K, V]) Unlocks(keys ...K) {
+	indices := dict.toLockIndices(keys)
+	for i := len(indices) - 1; i >= 0; i-- {
+		dict.table[indices[i]].mu.Unlock()
+	}
+}
+
+// RLocks 给多个键所在的分段加读锁，之后使用 GetWithLock 读取这些键，最后调用 RUnlocks
+func (dict *ConcurrentDict[K, V]) RLocks(keys ...K) {
+	for _, index := range dict.toLockIndices(keys) {
+		dict.table[index].mu.RLock()
+	}
+}
+
+// RUnlocks 释放 RLocks 加的读锁
+func (dict *ConcurrentDict[K, V]) RUnlocks(keys ...K) {
+	indices := dict.toLockIndices(keys)
+	for i := len(indices) - 1; i >= 0; i-- {
+		dict.table[indices[i]].mu.RUnlock()
+	}
+}
+
+// GetWithLock 在调用者已经加锁的情况下读取键
+func (dict *ConcurrentDict[K, V]) GetWithLock(key K) (val V, exists bool) {
+	val, exists = dict.getShard(key).m[key]
+	return val, exists
+}
+
+// PutWithLock 在调用者已经加写锁的情况下放值
+func (dict *ConcurrentDict[K, V]) PutWithLock(key K, val V) (result int) {
+	return dict.put(dict.getShard(key), key, val)
+}
+
+// PutIfAbsentWithLock 在调用者已经加写锁的情况下，键不存在时放值
+func (dict *ConcurrentDict[K, V]) PutIfAbsentWithLock(key K, val V) (result int) {
+	return dict.putIfAbsent(dict.getShard(key), key, val)
+}
+
+// RemoveWithLock 在调用者已经加写锁的情况下删除键
+func (dict *ConcurrentDict[K, V]) RemoveWithLock(key K) (result int) {
+	return dict.remove(dict.getShard(key), key)
+}
@@ -0,0 +1,34 @@
+package gdict
+
+/*
+ * 使用泛型的字典抽象：键值都带类型，调用者不需要再做类型断言
+ */
+
+// Consumer 用于遍历字典，如果返回 false 则遍历中断
+type Consumer[K comparable, V any] func(key K, val V) bool
+
+// Dict 带类型的 kv 存储数据结构的抽象，操作和 dict.Dict 相同
+type Dict[K comparable, V any] interface {
+	Get(key K) (val V, exists bool)        // key 获取 val（以及 key 是否存在）
+	Len() int                              // 返回数据长度
+	Put(key K, val V) (result int)         // 存入 kv
+	PutIfAbsent(key K, val V) (result int) // 如果不存在才存入 kv
+	PutIfExists(key K, val V) (result int) // 如果存在才存入 kv
+	Remove(key K) (result int)             // 删除
+	Range(consumer Consumer[K, V])         // 遍历整个字典，consumer 返回 false 时停止
+	Keys() []K                             // 列出所有键
+	RandomKeys(limit int) []K              // 列出指定个数的键
+	RandomDistinctKeys(limit int) []K      // 返回多个不重复的键
+	Clear()                                // 清空字典
+}
+
+// Scanner 支持游标遍历的字典（SCAN），游标在字典扩容、缩容之后依然有效
+type Scanner[K comparable, V any] interface {
+	Scan(cursor uint64, count int, consumer Consumer[K, V]) uint64 // 从 cursor 开始遍历一部分键值，返回下一次的游标，0 表示结束
+}
+
+// entry 遍历时在锁里拷贝出来的键值
+type entry[K comparable, V any] struct {
+	key K
+	val V
+}
@@ -0,0 +1,97 @@
+package gdict
+
+import (
+	"strconv"
+	"testing"
+)
+
+// implementations 所有的实现都要通过同一组测试
+func implementations() map[string]func() Dict[string, int] {
+	return map[string]func() Dict[string, int]{
+		"concurrent":  func() Dict[string, int] { return MakeConcurrentDict[string, int](16) },
+		"concurrent1": func() Dict[string, int] { return MakeConcurrentDict[string, int](1) },
+		"concurrent3": func() Dict[string, int] { return MakeConcurrentDict[string, int](3) },
+		"lock":        func() Dict[string, int] { return MakeLockDict[string, int]() },
+		"ordered":     func() Dict[string, int] { return MakeOrderedDict[string, int]() },
+	}
+}
+
+func TestDict(t *testing.T) {
+	for name, makeDict := range implementations() {
+		t.Run(name, func(t *testing.T) {
+			d := makeDict()
+			for i := 0; i < 100; i++ {
+				if d.Put("k"+strconv.Itoa(i), i) != 1 {
+					t.Fatal("expected new key")
+				}
+			}
+			if d.Put("k0", 0) != 0 || d.Len() != 100 || len(d.Keys()) != 100 {
+				t.Errorf("expected 100 keys, got %d", d.Len())
+			}
+			if d.PutIfAbsent("k1", 0) != 0 || d.PutIfExists("k1", -1) != 1 || d.PutIfExists("none", 0) != 0 {
+				t.Error("conditional put failed")
+			}
+			if val, ok := d.Get("k1"); !ok || val != -1 {
+				t.Errorf("unexpected value %d", val)
+			}
+			if _, ok := d.Get("none"); ok {
+				t.Error("none should not exist")
+			}
+			visited := 0
+			d.Range(func(key string, val int) bool {
+				visited++
+				return visited < 10
+			})
+			if visited != 10 {
+				t.Errorf("Range should stop when consumer returns false, visited %d", visited)
+			}
+			if len(d.RandomKeys(20)) != 20 || len(d.RandomDistinctKeys(20)) != 20 || len(d.RandomDistinctKeys(200)) != 100 {
+				t.Error("random keys returned wrong count")
+			}
+			if d.Remove("k1") != 1 || d.Remove("k1") != 0 || d.Len() != 99 {
+				t.Error("remove failed")
+			}
+			d.Clear()
+			if d.Len() != 0 || len(d.Keys()) != 0 || len(d.RandomKeys(1)) != 0 {
+				t.Error("clear failed")
+			}
+		})
+	}
+}
+
+func TestOrderedDictOrder(t *testing.T) {
+	d := MakeOrderedDict[string, int]()
+	for _, key := range []string{"c", "a", "d", "b"} {
+		d.Put(key, 0)
+	}
+	d.Put("a", 1) // 更新不改变位置
+	d.Remove("d")
+	d.Put("d", 2) // 删除后重新插入排到最后
+	expected := []string{"c", "a", "b", "d"}
+	keys := d.Keys()
+	ranged := make([]string, 0)
+	d.Range(func(key string, val int) bool {
+		ranged = append(ranged, key)
+		return true
+	})
+	for i, key := range expected {
+		if keys[i] != key || ranged[i] != key {
+			t.Fatalf("expected %v, got %v and %v", expected, keys, ranged)
+		}
+	}
+}
+
+func TestComputeCapacity(t *testing.T) {
+	tests := map[int]int{1: 1, 2: 2, 3: 4, 4: 4, 5: 8, 8: 8, 9: 16, 16: 16, 17: 32, 256: 256, 1000: 1024}
+	for param, expected := range tests {
+		if got := computeCapacity(param); got != expected {
+			t.Errorf("computeCapacity(%d): expected %d, got %d", param, expected, got)
+		}
+		if got := len(MakeConcurrentDict[string, int](param).table); got != expected {
+			t.Errorf("MakeConcurrentDict(%d): expected %d shards, got %d", param, expected, got)
+		}
+	}
+	if got := len(MakeConcurrentDict[string, int](0).table); got != defaultShardCount {
+		t.Errorf("expected %d shards by default, got %d", defaultShardCount, got)
+	}
+}
@@ -0,0 +1,149 @@
+package gdict
+
+/*
+ * 一把读写锁保护一个 map 的字典，实现简单，适合键不多或者并发不高的场景
+ */
+
+import (
+	"sync"
+)
+
+// LockDict 使用一把读写锁的并发安全字典
+type LockDict[K comparable, V any] struct {
+	m  map[K]V
+	mu sync.RWMutex
+}
+
+// MakeLockDict 创建
+func MakeLockDict[K comparable, V any]() *LockDict[K, V] {
+	return &LockDict[K, V]{
+		m: make(map[K]V),
+	}
+}
+
+// Get 返回绑定值以及键是否存在
+func (dict *LockDict[K, V]) Get(key K) (val V, exists bool) {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	val, exists = dict.m[key]
+	return val, exists
+}
+
+// Len 返回字典的个数
+func (dict *LockDict[K, V]) Len() int {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	return len(dict.m)
+}
+
+// Put 将键值放入字典并返回新插入的键值的个数
+func (dict *LockDict[K, V]) Put(key K, val V) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	_, existed := dict.m[key]
+	dict.m[key] = val
+	if existed {
+		return 0
+	}
+	return 1
+}
+
+// PutIfAbsent 如果键不存在，则放值，并返回更新的键值的个数
+func (dict *LockDict[K, V]) PutIfAbsent(key K, val V) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	if _, existed := dict.m[key]; existed {
+		return 0
+	}
+	dict.m[key] = val
+	return 1
+}
+
+// PutIfExists 如果键存在则放值，并返回插入的键值的个数
+func (dict *LockDict[K, V]) PutIfExists(key K, val V) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	if _, existed := dict.m[key]; existed {
+		dict.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+// Remove 删除键并返回已删除的键值的个数
+func (dict *LockDict[K, V]) Remove(key K) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	if _, existed := dict.m[key]; !existed {
+		return 0
+	}
+	delete(dict.m, key)
+	return 1
+}
+
+// Range 遍历字典，consumer 返回 false 时停止
+// 先在锁里拷贝出所有的键值，再在锁外调用 consumer，这样 consumer 里也可以修改字典
+func (dict *LockDict[K, V]) Range(consumer Consumer[K, V]) {
+	dict.mu.RLock()
+	entries := make([]entry[K, V], 0, len(dict.m))
+	for key, val := range dict.m {
+		entries = append(entries, entry[K, V]{key: key, val: val})
+	}
+	dict.mu.RUnlock()
+	for _, e := range entries {
+		if !consumer(e.key, e.val) {
+			break
+		}
+	}
+}
+
+// Keys 返回字典中的所有键
+func (dict *LockDict[K, V]) Keys() []K {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	result := make([]K, 0, len(dict.m))
+	for key := range dict.m {
+		result = append(result, key)
+	}
+	return result
+}
+
+// walk 在锁里按 map 的遍历顺序交出键（调用者需要持有锁）
+func (dict *LockDict[K, V]) walk(yield func(key K) bool) {
+	for key := range dict.m {
+		if !yield(key) {
+			return
+		}
+	}
+}
+
+// RandomKeys 随机返回给定数字的键，可能包含重复的键
+func (dict *LockDict[K, V]) RandomKeys(limit int) []K {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	if len(dict.m) == 0 || limit <= 0 {
+		return []K{}
+	}
+	return pickPositions(randomPositions(len(dict.m), limit), dict.walk)
+}
+
+// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键
+func (dict *LockDict[K, V]) RandomDistinctKeys(limit int) []K {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	if limit <= 0 {
+		return []K{}
+	}
+	r := newReservoir[K](limit)
+	for key := range dict.m {
+		r.offer(key)
+	}
+	return r.result
+}
+
+// Clear 删除字典中的所有键
+func (dict *LockDict[K, V]) Clear() {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	dict.m = make(map[K]V)
+}
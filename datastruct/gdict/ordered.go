@@ -0,0 +1,187 @@
+package gdict
+
+/*
+ * 按插入顺序遍历的字典：map 负责查找，双向链表记录插入顺序
+ * 更新已经存在的键不会改变它的位置
+ */
+
+import (
+	"sync"
+)
+
+// orderedEntry 链表中的节点
+type orderedEntry[K comparable, V any] struct {
+	key        K
+	val        V
+	prev, next *orderedEntry[K, V]
+}
+
+// OrderedDict 按插入顺序遍历的并发安全字典
+type OrderedDict[K comparable, V any] struct {
+	m    map[K]*orderedEntry[K, V]
+	head *orderedEntry[K, V] // 最早插入的节点
+	tail *orderedEntry[K, V] // 最晚插入的节点
+	mu   sync.RWMutex
+}
+
+// MakeOrderedDict 创建
+func MakeOrderedDict[K comparable, V any]() *OrderedDict[K, V] {
+	return &OrderedDict[K, V]{
+		m: make(map[K]*orderedEntry[K, V]),
+	}
+}
+
+// Get 返回绑定值以及键是否存在
+func (dict *OrderedDict[K, V]) Get(key K) (val V, exists bool) {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	e, exists := dict.m[key]
+	if !exists {
+		return val, false
+	}
+	return e.val, true
+}
+
+// Len 返回字典的个数
+func (dict *OrderedDict[K, V]) Len() int {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	return len(dict.m)
+}
+
+// pushBack 在链表末尾插入新节点（调用者需要持有写锁并确认键不存在）
+func (dict *OrderedDict[K, V]) pushBack(key K, val V) {
+	e := &orderedEntry[K, V]{key: key, val: val, prev: dict.tail}
+	if dict.tail == nil {
+		dict.head = e
+	} else {
+		dict.tail.next = e
+	}
+	dict.tail = e
+	dict.m[key] = e
+}
+
+// Put 将键值放入字典并返回新插入的键值的个数
+func (dict *OrderedDict[K, V]) Put(key K, val V) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	if e, existed := dict.m[key]; existed {
+		e.val = val
+		return 0
+	}
+	dict.pushBack(key, val)
+	return 1
+}
+
+// PutIfAbsent 如果键不存在，则放值，并返回更新的键值的个数
+func (dict *OrderedDict[K, V]) PutIfAbsent(key K, val V) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	if _, existed := dict.m[key]; existed {
+		return 0
+	}
+	dict.pushBack(key, val)
+	return 1
+}
+
+// PutIfExists 如果键存在则放值，并返回插入的键值的个数
+func (dict *OrderedDict[K, V]) PutIfExists(key K, val V) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	if e, existed := dict.m[key]; existed {
+		e.val = val
+		return 1
+	}
+	return 0
+}
+
+// Remove 删除键并返回已删除的键值的个数
+func (dict *OrderedDict[K, V]) Remove(key K) (result int) {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	e, existed := dict.m[key]
+	if !existed {
+		return 0
+	}
+	if e.prev == nil {
+		dict.head = e.next
+	} else {
+		e.prev.next = e.next
+	}
+	if e.next == nil {
+		dict.tail = e.prev
+	} else {
+		e.next.prev = e.prev
+	}
+	delete(dict.m, key)
+	return 1
+}
+
+// Range 按插入顺序遍历字典，consumer 返回 false 时停止
+// 先在锁里拷贝出所有的键值，再在锁外调用 consumer，这样 consumer 里也可以修改字典
+func (dict *OrderedDict[K, V]) Range(consumer Consumer[K, V]) {
+	dict.mu.RLock()
+	entries := make([]entry[K, V], 0, len(dict.m))
+	for e := dict.head; e != nil; e = e.next {
+		entries = append(entries, entry[K, V]{key: e.key, val: e.val})
+	}
+	dict.mu.RUnlock()
+	for _, e := range entries {
+		if !consumer(e.key, e.val) {
+			break
+		}
+	}
+}
+
+// Keys 按插入顺序返回字典中的所有键
+func (dict *OrderedDict[K, V]) Keys() []K {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	result := make([]K, 0, len(dict.m))
+	for e := dict.head; e != nil; e = e.next {
+		result = append(result, e.key)
+	}
+	return result
+}
+
+// walk 在锁里按插入顺序交出键（调用者需要持有锁）
+func (dict *OrderedDict[K, V]) walk(yield func(key K) bool) {
+	for e := dict.head; e != nil; e = e.next {
+		if !yield(e.key) {
+			return
+		}
+	}
+}
+
+// RandomKeys 随机返回给定数字的键，可能包含重复的键
+func (dict *OrderedDict[K, V]) RandomKeys(limit int) []K {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	if len(dict.m) == 0 || limit <= 0 {
+		return []K{}
+	}
+	return pickPositions(randomPositions(len(dict.m), limit), dict.walk)
+}
+
+// RandomDistinctKeys 随机返回给定数字的键，不会包含重复的键
+func (dict *OrderedDict[K, V]) RandomDistinctKeys(limit int) []K {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	if limit <= 0 {
+		return []K{}
+	}
+	r := newReservoir[K](limit)
+	for e := dict.head; e != nil; e = e.next {
+		r.offer(e.key)
+	}
+	return r.result
+}
+
+// Clear 删除字典中的所有键
+func (dict *OrderedDict[K, V]) Clear() {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	dict.m = make(map[K]*orderedEntry[K, V])
+	dict.head = nil
+	dict.tail = nil
+}
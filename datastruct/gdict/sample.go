@@ -0,0 +1,60 @@
+package gdict
+
+/*
+ * 随机取键的公共逻辑，给不能直接随机访问的字典使用
+ */
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// randomPositions 在 [0, size) 中随机挑选 limit 个位置（可能重复），从小到大排好
+// 调用者按顺序遍历一次字典，就能取出这些位置上的键
+func randomPositions(size int, limit int) []int {
+	positions := make([]int, limit)
+	for i := range positions {
+		positions[i] = rand.Intn(size)
+	}
+	sort.Ints(positions)
+	return positions
+}
+
+// pickPositions 按顺序遍历 walk 交出的键，取出 positions 位置上的键
+func pickPositions[K any](positions []int, walk func(yield func(key K) bool)) []K {
+	result := make([]K, 0, len(positions))
+	pos := 0
+	walk(func(key K) bool {
+		for len(result) < len(positions) && positions[len(result)] == pos {
+			result = append(result, key)
+		}
+		pos++
+		return len(result) < len(positions)
+	})
+	return result
+}
+
+// reservoir 蓄水池抽样，遍历一次就能等概率地取出 limit 个不重复的键
+type reservoir[K any] struct {
+	limit  int
+	seen   int
+	result []K
+}
+
+// newReservoir 创建
+func newReservoir[K any](limit int) *reservoir[K] {
+	return &reservoir[K]{
+		limit:  limit,
+		result: make([]K, 0, limit),
+	}
+}
+
+// offer 交出一个键
+func (r *reservoir[K]) offer(key K) {
+	r.seen++
+	if len(r.result) < r.limit {
+		r.result = append(r.result, key)
+	} else if j := rand.Intn(r.seen); j < r.limit {
+		r.result[j] = key
+	}
+}
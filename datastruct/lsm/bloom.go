@@ -0,0 +1,70 @@
+package lsm
+
+/*
+ * SSTable 的布隆过滤器：查找不存在的键时大部分文件不需要读
+ * 使用两个哈希值模拟 k 个哈希函数（Kirsch-Mitzenmacher）
+ */
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomFilter 位数组和哈希函数的个数
+type bloomFilter struct {
+	bits []byte
+	k    uint8
+}
+
+// bloomHash 键的 64 位哈希，写入文件以后需要在重启之后得到相同的结果，所以不能用带随机种子的哈希
+func bloomHash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// newBloomFilter 根据所有键的哈希创建过滤器，每个键占 bitsPerKey 位
+func newBloomFilter(hashes []uint64, bitsPerKey int) bloomFilter {
+	k := uint8(math.Round(float64(bitsPerKey) * math.Ln2)) // 误判率最低的哈希函数个数
+	k = min(max(k, 1), 30)
+	nBits := max(len(hashes)*bitsPerKey, 64)
+	filter := bloomFilter{bits: make([]byte, (nBits+7)/8), k: k}
+	nBits = len(filter.bits) * 8
+	for _, h := range hashes {
+		h1, h2 := uint32(h), uint32(h>>32)
+		for i := uint32(0); i < uint32(k); i++ {
+			bit := (h1 + i*h2) % uint32(nBits)
+			filter.bits[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return filter
+}
+
+// mayContain 返回 false 时键一定不存在
+func (filter bloomFilter) mayContain(h uint64) bool {
+	nBits := uint32(len(filter.bits) * 8)
+	if nBits == 0 {
+		return true
+	}
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := uint32(0); i < uint32(filter.k); i++ {
+		bit := (h1 + i*h2) % nBits
+		if filter.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encode 编码为 <k 1 字节> <位数组>
+func (filter bloomFilter) encode() []byte {
+	return append([]byte{filter.k}, filter.bits...)
+}
+
+// decodeBloomFilter 解码
+func decodeBloomFilter(data []byte) bloomFilter {
+	if len(data) == 0 {
+		return bloomFilter{}
+	}
+	return bloomFilter{k: data[0], bits: data[1:]}
+}
@@ -0,0 +1,234 @@
+package lsm
+
+/*
+ * 后台的 leveled compaction
+ * 第 0 层的文件之间键范围会重叠，个数达到阈值时全部和第 1 层重叠的文件合并
+ * 第 1 层开始每层内的文件互不重叠，总大小超过上限时轮流挑一个文件和下一层重叠的文件合并
+ * 合并在锁外读写文件，完成后持有写锁替换文件列表
+ */
+
+import (
+	"GoMiniCache/lib/logger"
+	"os"
+	"sort"
+)
+
+// compaction 一次合并的输入
+type compaction struct {
+	level          int
+	inputs         []*table // level 层的输入，第 0 层从新到旧
+	next           []*table // level+1 层和输入重叠的文件
+	dropTombstones bool     // 更深的层没有数据时墓碑可以直接丢掉
+	generation     uint64
+}
+
+// scheduleCompaction 通知后台检查是否需要合并
+func (db *DB) scheduleCompaction() {
+	select {
+	case db.compactCh <- struct{}{}:
+	default:
+	}
+}
+
+// compactionLoop 后台合并的协程
+func (db *DB) compactionLoop() {
+	defer db.wg.Done()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case <-db.compactCh:
+		}
+		for {
+			select {
+			case <-db.closing:
+				return
+			default:
+			}
+			done, err := db.compactOnce()
+			if err != nil {
+				logger.Error("lsm compaction failed: " + err.Error())
+				break
+			}
+			if !done {
+				break
+			}
+		}
+	}
+}
+
+// compactOnce 挑选并执行一次合并，没有需要合并的层时返回 false
+func (db *DB) compactOnce() (bool, error) {
+	db.mu.Lock() // 挑选时会移动每层的合并位置，所以持有写锁
+	c := db.pickCompactionLocked()
+	db.mu.Unlock()
+	if c == nil {
+		return false, nil
+	}
+	outputs, err := db.runCompaction(c)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed || db.generation != c.generation { // 合并期间数据库被清空或关闭了，输入的文件可能已经删除
+		db.removeTables(outputs)
+		return false, nil
+	}
+	if err != nil {
+		db.removeTables(outputs)
+		return false, err
+	}
+	db.levels[c.level] = withoutTables(db.levels[c.level], c.inputs)
+	next := append(withoutTables(db.levels[c.level+1], c.next), outputs...)
+	sort.Slice(next, func(i, j int) bool { return next[i].smallest < next[j].smallest })
+	db.levels[c.level+1] = next
+	if err := db.writeManifestLocked(); err != nil {
+		return false, err
+	}
+	db.removeTables(c.inputs)
+	db.removeTables(c.next)
+	db.compactions++
+	return true, nil
+}
+
+// pickCompactionLocked 挑选需要合并的层，第 0 层优先
+func (db *DB) pickCompactionLocked() *compaction {
+	c := &compaction{generation: db.generation}
+	if len(db.levels[0]) >= db.opts.L0CompactionTrigger {
+		c.inputs = append([]*table(nil), db.levels[0]...)
+	} else {
+		limit := db.opts.LevelBaseSize
+		for level := 1; level < numLevels-1; level++ {
+			tables := db.levels[level]
+			if len(tables) > 0 && levelSize(tables) > limit {
+				// 从上次合并的位置之后挑一个文件，整层轮流合并
+				i := sort.Search(len(tables), func(i int) bool { return tables[i].smallest > db.pointers[level] })
+				if i == len(tables) {
+					i = 0
+				}
+				db.pointers[level] = tables[i].largest
+				c.level = level
+				c.inputs = []*table{tables[i]}
+				break
+			}
+			limit *= int64(db.opts.LevelMultiplier)
+		}
+		if c.inputs == nil {
+			return nil
+		}
+	}
+	smallest, largest := c.inputs[0].smallest, c.inputs[0].largest
+	for _, t := range c.inputs[1:] {
+		smallest, largest = min(smallest, t.smallest), max(largest, t.largest)
+	}
+	for _, t := range db.levels[c.level+1] {
+		if t.overlaps(smallest, largest) {
+			c.next = append(c.next, t)
+		}
+	}
+	c.dropTombstones = true
+	for level := c.level + 2; level < numLevels; level++ {
+		if len(db.levels[level]) > 0 {
+			c.dropTombstones = false
+		}
+	}
+	return c
+}
+
+// runCompaction 归并输入文件，按 TableSize 切分写出新的文件
+func (db *DB) runCompaction(c *compaction) ([]*table, error) {
+	var iters []iterator
+	if c.level == 0 {
+		for _, t := range c.inputs {
+			iters = append(iters, t.iterator())
+		}
+	} else {
+		iters = append(iters, newLevelIterator(c.inputs))
+	}
+	iters = append(iters, newLevelIterator(c.next))
+	it := newMergeIterator(iters)
+
+	var outputs []*table
+	var tw *tableWriter
+	var num uint64
+	finish := func() error {
+		if tw == nil {
+			return nil
+		}
+		entries := tw.entries
+		err := tw.finish()
+		tw = nil
+		if err != nil {
+			_ = os.Remove(db.path(num, tableExt))
+			return err
+		}
+		t, err := openTable(db.path(num, tableExt), num)
+		if err != nil {
+			return err
+		}
+		t.entries = entries
+		outputs = append(outputs, t)
+		return nil
+	}
+	for it.seek(""); it.valid(); it.next() {
+		if it.deleted() && c.dropTombstones {
+			continue
+		}
+		if tw == nil {
+			num = db.newFileNum()
+			var err error
+			if tw, err = newTableWriter(db.path(num, tableExt), db.opts.BlockSize, db.opts.BloomBitsPerKey); err != nil {
+				return outputs, err
+			}
+		}
+		if err := tw.add(it.key(), it.value(), it.deleted()); err != nil {
+			tw.abort()
+			return outputs, err
+		}
+		if int64(tw.size()) >= db.opts.TableSize {
+			if err := finish(); err != nil {
+				return outputs, err
+			}
+		}
+	}
+	if err := it.err(); err != nil {
+		if tw != nil {
+			tw.abort()
+		}
+		return outputs, err
+	}
+	return outputs, finish()
+}
+
+// newFileNum 分配一个文件编号，合并在锁外写文件时使用
+func (db *DB) newFileNum() uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	num := db.nextFile
+	db.nextFile++
+	return num
+}
+
+// removeTables 关闭并删除文件
+func (db *DB) removeTables(tables []*table) {
+	for _, t := range tables {
+		t.close()
+		_ = os.Remove(db.path(t.num, tableExt))
+	}
+}
+
+// withoutTables 返回去掉 removed 以后的文件列表
+func withoutTables(tables []*table, removed []*table) []*table {
+	result := make([]*table, 0, len(tables))
+	for _, t := range tables {
+		keep := true
+		for _, r := range removed {
+			if r == t {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, t)
+		}
+	}
+	return result
+}
@@ -0,0 +1,120 @@
+package lsm
+
+/*
+ * 迭代器：内存表、单个 SSTable、一层 SSTable 都按键的顺序遍历，mergeIterator 把它们合并起来
+ * 同一个键出现在多个迭代器里时，排在前面（更新）的迭代器里的值生效
+ */
+
+// iterator 按键的顺序遍历记录（包括墓碑）
+type iterator interface {
+	seek(key string) // 定位到第一个不小于 key 的记录
+	valid() bool
+	key() string
+	value() []byte
+	deleted() bool
+	next()
+	err() error
+}
+
+// levelIterator 遍历一层互不重叠、按键排序的 SSTable
+type levelIterator struct {
+	tables []*table
+	idx    int
+	cur    *tableIterator
+}
+
+func newLevelIterator(tables []*table) *levelIterator {
+	return &levelIterator{tables: tables}
+}
+
+// open 从第 idx 个文件开始找到第一条记录
+func (it *levelIterator) open(idx int, key string) {
+	it.cur = nil
+	for it.idx = idx; it.idx < len(it.tables); it.idx++ {
+		it.cur = it.tables[it.idx].iterator()
+		it.cur.seek(key)
+		if it.cur.valid() || it.cur.err() != nil {
+			return
+		}
+	}
+}
+
+func (it *levelIterator) seek(key string) {
+	idx := 0
+	for idx < len(it.tables) && it.tables[idx].largest < key {
+		idx++
+	}
+	it.open(idx, key)
+}
+
+func (it *levelIterator) valid() bool   { return it.cur != nil && it.cur.valid() }
+func (it *levelIterator) key() string   { return it.cur.key() }
+func (it *levelIterator) value() []byte { return it.cur.value() }
+func (it *levelIterator) deleted() bool { return it.cur.deleted() }
+
+func (it *levelIterator) next() {
+	it.cur.next()
+	if !it.cur.valid() && it.cur.err() == nil {
+		it.open(it.idx+1, "")
+	}
+}
+
+func (it *levelIterator) err() error {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.err()
+}
+
+// mergeIterator 合并多个迭代器，iters 按从新到旧排列，同一个键只交出最新的记录
+type mergeIterator struct {
+	iters []iterator
+	cur   int // 当前最小的键所在的迭代器，-1 表示遍历结束
+	e     error
+}
+
+func newMergeIterator(iters []iterator) *mergeIterator {
+	return &mergeIterator{iters: iters, cur: -1}
+}
+
+// pick 选出键最小的迭代器，键相同时选更新的
+func (it *mergeIterator) pick() {
+	it.cur = -1
+	for i, sub := range it.iters {
+		if err := sub.err(); err != nil {
+			it.e = err
+			it.cur = -1
+			return
+		}
+		if !sub.valid() {
+			continue
+		}
+		if it.cur < 0 || sub.key() < it.iters[it.cur].key() {
+			it.cur = i
+		}
+	}
+}
+
+func (it *mergeIterator) seek(key string) {
+	for _, sub := range it.iters {
+		sub.seek(key)
+	}
+	it.pick()
+}
+
+func (it *mergeIterator) valid() bool   { return it.e == nil && it.cur >= 0 }
+func (it *mergeIterator) key() string   { return it.iters[it.cur].key() }
+func (it *mergeIterator) value() []byte { return it.iters[it.cur].value() }
+func (it *mergeIterator) deleted() bool { return it.iters[it.cur].deleted() }
+func (it *mergeIterator) err() error    { return it.e }
+
+// next 跳过所有迭代器中和当前键相同的旧记录
+func (it *mergeIterator) next() {
+	key := it.key()
+	for _, sub := range it.iters {
+		if sub.valid() && sub.key() == key {
+			sub.next()
+		}
+	}
+	it.pick()
+}
@@ -0,0 +1,597 @@
+package lsm
+
+/*
+ * 嵌入式的 LSM-tree 存储引擎
+ *
+ * 写入: 先追加到预写日志，再写进内存表；内存表写满以后刷成第 0 层的 SSTable，换一个新的日志
+ * 读取: 内存表 -> 第 0 层（从新到旧）-> 第 1 层 -> ... 第一个找到的记录生效，墓碑表示已经删除
+ * 合并: 第 0 层的文件个数达到阈值，或者某一层的总大小超过上限时，在后台把它和下一层重叠的文件合并（leveled compaction）
+ *
+ * 重启时只需要读 manifest、打开 SSTable 的索引，再回放最后一个日志，不需要重放所有的写入
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	numLevels  = 7
+	scanChunk  = 256 // ForEach 每次持有读锁取出的记录个数
+	tableExt   = ".sst"
+	walExt     = ".wal"
+	maxKeySize = 1 << 30
+)
+
+// ErrClosed 引擎已经关闭
+var ErrClosed = errors.New("lsm: closed")
+
+// Options 引擎的参数，值为 0 时使用默认值
+type Options struct {
+	MemtableSize        int64 // 内存表的大小上限，默认 4MB
+	BlockSize           int   // 数据块的大小，默认 4KB
+	BloomBitsPerKey     int   // 布隆过滤器每个键占用的位数，默认 10（误判率约 1%）
+	L0CompactionTrigger int   // 第 0 层的文件个数达到这个值时合并，默认 4
+	LevelBaseSize       int64 // 第 1 层的大小上限，默认 10MB，之后每层乘以 LevelMultiplier
+	LevelMultiplier     int   // 相邻两层大小上限的倍数，默认 10
+	TableSize           int64 // 合并输出的单个 SSTable 的大小，默认 2MB
+	SyncWrites          bool  // 每次写入后对日志刷盘
+}
+
+// withDefaults 补上默认值
+func (opts Options) withDefaults() Options {
+	if opts.MemtableSize <= 0 {
+		opts.MemtableSize = 4 << 20
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = 4 << 10
+	}
+	if opts.BloomBitsPerKey <= 0 {
+		opts.BloomBitsPerKey = 10
+	}
+	if opts.L0CompactionTrigger <= 0 {
+		opts.L0CompactionTrigger = 4
+	}
+	if opts.LevelBaseSize <= 0 {
+		opts.LevelBaseSize = 10 << 20
+	}
+	if opts.LevelMultiplier <= 1 {
+		opts.LevelMultiplier = 10
+	}
+	if opts.TableSize <= 0 {
+		opts.TableSize = 2 << 20
+	}
+	return opts
+}
+
+// Stats 引擎的统计信息
+type Stats struct {
+	Keys          int64   // 键的个数
+	Bytes         int64   // 所有键值的总长度
+	MemtableBytes int64   // 内存表估算占用的内存
+	LevelTables   []int   // 每一层的文件个数
+	LevelBytes    []int64 // 每一层的文件大小
+	Compactions   int64   // 完成的合并次数
+}
+
+// DB LSM-tree 引擎，并发安全
+type DB struct {
+	dir  string
+	opts Options
+
+	mu           sync.RWMutex
+	mem          *memtable
+	log          *wal
+	walNum       uint64
+	levels       [numLevels][]*table
+	nextFile     uint64
+	count        int64 // 键的个数
+	bytes        int64 // 所有键值的总长度
+	flushedCount int64 // 最近一次刷盘时的 count，写进 manifest
+	flushedBytes int64
+	pointers     [numLevels]string // 每一层下一次从哪个键之后挑选文件合并
+	generation   uint64            // Clear 以后递增，丢弃清空之前开始的合并
+	compactions  int64
+	closed       bool
+
+	compactCh chan struct{}
+	closing   chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Open 打开目录中的引擎，目录不存在时创建一个空的
+func Open(dir string, opts Options) (*DB, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	db := &DB{
+		dir:       dir,
+		opts:      opts.withDefaults(),
+		mem:       newMemtable(),
+		nextFile:  1,
+		compactCh: make(chan struct{}, 1),
+		closing:   make(chan struct{}),
+	}
+	if err := db.recover(); err != nil {
+		db.closeTables()
+		return nil, err
+	}
+	db.wg.Add(1)
+	go db.compactionLoop()
+	db.scheduleCompaction()
+	return db, nil
+}
+
+// recover 读 manifest，打开 SSTable，回放日志，删除不再需要的文件
+func (db *DB) recover() error {
+	m, err := readManifest(db.dir)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = &manifest{NextFile: 1}
+	}
+	db.nextFile = m.NextFile
+	db.walNum = m.WAL
+	db.count, db.bytes = m.Count, m.Bytes
+	live := make(map[uint64]bool)
+	for level, metas := range m.Levels {
+		if level >= numLevels {
+			return fmt.Errorf("lsm: too many levels in manifest")
+		}
+		for _, meta := range metas {
+			t, err := openTable(db.path(meta.Num, tableExt), meta.Num)
+			if err != nil {
+				return fmt.Errorf("lsm: open table %d: %w", meta.Num, err)
+			}
+			t.entries = meta.Entries
+			db.levels[level] = append(db.levels[level], t)
+			live[meta.Num] = true
+		}
+	}
+	tables, wals, err := db.listFiles()
+	if err != nil {
+		return err
+	}
+	for _, num := range tables { // 刷盘或合并到一半时崩溃留下的文件
+		if !live[num] {
+			_ = os.Remove(db.path(num, tableExt))
+		}
+	}
+	for _, num := range wals {
+		if num < m.WAL {
+			_ = os.Remove(db.path(num, walExt))
+			continue
+		}
+		if _, err := replayWAL(db.path(num, walExt), db.replay); err != nil {
+			return err
+		}
+		db.nextFile = max(db.nextFile, num+1)
+	}
+	// 回放的数据直接刷成 SSTable，然后从一个新的日志开始
+	return db.flushLocked()
+}
+
+// replay 回放一条日志记录
+func (db *DB) replay(op byte, key string, val []byte) error {
+	existed, oldLen, err := db.existsLocked(key)
+	if err != nil {
+		return err
+	}
+	if op == walOpDelete {
+		if existed {
+			db.applyDelete(key, oldLen)
+		}
+		return nil
+	}
+	db.applyPut(key, bytes.Clone(val), existed, oldLen)
+	return nil
+}
+
+// listFiles 列出目录中的 SSTable 和日志的编号
+func (db *DB) listFiles() (tables []uint64, wals []uint64, err error) {
+	entries, err := os.ReadDir(db.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		num, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch ext {
+		case tableExt:
+			tables = append(tables, num)
+		case walExt:
+			wals = append(wals, num)
+		}
+	}
+	sort.Slice(wals, func(i, j int) bool { return wals[i] < wals[j] })
+	return tables, wals, nil
+}
+
+// path 返回文件编号对应的路径
+func (db *DB) path(num uint64, ext string) string {
+	return filepath.Join(db.dir, fmt.Sprintf("%06d%s", num, ext))
+}
+
+/* ---- 读 ----- */
+
+// Get 返回键的值
+func (db *DB) Get(key string) ([]byte, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, false, ErrClosed
+	}
+	val, deleted, found, err := db.getLocked(key)
+	if err != nil || !found || deleted {
+		return nil, false, err
+	}
+	return bytes.Clone(val), true, nil
+}
+
+// getLocked 按从新到旧的顺序查找键，found 表示找到了记录（可能是墓碑）
+func (db *DB) getLocked(key string) (val []byte, deleted bool, found bool, err error) {
+	if val, deleted, found = db.mem.get(key); found {
+		return val, deleted, true, nil
+	}
+	hash := bloomHash(key)
+	for _, t := range db.levels[0] {
+		if val, deleted, found, err = t.get(key, hash); err != nil || found {
+			return val, deleted, found, err
+		}
+	}
+	for level := 1; level < numLevels; level++ {
+		tables := db.levels[level]
+		i := sort.Search(len(tables), func(i int) bool { return tables[i].largest >= key })
+		if i == len(tables) {
+			continue
+		}
+		if val, deleted, found, err = tables[i].get(key, hash); err != nil || found {
+			return val, deleted, found, err
+		}
+	}
+	return nil, false, false, nil
+}
+
+// existsLocked 返回键是否存在以及它原来的值的长度
+func (db *DB) existsLocked(key string) (bool, int, error) {
+	val, deleted, found, err := db.getLocked(key)
+	if err != nil {
+		return false, 0, err
+	}
+	return found && !deleted, len(val), nil
+}
+
+// Len 返回键的个数
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return int(db.count)
+}
+
+// Bytes 返回所有键值的总长度
+func (db *DB) Bytes() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.bytes
+}
+
+// newIteratorLocked 合并内存表和所有的 SSTable 的迭代器
+func (db *DB) newIteratorLocked() *mergeIterator {
+	iters := []iterator{&memIterator{m: db.mem}}
+	for _, t := range db.levels[0] {
+		iters = append(iters, t.iterator())
+	}
+	for level := 1; level < numLevels; level++ {
+		if len(db.levels[level]) > 0 {
+			iters = append(iters, newLevelIterator(db.levels[level]))
+		}
+	}
+	return newMergeIterator(iters)
+}
+
+// ForEach 按键的顺序遍历所有的键值，consumer 返回 false 时停止
+// 每次持有读锁取出一批记录，在锁外调用 consumer，所以 consumer 里也可以写入；遍历期间的写入可能看得到也可能看不到
+func (db *DB) ForEach(consumer func(key string, val []byte) bool) error {
+	start := ""
+	for {
+		keys := make([]string, 0, scanChunk)
+		vals := make([][]byte, 0, scanChunk)
+		db.mu.RLock()
+		if db.closed {
+			db.mu.RUnlock()
+			return ErrClosed
+		}
+		it := db.newIteratorLocked()
+		for it.seek(start); it.valid() && len(keys) < scanChunk; it.next() {
+			if !it.deleted() {
+				keys = append(keys, it.key())
+				vals = append(vals, bytes.Clone(it.value()))
+			}
+		}
+		err := it.err()
+		db.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			if !consumer(key, vals[i]) {
+				return nil
+			}
+		}
+		if len(keys) < scanChunk {
+			return nil
+		}
+		start = keys[len(keys)-1] + "\x00" // 比最后一个键大的最小的键
+	}
+}
+
+/* ---- 写 ----- */
+
+// 写入的条件
+const (
+	putAlways = iota
+	putIfAbsent
+	putIfExists
+)
+
+// Put 写入键值，返回键是否是新插入的
+func (db *DB) Put(key string, val []byte) (bool, error) {
+	existed, _, err := db.put(key, val, putAlways)
+	return !existed, err
+}
+
+// PutIfAbsent 键不存在时写入，返回是否写入了
+func (db *DB) PutIfAbsent(key string, val []byte) (bool, error) {
+	_, written, err := db.put(key, val, putIfAbsent)
+	return written, err
+}
+
+// PutIfExists 键存在时写入，返回是否写入了
+func (db *DB) PutIfExists(key string, val []byte) (bool, error) {
+	_, written, err := db.put(key, val, putIfExists)
+	return written, err
+}
+
+// put 按条件写入，返回键原来是否存在以及是否写入了
+func (db *DB) put(key string, val []byte, cond int) (bool, bool, error) {
+	if len(key) > maxKeySize {
+		return false, false, errors.New("lsm: key too large")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return false, false, ErrClosed
+	}
+	existed, oldLen, err := db.existsLocked(key)
+	if err != nil {
+		return false, false, err
+	}
+	if (cond == putIfAbsent && existed) || (cond == putIfExists && !existed) {
+		return existed, false, nil
+	}
+	if err := db.log.append(walOpPut, key, val); err != nil {
+		return existed, false, err
+	}
+	db.applyPut(key, bytes.Clone(val), existed, oldLen)
+	return existed, true, db.maybeFlushLocked()
+}
+
+// Delete 删除键，返回键原来是否存在
+func (db *DB) Delete(key string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return false, ErrClosed
+	}
+	existed, oldLen, err := db.existsLocked(key)
+	if err != nil || !existed {
+		return false, err
+	}
+	if err := db.log.append(walOpDelete, key, nil); err != nil {
+		return true, err
+	}
+	db.applyDelete(key, oldLen)
+	return true, db.maybeFlushLocked()
+}
+
+// applyPut 把写入应用到内存表并更新统计（调用者需要持有写锁），oldLen 是原来的值的长度
+func (db *DB) applyPut(key string, val []byte, existed bool, oldLen int) {
+	if existed {
+		db.bytes += int64(len(val) - oldLen)
+	} else {
+		db.count++
+		db.bytes += int64(len(key) + len(val))
+	}
+	db.mem.put(key, val, false)
+}
+
+// applyDelete 在内存表中写入墓碑并更新统计（调用者需要持有写锁并确认键存在）
+func (db *DB) applyDelete(key string, oldLen int) {
+	db.count--
+	db.bytes -= int64(len(key) + oldLen)
+	db.mem.put(key, nil, true)
+}
+
+// maybeFlushLocked 内存表写满时刷盘
+func (db *DB) maybeFlushLocked() error {
+	if db.mem.size < db.opts.MemtableSize {
+		return nil
+	}
+	return db.flushLocked()
+}
+
+// flushLocked 把内存表刷成第 0 层的 SSTable，换一个新的日志，然后重写 manifest
+func (db *DB) flushLocked() error {
+	if db.mem.count > 0 {
+		num := db.nextFile
+		db.nextFile++
+		tw, err := newTableWriter(db.path(num, tableExt), db.opts.BlockSize, db.opts.BloomBitsPerKey)
+		if err != nil {
+			return err
+		}
+		for node := db.mem.head.next[0]; node != nil; node = node.next[0] {
+			if err := tw.add(node.key, node.val, node.deleted); err != nil {
+				tw.abort()
+				return err
+			}
+		}
+		if err := tw.finish(); err != nil {
+			_ = os.Remove(db.path(num, tableExt))
+			return err
+		}
+		t, err := openTable(db.path(num, tableExt), num)
+		if err != nil {
+			return err
+		}
+		t.entries = db.mem.count
+		db.levels[0] = append([]*table{t}, db.levels[0]...)
+		db.mem = newMemtable()
+	}
+	oldLog := db.log
+	walNum := db.nextFile
+	db.nextFile++
+	log, err := openWAL(db.path(walNum, walExt), db.opts.SyncWrites)
+	if err != nil {
+		return err
+	}
+	db.log, db.walNum = log, walNum
+	db.flushedCount, db.flushedBytes = db.count, db.bytes
+	if err := db.writeManifestLocked(); err != nil {
+		return err
+	}
+	if oldLog != nil {
+		_ = oldLog.close()
+	}
+	_, wals, err := db.listFiles()
+	if err == nil {
+		for _, num := range wals {
+			if num < walNum {
+				_ = os.Remove(db.path(num, walExt))
+			}
+		}
+	}
+	db.scheduleCompaction()
+	return nil
+}
+
+// writeManifestLocked 把当前的文件列表写进 manifest
+func (db *DB) writeManifestLocked() error {
+	m := &manifest{
+		NextFile: db.nextFile,
+		WAL:      db.walNum,
+		Count:    db.flushedCount,
+		Bytes:    db.flushedBytes,
+		Levels:   make([][]tableMeta, numLevels),
+	}
+	for level, tables := range db.levels {
+		m.Levels[level] = make([]tableMeta, 0, len(tables))
+		for _, t := range tables {
+			m.Levels[level] = append(m.Levels[level], tableMeta{Num: t.num, Entries: t.entries})
+		}
+	}
+	return writeManifest(db.dir, m)
+}
+
+// Flush 把内存表刷成 SSTable
+func (db *DB) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return db.flushLocked()
+}
+
+// Clear 删除所有的数据
+func (db *DB) Clear() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	db.generation++
+	var old []*table
+	for level, tables := range db.levels {
+		old = append(old, tables...)
+		db.levels[level] = nil
+	}
+	db.mem = newMemtable()
+	db.count, db.bytes = 0, 0
+	// 和合并一样先写 manifest 再删除文件：换一个空的日志并写入空的 manifest，
+	// 中途崩溃时旧的 manifest 引用的文件都还在
+	if err := db.flushLocked(); err != nil {
+		return err
+	}
+	db.removeTables(old)
+	return nil
+}
+
+// Stats 返回统计信息
+func (db *DB) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	stats := Stats{
+		Keys:          db.count,
+		Bytes:         db.bytes,
+		MemtableBytes: db.mem.size,
+		LevelTables:   make([]int, numLevels),
+		LevelBytes:    make([]int64, numLevels),
+		Compactions:   db.compactions,
+	}
+	for level, tables := range db.levels {
+		stats.LevelTables[level] = len(tables)
+		stats.LevelBytes[level] = levelSize(tables)
+	}
+	return stats
+}
+
+// Close 把内存表刷盘，等待后台合并结束，关闭所有的文件
+func (db *DB) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	err := db.flushLocked() // 下次打开时不需要回放日志
+	db.closed = true
+	db.mu.Unlock()
+	close(db.closing)
+	db.wg.Wait()
+	if db.log != nil {
+		if closeErr := db.log.close(); err == nil {
+			err = closeErr
+		}
+	}
+	db.closeTables()
+	return err
+}
+
+// closeTables 关闭所有的 SSTable
+func (db *DB) closeTables() {
+	for _, tables := range db.levels {
+		for _, t := range tables {
+			t.close()
+		}
+	}
+}
+
+// levelSize 返回一层的文件大小
+func levelSize(tables []*table) int64 {
+	var size int64
+	for _, t := range tables {
+		size += t.size
+	}
+	return size
+}
@@ -0,0 +1,280 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smallOptions 很小的内存表和文件，少量数据就会触发刷盘和合并
+var smallOptions = Options{
+	MemtableSize:        4 << 10,
+	BlockSize:           256,
+	L0CompactionTrigger: 2,
+	LevelBaseSize:       16 << 10,
+	LevelMultiplier:     4,
+	TableSize:           8 << 10,
+}
+
+func key(i int) string {
+	return fmt.Sprintf("key:%05d", i)
+}
+
+func TestPutGetDelete(t *testing.T) {
+	db, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if inserted, _ := db.Put("a", []byte("1")); !inserted {
+		t.Error("a should be new")
+	}
+	if inserted, _ := db.Put("a", []byte("22")); inserted {
+		t.Error("a should be overwritten")
+	}
+	if ok, _ := db.PutIfAbsent("a", []byte("3")); ok {
+		t.Error("PutIfAbsent should not overwrite")
+	}
+	if ok, _ := db.PutIfExists("b", []byte("3")); ok {
+		t.Error("PutIfExists should not insert")
+	}
+	if val, ok, err := db.Get("a"); err != nil || !ok || string(val) != "22" {
+		t.Errorf("expected 22, got %q %v %v", val, ok, err)
+	}
+	if db.Len() != 1 || db.Bytes() != 3 {
+		t.Errorf("unexpected len %d bytes %d", db.Len(), db.Bytes())
+	}
+	if ok, _ := db.Delete("a"); !ok {
+		t.Error("delete failed")
+	}
+	if ok, _ := db.Delete("a"); ok {
+		t.Error("a should be deleted already")
+	}
+	if _, ok, _ := db.Get("a"); ok || db.Len() != 0 || db.Bytes() != 0 {
+		t.Error("a should not exist")
+	}
+}
+
+func TestFlushAndCompaction(t *testing.T) {
+	db, err := Open(t.TempDir(), smallOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	value := []byte(strings.Repeat("v", 100))
+	for round := 0; round < 3; round++ { // 反复覆盖，合并时要丢掉旧的版本
+		for i := 0; i < 1000; i++ {
+			if _, err := db.Put(key(i), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 0; i < 1000; i += 2 {
+		_, _ = db.Delete(key(i))
+	}
+	waitCompaction(t, db)
+	stats := db.Stats()
+	if stats.Compactions == 0 || stats.LevelTables[1] == 0 {
+		t.Fatalf("expected compactions, got %+v", stats)
+	}
+	if db.Len() != 500 {
+		t.Errorf("expected 500 keys, got %d", db.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		_, ok, err := db.Get(key(i))
+		if err != nil || ok != (i%2 == 1) {
+			t.Fatalf("unexpected get %s: %v %v", key(i), ok, err)
+		}
+	}
+	var keys []string
+	if err := db.ForEach(func(key string, val []byte) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 500 || keys[0] != key(1) || keys[499] != key(999) {
+		t.Errorf("unexpected keys %d", len(keys))
+	}
+}
+
+// waitCompaction 等待后台合并把第 0 层降到阈值以下
+func waitCompaction(t *testing.T, db *DB) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		db.mu.Lock()
+		c := db.pickCompactionLocked()
+		db.mu.Unlock()
+		if c == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("compaction did not finish")
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, smallOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		_, _ = db.Put(key(i), []byte(key(i)))
+	}
+	_, _ = db.Delete(key(0))
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(dir, smallOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Len() != 499 {
+		t.Errorf("expected 499 keys, got %d", db.Len())
+	}
+	if val, ok, _ := db.Get(key(42)); !ok || string(val) != key(42) {
+		t.Errorf("unexpected value %q", val)
+	}
+	if _, ok, _ := db.Get(key(0)); ok {
+		t.Error("deleted key came back")
+	}
+}
+
+func TestReplayWAL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = db.Put("a", []byte("1"))
+	_, _ = db.Put("b", []byte("2"))
+	_, _ = db.Delete("a")
+	// 不调用 Close，模拟进程崩溃：数据只在日志里
+	db.closed = true
+	close(db.closing)
+	db.wg.Wait()
+	_ = db.log.close()
+	db.closeTables()
+
+	// 日志末尾写了一半的记录要被丢掉
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+walExt))
+	f, err := os.OpenFile(matches[len(matches)-1], os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{1, 2, 3})
+	_ = f.Close()
+
+	db, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, ok, _ := db.Get("a"); ok {
+		t.Error("a should be deleted")
+	}
+	if val, ok, _ := db.Get("b"); !ok || string(val) != "2" || db.Len() != 1 {
+		t.Errorf("unexpected b %q, len %d", val, db.Len())
+	}
+}
+
+func TestClear(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, smallOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		_, _ = db.Put(key(i), []byte(key(i)))
+	}
+	if err := db.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := db.Get(key(1)); ok || db.Len() != 0 {
+		t.Error("clear failed")
+	}
+	// 清空时还在进行的合并结束后会删除自己的输出，关闭时等待它结束
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+tableExt))
+	if len(matches) != 0 {
+		t.Errorf("tables left after clear: %v", matches)
+	}
+	// manifest 已经是空的，重新打开以后还是空的
+	db, err = Open(dir, smallOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Len() != 0 {
+		t.Errorf("expected an empty db after reopen, got %d keys", db.Len())
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	var hashes []uint64
+	for i := 0; i < 1000; i++ {
+		hashes = append(hashes, bloomHash(key(i)))
+	}
+	f := decodeBloomFilter(newBloomFilter(hashes, 10).encode())
+	for _, h := range hashes {
+		if !f.mayContain(h) {
+			t.Fatal("false negative")
+		}
+	}
+	falsePositives := 0
+	for i := 1000; i < 11000; i++ {
+		if f.mayContain(bloomHash(key(i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 { // 10 位每个键大约 1%
+		t.Errorf("too many false positives: %d", falsePositives)
+	}
+}
+
+func TestSampleKeys(t *testing.T) {
+	db, err := Open(t.TempDir(), smallOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if keys, err := db.SampleKeys(5); err != nil || len(keys) != 0 {
+		t.Errorf("empty db should give no samples, got %v %v", keys, err)
+	}
+	for i := 0; i < 1000; i++ {
+		_, _ = db.Put(key(i), []byte("value"))
+	}
+	for i := 0; i < 1000; i += 2 { // 一半是墓碑，不能被选中
+		_, _ = db.Delete(key(i))
+	}
+	tables := 0
+	for _, n := range db.Stats().LevelTables {
+		tables += n
+	}
+	if tables == 0 {
+		t.Fatal("expected flushed tables")
+	}
+	keys, err := db.SampleKeys(2000)
+	if err != nil || len(keys) != 2000 {
+		t.Fatalf("expected 2000 samples, got %d %v", len(keys), err)
+	}
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		var i int
+		if _, err := fmt.Sscanf(k, "key:%d", &i); err != nil || i%2 == 0 {
+			t.Fatalf("sampled deleted or unknown key %q", k)
+		}
+		seen[k] = true
+	}
+	// 500 个键取样 2000 次，近似均匀时大部分键都会被选中
+	if len(seen) < 300 {
+		t.Errorf("samples are not spread out: %d distinct keys", len(seen))
+	}
+}
@@ -0,0 +1,70 @@
+package lsm
+
+/*
+ * manifest 记录每一层有哪些 SSTable、当前的日志文件以及刷盘时的键的个数
+ * 每次刷盘和合并以后整体重写：先写临时文件再改名，保证崩溃时看到的是完整的旧版本或新版本
+ */
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+const manifestName = "MANIFEST"
+
+// tableMeta manifest 中的一个 SSTable
+type tableMeta struct {
+	Num     uint64 `json:"num"`
+	Entries int    `json:"entries"`
+}
+
+// manifest 持久化的元数据
+type manifest struct {
+	NextFile uint64        `json:"next_file"` // 下一个文件的编号，SSTable 和日志共用
+	WAL      uint64        `json:"wal"`       // 当前日志文件的编号，编号不小于它的日志都需要回放
+	Count    int64         `json:"count"`     // 已经刷成 SSTable 的数据中键的个数
+	Bytes    int64         `json:"bytes"`     // 已经刷成 SSTable 的数据中键值的总长度
+	Levels   [][]tableMeta `json:"levels"`    // 第 0 层从新到旧，其他层按键排序
+}
+
+// readManifest 读出 manifest，文件不存在时返回 nil
+func readManifest(dir string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeManifest 原子地重写 manifest
+func writeManifest(dir string, m *manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, manifestName))
+}
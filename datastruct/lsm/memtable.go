@@ -0,0 +1,111 @@
+package lsm
+
+/*
+ * 内存表：按键排序的跳表，写入先进内存表，写满以后整个刷成一个 SSTable
+ * 删除也是一次写入（墓碑），刷盘和合并时用来覆盖更老的文件里的值
+ * 内存表本身不加锁，由 DB 的锁保护
+ */
+
+import (
+	"math/rand"
+)
+
+const (
+	maxHeight    = 12
+	nodeOverhead = 48 // 一个节点除了键值和指针以外的大致开销
+)
+
+// memNode 跳表的节点
+type memNode struct {
+	key     string
+	val     []byte
+	deleted bool
+	next    []*memNode
+}
+
+// memtable 按键排序的跳表
+type memtable struct {
+	head   *memNode
+	height int
+	size   int64 // 估算占用的内存，超过 MemtableSize 时刷盘
+	count  int   // 节点的个数（包括墓碑）
+}
+
+func newMemtable() *memtable {
+	return &memtable{
+		head:   &memNode{next: make([]*memNode, maxHeight)},
+		height: 1,
+	}
+}
+
+// randomHeight 每一层以 1/4 的概率继续往上
+func randomHeight() int {
+	h := 1
+	for h < maxHeight && rand.Intn(4) == 0 {
+		h++
+	}
+	return h
+}
+
+// findGreaterOrEqual 返回第一个键不小于 key 的节点，prev 不为空时记录每一层的前驱
+func (m *memtable) findGreaterOrEqual(key string, prev []*memNode) *memNode {
+	x := m.head
+	for level := m.height - 1; level >= 0; level-- {
+		for x.next[level] != nil && x.next[level].key < key {
+			x = x.next[level]
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+	}
+	return x.next[0]
+}
+
+// put 写入键值或墓碑，键已经存在时覆盖
+func (m *memtable) put(key string, val []byte, deleted bool) {
+	prev := make([]*memNode, maxHeight)
+	node := m.findGreaterOrEqual(key, prev)
+	if node != nil && node.key == key {
+		m.size += int64(len(val) - len(node.val))
+		node.val = val
+		node.deleted = deleted
+		return
+	}
+	height := randomHeight()
+	if height > m.height {
+		for level := m.height; level < height; level++ {
+			prev[level] = m.head
+		}
+		m.height = height
+	}
+	node = &memNode{key: key, val: val, deleted: deleted, next: make([]*memNode, height)}
+	for level := 0; level < height; level++ {
+		node.next[level] = prev[level].next[level]
+		prev[level].next[level] = node
+	}
+	m.size += int64(len(key)+len(val)+8*height) + nodeOverhead
+	m.count++
+}
+
+// get 查找键，found 表示内存表里有这个键（可能是墓碑）
+func (m *memtable) get(key string) (val []byte, deleted bool, found bool) {
+	node := m.findGreaterOrEqual(key, nil)
+	if node == nil || node.key != key {
+		return nil, false, false
+	}
+	return node.val, node.deleted, true
+}
+
+// memIterator 内存表的迭代器
+type memIterator struct {
+	m    *memtable
+	node *memNode
+}
+
+func (it *memIterator) seek(key string) { it.node = it.m.findGreaterOrEqual(key, nil) }
+func (it *memIterator) valid() bool     { return it.node != nil }
+func (it *memIterator) key() string     { return it.node.key }
+func (it *memIterator) value() []byte   { return it.node.val }
+func (it *memIterator) deleted() bool   { return it.node.deleted }
+func (it *memIterator) next()           { it.node = it.node.next[0] }
+func (it *memIterator) err() error      { return nil }
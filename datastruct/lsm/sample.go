@@ -0,0 +1,103 @@
+package lsm
+
+/*
+ * 随机取样：淘汰、RANDOMKEY、MEMORY DOCTOR 只需要几个随机的键，不能为此遍历整个引擎
+ * 按记录个数在内存表和所有的 SSTable 之间随机选一个位置，定位到它所在的数据块，再往后跳过块内的偏移
+ * 每个数据块里的记录个数差不多，所以样本近似均匀；墓碑和被新版本覆盖的记录会落到它后面的第一个键上
+ */
+
+import (
+	"math/rand"
+)
+
+// SampleKeys 随机返回 n 个键（可能重复），每个样本只需要读几个数据块
+func (db *DB) SampleKeys(n int) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	result := make([]string, 0, n)
+	if db.count == 0 || n <= 0 {
+		return result, nil
+	}
+	var tables []*table
+	total := db.mem.count
+	for _, level := range db.levels {
+		for _, t := range level {
+			tables = append(tables, t)
+			total += tableWeight(t)
+		}
+	}
+	if total == 0 {
+		return result, nil
+	}
+	for len(result) < n {
+		start, skip := db.samplePointLocked(tables, rand.Intn(total))
+		key, err := db.keyAfterLocked(start, skip)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, key)
+	}
+	return result, nil
+}
+
+// tableWeight 取样时 SSTable 的权重，也就是记录的个数
+func tableWeight(t *table) int {
+	return max(t.entries, len(t.index))
+}
+
+// samplePointLocked 把第 r 条记录换成起点和起点之后要跳过的记录个数
+func (db *DB) samplePointLocked(tables []*table, r int) (string, int) {
+	if r < db.mem.count { // 内存表最多 MemtableSize，沿着最底层走过去的代价是固定的
+		node := db.mem.head.next[0]
+		for ; r > 0 && node.next[0] != nil; r-- {
+			node = node.next[0]
+		}
+		return node.key, 0
+	}
+	r -= db.mem.count
+	for _, t := range tables {
+		weight := tableWeight(t)
+		if r >= weight {
+			r -= weight
+			continue
+		}
+		if len(t.index) == 0 {
+			return "", 0
+		}
+		perBlock := max(1, weight/len(t.index))
+		block := min(r/perBlock, len(t.index)-1)
+		start := t.smallest
+		if block > 0 {
+			start = t.index[block-1].lastKey + "\x00" // 比上一个块的最后一个键大的最小的键
+		}
+		return start, r - block*perBlock
+	}
+	return "", 0
+}
+
+// keyAfterLocked 返回 start 之后第 skip 个存在的键，走到末尾时用最后一个看到的键，一个都没有时从头开始
+func (db *DB) keyAfterLocked(start string, skip int) (string, error) {
+	it := db.newIteratorLocked()
+	var key string
+	found := false
+	for it.seek(start); it.valid(); it.next() {
+		if it.deleted() {
+			continue
+		}
+		key, found = it.key(), true
+		if skip == 0 {
+			break
+		}
+		skip--
+	}
+	if err := it.err(); err != nil {
+		return "", err
+	}
+	if found || start == "" {
+		return key, nil
+	}
+	return db.keyAfterLocked("", 0)
+}
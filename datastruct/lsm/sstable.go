@@ -0,0 +1,388 @@
+package lsm
+
+/*
+ * SSTable：按键排序、写完就不再修改的文件
+ *
+ * 布局: <数据块> ... <数据块> <布隆过滤器> <索引块> <footer 40 字节>
+ * 数据块: 若干条记录 + crc32，每条记录为 <标记 1 字节> <key 长度 uvarint> <value 长度 uvarint> <key> <value>
+ * 索引块: 每个数据块一项 <最后一个键的长度 uvarint> <最后一个键> <偏移 uvarint> <大小 uvarint>
+ * footer: <索引偏移> <索引大小> <过滤器偏移> <过滤器大小> <魔数>，都是 8 字节小端
+ */
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"sort"
+)
+
+const (
+	footerSize   = 40
+	tableMagic   = 0x4753_4D54_4142_4C45 // "GSMTABLE"
+	flagValue    = 0
+	flagDeleted  = 1
+	blockCRCSize = 4
+)
+
+// errCorrupted 文件内容校验失败
+var errCorrupted = errors.New("lsm: corrupted table")
+
+// indexEntry 索引块中的一项，指向一个数据块
+type indexEntry struct {
+	lastKey string
+	offset  uint64
+	size    uint64 // 包括末尾的 crc32
+}
+
+/* ---- 写 ----- */
+
+// tableWriter 按键的顺序写入一个 SSTable
+type tableWriter struct {
+	file       *os.File
+	w          *bufio.Writer
+	offset     uint64
+	blockSize  int
+	bitsPerKey int
+
+	block    []byte
+	lastKey  string
+	index    []indexEntry
+	hashes   []uint64
+	entries  int
+	smallest string
+}
+
+func newTableWriter(path string, blockSize int, bitsPerKey int) (*tableWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{
+		file:       file,
+		w:          bufio.NewWriter(file),
+		blockSize:  blockSize,
+		bitsPerKey: bitsPerKey,
+	}, nil
+}
+
+// add 追加一条记录，键必须比前一条大
+func (tw *tableWriter) add(key string, val []byte, deleted bool) error {
+	if tw.entries == 0 {
+		tw.smallest = key
+	}
+	flag := byte(flagValue)
+	if deleted {
+		flag = flagDeleted
+	}
+	tw.block = append(tw.block, flag)
+	tw.block = binary.AppendUvarint(tw.block, uint64(len(key)))
+	tw.block = binary.AppendUvarint(tw.block, uint64(len(val)))
+	tw.block = append(tw.block, key...)
+	tw.block = append(tw.block, val...)
+	tw.lastKey = key
+	tw.hashes = append(tw.hashes, bloomHash(key))
+	tw.entries++
+	if len(tw.block) >= tw.blockSize {
+		return tw.flushBlock()
+	}
+	return nil
+}
+
+// size 返回已经写入的大小（不包括还在缓冲的块）
+func (tw *tableWriter) size() uint64 {
+	return tw.offset + uint64(len(tw.block))
+}
+
+// flushBlock 写出当前数据块并记录索引
+func (tw *tableWriter) flushBlock() error {
+	if len(tw.block) == 0 {
+		return nil
+	}
+	tw.block = binary.LittleEndian.AppendUint32(tw.block, crc32.ChecksumIEEE(tw.block))
+	if _, err := tw.w.Write(tw.block); err != nil {
+		return err
+	}
+	tw.index = append(tw.index, indexEntry{lastKey: tw.lastKey, offset: tw.offset, size: uint64(len(tw.block))})
+	tw.offset += uint64(len(tw.block))
+	tw.block = tw.block[:0]
+	return nil
+}
+
+// finish 写出过滤器、索引和 footer，刷盘并关闭文件
+func (tw *tableWriter) finish() error {
+	if err := tw.flushBlock(); err != nil {
+		_ = tw.file.Close()
+		return err
+	}
+	bloom := newBloomFilter(tw.hashes, tw.bitsPerKey).encode()
+	bloomOffset := tw.offset
+	var index []byte
+	for _, e := range tw.index {
+		index = binary.AppendUvarint(index, uint64(len(e.lastKey)))
+		index = append(index, e.lastKey...)
+		index = binary.AppendUvarint(index, e.offset)
+		index = binary.AppendUvarint(index, e.size)
+	}
+	indexOffset := bloomOffset + uint64(len(bloom))
+	footer := make([]byte, 0, footerSize)
+	footer = binary.LittleEndian.AppendUint64(footer, indexOffset)
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(index)))
+	footer = binary.LittleEndian.AppendUint64(footer, bloomOffset)
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(bloom)))
+	footer = binary.LittleEndian.AppendUint64(footer, tableMagic)
+	for _, part := range [][]byte{bloom, index, footer} {
+		if _, err := tw.w.Write(part); err != nil {
+			_ = tw.file.Close()
+			return err
+		}
+	}
+	if err := tw.w.Flush(); err != nil {
+		_ = tw.file.Close()
+		return err
+	}
+	if err := tw.file.Sync(); err != nil {
+		_ = tw.file.Close()
+		return err
+	}
+	return tw.file.Close()
+}
+
+// abort 放弃写了一半的文件
+func (tw *tableWriter) abort() {
+	_ = tw.file.Close()
+	_ = os.Remove(tw.file.Name())
+}
+
+/* ---- 读 ----- */
+
+// table 打开的 SSTable，索引和布隆过滤器常驻内存
+type table struct {
+	num      uint64
+	file     *os.File
+	size     int64
+	entries  int // 记录的个数（包括墓碑），写文件时记在 manifest 里
+	index    []indexEntry
+	bloom    bloomFilter
+	smallest string
+	largest  string
+}
+
+// openTable 打开文件，读出 footer、索引和过滤器
+func openTable(path string, num uint64) (*table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := loadTable(file, num)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func loadTable(file *os.File, num uint64) (*table, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < footerSize {
+		return nil, errCorrupted
+	}
+	footer := make([]byte, footerSize)
+	if _, err := file.ReadAt(footer, info.Size()-footerSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(footer[32:]) != tableMagic {
+		return nil, errCorrupted
+	}
+	indexOffset := binary.LittleEndian.Uint64(footer[0:])
+	indexLen := binary.LittleEndian.Uint64(footer[8:])
+	bloomOffset := binary.LittleEndian.Uint64(footer[16:])
+	bloomLen := binary.LittleEndian.Uint64(footer[24:])
+	if indexOffset+indexLen > uint64(info.Size()) || bloomOffset+bloomLen > indexOffset {
+		return nil, errCorrupted
+	}
+	raw := make([]byte, bloomLen+indexLen)
+	if _, err := file.ReadAt(raw, int64(bloomOffset)); err != nil {
+		return nil, err
+	}
+	t := &table{num: num, file: file, size: info.Size(), bloom: decodeBloomFilter(raw[:bloomLen])}
+	buf := raw[bloomLen:]
+	for len(buf) > 0 {
+		var e indexEntry
+		keyLen, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < keyLen {
+			return nil, errCorrupted
+		}
+		e.lastKey = string(buf[n : n+int(keyLen)])
+		buf = buf[n+int(keyLen):]
+		if e.offset, n = binary.Uvarint(buf); n <= 0 {
+			return nil, errCorrupted
+		}
+		buf = buf[n:]
+		if e.size, n = binary.Uvarint(buf); n <= 0 {
+			return nil, errCorrupted
+		}
+		buf = buf[n:]
+		t.index = append(t.index, e)
+	}
+	if len(t.index) > 0 {
+		t.largest = t.index[len(t.index)-1].lastKey
+		it := t.iterator()
+		it.seekToFirst()
+		if it.err() != nil {
+			return nil, it.err()
+		}
+		if it.valid() {
+			t.smallest = it.key()
+		}
+	}
+	return t, nil
+}
+
+// readBlock 读出第 i 个数据块并校验
+func (t *table) readBlock(i int) ([]byte, error) {
+	e := t.index[i]
+	if e.size < blockCRCSize {
+		return nil, errCorrupted
+	}
+	buf := make([]byte, e.size)
+	if _, err := t.file.ReadAt(buf, int64(e.offset)); err != nil {
+		return nil, err
+	}
+	data := buf[:len(buf)-blockCRCSize]
+	if binary.LittleEndian.Uint32(buf[len(data):]) != crc32.ChecksumIEEE(data) {
+		return nil, errCorrupted
+	}
+	return data, nil
+}
+
+// decodeEntry 解码块中的一条记录，返回剩下的部分
+func decodeEntry(block []byte) (key string, val []byte, deleted bool, rest []byte, err error) {
+	if len(block) < 1 {
+		return "", nil, false, nil, errCorrupted
+	}
+	deleted = block[0] == flagDeleted
+	block = block[1:]
+	keyLen, n := binary.Uvarint(block)
+	if n <= 0 {
+		return "", nil, false, nil, errCorrupted
+	}
+	block = block[n:]
+	valLen, n := binary.Uvarint(block)
+	if n <= 0 || uint64(len(block)-n) < keyLen+valLen {
+		return "", nil, false, nil, errCorrupted
+	}
+	block = block[n:]
+	key = string(block[:keyLen])
+	val = block[keyLen : keyLen+valLen]
+	return key, val, deleted, block[keyLen+valLen:], nil
+}
+
+// overlaps 返回文件的键范围是否和 [smallest, largest] 有交集
+func (t *table) overlaps(smallest, largest string) bool {
+	return t.largest >= smallest && t.smallest <= largest
+}
+
+// get 在文件中查找键，found 表示文件里有这个键（可能是墓碑）
+func (t *table) get(key string, hash uint64) (val []byte, deleted bool, found bool, err error) {
+	if key < t.smallest || key > t.largest || !t.bloom.mayContain(hash) {
+		return nil, false, false, nil
+	}
+	i := sort.Search(len(t.index), func(i int) bool { return t.index[i].lastKey >= key })
+	if i == len(t.index) {
+		return nil, false, false, nil
+	}
+	block, err := t.readBlock(i)
+	if err != nil {
+		return nil, false, false, err
+	}
+	for len(block) > 0 {
+		var k string
+		if k, val, deleted, block, err = decodeEntry(block); err != nil {
+			return nil, false, false, err
+		}
+		if k == key {
+			return val, deleted, true, nil
+		}
+		if k > key {
+			break
+		}
+	}
+	return nil, false, false, nil
+}
+
+// close 关闭文件
+func (t *table) close() {
+	_ = t.file.Close()
+}
+
+// tableIterator SSTable 的迭代器
+type tableIterator struct {
+	t        *table
+	blockIdx int
+	block    []byte // 当前块中还没有读的部分
+	curKey   string
+	curVal   []byte
+	curDel   bool
+	ok       bool
+	e        error
+}
+
+func (t *table) iterator() *tableIterator {
+	return &tableIterator{t: t}
+}
+
+// loadBlock 从第 i 个块开始，定位到第一条记录
+func (it *tableIterator) loadBlock(i int) {
+	it.ok = false
+	for ; i < len(it.t.index); i++ {
+		block, err := it.t.readBlock(i)
+		if err != nil {
+			it.e = err
+			return
+		}
+		if len(block) > 0 {
+			it.blockIdx = i
+			it.block = block
+			it.next()
+			return
+		}
+	}
+}
+
+func (it *tableIterator) seekToFirst() {
+	it.loadBlock(0)
+}
+
+func (it *tableIterator) seek(key string) {
+	i := sort.Search(len(it.t.index), func(i int) bool { return it.t.index[i].lastKey >= key })
+	it.loadBlock(i)
+	for it.ok && it.curKey < key {
+		it.next()
+	}
+}
+
+func (it *tableIterator) next() {
+	if len(it.block) == 0 {
+		it.loadBlock(it.blockIdx + 1)
+		return
+	}
+	var err error
+	it.curKey, it.curVal, it.curDel, it.block, err = decodeEntry(it.block)
+	if err != nil {
+		it.e = err
+		it.ok = false
+		return
+	}
+	it.ok = true
+}
+
+func (it *tableIterator) valid() bool   { return it.ok }
+func (it *tableIterator) key() string   { return it.curKey }
+func (it *tableIterator) value() []byte { return it.curVal }
+func (it *tableIterator) deleted() bool { return it.curDel }
+func (it *tableIterator) err() error    { return it.e }
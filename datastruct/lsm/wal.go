@@ -0,0 +1,100 @@
+package lsm
+
+/*
+ * 预写日志：写入内存表之前先追加到日志，重启时回放还没有刷成 SSTable 的写入
+ * 每条记录: <crc32 4 字节> <payload 长度 4 字节> <payload>
+ * payload: <操作 1 字节> <key 长度 uvarint> <key> <value>
+ */
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	walHeaderSize = 8
+	walOpPut      = 0
+	walOpDelete   = 1
+)
+
+// wal 当前内存表对应的日志文件
+type wal struct {
+	file *os.File
+	sync bool // 每次写入后刷盘
+}
+
+// openWAL 打开日志文件用于追加
+func openWAL(path string, sync bool) (*wal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{file: file, sync: sync}, nil
+}
+
+// append 追加一条记录
+func (w *wal) append(op byte, key string, val []byte) error {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64+len(key)+len(val))
+	payload = append(payload, op)
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, val...)
+	record := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(payload)))
+	record = append(record, payload...)
+	if _, err := w.file.Write(record); err != nil {
+		return err
+	}
+	if w.sync {
+		return w.file.Sync()
+	}
+	return nil
+}
+
+// close 刷盘并关闭
+func (w *wal) close() error {
+	if err := w.file.Sync(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// replayWAL 按顺序回放日志中的记录，返回最后一条完整记录结束的位置
+// 进程在写日志时崩溃会留下不完整的尾部，回放到那里为止
+func replayWAL(path string, apply func(op byte, key string, val []byte) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var offset int64
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return offset, nil // 文件结尾或者不完整的头部
+		}
+		payload := make([]byte, binary.LittleEndian.Uint32(header[4:8]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, nil
+		}
+		if binary.LittleEndian.Uint32(header[0:4]) != crc32.ChecksumIEEE(payload) || len(payload) < 1 {
+			return offset, nil
+		}
+		keyLen, n := binary.Uvarint(payload[1:])
+		if n <= 0 || uint64(len(payload)-1-n) < keyLen {
+			return offset, nil
+		}
+		key := string(payload[1+n : 1+n+int(keyLen)])
+		val := payload[1+n+int(keyLen):]
+		if err := apply(payload[0], key, val); err != nil {
+			return offset, err
+		}
+		offset += int64(walHeaderSize + len(payload))
+	}
+}
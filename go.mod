@@ -1 +1,3 @@
 module GoMiniCache
+
+go 1.24
@@ -18,4 +18,9 @@ type Database interface {
 // DataEntity 指代 Redis 的数据结构，包括 string, list, hash, set 等等
 type DataEntity struct {
 	Data interface{}
+	Size int64 // 估算的值占用的内存（字节），存入数据库时计算，删除时按它归还
+
+	// 下面两个字段用于内存淘汰，并发读写时需要使用原子操作
+	AccessTime int64  // 最近一次访问的时间（Unix 毫秒），LRU 淘汰使用
+	LFUCounter uint32 // 对数访问计数器（0-255），LFU 淘汰使用
 }
@@ -0,0 +1,16 @@
+package persistence
+
+/*
+ * 对持久化存储介质的抽象（AOF 的命令日志可以写到文件、内存或者分段的日志文件里）
+ */
+
+import "io"
+
+// Backend 存放序列化好的命令日志
+type Backend interface {
+	Append(data []byte) error                    // 在末尾追加数据
+	Sync() error                                 // 把已经追加的数据刷到存储介质上
+	Rewrite(write func(w io.Writer) error) error // 用 write 写出的内容替换掉全部旧内容
+	Load() (io.ReadCloser, error)                // 从头读取全部内容，用于回放
+	Close() error                                // 关闭
+}
@@ -9,4 +9,20 @@ type Connection interface {
 	Write([]byte) error // 向客户端写消息
 	GetDBIndex() int    // 获取数据库编号
 	SelectDB(int)       // 选择数据库编号
+	GetID() int64       // 客户端编号
+	GetProtocol() int   // RESP 协议版本，2 或者 3
+	SetProtocol(int)    // HELLO 切换协议版本
+	GetName() string    // CLIENT SETNAME 或者 HELLO SETNAME 设置的名称
+	SetName(string)
+	IsAuthenticated() bool // 设置了 requirepass 时，是否已经通过了 AUTH 或者 HELLO AUTH
+	SetAuthenticated(bool)
+
+	// 发布订阅：订阅了频道或者模式的连接进入订阅模式，只能执行订阅相关的命令
+	Subscribe(channel string) bool    // 订阅频道，返回是否是新订阅的
+	Unsubscribe(channel string) bool  // 退订频道，返回原来是否订阅了
+	PSubscribe(pattern string) bool   // 订阅模式，返回是否是新订阅的
+	PUnsubscribe(pattern string) bool // 退订模式，返回原来是否订阅了
+	GetChannels() []string            // 订阅的所有频道
+	GetPatterns() []string            // 订阅的所有模式
+	SubsCount() int                   // 订阅的频道和模式的总个数
 }
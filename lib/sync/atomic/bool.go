@@ -19,3 +19,15 @@ func (b *Boolean) Set(v bool) {
 		atomic.StoreUint32((*uint32)(b), 0)
 	}
 }
+
+// CompareAndSwap 值等于 old 时换成 new，返回是否换成功了
+func (b *Boolean) CompareAndSwap(old, new bool) bool {
+	return atomic.CompareAndSwapUint32((*uint32)(b), boolToUint32(old), boolToUint32(new))
+}
+
+func boolToUint32(v bool) uint32 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,21 @@
+package atomic
+
+/**
+ * 封装一个原子性的计数器
+ */
+
+import "sync/atomic"
+
+type Int64 int64
+
+func (i *Int64) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *Int64) Set(v int64) {
+	atomic.StoreInt64((*int64)(i), v)
+}
+
+func (i *Int64) Add(delta int64) int64 {
+	return atomic.AddInt64((*int64)(i), delta)
+}
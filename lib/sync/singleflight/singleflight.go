@@ -0,0 +1,54 @@
+package singleflight
+
+/*
+ * 合并同一个键上的并发调用：第一个调用者执行函数，执行期间到达的调用者等它的结果，函数只执行一次
+ */
+
+import (
+	"errors"
+	"sync"
+)
+
+// errPanicked fn 没有正常返回时等待的调用者拿到的错误
+var errPanicked = errors.New("singleflight: function panicked")
+
+// call 一次正在执行或已经完成的调用
+type call[T any] struct {
+	wg  sync.WaitGroup
+	val T
+	err error
+}
+
+// Group 按键合并调用，零值可以直接使用
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+// Do 执行 fn 并返回它的结果，同一个键上已经有调用在执行时等待它完成并共用结果
+// shared 表示这次没有执行 fn，拿到的是其他调用者的结果
+func (g *Group[T]) Do(key string, fn func() (T, error)) (val T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call[T]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() { // fn panic 时也要唤醒等待的调用者
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.err = errPanicked
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
@@ -1,5 +1,7 @@
 package utils
 
+import "strconv"
+
 // ToCmdLine 将 string 转换成 [][]byte
 func ToCmdLine(cmd ...string) [][]byte {
 	args := make([][]byte, len(cmd))
@@ -37,3 +39,16 @@ func BytesEquals(a []byte, b []byte) bool {
 	}
 	return true
 }
+
+// ParseInteger 判断字节数组是否是规范的 int64（没有前导的 0 和 +，转换回字符串和原来完全一样）
+// 只有规范的整数才能按整数保存，否则读出来的值会和写进去的不一样
+func ParseInteger(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 20 { // int64 最长 20 个字符
+		return 0, false
+	}
+	v, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != string(b) {
+		return 0, false
+	}
+	return v, true
+}
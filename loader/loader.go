@@ -0,0 +1,234 @@
+package loader
+
+/*
+ * 读穿透（read-through）：GET 未命中时按键的前缀找到注册的 Loader，从数据源加载值写回缓存
+ *   - 同一个数据库里同一个键的并发未命中通过 singleflight 合并，Loader 只调用一次
+ *   - Loader 返回 ErrNotFound 时记下这个键，NegativeTTL 之内再次未命中直接返回空，不再调用 Loader
+ *   - 其他的错误不缓存，原样交给客户端
+ *   - 写命令写入的键调用 Forget 删除负缓存，之后的未命中重新调用 Loader
+ */
+
+import (
+	"GoMiniCache/lib/sync/singleflight"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const negativeSweepSize = 1024 // 负缓存超过这么多条时在写入前清理过期的记录
+
+// ErrNotFound Loader 返回它表示数据源里也没有这个键
+var ErrNotFound = errors.New("loader: not found")
+
+// Loader 从数据源加载一个键的值，ttl 为 0 表示不过期
+type Loader interface {
+	Load(key string) (val []byte, ttl time.Duration, err error)
+}
+
+// Func 把普通函数转换成 Loader
+type Func func(key string) ([]byte, time.Duration, error)
+
+// Load 调用函数本身
+func (f Func) Load(key string) ([]byte, time.Duration, error) {
+	return f(key)
+}
+
+// Options 注册 Loader 时的参数
+type Options struct {
+	NegativeTTL time.Duration // 数据源里没有的键在这段时间内不再加载，0 表示不缓存
+}
+
+// Stats 读穿透的统计
+type Stats struct {
+	Loads        int64 // 调用 Loader 的次数
+	Shared       int64 // 没有调用 Loader，等待其他调用者的加载结果的次数
+	NotFound     int64 // Loader 返回 ErrNotFound 的次数
+	Errors       int64 // Loader 返回其他错误的次数
+	NegativeHits int64 // 命中负缓存的次数
+}
+
+// namespace 一个前缀上注册的 Loader
+type namespace struct {
+	prefix string
+	loader Loader
+	opts   Options
+}
+
+// result 一次加载的结果
+type result struct {
+	val []byte
+	ttl time.Duration
+}
+
+// Registry 按前缀注册的 Loader，并发安全
+type Registry struct {
+	mu         sync.RWMutex
+	namespaces []*namespace // 按前缀从长到短排列，最长的前缀优先匹配
+
+	group singleflight.Group[result]
+
+	negativeMu sync.Mutex
+	negative   map[string]time.Time // 数据源里没有的键到负缓存的过期时间
+	negativeN  atomic.Int64         // negative 的大小，为 0 时 Forget 不需要加锁
+
+	loads, shared, notFound, errors, negativeHits int64 // 原子操作
+}
+
+// NewRegistry 创建一个空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{negative: make(map[string]time.Time)}
+}
+
+// Register 给前缀注册 Loader，前缀已经注册过时替换；空的前缀匹配所有的键
+func (r *Registry) Register(prefix string, loader Loader, opts Options) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ns := &namespace{prefix: prefix, loader: loader, opts: opts}
+	for i, old := range r.namespaces {
+		if old.prefix == prefix {
+			r.namespaces[i] = ns
+			return
+		}
+	}
+	r.namespaces = append(r.namespaces, ns)
+	sort.SliceStable(r.namespaces, func(i, j int) bool {
+		return len(r.namespaces[i].prefix) > len(r.namespaces[j].prefix)
+	})
+}
+
+// Unregister 删除前缀上的 Loader，返回是否注册过
+func (r *Registry) Unregister(prefix string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, ns := range r.namespaces {
+		if ns.prefix == prefix {
+			r.namespaces = append(r.namespaces[:i], r.namespaces[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Match 返回是否有匹配键的 Loader
+func (r *Registry) Match(key string) bool {
+	return r.match(key) != nil
+}
+
+// match 返回匹配键的最长的前缀上的 Loader
+func (r *Registry) match(key string) *namespace {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, ns := range r.namespaces {
+		if strings.HasPrefix(key, ns.prefix) {
+			return ns
+		}
+	}
+	return nil
+}
+
+// Load 加载数据库 dbIndex 中的键，found 为 false 表示没有匹配的 Loader 或者数据源里没有这个键
+func (r *Registry) Load(dbIndex int, key string) (val []byte, ttl time.Duration, found bool, err error) {
+	ns := r.match(key)
+	if ns == nil {
+		return nil, 0, false, nil
+	}
+	flightKey := strconv.Itoa(dbIndex) + ":" + key
+	if r.negativeHit(flightKey) {
+		atomic.AddInt64(&r.negativeHits, 1)
+		return nil, 0, false, nil
+	}
+	res, err, shared := r.group.Do(flightKey, func() (result, error) {
+		atomic.AddInt64(&r.loads, 1)
+		val, ttl, err := ns.loader.Load(key)
+		return result{val: val, ttl: ttl}, err
+	})
+	if shared {
+		atomic.AddInt64(&r.shared, 1)
+	}
+	if errors.Is(err, ErrNotFound) {
+		if !shared { // 只由真正调用 Loader 的那个调用者计数和写负缓存
+			atomic.AddInt64(&r.notFound, 1)
+			r.rememberMissing(flightKey, ns.opts.NegativeTTL)
+		}
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		if !shared {
+			atomic.AddInt64(&r.errors, 1)
+		}
+		return nil, 0, false, err
+	}
+	if res.val == nil {
+		res.val = []byte{}
+	}
+	return res.val, res.ttl, true, nil
+}
+
+// Forget 删除一个键的负缓存，数据源里新增了这个键时调用
+func (r *Registry) Forget(dbIndex int, key string) {
+	if r.negativeN.Load() == 0 { // 每个写命令都会调用，没有负缓存时不加锁
+		return
+	}
+	r.negativeMu.Lock()
+	defer r.negativeMu.Unlock()
+	r.deleteNegativeLocked(strconv.Itoa(dbIndex) + ":" + key)
+}
+
+// deleteNegativeLocked 删除一条负缓存（调用者需要持有 negativeMu）
+func (r *Registry) deleteNegativeLocked(flightKey string) {
+	if _, ok := r.negative[flightKey]; ok {
+		delete(r.negative, flightKey)
+		r.negativeN.Add(-1)
+	}
+}
+
+// negativeHit 返回键是否在负缓存中
+func (r *Registry) negativeHit(flightKey string) bool {
+	r.negativeMu.Lock()
+	defer r.negativeMu.Unlock()
+	expireAt, ok := r.negative[flightKey]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expireAt) {
+		r.deleteNegativeLocked(flightKey)
+		return false
+	}
+	return true
+}
+
+// rememberMissing 把数据源里没有的键写进负缓存
+func (r *Registry) rememberMissing(flightKey string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	r.negativeMu.Lock()
+	defer r.negativeMu.Unlock()
+	if len(r.negative) >= negativeSweepSize {
+		for k, expireAt := range r.negative {
+			if now.After(expireAt) {
+				r.deleteNegativeLocked(k)
+			}
+		}
+	}
+	if _, ok := r.negative[flightKey]; !ok {
+		r.negativeN.Add(1)
+	}
+	r.negative[flightKey] = now.Add(ttl)
+}
+
+// Stats 返回统计信息
+func (r *Registry) Stats() Stats {
+	return Stats{
+		Loads:        atomic.LoadInt64(&r.loads),
+		Shared:       atomic.LoadInt64(&r.shared),
+		NotFound:     atomic.LoadInt64(&r.notFound),
+		Errors:       atomic.LoadInt64(&r.errors),
+		NegativeHits: atomic.LoadInt64(&r.negativeHits),
+	}
+}
@@ -0,0 +1,101 @@
+package loader
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleflight(t *testing.T) {
+	r := NewRegistry()
+	var calls int64
+	release := make(chan struct{})
+	r.Register("user:", Func(func(key string) ([]byte, time.Duration, error) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		return []byte("v:" + key), time.Minute, nil
+	}), Options{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, ttl, found, err := r.Load(0, "user:1")
+			if err != nil || !found || string(val) != "v:user:1" || ttl != time.Minute {
+				t.Errorf("unexpected load %q %v %v %v", val, ttl, found, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // 等所有的调用者都在等待同一次加载
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader should be called once, got %d", calls)
+	}
+	if stats := r.Stats(); stats.Loads != 1 || stats.Shared != 9 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if _, _, found, _ := r.Load(0, "order:1"); found { // 没有匹配的前缀
+		t.Error("unmatched key should not be loaded")
+	}
+}
+
+func TestNegativeCacheAndErrors(t *testing.T) {
+	r := NewRegistry()
+	var calls int64
+	r.Register("", Func(func(key string) ([]byte, time.Duration, error) {
+		atomic.AddInt64(&calls, 1)
+		if key == "broken" {
+			return nil, 0, errors.New("backend down")
+		}
+		return nil, 0, ErrNotFound
+	}), Options{NegativeTTL: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		if _, _, found, err := r.Load(0, "missing"); found || err != nil {
+			t.Fatalf("unexpected result %v %v", found, err)
+		}
+	}
+	if calls != 1 || r.Stats().NegativeHits != 2 {
+		t.Errorf("missing key should be cached, calls %d stats %+v", calls, r.Stats())
+	}
+	if _, _, found, _ := r.Load(1, "missing"); found || calls != 2 { // 每个数据库各自缓存
+		t.Errorf("negative cache should be per database, calls %d", calls)
+	}
+	r.Forget(0, "missing")
+	if r.Load(0, "missing"); calls != 3 {
+		t.Errorf("Forget should drop the negative entry, calls %d", calls)
+	}
+	for i := 0; i < 2; i++ { // 错误不缓存
+		if _, _, _, err := r.Load(0, "broken"); err == nil || err.Error() != "backend down" {
+			t.Errorf("expected loader error, got %v", err)
+		}
+	}
+	if calls != 5 || r.Stats().Errors != 2 {
+		t.Errorf("errors should not be cached, calls %d", calls)
+	}
+}
+
+func TestLongestPrefix(t *testing.T) {
+	r := NewRegistry()
+	constant := func(val string) Loader {
+		return Func(func(string) ([]byte, time.Duration, error) { return []byte(val), 0, nil })
+	}
+	r.Register("a:", constant("short"), Options{})
+	r.Register("a:b:", constant("long"), Options{})
+	if val, _, _, _ := r.Load(0, "a:b:c"); string(val) != "long" {
+		t.Errorf("expected longest prefix, got %q", val)
+	}
+	if val, _, _, _ := r.Load(0, "a:c"); string(val) != "short" {
+		t.Errorf("expected short prefix, got %q", val)
+	}
+	if !r.Unregister("a:b:") || r.Unregister("a:b:") {
+		t.Error("unregister failed")
+	}
+	if val, _, _, _ := r.Load(0, "a:b:c"); string(val) != "short" {
+		t.Errorf("expected fallback to short prefix, got %q", val)
+	}
+}
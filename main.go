@@ -12,8 +12,9 @@ import (
 const configFile string = "GoMiniCache.conf"
 
 var defaultProperties = &config.ServerProperties{
-	Bind: "0.0.0.0",
-	Port: 9999,
+	Bind:         "0.0.0.0",
+	Port:         9999,
+	LfuDecayTime: config.DefaultLfuDecayTime,
 }
 
 func fileExists(filename string) bool {
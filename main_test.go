@@ -16,7 +16,7 @@ func TestListenAndServe(t *testing.T) {
 	// 绑定监听地址
 	listener, err := net.Listen("tcp", addressTest)
 	if err != nil {
-		log.Fatalf(fmt.Sprintf("listen err: %v", err))
+		log.Fatalf("listen err: %v", err)
 	}
 	defer func(listener net.Listener) {
 		err := listener.Close()
@@ -31,7 +31,7 @@ func TestListenAndServe(t *testing.T) {
 		conn, err := listener.Accept()
 		if err != nil {
 			// 通常是 listen 被关闭导致的错误
-			log.Fatalf(fmt.Sprintf("accept err: %v", err))
+			log.Fatalf("accept err: %v", err)
 		}
 		// 开启新的 goroutine 处理该请求
 		go Handle(conn)
@@ -0,0 +1,191 @@
+package notify
+
+/*
+ * 键空间通知（keyspace notifications）的事件总线
+ *   - 数据库修改键的时候调用 Bus.Notify，事件按 notify-keyspace-events 配置的类别过滤
+ *   - 通过过滤的事件交给所有的监听者：进程内的 Go 代码直接注册 Listener，
+ *     发布订阅也只是其中一个监听者，把事件发布到 __keyspace@<db>__:<key> 和 __keyevent@<db>__:<event> 频道
+ *   - 监听者在修改键的协程里同步调用，不能阻塞，也不能再调用数据库的命令
+ *
+ * 配置字符串和 Redis 相同: K 发布 __keyspace@ 频道，E 发布 __keyevent@ 频道，至少要有其中一个才会产生事件；
+ * 其余的字符选择事件的类别，A 是 g$lshzxetd 的别名
+ */
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 事件的类别，和 notify-keyspace-events 中的字符一一对应
+const (
+	Keyspace = 1 << iota // K: 发布到 __keyspace@<db>__:<key>
+	Keyevent             // E: 发布到 __keyevent@<db>__:<event>
+	Generic              // g: DEL、EXPIRE、RENAME 等和类型无关的命令
+	String               // $: 字符串命令
+	List                 // l
+	Set                  // s
+	Hash                 // h
+	ZSet                 // z
+	Expired              // x: 键过期被删除
+	Evicted              // e: 键因为 maxmemory 被淘汰
+	Stream               // t
+	KeyMiss              // m: 读取的键不存在
+	Module               // d: 模块类型
+	New                  // n: 新建了一个键
+
+	// All A 代表的类别，和 Redis 一样不包括 m 和 n
+	All = Generic | String | List | Set | Hash | ZSet | Expired | Evicted | Stream | Module
+)
+
+// flagChars 配置字符和类别的对应关系，也是 FormatFlags 输出的顺序
+var flagChars = []struct {
+	char byte
+	flag int
+}{
+	{'g', Generic}, {'$', String}, {'l', List}, {'s', Set}, {'h', Hash}, {'z', ZSet},
+	{'x', Expired}, {'e', Evicted}, {'t', Stream}, {'d', Module},
+	{'K', Keyspace}, {'E', Keyevent}, {'m', KeyMiss}, {'n', New},
+}
+
+// ParseFlags 解析 notify-keyspace-events 的配置字符串，空字符串表示关闭通知
+func ParseFlags(s string) (int, error) {
+	flags := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			flags |= All
+			continue
+		}
+		found := false
+		for _, fc := range flagChars {
+			if fc.char == s[i] {
+				flags |= fc.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid notify-keyspace-events character '%c'", s[i])
+		}
+	}
+	return flags, nil
+}
+
+// FormatFlags 把类别转换回配置字符串，包含所有类别时输出 A
+func FormatFlags(flags int) string {
+	var sb strings.Builder
+	if flags&All == All {
+		sb.WriteByte('A')
+	}
+	for _, fc := range flagChars {
+		if fc.flag&All != 0 && flags&All == All {
+			continue
+		}
+		if flags&fc.flag != 0 {
+			sb.WriteByte(fc.char)
+		}
+	}
+	return sb.String()
+}
+
+// Event 一次键空间事件
+type Event struct {
+	DB    int    // 数据库编号
+	Class int    // 事件的类别，例：Generic
+	Name  string // 事件名，例：set、del、expired
+	Key   string
+}
+
+// Listener 事件的监听者，在修改键的协程里同步调用
+type Listener func(Event)
+
+// subscription 一个注册的监听者
+type subscription struct {
+	id       uint64
+	listener Listener
+}
+
+// Bus 事件总线，并发安全
+// 监听者列表写时复制，发出事件时不需要加锁，监听者里也可以取消注册
+type Bus struct {
+	flags atomic.Int64
+
+	mu        sync.Mutex // 修改监听者列表时持有
+	listeners atomic.Pointer[[]subscription]
+	watchers  atomic.Pointer[[]subscription] // 不受配置过滤的监听者
+	nextID    uint64
+}
+
+// NewBus 创建事件总线，flags 是 ParseFlags 的结果
+func NewBus(flags int) *Bus {
+	bus := &Bus{}
+	bus.flags.Store(int64(flags))
+	bus.listeners.Store(&[]subscription{})
+	bus.watchers.Store(&[]subscription{})
+	return bus
+}
+
+// Flags 返回当前的配置
+func (bus *Bus) Flags() int {
+	return int(bus.flags.Load())
+}
+
+// SetFlags 修改配置，立即生效
+func (bus *Bus) SetFlags(flags int) {
+	bus.flags.Store(int64(flags))
+}
+
+// Subscribe 注册监听者，只收到配置开启的事件，返回取消注册的函数
+func (bus *Bus) Subscribe(listener Listener) (unsubscribe func()) {
+	return bus.add(&bus.listeners, listener)
+}
+
+// Watch 注册监听者，不管配置有没有开启都收到所有的事件，返回取消注册的函数
+// 用于服务器内部依赖事件的功能，例：键过期和被淘汰时让客户端缓存失效
+func (bus *Bus) Watch(listener Listener) (unwatch func()) {
+	return bus.add(&bus.watchers, listener)
+}
+
+// add 把监听者加入列表
+func (bus *Bus) add(list *atomic.Pointer[[]subscription], listener Listener) func() {
+	bus.mu.Lock()
+	id := bus.nextID
+	bus.nextID++
+	subs := append(slices.Clone(*list.Load()), subscription{id: id, listener: listener})
+	list.Store(&subs)
+	bus.mu.Unlock()
+	return func() {
+		bus.mu.Lock()
+		subs := slices.DeleteFunc(slices.Clone(*list.Load()), func(sub subscription) bool {
+			return sub.id == id
+		})
+		list.Store(&subs)
+		bus.mu.Unlock()
+	}
+}
+
+// Enabled 返回这个类别的事件是否会发给 Subscribe 注册的监听者，调用者可以用它跳过准备事件的开销
+func (bus *Bus) Enabled(class int) bool {
+	flags := bus.Flags()
+	return flags&class != 0 && flags&(Keyspace|Keyevent) != 0
+}
+
+// Notify 发出一个事件，类别没有开启并且没有 Watch 的监听者时什么都不做
+func (bus *Bus) Notify(dbIndex int, class int, name string, key string) {
+	watchers := *bus.watchers.Load()
+	enabled := bus.Enabled(class)
+	if !enabled && len(watchers) == 0 {
+		return
+	}
+	event := Event{DB: dbIndex, Class: class, Name: name, Key: key}
+	if enabled {
+		for _, sub := range *bus.listeners.Load() {
+			sub.listener(event)
+		}
+	}
+	for _, sub := range watchers {
+		sub.listener(event)
+	}
+}
@@ -0,0 +1,61 @@
+package notify
+
+import "testing"
+
+func TestParseFlags(t *testing.T) {
+	cases := map[string]string{
+		"":      "",
+		"KEA":   "AKE",
+		"Kx":    "xK",
+		"E$gnm": "g$Emn",
+		"AKEmn": "AKEmn",
+	}
+	for in, want := range cases {
+		flags, err := ParseFlags(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatFlags(flags); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+	if _, err := ParseFlags("Kq"); err == nil {
+		t.Error("expected error for unknown character")
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus(0)
+	var events []Event
+	unsubscribe := bus.Subscribe(func(e Event) { events = append(events, e) })
+	bus.Notify(0, String, "set", "k")
+	if len(events) != 0 {
+		t.Fatal("notifications are disabled")
+	}
+	flags, _ := ParseFlags("E$")
+	bus.SetFlags(flags)
+	bus.Notify(1, String, "set", "k")
+	bus.Notify(1, Generic, "del", "k") // 没有开启 g
+	if len(events) != 1 || events[0] != (Event{DB: 1, Class: String, Name: "set", Key: "k"}) {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	flags, _ = ParseFlags("$") // 没有 K 和 E 时不产生事件
+	bus.SetFlags(flags)
+	bus.Notify(1, String, "set", "k")
+	unsubscribe()
+	bus.SetFlags(All | Keyspace)
+	bus.Notify(1, String, "set", "k")
+	if len(events) != 1 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	bus.SetFlags(0)
+	watched := 0
+	unwatch := bus.Watch(func(e Event) { watched++ }) // Watch 不受配置过滤
+	bus.Notify(0, Expired, "expired", "k")
+	unwatch()
+	bus.Notify(0, Expired, "expired", "k")
+	if watched != 1 {
+		t.Fatalf("expected 1 watched event, got %d", watched)
+	}
+}
@@ -5,6 +5,7 @@ package connection
  */
 
 import (
+	"GoMiniCache/lib/sync/atomic"
 	"GoMiniCache/lib/sync/wait"
 	"net"
 	"sync"
@@ -17,13 +18,49 @@ type Connection struct {
 	waitingReply wait.Wait // 在关闭服务的时候需要 wait 解决未完成的任务
 	mu           sync.Mutex
 	selectedDB   int // Redis 有 16 个独立的数据库，这里指示的是正在操作的那个
+	closed       atomic.Boolean
+	id           int64        // 客户端编号，CLIENT ID 返回它，从 1 开始递增
+	protocol     atomic.Int64 // RESP 协议版本，0 表示默认的 RESP2；给别的连接推送消息时也会读
+	name         string       // 客户端的名称
+	authed       bool         // 通过了密码验证
+
+	// 订阅的频道和模式，只在处理这个连接的命令的协程里访问
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// activeClients 当前打开的客户端连接的个数
+var activeClients atomic.Int64
+
+// nextID 上一个分配的客户端编号
+var nextID atomic.Int64
+
+// clients 客户端编号到打开的连接，CLIENT TRACKING 的 REDIRECT 按编号找到目标连接
+var clients sync.Map
+
+// ActiveClients 返回当前打开的客户端连接的个数
+func ActiveClients() int64 {
+	return activeClients.Get()
 }
 
 // NewConn 创建新连接
 func NewConn(conn net.Conn) *Connection {
-	return &Connection{
+	activeClients.Add(1)
+	c := &Connection{
 		conn: conn,
+		id:   nextID.Add(1),
 	}
+	clients.Store(c.id, c)
+	return c
+}
+
+// Lookup 按编号返回打开的客户端连接
+func Lookup(id int64) (*Connection, bool) {
+	c, ok := clients.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return c.(*Connection), true
 }
 
 // RemoteAddr 返回远程的地址
@@ -33,6 +70,11 @@ func (c *Connection) RemoteAddr() net.Addr {
 
 // Close 关闭客户端连接
 func (c *Connection) Close() error {
+	if !c.closed.CompareAndSwap(false, true) { // 关闭服务时可能和客户端断开同时发生，只有一个能关闭
+		return nil
+	}
+	activeClients.Add(-1)
+	clients.Delete(c.id)
 	c.waitingReply.WaitWithTimeout(10 * time.Second)
 	_ = c.conn.Close()
 	return nil
@@ -54,6 +96,44 @@ func (c *Connection) Write(b []byte) error {
 	return err
 }
 
+// GetID 返回客户端编号
+func (c *Connection) GetID() int64 {
+	return c.id
+}
+
+// GetProtocol 返回连接使用的 RESP 协议版本
+func (c *Connection) GetProtocol() int {
+	if protocol := c.protocol.Get(); protocol != 0 {
+		return int(protocol)
+	}
+	return 2
+}
+
+// SetProtocol 设置连接使用的 RESP 协议版本
+func (c *Connection) SetProtocol(protocol int) {
+	c.protocol.Set(int64(protocol))
+}
+
+// GetName 返回客户端的名称
+func (c *Connection) GetName() string {
+	return c.name
+}
+
+// SetName 设置客户端的名称
+func (c *Connection) SetName(name string) {
+	c.name = name
+}
+
+// IsAuthenticated 返回是否通过了密码验证
+func (c *Connection) IsAuthenticated() bool {
+	return c.authed
+}
+
+// SetAuthenticated 设置是否通过了密码验证
+func (c *Connection) SetAuthenticated(authed bool) {
+	c.authed = authed
+}
+
 // GetDBIndex 获取选择的数据库编号
 func (c *Connection) GetDBIndex() int {
 	return c.selectedDB
@@ -63,3 +143,68 @@ func (c *Connection) GetDBIndex() int {
 func (c *Connection) SelectDB(dbNum int) {
 	c.selectedDB = dbNum
 }
+
+// Subscribe 订阅频道，返回是否是新订阅的
+func (c *Connection) Subscribe(channel string) bool {
+	if c.channels == nil {
+		c.channels = make(map[string]struct{})
+	}
+	return addSub(c.channels, channel)
+}
+
+// Unsubscribe 退订频道，返回原来是否订阅了
+func (c *Connection) Unsubscribe(channel string) bool {
+	return removeSub(c.channels, channel)
+}
+
+// PSubscribe 订阅模式，返回是否是新订阅的
+func (c *Connection) PSubscribe(pattern string) bool {
+	if c.patterns == nil {
+		c.patterns = make(map[string]struct{})
+	}
+	return addSub(c.patterns, pattern)
+}
+
+// PUnsubscribe 退订模式，返回原来是否订阅了
+func (c *Connection) PUnsubscribe(pattern string) bool {
+	return removeSub(c.patterns, pattern)
+}
+
+// GetChannels 返回订阅的所有频道
+func (c *Connection) GetChannels() []string {
+	return subNames(c.channels)
+}
+
+// GetPatterns 返回订阅的所有模式
+func (c *Connection) GetPatterns() []string {
+	return subNames(c.patterns)
+}
+
+// SubsCount 返回订阅的频道和模式的总个数，大于 0 时连接处于订阅模式
+func (c *Connection) SubsCount() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+func addSub(subs map[string]struct{}, name string) bool {
+	if _, ok := subs[name]; ok {
+		return false
+	}
+	subs[name] = struct{}{}
+	return true
+}
+
+func removeSub(subs map[string]struct{}, name string) bool {
+	if _, ok := subs[name]; !ok {
+		return false
+	}
+	delete(subs, name)
+	return true
+}
+
+func subNames(subs map[string]struct{}) []string {
+	names := make([]string, 0, len(subs))
+	for name := range subs {
+		names = append(names, name)
+	}
+	return names
+}
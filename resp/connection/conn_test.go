@@ -0,0 +1,33 @@
+package connection
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+// TestCloseTwice 并发关闭同一个连接，只能减少一次客户端个数
+func TestCloseTwice(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	before := ActiveClients()
+	c := NewConn(server)
+	if ActiveClients() != before+1 {
+		t.Fatalf("expected %d clients, got %d", before+1, ActiveClients())
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = c.Close()
+		}()
+	}
+	wg.Wait()
+	if ActiveClients() != before {
+		t.Errorf("expected %d clients after close, got %d", before, ActiveClients())
+	}
+	if _, ok := Lookup(c.id); ok {
+		t.Error("closed connection should not be found")
+	}
+}
@@ -39,6 +39,13 @@ func MakeRespHandler() *RespHandler {
 	}
 }
 
+// MakeRespHandlerWithDB 使用调用者创建好的数据库内核创建 RespHandler，嵌入时可以先在数据库上注册 Loader
+func MakeRespHandlerWithDB(db databaseface.Database) *RespHandler {
+	return &RespHandler{
+		db: db,
+	}
+}
+
 // closeClient 关闭这个客户端连接
 func (h *RespHandler) closeClient(client *connection.Connection) {
 	_ = client.Close()
@@ -57,10 +64,11 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 	client := connection.NewConn(conn)
 	h.activeConn.Store(client, 1)
 
-	// 将连接交给 parser.ParseStream 解析，他会将解析好的内容返回到这个管道
-	ch := parser.ParseStream(conn)
-	// 我们只需要监听这个管道即可
-	for payload := range ch {
+	// 在当前协程里逐个解析并执行请求，读缓冲区在连接关闭时归还
+	reader := parser.NewReader(conn)
+	defer reader.Close()
+	for {
+		payload := reader.Next()
 		// 如果出现错误
 		if payload.Err != nil {
 			// 如果出现 io 错误; 客户端断开连接; 使用一个已经关闭的连接; 就关闭客户端连接
@@ -71,10 +79,11 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 				logger.Info("connection closed: " + client.RemoteAddr().String())
 				return
 			}
-			// 如果是出现协议错误，就返回错误回复，继续监听管道等待用户下一次数据
+			// 如果是出现协议错误，就返回错误回复，继续读取用户下一次的数据
+			// 如果是出现无法恢复的协议错误（例：超过了长度限制），返回错误回复以后关闭连接
 			errReply := reply.MakeErrReply(payload.Err.Error())
 			err := client.Write(errReply.ToBytes())
-			if err != nil {
+			if err != nil || reader.Err() != nil {
 				h.closeClient(client)
 				logger.Info("connection closed: " + client.RemoteAddr().String())
 				return
@@ -92,11 +101,17 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 			logger.Error("require multi bulk reply")
 			continue
 		}
+		// QUIT: 回复 OK 以后关闭连接，下一次读取到连接关闭的错误以后走上面的清理流程
+		if len(r.Args) > 0 && strings.EqualFold(string(r.Args[0]), "quit") {
+			_ = client.Write(reply.MakeOkReply().ToBytes())
+			_ = client.Close()
+			continue
+		}
 		// 把结果传给内核数据库执行指令
 		result := h.db.Exec(client, r.Args)
-		// 将结果写回客户端
+		// 将结果按连接的协议版本写回客户端
 		if result != nil {
-			_ = client.Write(result.ToBytes())
+			_ = client.Write(reply.Encode(result, client.GetProtocol()))
 		} else { // 如果结果为空，只能返回未知错误了（前面排了无数错误了）
 			_ = client.Write(unknownErrReplyBytes)
 		}
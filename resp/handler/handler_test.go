@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"GoMiniCache/config"
+	"GoMiniCache/lib/utils"
+	"GoMiniCache/resp/reply"
+	"bytes"
+	"context"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// testClient 通过 net.Pipe 连接 RespHandler 的客户端
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func dial(t *testing.T, h *RespHandler) *testClient {
+	server, client := net.Pipe()
+	go h.Handle(context.Background(), server)
+	t.Cleanup(func() { _ = client.Close() })
+	return &testClient{t: t, conn: client}
+}
+
+// do 发送命令，读取回复直到 done 返回 true
+func (tc *testClient) do(done func([]byte) bool, args ...string) string {
+	tc.t.Helper()
+	req := reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes()
+	_ = tc.conn.SetDeadline(time.Now().Add(2 * time.Second))
+	go func() { _, _ = tc.conn.Write(req) }()
+	var buf bytes.Buffer
+	chunk := make([]byte, 4096)
+	for !done(buf.Bytes()) {
+		n, err := tc.conn.Read(chunk)
+		if err != nil {
+			tc.t.Fatalf("%v: read failed after %q: %v", args, buf.String(), err)
+		}
+		buf.Write(chunk[:n])
+	}
+	return buf.String()
+}
+
+func (tc *testClient) expect(expected string, args ...string) {
+	tc.t.Helper()
+	got := tc.do(func(b []byte) bool { return len(b) >= len(expected) }, args...)
+	if got != expected {
+		tc.t.Errorf("%v: expected %q, got %q", args, expected, got)
+	}
+}
+
+var helloID = regexp.MustCompile(`\$2\r\nid\r\n:\d+\r\n`)
+
+// helloReply HELLO 的回复，连接编号换成 N
+func helloReply(header string, proto string) string {
+	return header +
+		"$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n7.0.0\r\n$5\r\nproto\r\n:" + proto + "\r\n" +
+		"$2\r\nid\r\n:N\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n" +
+		"$7\r\nmodules\r\n*0\r\n"
+}
+
+func (tc *testClient) expectHello(expected string, args ...string) {
+	tc.t.Helper()
+	got := tc.do(func(b []byte) bool { return bytes.HasSuffix(b, []byte("$7\r\nmodules\r\n*0\r\n")) }, args...)
+	got = helloID.ReplaceAllString(got, "$$2\r\nid\r\n:N\r\n")
+	if got != expected {
+		tc.t.Errorf("%v: expected %q, got %q", args, expected, got)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	saved := *config.Properties
+	defer func() { *config.Properties = saved }()
+	config.Properties.RequirePass = "secret"
+	h := MakeRespHandler()
+	defer h.Close()
+
+	c := dial(t, h)
+	c.expect("-NOAUTH Authentication required.\r\n", "set", "k", "v")
+	c.expect("-NOAUTH Authentication required.\r\n", "get", "k")
+	c.expect("-WRONGPASS invalid username-password pair or user is disabled.\r\n", "auth", "wrong")
+	c.expect("-WRONGPASS invalid username-password pair or user is disabled.\r\n", "auth", "someone", "secret")
+	c.expect("-Err syntax error\r\n", "auth", "a", "b", "c")
+	c.expect("+OK\r\n", "auth", "secret")
+	c.expect("+OK\r\n", "set", "k", "v")
+
+	other := dial(t, h) // 验证只对自己的连接有效
+	other.expect("-NOAUTH Authentication required.\r\n", "get", "k")
+	other.expect("-NOAUTH HELLO must be called with the client already authenticated, "+
+		"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client "+
+		"and select the RESP protocol version at the same time\r\n", "hello", "3")
+	other.expectHello(helloReply("%7\r\n", "3"), "hello", "3", "auth", "default", "secret")
+	other.expect("$1\r\nv\r\n", "get", "k")
+
+	config.Properties.RequirePass = ""
+	third := dial(t, h)
+	third.expect("-ERR AUTH <password> called without any password configured for the default user. "+
+		"Are you sure your configuration is correct?\r\n", "auth", "secret")
+	third.expect("$1\r\nv\r\n", "get", "k")
+}
+
+func TestHello(t *testing.T) {
+	h := MakeRespHandler()
+	defer h.Close()
+	c := dial(t, h)
+
+	c.expect("-NOPROTO unsupported protocol version\r\n", "hello", "4")
+	c.expect("-ERR Protocol version is not an integer or out of range\r\n", "hello", "x")
+	c.expect("-ERR Syntax error in HELLO option 'auth'\r\n", "hello", "3", "auth", "default")
+	c.expectHello(helloReply("*14\r\n", "2"), "hello") // 默认 RESP2，map 编码成数组
+
+	c.expectHello(helloReply("%7\r\n", "3"), "hello", "3", "setname", "app")
+	c.expect("$3\r\napp\r\n", "client", "getname")
+	c.expect("_\r\n", "get", "missing")
+	// map 和 set 按 RESP3 编码
+	c.expect("%3\r\n$5\r\nflags\r\n~1\r\n$3\r\noff\r\n$8\r\nredirect\r\n:-1\r\n$8\r\nprefixes\r\n*0\r\n",
+		"client", "trackinginfo")
+	// 订阅的回复和消息是推送
+	c.expect(">3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", "subscribe", "news")
+	dial(t, h).expect(":1\r\n", "publish", "news", "hello")
+	c.expect(">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n_\r\n", "get", "missing")
+	c.expect(">3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:0\r\n", "unsubscribe")
+
+	// 降级回 RESP2：map 和 set 编码成数组，空值是 $-1
+	c.expectHello(helloReply("*14\r\n", "2"), "hello", "2")
+	c.expect("$-1\r\n", "get", "missing")
+	c.expect("*6\r\n$5\r\nflags\r\n*1\r\n$3\r\noff\r\n$8\r\nredirect\r\n:-1\r\n$8\r\nprefixes\r\n*0\r\n",
+		"client", "trackinginfo")
+}
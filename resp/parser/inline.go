@@ -0,0 +1,139 @@
+package parser
+
+/*
+ * 内联命令：不按 RESP 协议编码，直接一行文本，参数之间用空白隔开，例：telnet 或者 nc 中输入的 SET key "hello world"
+ * 规则和 redis-cli 一致：双引号中支持 \n、\r、\t、\b、\a、\xHH 转义，单引号中只支持 \' 转义
+ */
+
+import (
+	"errors"
+)
+
+// maxInlineSize 一行内联命令（或者 RESP 的头部行）最多的字节数，超过了就认为客户端出错并关闭连接
+const maxInlineSize = 64 * 1024
+
+// errUnbalancedQuotes 引号没有闭合，或者闭合的引号后面不是空白
+var errUnbalancedQuotes = errors.New("protocol error: unbalanced quotes in request")
+
+// isInlineCommand 不是以 RESP 类型开头的行都当作内联命令
+func isInlineCommand(msg []byte) bool {
+	switch msg[0] {
+	case '*', '$', '+', '-', ':', '#':
+		return false
+	}
+	return !isResp3Header(msg)
+}
+
+// parseInlineCommand 把一行内联命令拆成参数，空行返回空的参数列表
+func parseInlineCommand(msg []byte) ([][]byte, error) {
+	line := msg[:len(msg)-1] // 去掉 \n，telnet 会发送 \r\n，nc 只发送 \n
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		var err error
+		switch line[i] {
+		case '"':
+			arg, i, err = readDoubleQuoted(line, i+1)
+		case '\'':
+			arg, i, err = readSingleQuoted(line, i+1)
+		default:
+			start := i
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			arg = line[start:i]
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+}
+
+// readDoubleQuoted 读取双引号中的参数，i 指向左引号的下一个字符，返回参数和右引号之后的位置
+func readDoubleQuoted(line []byte, i int) ([]byte, int, error) {
+	arg := []byte{}
+	for ; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+			arg = append(arg, hexValue(line[i+2])<<4|hexValue(line[i+3]))
+			i += 3
+		case c == '\\' && i+1 < len(line):
+			i++
+			switch line[i] {
+			case 'n':
+				arg = append(arg, '\n')
+			case 'r':
+				arg = append(arg, '\r')
+			case 't':
+				arg = append(arg, '\t')
+			case 'b':
+				arg = append(arg, '\b')
+			case 'a':
+				arg = append(arg, '\a')
+			default:
+				arg = append(arg, line[i])
+			}
+		case c == '"':
+			return closeQuote(line, arg, i+1)
+		default:
+			arg = append(arg, c)
+		}
+	}
+	return nil, 0, errUnbalancedQuotes
+}
+
+// readSingleQuoted 读取单引号中的参数，i 指向左引号的下一个字符，返回参数和右引号之后的位置
+func readSingleQuoted(line []byte, i int) ([]byte, int, error) {
+	arg := []byte{}
+	for ; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+			arg = append(arg, '\'')
+			i++
+		case c == '\'':
+			return closeQuote(line, arg, i+1)
+		default:
+			arg = append(arg, c)
+		}
+	}
+	return nil, 0, errUnbalancedQuotes
+}
+
+// closeQuote 右引号后面必须是空白或者行尾，例："a"b 是错误的
+func closeQuote(line []byte, arg []byte, i int) ([]byte, int, error) {
+	if i < len(line) && !isSpace(line[i]) {
+		return nil, 0, errUnbalancedQuotes
+	}
+	return arg, i, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,100 @@
+package parser
+
+/*
+ * 协议的长度限制：数据的长度都来自客户端，不检查的话 $9999999999 这样的头部就能让服务器分配大量内存
+ * 超过限制的错误没办法恢复（后面的数据已经不可信了），返回错误以后结束解析，连接会被关闭
+ */
+
+import (
+	"GoMiniCache/config"
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"slices"
+)
+
+const (
+	defaultProtoMaxBulkLen  = 512 * 1024 * 1024  // proto-max-bulk-len 的默认值，和 Redis 相同
+	defaultQueryBufferLimit = 1024 * 1024 * 1024 // client-query-buffer-limit 的默认值，和 Redis 相同
+	maxMultiBulkLen         = math.MaxInt32      // 数组最多的元素个数
+	maxPreallocArgs         = 1024               // 按数组头部预先分配的参数个数上限，更多的参数随着读取增长
+	maxPreallocBulk         = 64 * 1024          // 按 $ 头部预先分配的字节数上限，更大的数据随着读取增长
+)
+
+var (
+	errTooBigInline           = errors.New("protocol error: too big inline request")
+	errTooBigCount            = errors.New("protocol error: too big count string")
+	errInvalidMultiBulkLength = errors.New("protocol error: invalid multibulk length")
+	errInvalidBulkLength      = errors.New("protocol error: invalid bulk length")
+	errQueryBufferLimit       = errors.New("protocol error: client query buffer limit reached")
+)
+
+// isFatal 返回错误是否需要结束解析
+func isFatal(err error) bool {
+	return err == errTooBigInline || err == errTooBigCount || err == errInvalidMultiBulkLength ||
+		err == errInvalidBulkLength || err == errQueryBufferLimit
+}
+
+// Limits 解析时使用的长度限制，值 <= 0 时使用默认值
+// 创建 Reader 时确定下来，解析过程中不再读取配置
+type Limits struct {
+	ProtoMaxBulkLen  int64 // 一个字符串最多的字节数
+	QueryBufferLimit int64 // 一条命令（或者一个 RESP3 的值）最多占用的字节数
+}
+
+// configLimits 从当前的配置中取出长度限制
+func configLimits() Limits {
+	return Limits{
+		ProtoMaxBulkLen:  int64(config.Properties.ProtoMaxBulkLen),
+		QueryBufferLimit: int64(config.Properties.ClientQueryBufferLimit),
+	}
+}
+
+// withDefaults 把没有设置的限制换成默认值
+func (l Limits) withDefaults() Limits {
+	if l.ProtoMaxBulkLen <= 0 {
+		l.ProtoMaxBulkLen = defaultProtoMaxBulkLen
+	}
+	if l.QueryBufferLimit <= 0 {
+		l.QueryBufferLimit = defaultQueryBufferLimit
+	}
+	return l
+}
+
+// checkBulkLen 检查 $ 头部声明的长度，-1 表示空值
+func (l *Limits) checkBulkLen(n int64) error {
+	if n < -1 || n > l.ProtoMaxBulkLen {
+		return errInvalidBulkLength
+	}
+	return nil
+}
+
+// checkMultiBulkLen 检查数组头部声明的元素个数，-1 表示空值
+func checkMultiBulkLen(n int64) error {
+	if n < -1 || n > maxMultiBulkLen {
+		return errInvalidMultiBulkLength
+	}
+	return nil
+}
+
+// readFull 读取 n 个字节；数据比较大时不按客户端声明的长度一次分配，而是随着数据到达增长，
+// 这样只发送头部不发送数据的客户端占不了多少内存
+func readFull(bufReader *bufio.Reader, n int64) ([]byte, error) {
+	if n <= maxPreallocBulk {
+		data := make([]byte, n)
+		_, err := io.ReadFull(bufReader, data)
+		return data, err
+	}
+	data := make([]byte, 0, maxPreallocBulk)
+	for int64(len(data)) < n {
+		chunk := int(min(n-int64(len(data)), int64(cap(data))))
+		data = slices.Grow(data, chunk)
+		read, err := io.ReadFull(bufReader, data[len(data):len(data)+chunk])
+		data = data[:len(data)+read]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
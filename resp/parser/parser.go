@@ -6,21 +6,37 @@ package parser
 
 import (
 	"GoMiniCache/interface/resp"
-	"GoMiniCache/lib/logger"
 	"GoMiniCache/resp/reply"
 	"bufio"
 	"bytes"
 	"errors"
 	"io"
-	"runtime/debug"
+	"math"
 	"strconv"
 	"strings"
 )
 
+// ReadBufferSize 每个连接的读缓冲区大小
+const ReadBufferSize = 4096
+
 // Payload 存储 resp.Reply 和错误 err
 type Payload struct {
-	Data resp.Reply
-	Err  error
+	Data   resp.Reply
+	Err    error
+	Offset int64 // 解析出该数据（或错误）后，在字节流中已经消费到的位置
+	Inline bool  // 数据来自内联命令，不是 RESP 编码的数组
+}
+
+// countingReader 记录从底层 io.Reader 读出的字节数，配合 bufio.Reader.Buffered 计算精确的偏移量
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
 }
 
 // readState 读取解析器的状态
@@ -30,6 +46,8 @@ type readState struct {
 	msgType           byte     // 解析的数据类型
 	args              [][]byte // 存储已经解析的数据
 	bulkLen           int64    // 需要读取的数据长度（例：$？）
+	readingBulk       bool     // 下一次读取的是 $ 声明的 bulkLen 个字节，而不是一行
+	queryLen          int64    // 这条命令已经读取的字节数
 }
 
 // finished 解析完成了
@@ -46,115 +64,22 @@ func ParseStream(reader io.Reader) <-chan *Payload {
 
 // ParseOne reads data from []byte and return the first payload
 func ParseOne(data []byte) (resp.Reply, error) {
-	ch := make(chan *Payload)
-	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
-	payload := <-ch // parse0 will close the channel
-	if payload == nil {
-		return nil, errors.New("no reply")
-	}
+	r := NewReader(bytes.NewReader(data)) // 只读一个数据，不需要解析协程
+	defer r.Close()
+	payload := r.Next()
 	return payload.Data, payload.Err
 }
 
-// parse0 解析客户端传来的数据的主逻辑
+// parse0 解析客户端传来的数据，通过管道发送，遇到 io 错误或者无法恢复的错误以后关闭管道
 func parse0(reader io.Reader, ch chan<- *Payload) {
-	// 接收错误信息，确保一个用户的 goroutine panic 后不会导致主协程崩溃
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error(string(debug.Stack()))
-		}
-	}()
-	bufReader := bufio.NewReader(reader)
-	var state readState
-	var err error
-	var msg []byte
-	// 开启死循环读取用户输入的信息，直到他退出
+	r := NewReader(reader)
+	defer r.Close()
 	for {
-		var ioErr bool
-		// readLine 读一行数据
-		msg, ioErr, err = readLine(bufReader, &state)
-		if err != nil { // 出现错误
-			if ioErr == true { // 如果出现 io 错误，就返回错误，关闭通信通道，结束通信
-				ch <- &Payload{
-					Err: err,
-				}
-				close(ch)
-				return
-			}
-			// 如果不是 io 错误，那就是协议解析出错，直接给用户返回错误
-			ch <- &Payload{
-				Err: err,
-			}
-			state = readState{} // 重置 readState 状态，让用户重新发送数据
-			continue
-		}
-
-		// 解析读到的这一行数据，判断是不是多行解析模式（其实就是判断这行数据有没有解析）
-		if !state.readingMultiLine { // 开始解析
-			if msg[0] == '*' { // 第一个字符是'*'的情况
-				err = parseMultiBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{}
-					continue
-				}
-				if state.expectedArgsCount == 0 { // 需要解析的参数为0
-					ch <- &Payload{
-						Data: &reply.EmptyMultiBulkReply{},
-					}
-					state = readState{}
-					continue
-				}
-			} else if msg[0] == '$' { // 第一个字符是'$'的情况
-				err = parseBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{}
-					continue
-				}
-				if state.bulkLen == -1 { // 数据为空
-					ch <- &Payload{
-						Data: &reply.NullBulkReply{},
-					}
-					state = readState{}
-					continue
-				}
-			} else { // 类似 +OK 这类单行数据
-				result, err := parseSingleLineReply(msg)
-				ch <- &Payload{ // 直接返回内容了
-					Data: result,
-					Err:  err,
-				}
-				state = readState{}
-				continue
-			}
-		} else { // 已经是多行读取模式（也就是初步解析过数据行了）
-			err = readBody(msg, &state) // 最后对 body 进行解析
-			if err != nil {
-				ch <- &Payload{
-					Err: errors.New("protocol error: " + string(msg)),
-				}
-				state = readState{}
-				continue
-			}
-			// 数据解析完成了，根据请求类型发送返回的数据
-			if state.finished() {
-				var result resp.Reply
-				if state.msgType == '*' {
-					result = reply.MakeMultiBulkReply(state.args)
-				} else if state.msgType == '$' {
-					result = reply.MakeBulkReply(state.args[0])
-				}
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{}
-			}
+		payload := r.Next()
+		ch <- &payload
+		if r.Err() != nil {
+			close(ch)
+			return
 		}
 	}
 }
@@ -162,77 +87,146 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 // readLine 精确读取一行指令
 // 例1: *3\r\n（向 Redis 服务器发送一个包含 3 个元素的数组）
 // 例2: $3 SET\r\n（表示一个包含一个元素的数组，其中元素是一个长度为 3 的字符串"SET"）
-func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
+func readLine(bufReader *bufio.Reader, state *readState, limits *Limits) ([]byte, bool, error) {
 	var msg []byte
 	var err error
-	if state.bulkLen == 0 { // 正常情况，直接根据 \r\n 进行切分
-		msg, err = bufReader.ReadBytes('\n') // 根据 \n 切分数据流
+	if !state.readingBulk { // 正常情况，直接根据 \r\n 进行切分
+		msg, err = readLimitedLine(bufReader)
 		if err != nil {
 			return nil, true, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' { // 如果数据是空或者 \n 前面不是 \r 也要返回错误
-			return nil, false, errors.New("protocol error: " + string(msg))
+		// \n 前面不是 \r 要返回错误，只有内联命令可以用 \n 结尾
+		if len(msg) < 2 || msg[len(msg)-2] != '\r' {
+			if state.readingMultiLine || !isInlineCommand(msg) {
+				return nil, false, errors.New("protocol error: " + string(msg))
+			}
 		}
 	} else { // 根据之前读取的 $ 数字，严格读取字符的个数
-		msg = make([]byte, state.bulkLen+2)  // 加上 \r\n 的两个字节
-		_, err = io.ReadFull(bufReader, msg) // 读取 bulkLen+2 字节的数据
+		msg, err = readFull(bufReader, state.bulkLen+2) // 读取 bulkLen+2 字节的数据（加上 \r\n 的两个字节）
 		if err != nil {
 			return nil, true, err
 		}
-		if len(msg) == 0 ||
-			msg[len(msg)-2] != '\r' ||
-			msg[len(msg)-1] != '\n' { // 数据不为空且结尾是 \r\n 才正确
+		if msg[len(msg)-2] != '\r' || msg[len(msg)-1] != '\n' { // 结尾是 \r\n 才正确
 			return nil, false, errors.New("protocol error: " + string(msg))
 		}
-		state.bulkLen = 0 // $ 数据读完了，重置
+	}
+	if state.readingMultiLine { // 检查这条命令一共读取的字节数
+		state.queryLen += int64(len(msg))
+		if state.queryLen > limits.QueryBufferLimit {
+			return nil, false, errQueryBufferLimit
+		}
 	}
 	return msg, false, nil
 }
 
+// readLimitedLine 读取以 \n 结尾的一行，超过 maxInlineSize 还没有遇到 \n 时返回错误，防止客户端一直不换行把内存占满
+// 超长的错误没办法恢复（不知道这一行在哪里结束），和 io 错误一样结束解析
+// 一行在读缓冲区中放得下时直接返回读缓冲区的切片，下一次读取以后就失效了，需要保存时要复制
+func readLimitedLine(bufReader *bufio.Reader) ([]byte, error) {
+	line, err := bufReader.ReadSlice('\n')
+	if err == nil {
+		if len(line) > maxInlineSize {
+			return nil, errTooBigInline
+		}
+		return line, nil
+	}
+	if err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	line = bytes.Clone(line) // 比读缓冲区长，拼接起来
+	for {
+		chunk, err := bufReader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxInlineSize {
+			if line[0] == '*' || line[0] == '$' {
+				return nil, errTooBigCount
+			}
+			return nil, errTooBigInline
+		}
+		if err == nil {
+			return line, nil
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+}
+
+// parseLength 解析头部中的长度；直接解析 []byte，不用转成 string 再分配内存
+// 超过 int64 范围的数字返回 math.MaxInt64，交给长度限制去拒绝
+func parseLength(digits []byte) (int64, bool) {
+	negative := len(digits) > 0 && digits[0] == '-'
+	if negative {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 {
+		return 0, false
+	}
+	var n int64
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		if n > (math.MaxInt64-9)/10 {
+			n = math.MaxInt64
+			continue
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if negative {
+		return -n, true
+	}
+	return n, true
+}
+
 // parseMultiBulkHeader 前面 readLine 读取完一行之后，需要解析这一行数据的含义（正常的解析情况）
 func parseMultiBulkHeader(msg []byte, state *readState) error {
-	var err error
-	var expectedLine uint64
-	// 把无意义的部分切走，留下数字（例：*300\r\n, 切走第一个字符和最后两个字符）（注：base 是进制，bitSize 位数）
-	expectedLine, err = strconv.ParseUint(string(msg[1:len(msg)-2]), 10, 64)
-	if err != nil {
+	// 把无意义的部分切走，留下数字（例：*300\r\n, 切走第一个字符和最后两个字符）
+	expectedLine, ok := parseLength(msg[1 : len(msg)-2])
+	if !ok || expectedLine < 0 {
 		return errors.New("protocol error: " + string(msg))
 	}
+	if expectedLine > maxMultiBulkLen {
+		return errInvalidMultiBulkLength
+	}
 	if expectedLine == 0 { // 用户没加参数，返回
 		state.expectedArgsCount = 0
 		return nil
-	} else if expectedLine > 0 { // 用户有加参数，处理
-		state.msgType = msg[0]                       // 例：*3\r\n, msgType = * 表示他是个数组
-		state.readingMultiLine = true                // 进入多行模式
-		state.expectedArgsCount = int(expectedLine)  // 数据长度
-		state.args = make([][]byte, 0, expectedLine) // 初始化 args
-		return nil
-	} else {
-		return errors.New("protocol error: " + string(msg))
 	}
+	// 用户有加参数，处理
+	state.msgType = msg[0]                      // 例：*3\r\n, msgType = * 表示他是个数组
+	state.readingMultiLine = true               // 进入多行模式
+	state.expectedArgsCount = int(expectedLine) // 数据长度
+	state.queryLen = int64(len(msg))
+	// 初始化 args，个数来自客户端，只预先分配一部分
+	state.args = make([][]byte, 0, min(expectedLine, maxPreallocArgs))
+	return nil
 }
 
 // parseBulkHeader 如果是遇到 $ 开头的数据行，使用这个解析
-func parseBulkHeader(msg []byte, state *readState) error {
-	var err error
-	state.bulkLen, err = strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
-	if err != nil {
+func parseBulkHeader(msg []byte, state *readState, limits *Limits) error {
+	var ok bool
+	state.bulkLen, ok = parseLength(msg[1 : len(msg)-2])
+	if !ok {
 		return errors.New("protocol error: " + string(msg))
 	}
+	if err := limits.checkBulkLen(state.bulkLen); err != nil {
+		return err
+	}
 	if state.bulkLen == -1 { // 空
 		return nil
-	} else if state.bulkLen > 0 { // 修改解析器的状态
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = 1
-		state.args = make([][]byte, 0, 1) // 给该数据初始化了一个切片，元素类型为[]byte，长度为0，容量为1
-		return nil
-	} else {
-		return errors.New("protocol error: " + string(msg))
 	}
+	// 修改解析器的状态
+	state.msgType = msg[0]
+	state.readingMultiLine = true
+	state.readingBulk = true
+	state.expectedArgsCount = 1
+	state.queryLen = int64(len(msg))
+	state.args = make([][]byte, 0, 1) // 给该数据初始化了一个切片，元素类型为[]byte，长度为0，容量为1
+	return nil
 }
 
-// parseSingleLineReply 如果客户端发送类似 +OK 的信息，使用这个方法解析
+// parseSingleLineReply 如果客户端发送类似 +OK 的信息（或者 # 开头的注释行），使用这个方法解析
 func parseSingleLineReply(msg []byte) (resp.Reply, error) {
 	str := strings.TrimSuffix(string(msg), "\r\n") // 去除后缀"\r\n"
 	var result resp.Reply
@@ -247,25 +241,43 @@ func parseSingleLineReply(msg []byte) (resp.Reply, error) {
 			return nil, errors.New("protocol error: " + string(msg))
 		}
 		result = reply.MakeIntReply(val)
+	case '#': // 注释行（例：AOF 中的时间戳）
+		result = reply.MakeAnnotationReply(str[1:])
 	}
 	return result, nil
 }
 
 // readBody 前面解析完头数字，后续的 body 需要根据数字解析解析
-func readBody(msg []byte, state *readState) error {
+func readBody(msg []byte, state *readState, limits *Limits) error {
 	line := msg[0 : len(msg)-2]
 	var err error
+	if state.readingBulk { // $ 声明的数据，可能以 $ 开头，也可能是空的
+		state.args = append(state.args, line)
+		state.readingBulk = false
+		return nil
+	}
+	if len(line) == 0 {
+		return errors.New("protocol error: " + string(msg))
+	}
 	if line[0] == '$' {
-		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
+		var ok bool
+		state.bulkLen, ok = parseLength(line[1:])
+		if !ok {
 			return errors.New("protocol error: " + string(msg))
 		}
-		if state.bulkLen <= 0 { // 空
+		if err = limits.checkBulkLen(state.bulkLen); err != nil {
+			return err
+		}
+		if state.queryLen+state.bulkLen > limits.QueryBufferLimit { // 还没读数据就知道会超过限制
+			return errQueryBufferLimit
+		}
+		if state.bulkLen == -1 { // 空
 			state.args = append(state.args, []byte{})
-			state.bulkLen = 0
+		} else {
+			state.readingBulk = true
 		}
-	} else { // 正常情况就直接塞进 args 里面
-		state.args = append(state.args, line)
+	} else { // 正常情况就直接塞进 args 里面（行数据引用读缓冲区，要复制）
+		state.args = append(state.args, bytes.Clone(line))
 	}
 	return nil
 }
@@ -4,9 +4,14 @@ import (
 	"GoMiniCache/interface/resp"
 	"GoMiniCache/lib/utils"
 	"GoMiniCache/resp/reply"
+	"bufio"
 	"bytes"
 	"io"
+	"math/big"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseStream(t *testing.T) {
@@ -79,3 +84,275 @@ func TestParseOne(t *testing.T) {
 		}
 	}
 }
+
+func TestParseResp3(t *testing.T) {
+	replies := []resp.Reply{
+		reply.MakeNullReply(),
+		reply.MakeBoolReply(true),
+		reply.MakeBoolReply(false),
+		reply.MakeDoubleReply(1.5),
+		reply.MakeBigNumberReply(new(big.Int).Lsh(big.NewInt(1), 100)),
+		reply.MakeVerbatimReply("txt", []byte("a\r\nb")),
+		reply.MakeMapReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("k")),
+			reply.MakeSetReply([]resp.Reply{reply.MakeIntReply(1), reply.MakeNullReply()}),
+		}),
+		reply.MakePushReply([]resp.Reply{reply.MakeBulkReply([]byte("invalidate")), reply.MakeNullReply()}),
+		reply.MakeAttributeReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("ttl")), reply.MakeIntReply(10),
+		}, reply.MakeBulkReply([]byte("v"))),
+	}
+	for _, re := range replies {
+		result, err := ParseOne(reply.Encode(re, 3))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !utils.BytesEquals(reply.Encode(result, 3), reply.Encode(re, 3)) {
+			t.Error("parse failed: " + string(reply.Encode(re, 3)))
+		}
+	}
+
+	// 注释行仍然按单行解析
+	result, err := ParseOne([]byte("#1700000000\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.(*reply.AnnotationReply); !ok {
+		t.Errorf("expected annotation, got %T", result)
+	}
+
+	// 嵌套太深的聚合类型
+	deep := bytes.Repeat([]byte("*1\r\n"), maxNestingDepth+1)
+	deep = append(deep, ":1\r\n"...)
+	if _, err := ParseOne(append([]byte("~1\r\n"), deep...)); err == nil {
+		t.Error("expected nesting error")
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+	}{
+		{"PING\n", []string{"PING"}},
+		{"  set  a\t b \r\n", []string{"set", "a", "b"}},
+		{`set "hello world" 'it\'s'` + "\r\n", []string{"set", "hello world", "it's"}},
+		{`set k "a\r\n\x41\"b"` + "\r\n", []string{"set", "k", "a\r\nA\"b"}},
+		{`set k ""` + "\r\n", []string{"set", "k", ""}},
+	}
+	for _, tt := range tests {
+		result, err := ParseOne([]byte(tt.line))
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		args := make([][]byte, len(tt.args))
+		for i, arg := range tt.args {
+			args[i] = []byte(arg)
+		}
+		expected := reply.MakeMultiBulkReply(args)
+		if !utils.BytesEquals(result.ToBytes(), expected.ToBytes()) {
+			t.Errorf("%q: got %q", tt.line, result.ToBytes())
+		}
+	}
+
+	for _, line := range []string{`set "a` + "\r\n", `set 'a'b` + "\r\n", `set "a"b` + "\r\n"} {
+		if _, err := ParseOne([]byte(line)); err != errUnbalancedQuotes {
+			t.Errorf("%q: expected unbalanced quotes, got %v", line, err)
+		}
+	}
+
+	// 空行被忽略，一行太长时返回错误并结束解析
+	stream := "\r\n\nPING\r\n" + strings.Repeat("a", maxInlineSize+1) + "\r\nPING\r\n"
+	var payloads []*Payload
+	for payload := range ParseStream(strings.NewReader(stream)) {
+		payloads = append(payloads, payload)
+	}
+	if len(payloads) != 2 || !payloads[0].Inline || payloads[1].Err == nil ||
+		payloads[1].Err.Error() != "protocol error: too big inline request" {
+		t.Errorf("unexpected payloads: %+v", payloads)
+	}
+}
+
+func TestParseEmptyAndDollarArgs(t *testing.T) {
+	// 空字符串和以 $ 开头的参数
+	expected := reply.MakeMultiBulkReply([][]byte{[]byte("set"), {}, []byte("$5")})
+	result, err := ParseOne(expected.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utils.BytesEquals(result.ToBytes(), expected.ToBytes()) {
+		t.Errorf("got %q", result.ToBytes())
+	}
+	result, err = ParseOne([]byte("$0\r\n\r\n"))
+	if err != nil || !utils.BytesEquals(result.ToBytes(), []byte("$0\r\n\r\n")) {
+		t.Errorf("empty bulk: %v %v", result, err)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits := Limits{ProtoMaxBulkLen: 16, QueryBufferLimit: 64}
+
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{"$9999999999\r\n", errInvalidBulkLength},
+		{"$17\r\n", errInvalidBulkLength},
+		{"*1\r\n$-2\r\n", errInvalidBulkLength},
+		{"*9999999999\r\n", errInvalidMultiBulkLength},
+		{"*3\r\n$16\r\naaaaaaaaaaaaaaaa\r\n$16\r\naaaaaaaaaaaaaaaa\r\n$16\r\n", errQueryBufferLimit},
+		{"*100\r\n" + strings.Repeat("$1\r\na\r\n", 20), errQueryBufferLimit},
+		{"!17\r\n", errInvalidBulkLength},
+		{"~9999999999\r\n", errInvalidMultiBulkLength},
+		{"~3\r\n$16\r\naaaaaaaaaaaaaaaa\r\n$16\r\naaaaaaaaaaaaaaaa\r\n$16\r\n", errQueryBufferLimit},
+		{"*" + strings.Repeat("1", maxInlineSize) + "\r\n", errTooBigCount},
+	}
+	for _, tt := range tests {
+		// 超过限制以后结束解析，后面的 PING 不会被解析
+		r := NewReaderWithLimits(strings.NewReader(tt.input+"*1\r\n$4\r\nPING\r\n"), limits)
+		payload := r.Next()
+		if payload.Err != tt.err || r.Err() != tt.err || r.Next().Err != tt.err {
+			t.Errorf("%.40q: expected %v, got %v", tt.input, tt.err, payload.Err)
+		}
+		r.Close()
+	}
+
+	// 没有超过限制的数据正常解析
+	expected := reply.MakeMultiBulkReply([][]byte{[]byte("set"), []byte("aaaaaaaaaaaaaaaa")})
+	r := NewReaderWithLimits(bytes.NewReader(expected.ToBytes()), limits)
+	defer r.Close()
+	payload := r.Next()
+	if payload.Err != nil || !utils.BytesEquals(payload.Data.ToBytes(), expected.ToBytes()) {
+		t.Errorf("parse failed: %v", payload.Err)
+	}
+}
+
+func TestReadFull(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), maxPreallocBulk)
+	result, err := readFull(bufio.NewReader(bytes.NewReader(data)), int64(len(data)))
+	if err != nil || !bytes.Equal(result, data) {
+		t.Errorf("readFull failed: %v", err)
+	}
+	if _, err := readFull(bufio.NewReader(bytes.NewReader(data)), int64(len(data)+1)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF, got %v", err)
+	}
+}
+
+// FuzzParseStream 任意输入都不能让解析协程 panic 或者卡住，管道最后一个数据一定是错误
+// 正常的数据作为种子，畸形的数据放在 testdata/fuzz/FuzzParseStream 中
+func FuzzParseStream(f *testing.F) {
+	for _, seed := range []string{
+		"*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\nb\r\n",
+		"*2\r\n$3\r\nget\r\n$0\r\n\r\n",
+		"$-1\r\n*-1\r\n*0\r\n",
+		"+OK\r\n-ERR x\r\n:1\r\n#1700000000\r\n",
+		"PING\nset k \"a b\" 'c'\r\n",
+		"%1\r\n+k\r\n~2\r\n#t\r\n_\r\n",
+		"|1\r\n+a\r\n:1\r\n>1\r\n,1.5\r\n(123\r\n=7\r\ntxt:abc\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ch := ParseStream(bytes.NewReader(data))
+		var last *Payload
+		for {
+			select {
+			case payload, ok := <-ch:
+				if !ok {
+					if last == nil || last.Err == nil {
+						t.Fatalf("parser stopped without an error: %q", data)
+					}
+					return
+				}
+				if payload.Err == nil && payload.Data == nil {
+					t.Fatalf("empty payload: %q", data)
+				}
+				last = payload
+			case <-time.After(5 * time.Second):
+				t.Fatalf("parser hangs: %q", data)
+			}
+		}
+	})
+}
+
+func TestReader(t *testing.T) {
+	// 保存下来的参数不能被后面的读取覆盖（行数据引用读缓冲区）
+	var stream bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		stream.Write(reply.MakeMultiBulkReply([][]byte{[]byte("set"), []byte(strconv.Itoa(i))}).ToBytes())
+		stream.WriteString("*2\r\nget\r\n" + strconv.Itoa(i) + "\r\n") // 不带 $ 的参数
+		stream.WriteString("echo " + strconv.Itoa(i) + "\r\n")
+	}
+	reader := NewReader(bytes.NewReader(stream.Bytes()))
+	defer reader.Close()
+	var saved [][][]byte
+	for {
+		payload := reader.Next()
+		if payload.Err != nil {
+			if payload.Err != io.EOF || reader.Err() != io.EOF {
+				t.Fatalf("unexpected error: %v", payload.Err)
+			}
+			break
+		}
+		saved = append(saved, payload.Data.(*reply.MultiBulkReply).Args)
+	}
+	if len(saved) != 3000 {
+		t.Fatalf("expected 3000 commands, got %d", len(saved))
+	}
+	for i, args := range saved {
+		if string(args[1]) != strconv.Itoa(i/3) {
+			t.Fatalf("command %d: args overwritten: %q", i, args)
+		}
+	}
+	if reader.Next().Err != io.EOF || reader.Offset() != int64(stream.Len()) {
+		t.Error("reader should keep returning EOF at the end of the stream")
+	}
+}
+
+// benchmarkCommands 生成 n 条 SET 命令
+func benchmarkCommands(n int) []byte {
+	var buf bytes.Buffer
+	value := bytes.Repeat([]byte("v"), 64)
+	for i := 0; i < n; i++ {
+		buf.Write(reply.MakeMultiBulkReply([][]byte{[]byte("SET"), []byte("key:" + strconv.Itoa(i)), value}).ToBytes())
+	}
+	return buf.Bytes()
+}
+
+const benchmarkBatch = 1000
+
+func BenchmarkParseStream(b *testing.B) {
+	data := benchmarkCommands(benchmarkBatch)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for payload := range ParseStream(bytes.NewReader(data)) {
+			if payload.Err != nil && payload.Err != io.EOF {
+				b.Fatal(payload.Err)
+			}
+		}
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	data := benchmarkCommands(benchmarkBatch)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader := NewReader(bytes.NewReader(data))
+		for {
+			payload := reader.Next()
+			if payload.Err != nil {
+				if payload.Err != io.EOF {
+					b.Fatal(payload.Err)
+				}
+				break
+			}
+		}
+		reader.Close()
+	}
+}
@@ -0,0 +1,202 @@
+package parser
+
+/*
+ * 同步的解析器：调用者在自己的协程里逐个读取请求，不需要额外的协程和管道
+ * 读缓冲区从池中取出，关闭时放回；行数据直接引用读缓冲区，只有参数本身需要分配内存
+ */
+
+import (
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/lib/logger"
+	"GoMiniCache/resp/reply"
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"runtime/debug"
+	"sync"
+)
+
+// bufReaderPool 复用每个连接的读缓冲区
+var bufReaderPool = sync.Pool{
+	New: func() any {
+		return bufio.NewReaderSize(nil, ReadBufferSize)
+	},
+}
+
+// Reader 从 io.Reader 中逐个解析请求
+type Reader struct {
+	counter   countingReader
+	bufReader *bufio.Reader
+	state     readState
+	limits    Limits
+	err       error // 结束解析的错误（io 错误或者超过了长度限制），之后不能再读取
+}
+
+// NewReader 创建 Reader，长度限制取自创建时的配置，用完以后要调用 Close 归还读缓冲区
+func NewReader(reader io.Reader) *Reader {
+	return NewReaderWithLimits(reader, configLimits())
+}
+
+// NewReaderWithLimits 使用指定的长度限制创建 Reader
+func NewReaderWithLimits(reader io.Reader, limits Limits) *Reader {
+	r := &Reader{
+		counter: countingReader{reader: reader},
+		limits:  limits.withDefaults(),
+	}
+	r.bufReader = bufReaderPool.Get().(*bufio.Reader)
+	r.bufReader.Reset(&r.counter)
+	return r
+}
+
+// Close 把读缓冲区放回池中，不会关闭底层的 io.Reader
+func (r *Reader) Close() {
+	if r.bufReader == nil {
+		return
+	}
+	r.bufReader.Reset(nil)
+	bufReaderPool.Put(r.bufReader)
+	r.bufReader = nil
+	if r.err == nil {
+		r.err = errors.New("reader closed")
+	}
+}
+
+// Err 返回结束解析的错误，为 nil 时可以继续读取
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Offset 返回已经消费的字节数（读进缓冲区但还没解析的不算）
+func (r *Reader) Offset() int64 {
+	if r.bufReader == nil {
+		return r.counter.count
+	}
+	return r.counter.count - int64(r.bufReader.Buffered())
+}
+
+// fail 记录结束解析的错误
+func (r *Reader) fail(err error) Payload {
+	r.err = err
+	return Payload{
+		Err:    err,
+		Offset: r.Offset(),
+	}
+}
+
+// reset 协议错误以后重置状态，让用户重新发送数据
+func (r *Reader) reset(err error) Payload {
+	r.state = readState{}
+	return Payload{
+		Err:    err,
+		Offset: r.Offset(),
+	}
+}
+
+// done 解析出一个完整的数据
+func (r *Reader) done(data resp.Reply) Payload {
+	r.state = readState{}
+	return Payload{
+		Data:   data,
+		Offset: r.Offset(),
+	}
+}
+
+// Next 读取下一个数据（或者错误）；Err 不为 nil 以后一直返回同一个错误
+// 返回的数据不会再被 Reader 修改，可以放心保存
+func (r *Reader) Next() (payload Payload) {
+	if r.err != nil {
+		return Payload{
+			Err:    r.err,
+			Offset: r.Offset(),
+		}
+	}
+	// 接收错误信息，确保解析出错不会导致整个服务崩溃
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+			payload = r.fail(errors.New("protocol error: internal error"))
+		}
+	}()
+	for {
+		// readLine 读一行数据，行数据引用读缓冲区，下一次读取以后就失效了
+		msg, ioErr, err := readLine(r.bufReader, &r.state, &r.limits)
+		if err != nil { // 出现错误
+			if ioErr || isFatal(err) { // 如果出现 io 错误（或者超过了长度限制），就结束解析
+				return r.fail(err)
+			}
+			// 如果不是 io 错误，那就是协议解析出错，直接给用户返回错误
+			return r.reset(err)
+		}
+
+		// 解析读到的这一行数据，判断是不是多行解析模式（其实就是判断这行数据有没有解析）
+		if r.state.readingMultiLine { // 已经是多行读取模式（也就是初步解析过数据行了）
+			err = readBody(msg, &r.state, &r.limits) // 最后对 body 进行解析
+			if isFatal(err) {
+				return r.fail(err)
+			}
+			if err != nil {
+				return r.reset(errors.New("protocol error: " + string(msg)))
+			}
+			// 数据解析完成了，根据请求类型返回数据
+			if r.state.finished() {
+				if r.state.msgType == '$' {
+					return r.done(reply.MakeBulkReply(r.state.args[0]))
+				}
+				return r.done(reply.MakeMultiBulkReply(r.state.args))
+			}
+			continue
+		}
+
+		switch {
+		case msg[0] == '*': // 第一个字符是'*'的情况
+			err = parseMultiBulkHeader(msg, &r.state)
+			if isFatal(err) {
+				return r.fail(err)
+			}
+			if err != nil {
+				return r.reset(errors.New("protocol error: " + string(msg)))
+			}
+			if r.state.expectedArgsCount == 0 { // 需要解析的参数为0
+				return r.done(&reply.EmptyMultiBulkReply{})
+			}
+		case msg[0] == '$': // 第一个字符是'$'的情况
+			err = parseBulkHeader(msg, &r.state, &r.limits)
+			if isFatal(err) {
+				return r.fail(err)
+			}
+			if err != nil {
+				return r.reset(errors.New("protocol error: " + string(msg)))
+			}
+			if r.state.bulkLen == -1 { // 数据为空
+				return r.done(&reply.NullBulkReply{})
+			}
+		case isResp3Header(msg): // RESP3 的类型，聚合类型会一直读到整个值结束
+			result, ioErr, err := readResp3(r.bufReader, bytes.Clone(msg), &r.limits)
+			if ioErr || isFatal(err) {
+				return r.fail(err)
+			}
+			if err != nil {
+				return r.reset(err)
+			}
+			return r.done(result)
+		case isInlineCommand(msg): // 内联命令，例：telnet 中输入的 PING
+			args, err := parseInlineCommand(bytes.Clone(msg)) // 参数引用这一行，不能引用读缓冲区
+			if err != nil {
+				return r.reset(err)
+			}
+			if len(args) == 0 { // 空行，忽略
+				continue
+			}
+			payload = r.done(reply.MakeMultiBulkReply(args))
+			payload.Inline = true
+			return payload
+		default: // 类似 +OK 这类单行数据
+			result, err := parseSingleLineReply(msg)
+			if err != nil {
+				return r.reset(err)
+			}
+			return r.done(result)
+		}
+	}
+}
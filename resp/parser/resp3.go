@@ -0,0 +1,208 @@
+package parser
+
+/*
+ * 解析 RESP3 的类型：_ 空值、# 布尔值、, 浮点数、( 大整数、= 原样输出的字符串、! 错误、
+ * % 字典、~ 集合、> 推送、| 属性
+ * 命令只会是字符串的数组，这些类型出现在复制或者 AOF 的数据里；聚合类型可以嵌套，所以递归地读完整个值
+ */
+
+import (
+	"GoMiniCache/interface/resp"
+	"GoMiniCache/resp/reply"
+	"bufio"
+	"bytes"
+	"errors"
+	"math/big"
+	"strconv"
+)
+
+// maxNestingDepth 聚合类型最多嵌套的层数，防止恶意的数据把栈用完
+const maxNestingDepth = 64
+
+// isResp3Header 返回这一行是不是 RESP3 类型的开头
+// # 开头的也可能是 AOF 中的注释行，只有 #t 和 #f 是布尔值
+func isResp3Header(msg []byte) bool {
+	switch msg[0] {
+	case '_', ',', '(', '=', '!', '%', '~', '>', '|':
+		return true
+	case '#':
+		line := string(msg[:len(msg)-2])
+		return line == "#t" || line == "#f"
+	}
+	return false
+}
+
+// protocolError 协议错误
+func protocolError(msg []byte) error {
+	return errors.New("protocol error: " + string(msg))
+}
+
+// resp3Reader 读取一个完整的值，记录还可以读取的字节数
+type resp3Reader struct {
+	bufReader *bufio.Reader
+	limits    *Limits
+	remaining int64 // 整个值最多还可以读取的字节数，见 client-query-buffer-limit
+}
+
+// consume 记录读取了 n 个字节，超过限制时返回错误
+func (r *resp3Reader) consume(n int64) error {
+	r.remaining -= n
+	if r.remaining < 0 {
+		return errQueryBufferLimit
+	}
+	return nil
+}
+
+// readHeader 读取一个值的第一行，返回的数据包括 \r\n
+func (r *resp3Reader) readHeader() ([]byte, bool, error) {
+	msg, err := readLimitedLine(r.bufReader)
+	if err != nil {
+		return nil, true, err
+	}
+	msg = bytes.Clone(msg) // 读取聚合类型里面的值时还会用到
+
+	if len(msg) < 3 || msg[len(msg)-2] != '\r' {
+		return nil, false, protocolError(msg)
+	}
+	if err := r.consume(int64(len(msg))); err != nil {
+		return nil, false, err
+	}
+	return msg, false, nil
+}
+
+// readResp3 读取一个完整的值，header 是已经读出的第一行，返回的 bool 表示是否是 io 错误
+func readResp3(bufReader *bufio.Reader, header []byte, limits *Limits) (resp.Reply, bool, error) {
+	r := &resp3Reader{
+		bufReader: bufReader,
+		limits:    limits,
+		remaining: limits.QueryBufferLimit,
+	}
+	if err := r.consume(int64(len(header))); err != nil {
+		return nil, false, err
+	}
+	return r.readValue(header, 0)
+}
+
+// readValue 按第一行的类型读取一个值，聚合类型递归地读取里面的值
+func (r *resp3Reader) readValue(header []byte, depth int) (resp.Reply, bool, error) {
+	line := string(header[1 : len(header)-2])
+	switch header[0] {
+	case '+', '-', ':':
+		result, err := parseSingleLineReply(header)
+		return result, false, err
+	case '_':
+		if line != "" {
+			return nil, false, protocolError(header)
+		}
+		return reply.MakeNullReply(), false, nil
+	case '#':
+		if line != "t" && line != "f" {
+			return nil, false, protocolError(header)
+		}
+		return reply.MakeBoolReply(line == "t"), false, nil
+	case ',':
+		value, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, false, protocolError(header)
+		}
+		return reply.MakeDoubleReply(value), false, nil
+	case '(':
+		value, ok := new(big.Int).SetString(line, 10)
+		if !ok {
+			return nil, false, protocolError(header)
+		}
+		return reply.MakeBigNumberReply(value), false, nil
+	case '$', '=', '!':
+		return r.readBlob(header)
+	case '*', '~', '>', '%', '|':
+		if depth >= maxNestingDepth {
+			return nil, false, errors.New("protocol error: too many nested aggregates")
+		}
+		return r.readAggregate(header, depth)
+	}
+	return nil, false, protocolError(header)
+}
+
+// readBlob 读取带长度的字符串：$ 字符串、= 原样输出的字符串、! 错误
+func (r *resp3Reader) readBlob(header []byte) (resp.Reply, bool, error) {
+	length, err := strconv.ParseInt(string(header[1:len(header)-2]), 10, 64)
+	if err != nil || (length == -1 && header[0] != '$') {
+		return nil, false, protocolError(header)
+	}
+	if err := r.limits.checkBulkLen(length); err != nil {
+		return nil, false, err
+	}
+	if length == -1 {
+		return reply.MakeNullBulkReply(), false, nil
+	}
+	if err := r.consume(length + 2); err != nil { // 还没读数据就知道会超过限制
+		return nil, false, err
+	}
+	data, err := readFull(r.bufReader, length+2)
+	if err != nil {
+		return nil, true, err
+	}
+	if data[length] != '\r' || data[length+1] != '\n' {
+		return nil, false, protocolError(header)
+	}
+	data = data[:length]
+	switch header[0] {
+	case '=': // 前三个字符是格式，例：txt:hello
+		if len(data) < 4 || data[3] != ':' {
+			return nil, false, protocolError(header)
+		}
+		return reply.MakeVerbatimReply(string(data[:3]), data[4:]), false, nil
+	case '!':
+		return reply.MakeErrReply(string(data)), false, nil
+	}
+	return reply.MakeBulkReply(data), false, nil
+}
+
+// readAggregate 读取聚合类型：* 数组、~ 集合、> 推送、% 字典、| 属性（后面还跟着一个值）
+func (r *resp3Reader) readAggregate(header []byte, depth int) (resp.Reply, bool, error) {
+	count, err := strconv.ParseInt(string(header[1:len(header)-2]), 10, 64)
+	if err != nil || (count == -1 && header[0] != '*') {
+		return nil, false, protocolError(header)
+	}
+	if err := checkMultiBulkLen(count); err != nil {
+		return nil, false, err
+	}
+	if count == -1 {
+		return reply.MakeNullBulkReply(), false, nil
+	}
+	if header[0] == '%' || header[0] == '|' { // 字典和属性的个数是键值对的个数
+		count *= 2
+	}
+	var items []resp.Reply // 个数来自客户端，不按它预先分配
+	for i := int64(0); i < count; i++ {
+		item, ioErr, err := r.readNext(depth + 1)
+		if err != nil {
+			return nil, ioErr, err
+		}
+		items = append(items, item)
+	}
+	switch header[0] {
+	case '~':
+		return reply.MakeSetReply(items), false, nil
+	case '>':
+		return reply.MakePushReply(items), false, nil
+	case '%':
+		return reply.MakeMapReply(items), false, nil
+	case '|':
+		value, ioErr, err := r.readNext(depth + 1)
+		if err != nil {
+			return nil, ioErr, err
+		}
+		return reply.MakeAttributeReply(items, value), false, nil
+	}
+	return reply.MakeMultiRawReply(items), false, nil
+}
+
+// readNext 读取下一个完整的值
+func (r *resp3Reader) readNext(depth int) (resp.Reply, bool, error) {
+	header, ioErr, err := r.readHeader()
+	if err != nil {
+		return nil, ioErr, err
+	}
+	return r.readValue(header, depth)
+}
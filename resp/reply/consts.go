@@ -55,6 +55,14 @@ func (r *NullBulkReply) ToBytes() []byte {
 	return nullBulkBytes
 }
 
+// Encode 按协议版本序列化，RESP3 中所有的空值都是 _
+func (r *NullBulkReply) Encode(protocol int) []byte {
+	if protocol < 3 {
+		return nullBulkBytes
+	}
+	return nullBytes
+}
+
 // MakeNullBulkReply creates a new NullBulkReply
 func MakeNullBulkReply() *NullBulkReply {
 	return &NullBulkReply{}
@@ -11,8 +11,6 @@ import (
 )
 
 var (
-	nullBulkReplyBytes = []byte("$-1")
-
 	// CRLF 常用的序列化分隔符
 	CRLF = "\r\n"
 )
@@ -33,9 +31,10 @@ func MakeBulkReply(arg []byte) *BulkReply {
 }
 
 // ToBytes 序列化 resp.Reply
+// Arg 为 nil 时是空回复 $-1，长度为 0 的切片是空字符串 $0
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
-		return nullBulkReplyBytes
+	if r.Arg == nil {
+		return nullBulkBytes
 	}
 	// 序列化成 RESP 协议的形式
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
@@ -70,6 +69,30 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	return buf.Bytes()
 }
 
+/* ---- 回复嵌套的多个回复 ---- */
+
+// MultiRawReply 存储多个任意类型的回复，用于嵌套的数组，例：SCAN 返回 [游标, [键...]]
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+// MakeMultiRawReply 创建 MultiRawReply
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *MultiRawReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化，嵌套的回复也按这个版本编码
+func (r *MultiRawReply) Encode(protocol int) []byte {
+	return encodeAggregate("*", len(r.Replies), r.Replies, protocol)
+}
+
 /* ---- 回复状态信息 ---- */
 
 // StatusReply 存储状态字符串
@@ -107,6 +130,25 @@ func (r *IntReply) ToBytes() []byte {
 	return []byte(":" + strconv.FormatInt(r.Code, 10) + CRLF)
 }
 
+/* ---- AOF 中的注释行 ---- */
+
+// AnnotationReply 以 '#' 开头的注释行，不是命令，例：AOF 里的时间戳 #TS:1700000000
+type AnnotationReply struct {
+	Text string
+}
+
+// MakeAnnotationReply 创建 AnnotationReply
+func MakeAnnotationReply(text string) *AnnotationReply {
+	return &AnnotationReply{
+		Text: text,
+	}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *AnnotationReply) ToBytes() []byte {
+	return []byte("#" + r.Text + CRLF)
+}
+
 /* ---- 回复错误信息 ---- */
 
 type ErrorReply interface {
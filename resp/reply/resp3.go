@@ -0,0 +1,311 @@
+package reply
+
+/*
+ * RESP3 新增的回复类型
+ *
+ * 命令的执行方法直接返回这些结构化的回复，写给客户端时按连接的协议版本编码：
+ *   - Encode(r, 3) 使用 RESP3 的编码
+ *   - ToBytes 和 Encode(r, 2) 降级成 RESP2 的类型，例：map 变成键和值交替的数组，布尔值变成 1 和 0
+ * 这样没有改过的调用者（AOF、测试、直接调用 ToBytes 的地方）拿到的仍然是 RESP2 的编码
+ */
+
+import (
+	"GoMiniCache/interface/resp"
+	"bytes"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// ProtocolReply 在 RESP2 和 RESP3 下编码不同的回复，ToBytes 返回 RESP2 的编码
+// 包含其他回复的容器也要实现它，把协议版本传给里面的回复
+type ProtocolReply interface {
+	resp.Reply
+	Encode(protocol int) []byte
+}
+
+// Encode 按连接使用的协议版本序列化回复
+func Encode(r resp.Reply, protocol int) []byte {
+	if pr, ok := r.(ProtocolReply); ok {
+		return pr.Encode(protocol)
+	}
+	return r.ToBytes()
+}
+
+// encodeAggregate 序列化聚合类型：RESP3 使用 prefix，RESP2 使用数组
+func encodeAggregate(prefix string, count int, items []resp.Reply, protocol int) []byte {
+	var buf bytes.Buffer
+	if protocol < 3 {
+		prefix = "*"
+	}
+	buf.WriteString(prefix + strconv.Itoa(count) + CRLF)
+	for _, item := range items {
+		buf.Write(Encode(item, protocol))
+	}
+	return buf.Bytes()
+}
+
+/* ---- 字典 ---- */
+
+// MapReply 键值对，例：CONFIG GET、HELLO 的回复；RESP2 中是键和值交替的数组
+type MapReply struct {
+	Pairs []resp.Reply // 键和值交替排列
+}
+
+// MakeMapReply 创建 MapReply，pairs 中键和值交替排列
+func MakeMapReply(pairs []resp.Reply) *MapReply {
+	return &MapReply{
+		Pairs: pairs,
+	}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *MapReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化
+func (r *MapReply) Encode(protocol int) []byte {
+	if protocol < 3 {
+		return encodeAggregate("*", len(r.Pairs), r.Pairs, protocol)
+	}
+	return encodeAggregate("%", len(r.Pairs)/2, r.Pairs, protocol)
+}
+
+/* ---- 集合 ---- */
+
+// SetReply 没有顺序、不重复的元素；RESP2 中是数组
+type SetReply struct {
+	Members []resp.Reply
+}
+
+// MakeSetReply 创建 SetReply
+func MakeSetReply(members []resp.Reply) *SetReply {
+	return &SetReply{
+		Members: members,
+	}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *SetReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化
+func (r *SetReply) Encode(protocol int) []byte {
+	return encodeAggregate("~", len(r.Members), r.Members, protocol)
+}
+
+/* ---- 推送消息 ---- */
+
+// PushReply 服务器主动推送给客户端的消息，例：发布订阅的消息、客户端缓存的失效通知；RESP2 中是数组
+type PushReply struct {
+	Items []resp.Reply
+}
+
+// MakePushReply 创建 PushReply
+func MakePushReply(items []resp.Reply) *PushReply {
+	return &PushReply{
+		Items: items,
+	}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *PushReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化
+func (r *PushReply) Encode(protocol int) []byte {
+	return encodeAggregate(">", len(r.Items), r.Items, protocol)
+}
+
+/* ---- 属性 ---- */
+
+// AttributeReply 附带在回复前面的辅助信息，客户端不关心时可以忽略；RESP2 中只有回复本身
+type AttributeReply struct {
+	Attrs []resp.Reply // 键和值交替排列
+	Reply resp.Reply
+}
+
+// MakeAttributeReply 创建 AttributeReply
+func MakeAttributeReply(attrs []resp.Reply, r resp.Reply) *AttributeReply {
+	return &AttributeReply{
+		Attrs: attrs,
+		Reply: r,
+	}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *AttributeReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化
+func (r *AttributeReply) Encode(protocol int) []byte {
+	if protocol < 3 {
+		return Encode(r.Reply, protocol)
+	}
+	return append(encodeAggregate("|", len(r.Attrs)/2, r.Attrs, protocol), Encode(r.Reply, protocol)...)
+}
+
+/* ---- 浮点数 ---- */
+
+// DoubleReply 浮点数；RESP2 中是字符串
+type DoubleReply struct {
+	Value float64
+}
+
+// MakeDoubleReply 创建 DoubleReply
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{
+		Value: value,
+	}
+}
+
+// FormatDouble 按 Redis 的格式输出浮点数，无穷大和非数字是 inf、-inf、nan
+func FormatDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	case math.IsNaN(value):
+		return "nan"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *DoubleReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化
+func (r *DoubleReply) Encode(protocol int) []byte {
+	str := FormatDouble(r.Value)
+	if protocol < 3 {
+		return []byte("$" + strconv.Itoa(len(str)) + CRLF + str + CRLF)
+	}
+	return []byte("," + str + CRLF)
+}
+
+/* ---- 布尔值 ---- */
+
+var (
+	trueBytes  = []byte("#t\r\n")
+	falseBytes = []byte("#f\r\n")
+)
+
+// BoolReply 布尔值；RESP2 中是整数 1 和 0
+type BoolReply struct {
+	Value bool
+}
+
+// MakeBoolReply 创建 BoolReply
+func MakeBoolReply(value bool) *BoolReply {
+	return &BoolReply{
+		Value: value,
+	}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *BoolReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化
+func (r *BoolReply) Encode(protocol int) []byte {
+	switch {
+	case protocol < 3 && r.Value:
+		return []byte(":1\r\n")
+	case protocol < 3:
+		return []byte(":0\r\n")
+	case r.Value:
+		return trueBytes
+	}
+	return falseBytes
+}
+
+/* ---- 空值 ---- */
+
+// nullBytes RESP3 的空值
+var nullBytes = []byte("_\r\n")
+
+// NullReply RESP3 的空值；RESP2 中是空的字符串 $-1
+type NullReply struct{}
+
+// MakeNullReply 创建 NullReply
+func MakeNullReply() *NullReply {
+	return &NullReply{}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *NullReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化
+func (r *NullReply) Encode(protocol int) []byte {
+	if protocol < 3 {
+		return nullBulkBytes
+	}
+	return nullBytes
+}
+
+/* ---- 大整数 ---- */
+
+// BigNumberReply 超过 64 位的整数；RESP2 中是字符串
+type BigNumberReply struct {
+	Value *big.Int
+}
+
+// MakeBigNumberReply 创建 BigNumberReply
+func MakeBigNumberReply(value *big.Int) *BigNumberReply {
+	return &BigNumberReply{
+		Value: value,
+	}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *BigNumberReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化
+func (r *BigNumberReply) Encode(protocol int) []byte {
+	str := r.Value.String()
+	if protocol < 3 {
+		return []byte("$" + strconv.Itoa(len(str)) + CRLF + str + CRLF)
+	}
+	return []byte("(" + str + CRLF)
+}
+
+/* ---- 原样输出的字符串 ---- */
+
+// VerbatimReply 带格式的字符串，客户端应该原样显示，例：MEMORY DOCTOR 的报告；RESP2 中是普通的字符串
+type VerbatimReply struct {
+	Format string // 三个字符，txt 表示纯文本，mkd 表示 markdown
+	Text   []byte
+}
+
+// MakeVerbatimReply 创建 VerbatimReply
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{
+		Format: format,
+		Text:   text,
+	}
+}
+
+// ToBytes 序列化 resp.Reply
+func (r *VerbatimReply) ToBytes() []byte {
+	return r.Encode(2)
+}
+
+// Encode 按协议版本序列化
+func (r *VerbatimReply) Encode(protocol int) []byte {
+	if protocol < 3 {
+		return []byte("$" + strconv.Itoa(len(r.Text)) + CRLF + string(r.Text) + CRLF)
+	}
+	return []byte("=" + strconv.Itoa(len(r.Text)+4) + CRLF + r.Format + ":" + string(r.Text) + CRLF)
+}
@@ -0,0 +1,324 @@
+package tiered
+
+/*
+ * 冷数据的磁盘层：只追加写的 value log，加上内存中的索引 key -> 位置
+ * 文件按大小切分成多个段，文件名为 <序号>.vlog；被覆盖或删除的记录变成垃圾，由 Compact 把段里还有效的记录搬到当前段后删除整个段
+ *
+ * 每条记录: <crc32 4 字节> <key 长度 4 字节> <value 长度 4 字节> <key> <value>
+ * crc32 覆盖两个长度以及 key 和 value
+ *
+ * 索引只保存在内存中，数据的持久化由 AOF 负责，所以打开时会清空目录里原来的段
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	recordHeaderSize   = 12
+	segmentSuffix      = ".vlog"
+	defaultSegmentSize = 64 << 20 // 单个段的默认大小上限
+)
+
+// ErrCorrupted 读出的记录校验失败
+var ErrCorrupted = errors.New("tiered: corrupted record")
+
+// segment value log 中的一个文件
+type segment struct {
+	id   int
+	file *os.File
+	size int64 // 文件大小
+	live int64 // 还被索引引用的记录的大小
+}
+
+// location 一条记录在 value log 中的位置
+type location struct {
+	seg    *segment
+	offset int64
+	size   int64 // 整条记录的大小，包括头部
+}
+
+// Stats 磁盘层的统计信息
+type Stats struct {
+	Keys        int   // 保存在磁盘上的键的个数
+	Segments    int   // 段的个数
+	TotalBytes  int64 // 所有段的大小
+	LiveBytes   int64 // 有效记录的大小，和 TotalBytes 的差就是可以回收的垃圾
+	Compactions int64 // 已经压缩掉的段的个数
+}
+
+// Store 保存冷数据的 value log
+type Store struct {
+	dir         string
+	segmentSize int64
+
+	mu          sync.RWMutex
+	index       map[string]location
+	segments    map[int]*segment
+	active      *segment // 当前写入的段
+	compactions int64
+}
+
+// Open 打开目录作为 value log，清空目录里原来的段；segmentSize 小于等于 0 时使用默认值
+func Open(dir string, segmentSize int64) (*Store, error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := removeSegments(dir); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:         dir,
+		segmentSize: segmentSize,
+		index:       make(map[string]location),
+		segments:    make(map[int]*segment),
+	}
+	if err := s.roll(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Put 把值追加到 value log 并更新索引，原来的值变成垃圾
+func (s *Store) Put(key string, val []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc, err := s.append(encodeRecord(key, val))
+	if err != nil {
+		return err
+	}
+	s.release(key)
+	s.index[key] = loc
+	return nil
+}
+
+// Get 读出键的值，键不在磁盘上时返回 false
+func (s *Store) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loc, ok := s.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+	readKey, val, err := readRecord(loc)
+	if err != nil {
+		return nil, false, err
+	}
+	if readKey != key {
+		return nil, false, ErrCorrupted
+	}
+	return val, true, nil
+}
+
+// Delete 从索引中删除键，返回键原来是否在磁盘上
+func (s *Store) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.release(key)
+}
+
+// Len 返回磁盘上的键的个数
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.index)
+}
+
+// Clear 删除所有的键和段
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seg := range s.segments {
+		_ = seg.file.Close()
+	}
+	s.index = make(map[string]location)
+	s.segments = make(map[int]*segment)
+	s.active = nil
+	if err := removeSegments(s.dir); err != nil {
+		return err
+	}
+	return s.roll()
+}
+
+// Compact 压缩一个垃圾比例不低于 minGarbageRatio 的段（垃圾最多的段优先），返回是否压缩了
+// 段里还有效的记录被搬到当前段，然后删除整个段；当前正在写入的段不参与压缩
+func (s *Store) Compact(minGarbageRatio float64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var victim *segment
+	bestRatio := minGarbageRatio
+	for _, seg := range s.segments {
+		if seg == s.active || seg.size == 0 {
+			continue
+		}
+		ratio := float64(seg.size-seg.live) / float64(seg.size)
+		if ratio >= bestRatio {
+			victim, bestRatio = seg, ratio
+		}
+	}
+	if victim == nil {
+		return false, nil
+	}
+	for key, loc := range s.index {
+		if loc.seg != victim {
+			continue
+		}
+		record := make([]byte, loc.size)
+		if _, err := victim.file.ReadAt(record, loc.offset); err != nil {
+			return false, err
+		}
+		newLoc, err := s.append(record)
+		if err != nil {
+			return false, err
+		}
+		s.index[key] = newLoc
+	}
+	s.dropSegment(victim)
+	s.compactions++
+	return true, nil
+}
+
+// Stats 返回统计信息
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := Stats{
+		Keys:        len(s.index),
+		Segments:    len(s.segments),
+		Compactions: s.compactions,
+	}
+	for _, seg := range s.segments {
+		stats.TotalBytes += seg.size
+		stats.LiveBytes += seg.live
+	}
+	return stats
+}
+
+// Close 关闭并删除所有的段
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seg := range s.segments {
+		_ = seg.file.Close()
+	}
+	s.index = nil
+	s.segments = nil
+	s.active = nil
+	return removeSegments(s.dir)
+}
+
+/* ---- 内部实现，调用者需要持有写锁 ----- */
+
+// append 把一条完整的记录写到当前段，当前段写满了先切换到下一个段
+func (s *Store) append(record []byte) (location, error) {
+	if s.active.size > 0 && s.active.size+int64(len(record)) > s.segmentSize {
+		if err := s.roll(); err != nil {
+			return location{}, err
+		}
+	}
+	seg := s.active
+	if _, err := seg.file.WriteAt(record, seg.size); err != nil {
+		return location{}, err
+	}
+	loc := location{seg: seg, offset: seg.size, size: int64(len(record))}
+	seg.size += loc.size
+	seg.live += loc.size
+	return loc, nil
+}
+
+// release 删除键的索引，它的记录变成垃圾；已经不再被引用的段直接删除
+func (s *Store) release(key string) bool {
+	loc, ok := s.index[key]
+	if !ok {
+		return false
+	}
+	delete(s.index, key)
+	loc.seg.live -= loc.size
+	if loc.seg.live > 0 {
+		return true
+	}
+	if loc.seg != s.active {
+		s.dropSegment(loc.seg)
+	} else if err := loc.seg.file.Truncate(0); err == nil { // 当前段里都是垃圾，从头开始写
+		loc.seg.size = 0
+	}
+	return true
+}
+
+// roll 打开下一个序号的段作为当前段
+func (s *Store) roll() error {
+	id := 1
+	if s.active != nil {
+		id = s.active.id + 1
+	}
+	file, err := os.OpenFile(filepath.Join(s.dir, strconv.Itoa(id)+segmentSuffix), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	s.active = &segment{id: id, file: file}
+	s.segments[id] = s.active
+	return nil
+}
+
+// dropSegment 关闭并删除一个段
+func (s *Store) dropSegment(seg *segment) {
+	delete(s.segments, seg.id)
+	_ = seg.file.Close()
+	_ = os.Remove(seg.file.Name())
+}
+
+// removeSegments 删除目录里所有的段
+func removeSegments(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentSuffix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeRecord 编码一条记录
+func encodeRecord(key string, val []byte) []byte {
+	record := make([]byte, recordHeaderSize+len(key)+len(val))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(key)))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(val)))
+	copy(record[recordHeaderSize:], key)
+	copy(record[recordHeaderSize+len(key):], val)
+	binary.LittleEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[4:]))
+	return record
+}
+
+// readRecord 读出并校验一条记录
+func readRecord(loc location) (string, []byte, error) {
+	record := make([]byte, loc.size)
+	if _, err := loc.seg.file.ReadAt(record, loc.offset); err != nil {
+		return "", nil, err
+	}
+	if binary.LittleEndian.Uint32(record[0:4]) != crc32.ChecksumIEEE(record[4:]) {
+		return "", nil, ErrCorrupted
+	}
+	keyLen := int64(binary.LittleEndian.Uint32(record[4:8]))
+	valLen := int64(binary.LittleEndian.Uint32(record[8:12]))
+	if recordHeaderSize+keyLen+valLen != loc.size {
+		return "", nil, ErrCorrupted
+	}
+	key := string(record[recordHeaderSize : recordHeaderSize+keyLen])
+	return key, record[recordHeaderSize+keyLen:], nil
+}
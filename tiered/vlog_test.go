@@ -0,0 +1,112 @@
+package tiered
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStorePutGet(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Put("b", []byte(strings.Repeat("x", 1000)))
+	_ = s.Put("a", []byte("2")) // 覆盖
+	if val, ok, err := s.Get("a"); err != nil || !ok || string(val) != "2" {
+		t.Errorf("expected 2, got %q %v %v", val, ok, err)
+	}
+	if val, ok, _ := s.Get("b"); !ok || len(val) != 1000 {
+		t.Error("b should be readable")
+	}
+	if _, ok, _ := s.Get("none"); ok {
+		t.Error("none should not exist")
+	}
+	if !s.Delete("b") || s.Delete("b") || s.Len() != 1 {
+		t.Error("delete failed")
+	}
+	stats := s.Stats()
+	if stats.Keys != 1 || stats.LiveBytes != int64(recordHeaderSize+2) || stats.TotalBytes <= stats.LiveBytes {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	value := []byte(strings.Repeat("v", 100))
+	for i := 0; i < 50; i++ {
+		_ = s.Put("k"+strconv.Itoa(i), value)
+	}
+	if s.Stats().Segments < 5 {
+		t.Fatalf("expected segments to roll, got %d", s.Stats().Segments)
+	}
+	for i := 0; i < 50; i++ {
+		if i%4 != 0 { // 删除 3/4 的键，老的段里大部分都是垃圾
+			s.Delete("k" + strconv.Itoa(i))
+		}
+	}
+	before := s.Stats()
+	for {
+		compacted, err := s.Compact(0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !compacted {
+			break
+		}
+	}
+	after := s.Stats()
+	if after.Compactions == 0 || after.TotalBytes >= before.TotalBytes || after.LiveBytes != before.LiveBytes {
+		t.Errorf("compaction did not reclaim space: before %+v, after %+v", before, after)
+	}
+	for i := 0; i < 50; i += 4 {
+		if val, ok, err := s.Get("k" + strconv.Itoa(i)); err != nil || !ok || string(val) != string(value) {
+			t.Errorf("k%d lost after compaction: %v %v", i, ok, err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(files) != after.Segments {
+		t.Errorf("expected %d segment files, found %d", after.Segments, len(files))
+	}
+}
+
+func TestStoreCorruption(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	_ = s.Put("a", []byte("hello"))
+	file, _ := os.OpenFile(filepath.Join(dir, "1"+segmentSuffix), os.O_WRONLY, 0600)
+	_, _ = file.WriteAt([]byte("j"), recordHeaderSize+1) // 改掉 value 的第一个字节
+	_ = file.Close()
+	if _, _, err := s.Get("a"); err != ErrCorrupted {
+		t.Errorf("expected ErrCorrupted, got %v", err)
+	}
+}
+
+func TestStoreOpenClearsOldSegments(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 0)
+	_ = s.Put("a", []byte("1"))
+	s2, err := Open(dir, 0) // 索引只在内存中，重新打开时旧的段没有意义
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.Len() != 0 || s2.Stats().TotalBytes != 0 {
+		t.Error("reopened store should be empty")
+	}
+	_ = s2.Close()
+}
@@ -0,0 +1,107 @@
+package writebehind
+
+/*
+ * 用一个 JSON 文件作为数据源，给测试和单机使用
+ * 所有的数据都在内存里，每写一批就把整个文件重写一遍（先写临时文件再改名），不适合数据量大的场景
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fileValue 文件中的一个值，Value 在 JSON 中是 base64 编码的
+type fileValue struct {
+	Value    []byte `json:"value"`
+	ExpireAt int64  `json:"expire_at,omitempty"` // Unix 毫秒，0 表示不过期
+}
+
+// FileStore 保存在 JSON 文件中的数据源
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+	data map[string]map[string]fileValue // 数据库编号 -> 键 -> 值
+}
+
+// OpenFileStore 打开 JSON 文件，文件不存在时从空的数据开始
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, data: make(map[string]map[string]fileValue)}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &s.data); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Write 应用一批记录并重写文件
+func (s *FileStore) Write(records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range records {
+		db := strconv.Itoa(r.DB)
+		switch r.Op {
+		case OpFlushDB:
+			delete(s.data, db)
+		case OpDelete:
+			delete(s.data[db], r.Key)
+		case OpPut:
+			if s.data[db] == nil {
+				s.data[db] = make(map[string]fileValue)
+			}
+			v := fileValue{Value: r.Value}
+			if !r.ExpireAt.IsZero() {
+				v.ExpireAt = r.ExpireAt.UnixMilli()
+			}
+			s.data[db][r.Key] = v
+		}
+	}
+	return s.save()
+}
+
+// save 把数据写到临时文件再改名，写到一半崩溃时不会留下损坏的文件
+func (s *FileStore) save() error {
+	raw, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Get 返回文件中的值，已经过期的值当作不存在
+func (s *FileStore) Get(dbIndex int, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[strconv.Itoa(dbIndex)][key]
+	if !ok || (v.ExpireAt > 0 && time.Now().UnixMilli() >= v.ExpireAt) {
+		return nil, false
+	}
+	return v.Value, true
+}
+
+// Len 返回数据库中键的个数
+func (s *FileStore) Len(dbIndex int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data[strconv.Itoa(dbIndex)])
+}
+
+// Close 数据在每次 Write 时已经写到文件里了，不需要做什么
+func (s *FileStore) Close() error {
+	return nil
+}
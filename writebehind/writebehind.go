@@ -0,0 +1,360 @@
+package writebehind
+
+/*
+ * 异步回写（write-behind）：观察已经提交的写命令，把被修改的键记下来，后台按批写到持久化的数据源
+ *   - 同一个键在写出之前的多次修改合并成一次，写出时读取键当前的值（不存在或者已经过期就删除）
+ *   - 清空数据库的操作会丢掉这个数据库里还没写出的键，按顺序写出一条清空的记录
+ *   - 淘汰只是把键从缓存里去掉，不写出删除；还没写出的键在淘汰之前用 Retain 留下当前的值，写出时用它
+ *   - 写入失败时按指数退避重试，超过次数以后丢掉这一批并计数
+ */
+
+import (
+	"GoMiniCache/lib/logger"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Op 记录的类型
+type Op int
+
+const (
+	OpPut     Op = iota // 写入键值
+	OpDelete            // 删除键
+	OpFlushDB           // 清空数据库
+)
+
+// Record 写到数据源的一条记录
+type Record struct {
+	Op       Op
+	DB       int
+	Key      string
+	Value    []byte
+	ExpireAt time.Time // 零值表示不过期
+}
+
+// Store 持久化的数据源，Write 返回错误时整批重试，所以需要是幂等的
+type Store interface {
+	Write(records []Record) error
+	Close() error
+}
+
+// Source 读取键当前的值和过期时间，键不存在时返回 false
+type Source func(dbIndex int, key string) (val []byte, expireAt time.Time, ok bool)
+
+// Options 回写的参数，值为 0 时使用默认值
+type Options struct {
+	BatchSize     int           // 每批最多的记录个数，默认 100
+	FlushInterval time.Duration // 最长多久写出一次，默认 1s
+	MaxRetries    int           // 一批写入失败以后最多重试的次数，默认 10
+	RetryBackoff  time.Duration // 第一次重试前等待的时间，之后每次翻倍，默认 100ms
+	MaxBackoff    time.Duration // 重试等待时间的上限，默认 30s
+}
+
+// withDefaults 补上默认值
+func (opts Options) withDefaults() Options {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 10
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	return opts
+}
+
+// Stats 回写的统计
+type Stats struct {
+	Pending   int           // 还没有写出的键和清空操作的个数
+	Lag       time.Duration // 最早的还没有写出的修改距离现在的时间
+	Coalesced int64         // 写出之前被合并掉的修改次数
+	Written   int64         // 写出的记录个数
+	Batches   int64         // 写出的批次
+	Retries   int64         // 重试的次数
+	Dropped   int64         // 重试失败被丢掉的记录个数
+	LastError string        // 最近一次写入失败的原因
+}
+
+// pendingKey 数据库编号和键
+type pendingKey struct {
+	db  int
+	key string
+}
+
+// entry 队列中的一项：一个被修改的键，或者一次清空
+type entry struct {
+	pendingKey
+	flush bool
+	since time.Time // 第一次修改的时间
+}
+
+// ErrClosed 回写已经关闭
+var ErrClosed = errors.New("write-behind: closed")
+
+// Writer 收集修改并在后台写到 Store
+type Writer struct {
+	store  Store
+	source Source
+	opts   Options
+
+	mu            sync.Mutex
+	queue         []entry               // 按修改的顺序排列
+	pending       map[pendingKey]bool   // 队列中的键，用来合并
+	inflight      map[pendingKey]bool   // 正在写出的一批中的键
+	retained      map[pendingKey]Record // 还没写出就被淘汰的键在淘汰之前的值
+	inflightSince time.Time             // 正在写出的一批中最早的修改时间，没有时为零值
+	stats         Stats
+	closed        bool
+
+	notify  chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// New 创建 Writer 并启动后台协程
+func New(store Store, source Source, opts Options) *Writer {
+	w := &Writer{
+		store:    store,
+		source:   source,
+		opts:     opts.withDefaults(),
+		pending:  make(map[pendingKey]bool),
+		inflight: make(map[pendingKey]bool),
+		retained: make(map[pendingKey]Record),
+		notify:   make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+// Touch 记下一个被修改的键
+func (w *Writer) Touch(dbIndex int, key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	k := pendingKey{db: dbIndex, key: key}
+	delete(w.retained, k) // 淘汰以后又写入了，写出时读取新的值
+	if w.pending[k] {
+		w.stats.Coalesced++
+		return
+	}
+	w.pending[k] = true
+	w.queue = append(w.queue, entry{pendingKey: k, since: time.Now()})
+	w.wakeIfFull()
+}
+
+// FlushDB 记下一次清空数据库，这个数据库里还没写出的键不再需要写出
+func (w *Writer) FlushDB(dbIndex int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	since := time.Now()
+	kept := w.queue[:0]
+	for _, e := range w.queue {
+		if e.db == dbIndex {
+			if !e.flush {
+				delete(w.pending, e.pendingKey)
+				delete(w.retained, e.pendingKey)
+			}
+			w.stats.Coalesced++
+			if e.since.Before(since) { // 清空的记录继承被合并掉的修改的时间，延迟不会变小
+				since = e.since
+			}
+			continue
+		}
+		kept = append(kept, e)
+	}
+	w.queue = append(kept, entry{pendingKey: pendingKey{db: dbIndex}, flush: true, since: since})
+	w.wakeIfFull()
+}
+
+// Retain 键马上要被淘汰，如果它还有没写出的修改，先读出当前的值留到写出的时候用
+// 淘汰不是删除，数据源里要保留最后写入的值，所以需要在键从缓存里去掉之前调用
+func (w *Writer) Retain(dbIndex int, key string) {
+	k := pendingKey{db: dbIndex, key: key}
+	w.mu.Lock()
+	waiting := !w.closed && (w.pending[k] || w.inflight[k])
+	w.mu.Unlock()
+	if !waiting {
+		return
+	}
+	val, expireAt, ok := w.source(dbIndex, key)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending[k] || w.inflight[k] {
+		w.retained[k] = Record{Op: OpPut, DB: dbIndex, Key: key, Value: val, ExpireAt: expireAt}
+	}
+}
+
+// wakeIfFull 攒够一批时唤醒后台协程（调用者需要持有锁）
+func (w *Writer) wakeIfFull() {
+	if len(w.queue) >= w.opts.BatchSize {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Stats 返回统计信息
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.Pending = len(w.queue)
+	oldest := w.inflightSince
+	if len(w.queue) > 0 && (oldest.IsZero() || w.queue[0].since.Before(oldest)) {
+		oldest = w.queue[0].since
+	}
+	if !oldest.IsZero() {
+		stats.Lag = time.Since(oldest)
+	}
+	return stats
+}
+
+// Close 写出所有还没写出的修改，然后关闭 Store
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	w.closed = true
+	w.mu.Unlock()
+	close(w.closing)
+	<-w.done
+	return w.store.Close()
+}
+
+// loop 后台协程：定时或者攒够一批时写出
+func (w *Writer) loop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closing:
+			for w.writeBatch() {
+			}
+			return
+		case <-ticker.C:
+			for w.writeBatch() {
+			}
+		case <-w.notify:
+			for w.full() && w.writeBatch() {
+			}
+		}
+	}
+}
+
+// full 返回是否攒够了一批
+func (w *Writer) full() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.queue) >= w.opts.BatchSize
+}
+
+// writeBatch 从队列头部取出一批，读取键当前的值写到 Store，队列为空时返回 false
+func (w *Writer) writeBatch() bool {
+	w.mu.Lock()
+	n := min(len(w.queue), w.opts.BatchSize)
+	if n == 0 {
+		w.mu.Unlock()
+		return false
+	}
+	batch := make([]entry, n)
+	copy(batch, w.queue)
+	w.queue = append(w.queue[:0], w.queue[n:]...)
+	w.inflightSince = batch[0].since
+	for _, e := range batch {
+		if !e.flush {
+			delete(w.pending, e.pendingKey) // 写出期间再次修改的键重新排队，由下一批写出
+			w.inflight[e.pendingKey] = true
+		}
+	}
+	w.mu.Unlock()
+
+	records := make([]Record, 0, n)
+	for _, e := range batch {
+		records = append(records, w.resolve(e))
+	}
+	w.mu.Lock()
+	for i, e := range batch {
+		if e.flush {
+			continue
+		}
+		// 读取时键已经被淘汰了，写出淘汰之前的值，而不是删除
+		if retained, ok := w.retained[e.pendingKey]; ok && records[i].Op == OpDelete {
+			records[i] = retained
+		}
+		delete(w.inflight, e.pendingKey)
+		if !w.pending[e.pendingKey] {
+			delete(w.retained, e.pendingKey)
+		}
+	}
+	w.mu.Unlock()
+	err := w.writeWithRetry(records)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inflightSince = time.Time{}
+	if err != nil {
+		w.stats.Dropped += int64(len(records))
+		logger.Error("write-behind: dropped " + strconv.Itoa(len(records)) + " records: " + err.Error())
+		return true
+	}
+	w.stats.Written += int64(len(records))
+	w.stats.Batches++
+	return true
+}
+
+// resolve 把队列中的一项转换成记录
+func (w *Writer) resolve(e entry) Record {
+	if e.flush {
+		return Record{Op: OpFlushDB, DB: e.db}
+	}
+	val, expireAt, ok := w.source(e.db, e.key)
+	if !ok {
+		return Record{Op: OpDelete, DB: e.db, Key: e.key}
+	}
+	return Record{Op: OpPut, DB: e.db, Key: e.key, Value: val, ExpireAt: expireAt}
+}
+
+// writeWithRetry 写入一批记录，失败时按指数退避重试
+func (w *Writer) writeWithRetry(records []Record) error {
+	backoff := w.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := w.store.Write(records)
+		if err == nil {
+			return nil
+		}
+		w.mu.Lock()
+		w.stats.LastError = err.Error()
+		w.mu.Unlock()
+		if attempt >= w.opts.MaxRetries {
+			return err
+		}
+		w.mu.Lock()
+		w.stats.Retries++
+		w.mu.Unlock()
+		time.Sleep(backoff)
+		backoff = min(backoff*2, w.opts.MaxBackoff)
+	}
+}
@@ -0,0 +1,164 @@
+package writebehind
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySource 测试用的数据库
+type memorySource struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (s *memorySource) set(key, val string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if val == "" {
+		delete(s.data, key)
+	} else {
+		s.data[key] = val
+	}
+}
+
+func (s *memorySource) read(dbIndex int, key string) ([]byte, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.data[key]
+	return []byte(val), time.Time{}, ok
+}
+
+// flakyStore 前 failures 次写入失败的 Store
+type flakyStore struct {
+	mu       sync.Mutex
+	failures int
+	batches  [][]Record
+}
+
+func (s *flakyStore) Write(records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store unavailable")
+	}
+	s.batches = append(s.batches, append([]Record(nil), records...))
+	return nil
+}
+
+func (s *flakyStore) Close() error { return nil }
+
+func TestCoalesceAndFlushDB(t *testing.T) {
+	source := &memorySource{data: map[string]string{}}
+	store := &flakyStore{}
+	w := New(store, source.read, Options{FlushInterval: time.Hour})
+	for i := 0; i < 5; i++ { // 同一个键的多次修改只写出一次
+		source.set("a", "v"+string(rune('0'+i)))
+		w.Touch(0, "a")
+	}
+	w.Touch(0, "gone") // 写出时已经不存在的键写成删除
+	w.Touch(1, "b")
+	w.FlushDB(1) // 数据库 1 还没写出的键被清空操作合并掉
+	stats := w.Stats()
+	if stats.Pending != 3 || stats.Coalesced != 5 || stats.Lag <= 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.batches) != 1 {
+		t.Fatalf("expected one batch, got %d", len(store.batches))
+	}
+	batch := store.batches[0]
+	if len(batch) != 3 ||
+		batch[0].Op != OpPut || batch[0].Key != "a" || string(batch[0].Value) != "v4" ||
+		batch[1].Op != OpDelete || batch[1].Key != "gone" ||
+		batch[2].Op != OpFlushDB || batch[2].DB != 1 {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+	if stats := w.Stats(); stats.Pending != 0 || stats.Lag != 0 || stats.Written != 3 {
+		t.Errorf("unexpected stats after close %+v", stats)
+	}
+}
+
+// TestRetain 还没写出就被淘汰的键写出淘汰之前的值，淘汰以后再写入的键写出新的值
+func TestRetain(t *testing.T) {
+	source := &memorySource{data: map[string]string{"a": "1", "b": "2"}}
+	store := &flakyStore{}
+	w := New(store, source.read, Options{FlushInterval: time.Hour})
+	w.Touch(0, "a")
+	w.Touch(0, "b")
+	w.Retain(0, "a")
+	source.set("a", "") // 淘汰
+	w.Retain(0, "b")
+	source.set("b", "")
+	source.set("b", "3") // 淘汰以后又写入了
+	w.Touch(0, "b")
+	w.Retain(0, "c") // 没有等待写出的修改，什么都不做
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	batch := store.batches[0]
+	if len(batch) != 2 ||
+		batch[0].Op != OpPut || batch[0].Key != "a" || string(batch[0].Value) != "1" ||
+		batch[1].Op != OpPut || batch[1].Key != "b" || string(batch[1].Value) != "3" {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	source := &memorySource{data: map[string]string{"a": "1"}}
+	store := &flakyStore{failures: 2}
+	w := New(store, source.read, Options{BatchSize: 1, RetryBackoff: time.Millisecond})
+	w.Touch(0, "a")
+	deadline := time.Now().Add(2 * time.Second)
+	for w.Stats().Written == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := w.Stats()
+	if stats.Written != 1 || stats.Retries != 2 || stats.LastError != "store unavailable" {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	_ = w.Close()
+
+	store = &flakyStore{failures: 100}
+	w = New(store, source.read, Options{MaxRetries: 2, RetryBackoff: time.Millisecond})
+	w.Touch(0, "a")
+	_ = w.Close()
+	if stats := w.Stats(); stats.Dropped != 1 || stats.Retries != 2 {
+		t.Errorf("batch should be dropped after retries, got %+v", stats)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Write([]Record{
+		{Op: OpPut, DB: 0, Key: "a", Value: []byte("1")},
+		{Op: OpPut, DB: 0, Key: "old", Value: []byte("x"), ExpireAt: time.Now().Add(-time.Second)},
+		{Op: OpPut, DB: 1, Key: "b", Value: []byte("2")},
+		{Op: OpFlushDB, DB: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, ok := s.Get(0, "a"); !ok || string(val) != "1" {
+		t.Errorf("unexpected a %q", val)
+	}
+	if _, ok := s.Get(0, "old"); ok {
+		t.Error("expired value should not be returned")
+	}
+	if s.Len(1) != 0 {
+		t.Error("db 1 should be flushed")
+	}
+}